@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/url"
@@ -42,6 +43,15 @@ func (p *Proxy) DialContext(ctx context.Context, metadata *C.Metadata) (C.Conn,
 	return conn, err
 }
 
+// Close releases what the adapter keeps open, like the connection of a
+// hysteria2 client, once the config dropped the proxy
+func (p *Proxy) Close() error {
+	if closer, ok := p.ProxyAdapter.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
 // DelayHistory implements C.Proxy
 func (p *Proxy) DelayHistory() []C.DelayHistory {
 	queue := p.history.Copy()
@@ -96,7 +106,7 @@ func (p *Proxy) URLTest(ctx context.Context, url string) (t uint16, err error) {
 			record.Delay = t
 		}
 		p.history.Put(record)
-		if p.history.Len() > 10 {
+		if p.history.Len() > C.MaxDelayHistory {
 			p.history.Pop()
 		}
 	}()
@@ -148,7 +158,7 @@ func (p *Proxy) URLTest(ctx context.Context, url string) (t uint16, err error) {
 }
 
 func NewProxy(adapter C.ProxyAdapter) *Proxy {
-	return &Proxy{adapter, queue.New(10), atomic.NewBool(true)}
+	return &Proxy{adapter, queue.New(C.MaxDelayHistory), atomic.NewBool(true)}
 }
 
 func urlToMetadata(rawURL string) (addr C.Metadata, err error) {
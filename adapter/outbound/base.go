@@ -5,14 +5,45 @@ import (
 	"errors"
 	"net"
 
+	"github.com/Dreamacro/clash/component/dialer"
 	C "github.com/Dreamacro/clash/constant"
 )
 
+// BasicOption are the options every outbound adapter takes, squashed into
+// their own
+type BasicOption struct {
+	IPVersion   string `proxy:"ip-version,omitempty"`
+	Interface   string `proxy:"interface-name,omitempty"`
+	RoutingMark int    `proxy:"routing-mark,omitempty"`
+}
+
+// dialOptions validates the basic options and turns them into the options
+// of the dials to the server
+func (o BasicOption) dialOptions() ([]dialer.Option, error) {
+	var options []dialer.Option
+	if o.IPVersion != "" {
+		version, err := dialer.ParseIPVersion(o.IPVersion)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, dialer.WithIPVersion(version))
+	}
+	if o.Interface != "" {
+		options = append(options, dialer.WithInterface(o.Interface))
+	}
+	if o.RoutingMark != 0 {
+		options = append(options, dialer.WithRoutingMark(o.RoutingMark))
+	}
+	return options, nil
+}
+
 type Base struct {
 	name string
 	addr string
 	tp   C.AdapterType
 	udp  bool
+
+	dialOptions []dialer.Option
 }
 
 // Name implements C.ProxyAdapter
@@ -52,13 +83,24 @@ func (b *Base) Addr() string {
 	return b.addr
 }
 
+// DialOptions returns the options the adapter dials its server with
+func (b *Base) DialOptions() []dialer.Option {
+	return b.dialOptions
+}
+
+// IPVersion returns the ip-version the adapter dials its server with, the
+// global one unless it sets its own
+func (b *Base) IPVersion() dialer.IPVersion {
+	return dialer.IPVersionOf(b.dialOptions...)
+}
+
 // Unwrap implements C.ProxyAdapter
 func (b *Base) Unwrap(metadata *C.Metadata) C.Proxy {
 	return nil
 }
 
 func NewBase(name string, addr string, tp C.AdapterType, udp bool) *Base {
-	return &Base{name, addr, tp, udp}
+	return &Base{name: name, addr: addr, tp: tp, udp: udp}
 }
 
 type conn struct {
@@ -0,0 +1,83 @@
+package outbound
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Dreamacro/clash/common/observable"
+)
+
+// connection event types
+const (
+	EventDialStart   = "dial-start"
+	EventHandshakeOK = "handshake-ok"
+	EventConnClose   = "conn-close"
+	EventError       = "error"
+)
+
+var (
+	eventCh     = make(chan interface{}, 64)
+	eventSource = observable.NewObservable(eventCh)
+)
+
+// ConnEvent is a connection event of a node
+type ConnEvent struct {
+	Proxy   string    `json:"proxy"`
+	Type    string    `json:"type"`
+	Network string    `json:"network"`
+	Error   string    `json:"error,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// SubscribeConnEvents returns a subscription to the connection events of
+// all nodes. Events are dropped rather than slowing down dials, so a slow
+// reader misses some.
+func SubscribeConnEvents() observable.Subscription {
+	sub, _ := eventSource.Subscribe()
+	return sub
+}
+
+func UnSubscribeConnEvents(sub observable.Subscription) {
+	eventSource.UnSubscribe(sub)
+}
+
+func emitConnEvent(proxy, network, tp string, err error) {
+	event := &ConnEvent{
+		Proxy:   proxy,
+		Type:    tp,
+		Network: network,
+		Time:    time.Now(),
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+
+	select {
+	case eventCh <- event:
+	default:
+	}
+}
+
+// eventConn emits conn-close once the conn is closed
+type eventConn struct {
+	net.Conn
+	proxy string
+	once  sync.Once
+}
+
+func (c *eventConn) Close() error {
+	c.once.Do(func() { emitConnEvent(c.proxy, "tcp", EventConnClose, nil) })
+	return c.Conn.Close()
+}
+
+type eventPacketConn struct {
+	net.PacketConn
+	proxy string
+	once  sync.Once
+}
+
+func (c *eventPacketConn) Close() error {
+	c.once.Do(func() { emitConnEvent(c.proxy, "udp", EventConnClose, nil) })
+	return c.PacketConn.Close()
+}
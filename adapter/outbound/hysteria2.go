@@ -0,0 +1,174 @@
+package outbound
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/Dreamacro/clash/component/dialer"
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/transport/hysteria2"
+)
+
+type Hysteria2 struct {
+	*Base
+	client *hysteria2.Client
+}
+
+type Hysteria2Option struct {
+	BasicOption `proxy:",squash"`
+	Name        string `proxy:"name"`
+	Server      string `proxy:"server"`
+	Port        int    `proxy:"port"`
+	Password    string `proxy:"password"`
+	// bandwidth as "100 Mbps", a bare number is in Mbps
+	Up             string `proxy:"up,omitempty"`
+	Down           string `proxy:"down,omitempty"`
+	Obfs           string `proxy:"obfs,omitempty"`
+	ObfsPassword   string `proxy:"obfs-password,omitempty"`
+	SNI            string `proxy:"sni,omitempty"`
+	SkipCertVerify bool   `proxy:"skip-cert-verify,omitempty"`
+	UDP            bool   `proxy:"udp,omitempty"`
+}
+
+// DialContext implements C.ProxyAdapter
+func (h *Hysteria2) DialContext(ctx context.Context, metadata *C.Metadata) (_ C.Conn, err error) {
+	c, err := h.client.DialConn(ctx, metadata.RemoteAddress())
+	if err != nil {
+		return nil, fmt.Errorf("%s connect error: %w", h.addr, err)
+	}
+	return NewConn(c, h), nil
+}
+
+// DialUDP implements C.ProxyAdapter
+func (h *Hysteria2) DialUDP(metadata *C.Metadata) (C.PacketConn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), C.DefaultTCPTimeout)
+	defer cancel()
+	pc, err := h.client.ListenPacket(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s connect error: %w", h.addr, err)
+	}
+	return newPacketConn(pc, h), nil
+}
+
+// Close closes the connection to the server, the proxy is dropped by the
+// config
+func (h *Hysteria2) Close() error {
+	return h.client.Close()
+}
+
+// parseBandwidth returns a bandwidth like "100 Mbps" or "1g" in bytes per
+// second, a bare number is in Mbps.
+func parseBandwidth(s string) (uint64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return 0, nil
+	}
+
+	i := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	if i == 0 {
+		return 0, fmt.Errorf("invalid bandwidth: %s", s)
+	}
+	num, unit := s, "mbps"
+	if i > 0 {
+		num, unit = s[:i], strings.TrimSpace(s[i:])
+	}
+	n, err := strconv.ParseUint(num, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid bandwidth: %s", s)
+	}
+
+	switch unit {
+	case "b", "bps":
+		return n / 8, nil
+	case "k", "kb", "kbps":
+		return n * 1000 / 8, nil
+	case "m", "mb", "mbps":
+		return n * 1000 * 1000 / 8, nil
+	case "g", "gb", "gbps":
+		return n * 1000 * 1000 * 1000 / 8, nil
+	case "t", "tb", "tbps":
+		return n * 1000 * 1000 * 1000 * 1000 / 8, nil
+	default:
+		return 0, fmt.Errorf("invalid bandwidth unit: %s", unit)
+	}
+}
+
+func NewHysteria2(option Hysteria2Option) (*Hysteria2, error) {
+	addr := net.JoinHostPort(option.Server, strconv.Itoa(option.Port))
+	if option.Password == "" {
+		return nil, fmt.Errorf("hysteria2 %s password is required", addr)
+	}
+
+	// the server is told the down rate and sends at it, up caps the
+	// packets sent to the server
+	up, err := parseBandwidth(option.Up)
+	if err != nil {
+		return nil, fmt.Errorf("hysteria2 %s up: %w", addr, err)
+	}
+	down, err := parseBandwidth(option.Down)
+	if err != nil {
+		return nil, fmt.Errorf("hysteria2 %s down: %w", addr, err)
+	}
+
+	switch option.Obfs {
+	case "":
+		if option.ObfsPassword != "" {
+			return nil, fmt.Errorf("hysteria2 %s obfs-password requires obfs: salamander", addr)
+		}
+	case "salamander":
+		if len(option.ObfsPassword) < 4 {
+			return nil, fmt.Errorf("hysteria2 %s obfs-password must be at least 4 characters", addr)
+		}
+	default:
+		return nil, fmt.Errorf("hysteria2 %s unsupported obfs: %s", addr, option.Obfs)
+	}
+
+	dialOptions, err := option.dialOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	serverName := option.Server
+	if option.SNI != "" {
+		serverName = option.SNI
+	}
+	tlsConfig := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: option.SkipCertVerify,
+		MinVersion:         tls.VersionTLS13,
+	}
+
+	client := hysteria2.NewClient(&hysteria2.Config{
+		Password:     option.Password,
+		TLSConfig:    tlsConfig,
+		ObfsPassword: option.ObfsPassword,
+		Up:           up,
+		Down:         down,
+		Dial: func(ctx context.Context) (net.PacketConn, net.Addr, error) {
+			ip, err := dialer.ResolveIP(option.Server, dialOptions...)
+			if err != nil {
+				return nil, nil, err
+			}
+			pc, err := dialer.ListenPacketWithOptions("udp", "", dialOptions...)
+			if err != nil {
+				return nil, nil, err
+			}
+			return pc, &net.UDPAddr{IP: ip, Port: option.Port}, nil
+		},
+	})
+
+	return &Hysteria2{
+		Base: &Base{
+			name:        option.Name,
+			addr:        addr,
+			tp:          C.Hysteria2,
+			udp:         option.UDP,
+			dialOptions: dialOptions,
+		},
+		client: client,
+	}, nil
+}
@@ -0,0 +1,64 @@
+package outbound
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBandwidth(t *testing.T) {
+	for s, expected := range map[string]uint64{
+		"":         0,
+		"100":      12500000,
+		"100 Mbps": 12500000,
+		"1g":       125000000,
+		"800 kbps": 100000,
+		" 8 bps ":  1,
+		"20 MBPS":  2500000,
+	} {
+		n, err := parseBandwidth(s)
+		assert.Nil(t, err, s)
+		assert.Equal(t, expected, n, s)
+	}
+
+	for _, s := range []string{"mbps", "1.5 mbps", "10 parsecs", "-1"} {
+		_, err := parseBandwidth(s)
+		assert.NotNil(t, err, s)
+	}
+}
+
+func TestNewHysteria2(t *testing.T) {
+	option := Hysteria2Option{
+		Name:     "test",
+		Server:   "hysteria.example.com",
+		Port:     443,
+		Password: "password",
+		Up:       "20 Mbps",
+		Down:     "100 Mbps",
+		UDP:      true,
+	}
+	h, err := NewHysteria2(option)
+	assert.Nil(t, err)
+	assert.True(t, h.SupportUDP())
+	assert.Equal(t, "Hysteria2", h.Type().String())
+	assert.Nil(t, h.Close())
+
+	obfs := option
+	obfs.Obfs = "salamander"
+	obfs.ObfsPassword = "cry me a r1ver"
+	_, err = NewHysteria2(obfs)
+	assert.Nil(t, err)
+
+	for _, f := range []func(o *Hysteria2Option){
+		func(o *Hysteria2Option) { o.Password = "" },
+		func(o *Hysteria2Option) { o.Up = "fast" },
+		func(o *Hysteria2Option) { o.Obfs = "xor" },
+		func(o *Hysteria2Option) { o.ObfsPassword = "cry me a r1ver" },
+		func(o *Hysteria2Option) { o.Obfs = "salamander" },
+	} {
+		o := option
+		f(&o)
+		_, err := NewHysteria2(o)
+		assert.NotNil(t, err)
+	}
+}
@@ -0,0 +1,117 @@
+package outbound
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Dreamacro/clash/tunnel/statistic"
+
+	"go.uber.org/atomic"
+)
+
+// adapterMetrics holds the counters behind statistic.NodeMetrics. Everything is atomic so
+// the dial and relay paths never take a lock for it.
+type adapterMetrics struct {
+	upload        *atomic.Int64
+	download      *atomic.Int64
+	connections   *atomic.Int64
+	active        *atomic.Int64
+	failures      *atomic.Int64
+	handshakeNano *atomic.Int64
+}
+
+func newAdapterMetrics() *adapterMetrics {
+	return &adapterMetrics{
+		upload:        atomic.NewInt64(0),
+		download:      atomic.NewInt64(0),
+		connections:   atomic.NewInt64(0),
+		active:        atomic.NewInt64(0),
+		failures:      atomic.NewInt64(0),
+		handshakeNano: atomic.NewInt64(0),
+	}
+}
+
+func (m *adapterMetrics) snapshot(proxy, tp, transport string) statistic.NodeMetrics {
+	return statistic.NodeMetrics{
+		Proxy:             proxy,
+		Type:              tp,
+		Transport:         transport,
+		UploadBytes:       m.upload.Load(),
+		DownloadBytes:     m.download.Load(),
+		Connections:       m.connections.Load(),
+		ActiveConnections: m.active.Load(),
+		HandshakeFailures: m.failures.Load(),
+		HandshakeSeconds:  time.Duration(m.handshakeNano.Load()).Seconds(),
+	}
+}
+
+// dialed records the result of a dial started at start.
+func (m *adapterMetrics) dialed(start time.Time, err error) {
+	if err != nil {
+		m.failures.Inc()
+		return
+	}
+
+	m.handshakeNano.Add(int64(time.Since(start)))
+	m.connections.Inc()
+	m.active.Inc()
+}
+
+func (m *adapterMetrics) closed() {
+	m.active.Dec()
+}
+
+func (m *adapterMetrics) wrapConn(c net.Conn) net.Conn {
+	return &meteredConn{Conn: c, metrics: m}
+}
+
+func (m *adapterMetrics) wrapPacketConn(pc net.PacketConn) net.PacketConn {
+	return &meteredPacketConn{PacketConn: pc, metrics: m}
+}
+
+type meteredConn struct {
+	net.Conn
+	metrics *adapterMetrics
+	once    sync.Once
+}
+
+func (c *meteredConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.metrics.download.Add(int64(n))
+	return n, err
+}
+
+func (c *meteredConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.metrics.upload.Add(int64(n))
+	return n, err
+}
+
+func (c *meteredConn) Close() error {
+	c.once.Do(c.metrics.closed)
+	return c.Conn.Close()
+}
+
+type meteredPacketConn struct {
+	net.PacketConn
+	metrics *adapterMetrics
+	once    sync.Once
+}
+
+func (c *meteredPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	c.metrics.download.Add(int64(n))
+	return n, addr, err
+}
+
+func (c *meteredPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	n, err := c.PacketConn.WriteTo(b, addr)
+	c.metrics.upload.Add(int64(n))
+	return n, err
+}
+
+func (c *meteredPacketConn) Close() error {
+	c.once.Do(c.metrics.closed)
+	return c.PacketConn.Close()
+}
@@ -0,0 +1,144 @@
+package outbound
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/Dreamacro/clash/transport/vless"
+	"github.com/Dreamacro/clash/transport/vmess"
+)
+
+const (
+	defaultMuxConcurrency = 8
+	maxMuxConcurrency     = 128
+)
+
+type MuxOption struct {
+	Enabled     bool `proxy:"enabled,omitempty"`
+	Concurrency int  `proxy:"concurrency,omitempty"`
+}
+
+// muxPool hands out mux.cool streams. A session takes up to concurrency
+// streams before the next one is dialed, and a session that died is
+// dropped, so the next stream dials a new one.
+type muxPool struct {
+	dial        func(ctx context.Context) (*vless.MuxSession, error)
+	concurrency int
+
+	mux      sync.Mutex
+	sessions []*vless.MuxSession
+	// closed once the session in dialing is in, nil with no dial running
+	dialing chan struct{}
+	// bumped by retire, a session dialed before doesn't join the pool
+	gen int
+}
+
+func newMuxPool(option *MuxOption, dial func(ctx context.Context) (*vless.MuxSession, error)) *muxPool {
+	concurrency := option.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultMuxConcurrency
+	} else if concurrency > maxMuxConcurrency {
+		concurrency = maxMuxConcurrency
+	}
+
+	return &muxPool{
+		dial:        dial,
+		concurrency: concurrency,
+	}
+}
+
+func (p *muxPool) openStream(ctx context.Context, dst *vmess.DstAddr) (net.Conn, error) {
+	for retry := false; ; retry = true {
+		s, err := p.session(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		st, err := s.OpenStream(dst)
+		if err == nil {
+			return st, nil
+		}
+		// the session went away under us, a fresh one gets another try
+		if retry || !s.IsClosed() {
+			return nil, err
+		}
+	}
+}
+
+// session returns the first live session with room for a stream, or dials
+// one. One dial runs at a time, the streams coming meanwhile wait for it
+// rather than dialing sessions of their own.
+func (p *muxPool) session(ctx context.Context) (*vless.MuxSession, error) {
+	for {
+		p.mux.Lock()
+		if s := p.pick(); s != nil {
+			p.mux.Unlock()
+			return s, nil
+		}
+
+		if dialing := p.dialing; dialing != nil {
+			p.mux.Unlock()
+			select {
+			case <-dialing:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		dialing := make(chan struct{})
+		p.dialing = dialing
+		gen := p.gen
+		p.mux.Unlock()
+
+		s, err := p.dial(ctx)
+
+		p.mux.Lock()
+		p.dialing = nil
+		if err == nil && gen == p.gen {
+			p.sessions = append(p.sessions, s)
+		}
+		p.mux.Unlock()
+		close(dialing)
+		return s, err
+	}
+}
+
+// pick returns the first live session with room for a stream, dropping the
+// dead ones. p.mux must be held.
+func (p *muxPool) pick() *vless.MuxSession {
+	alive := p.sessions[:0]
+	var found *vless.MuxSession
+	for _, s := range p.sessions {
+		if s.IsClosed() {
+			continue
+		}
+		alive = append(alive, s)
+		if found == nil && s.NumStreams() < p.concurrency {
+			found = s
+		}
+	}
+	for i := len(alive); i < len(p.sessions); i++ {
+		p.sessions[i] = nil
+	}
+	p.sessions = alive
+	return found
+}
+
+// retire takes the sessions out of the pool, so new streams dial a new one.
+// Those without streams are closed, the others drain and go away with the
+// idle timeout.
+func (p *muxPool) retire() {
+	p.mux.Lock()
+	sessions := p.sessions
+	p.sessions = nil
+	p.gen++
+	p.mux.Unlock()
+
+	for _, s := range sessions {
+		if s.NumStreams() == 0 {
+			s.Close()
+		}
+	}
+}
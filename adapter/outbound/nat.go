@@ -0,0 +1,237 @@
+package outbound
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	N "github.com/Dreamacro/clash/common/net"
+	"github.com/Dreamacro/clash/common/pool"
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/log"
+)
+
+const (
+	// destinations a UDP session may reach, each one holds a conn
+	natMaxDestinations = 64
+	// datagrams kept for a destination while its conn is dialed
+	natPendingPackets = 16
+)
+
+var errNATTableFull = errors.New("too many udp destinations")
+
+type natPacket struct {
+	buf  []byte
+	n    int
+	addr net.Addr
+}
+
+type natEntry struct {
+	pc      net.PacketConn // nil while dialing
+	pending [][]byte
+}
+
+// natPacketConn gives protocols whose UDP conn is bound to one destination,
+// vmess and vless, the full-cone behavior the tunnel expects from a
+// PacketConn: a datagram to a new destination dials a conn of its own, and
+// reads take from all of them.
+type natPacketConn struct {
+	dial     func(metadata *C.Metadata) (net.PacketConn, error)
+	metadata C.Metadata
+	local    net.Addr
+
+	mux     sync.Mutex
+	entries map[string]*natEntry
+	closed  bool
+
+	ch           chan natPacket
+	done         chan struct{}
+	readDeadline *N.PipeDeadline
+}
+
+// newNATPacketConn takes pc, dialed for metadata, as its first destination
+func newNATPacketConn(pc net.PacketConn, metadata *C.Metadata, dial func(metadata *C.Metadata) (net.PacketConn, error)) *natPacketConn {
+	n := &natPacketConn{
+		dial:         dial,
+		metadata:     *metadata,
+		local:        pc.LocalAddr(),
+		entries:      map[string]*natEntry{},
+		ch:           make(chan natPacket),
+		done:         make(chan struct{}),
+		readDeadline: N.MakePipeDeadline(),
+	}
+	key := metadata.UDPAddr().String()
+	n.entries[key] = &natEntry{pc: pc}
+	go n.readLoop(key, n.entries[key])
+	return n
+}
+
+func (n *natPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	key := addr.String()
+
+	n.mux.Lock()
+	if n.closed {
+		n.mux.Unlock()
+		return 0, io.ErrClosedPipe
+	}
+	entry, ok := n.entries[key]
+	if ok && entry.pc != nil {
+		n.mux.Unlock()
+		return entry.pc.WriteTo(b, addr)
+	}
+
+	if !ok {
+		udpAddr, isUDP := addr.(*net.UDPAddr)
+		if !isUDP {
+			n.mux.Unlock()
+			return 0, errors.New("udp addr invalid")
+		}
+		if len(n.entries) >= natMaxDestinations {
+			n.mux.Unlock()
+			return 0, errNATTableFull
+		}
+		entry = &natEntry{}
+		n.entries[key] = entry
+		go n.dialEntry(key, udpAddr, entry)
+	}
+	// dropped past the limit, as a full socket buffer would
+	if len(entry.pending) < natPendingPackets {
+		entry.pending = append(entry.pending, append([]byte(nil), b...))
+	}
+	n.mux.Unlock()
+	return len(b), nil
+}
+
+func (n *natPacketConn) dialEntry(key string, addr *net.UDPAddr, entry *natEntry) {
+	metadata := n.metadata
+	metadata.Host = ""
+	metadata.DstIP = addr.IP
+	metadata.DstPort = strconv.Itoa(addr.Port)
+	metadata.AddrType = C.AtypIPv6
+	if ip := addr.IP.To4(); ip != nil {
+		metadata.DstIP = ip
+		metadata.AddrType = C.AtypIPv4
+	}
+
+	pc, err := n.dial(&metadata)
+	if err != nil {
+		log.Debugln("[UDP] dial %s error: %s", key, err.Error())
+		n.remove(key, entry)
+		return
+	}
+
+	// flush what was queued meanwhile in order, then let writes through
+	for {
+		n.mux.Lock()
+		if n.closed || n.entries[key] != entry {
+			n.mux.Unlock()
+			pc.Close()
+			return
+		}
+		pending := entry.pending
+		entry.pending = nil
+		if len(pending) == 0 {
+			entry.pc = pc
+			n.mux.Unlock()
+			break
+		}
+		n.mux.Unlock()
+
+		for _, b := range pending {
+			if _, err := pc.WriteTo(b, addr); err != nil {
+				pc.Close()
+				n.remove(key, entry)
+				return
+			}
+		}
+	}
+
+	n.readLoop(key, entry)
+}
+
+// readLoop hands the datagrams of a destination to ReadFrom. A conn that
+// fails is forgotten, the next datagram to its destination dials again.
+func (n *natPacketConn) readLoop(key string, entry *natEntry) {
+	pc := entry.pc
+	defer pc.Close()
+
+	for {
+		buf := pool.Get(pool.RelayBufferSize)
+		size, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			pool.Put(buf)
+			n.remove(key, entry)
+			return
+		}
+
+		select {
+		case n.ch <- natPacket{buf: buf, n: size, addr: addr}:
+		case <-n.done:
+			pool.Put(buf)
+			return
+		}
+	}
+}
+
+func (n *natPacketConn) remove(key string, entry *natEntry) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	if n.entries[key] == entry {
+		delete(n.entries, key)
+	}
+}
+
+func (n *natPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	select {
+	case p := <-n.ch:
+		size := copy(b, p.buf[:p.n])
+		pool.Put(p.buf)
+		return size, p.addr, nil
+	case <-n.done:
+		return 0, nil, io.ErrClosedPipe
+	case <-n.readDeadline.Wait():
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+func (n *natPacketConn) Close() error {
+	n.mux.Lock()
+	if n.closed {
+		n.mux.Unlock()
+		return nil
+	}
+	n.closed = true
+	entries := n.entries
+	n.entries = map[string]*natEntry{}
+	n.mux.Unlock()
+
+	close(n.done)
+	for _, entry := range entries {
+		if entry.pc != nil {
+			entry.pc.Close()
+		}
+	}
+	return nil
+}
+
+func (n *natPacketConn) LocalAddr() net.Addr {
+	return n.local
+}
+
+func (n *natPacketConn) SetDeadline(t time.Time) error {
+	return n.SetReadDeadline(t)
+}
+
+func (n *natPacketConn) SetReadDeadline(t time.Time) error {
+	n.readDeadline.Set(t)
+	return nil
+}
+
+// SetWriteDeadline is a no-op, the conns of the destinations don't share one
+func (n *natPacketConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
@@ -0,0 +1,82 @@
+package outbound
+
+import (
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newUDPEcho(t *testing.T) *net.UDPConn {
+	pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	t.Cleanup(func() { pc.Close() })
+
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(buf[:n], addr)
+		}
+	}()
+	return pc
+}
+
+func TestNATPacketConn(t *testing.T) {
+	echoA, echoB := newUDPEcho(t), newUDPEcho(t)
+	addrA := echoA.LocalAddr().(*net.UDPAddr)
+	addrB := echoB.LocalAddr().(*net.UDPAddr)
+
+	// a conn bound to a single destination, like the vmess one
+	var dials int32
+	dial := func(metadata *C.Metadata) (net.PacketConn, error) {
+		atomic.AddInt32(&dials, 1)
+		c, err := net.DialUDP("udp", nil, metadata.UDPAddr())
+		if err != nil {
+			return nil, err
+		}
+		return &vmessPacketConn{Conn: c, rAddr: metadata.UDPAddr()}, nil
+	}
+
+	metadata := &C.Metadata{NetWork: C.UDP, AddrType: C.AtypIPv4, DstIP: addrA.IP, DstPort: strconv.Itoa(addrA.Port)}
+	first, err := dial(metadata)
+	assert.NoError(t, err)
+
+	pc := newNATPacketConn(first, metadata, dial)
+	defer pc.Close()
+
+	for _, addr := range []*net.UDPAddr{addrA, addrB, addrA} {
+		_, err := pc.WriteTo([]byte(addr.String()), addr)
+		assert.NoError(t, err)
+	}
+
+	pc.SetReadDeadline(time.Now().Add(time.Second))
+	got := map[string]int{}
+	buf := make([]byte, 1024)
+	for i := 0; i < 3; i++ {
+		n, from, err := pc.ReadFrom(buf)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, from.String(), string(buf[:n]))
+		got[from.String()]++
+	}
+	assert.Equal(t, map[string]int{addrA.String(): 2, addrB.String(): 1}, got)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&dials))
+
+	pc.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, _, err = pc.ReadFrom(buf)
+	assert.Error(t, err)
+
+	pc.Close()
+	_, err = pc.WriteTo([]byte("closed"), addrB)
+	assert.Error(t, err)
+}
@@ -0,0 +1,103 @@
+package outbound
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// id-ce-embeddedSCT, RFC 6962 section 3.3
+var oidExtensionSCT = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+
+const (
+	sctVersionV1    = 0
+	sctLogIDLen     = 32
+	sctTimestampLen = 8
+)
+
+// checkSCTs checks the server provided signed certificate timestamps, either
+// through the TLS extension / OCSP staple or embedded in the leaf certificate,
+// and that every one of them is a well formed v1 SCT. Their signatures are not
+// verified against a CT log list.
+func checkSCTs(certs []*x509.Certificate, scts [][]byte) error {
+	if len(scts) == 0 && len(certs) != 0 {
+		embedded, err := embeddedSCTs(certs[0])
+		if err != nil {
+			return fmt.Errorf("%w: %s", errNoSCT, err.Error())
+		}
+		scts = embedded
+	}
+
+	if len(scts) == 0 {
+		return errNoSCT
+	}
+	for i, sct := range scts {
+		if err := parseSCT(sct); err != nil {
+			return fmt.Errorf("%w: sct %d: %s", errNoSCT, i, err.Error())
+		}
+	}
+	return nil
+}
+
+// embeddedSCTs returns the SCTs of the SignedCertificateTimestampList of the
+// embedded SCT extension of leaf, RFC 6962 section 3.3
+func embeddedSCTs(leaf *x509.Certificate) ([][]byte, error) {
+	for _, ext := range leaf.Extensions {
+		if !ext.Id.Equal(oidExtensionSCT) {
+			continue
+		}
+
+		var value []byte
+		if rest, err := asn1.Unmarshal(ext.Value, &value); err != nil || len(rest) != 0 {
+			return nil, errors.New("malformed sct extension")
+		}
+
+		var list, sct cryptobyte.String
+		s := cryptobyte.String(value)
+		if !s.ReadUint16LengthPrefixed(&list) || !s.Empty() {
+			return nil, errors.New("malformed sct list")
+		}
+		var scts [][]byte
+		for !list.Empty() {
+			if !list.ReadUint16LengthPrefixed(&sct) {
+				return nil, errors.New("malformed sct list")
+			}
+			scts = append(scts, sct)
+		}
+		return scts, nil
+	}
+	return nil, nil
+}
+
+// parseSCT checks b is a SignedCertificateTimestamp v1 with a signature,
+// RFC 6962 section 3.2
+func parseSCT(b []byte) error {
+	var (
+		version, hash, signature uint8
+		logID, timestamp         []byte
+		extensions, sig          cryptobyte.String
+	)
+	s := cryptobyte.String(b)
+	if !s.ReadUint8(&version) {
+		return errors.New("empty")
+	}
+	if version != sctVersionV1 {
+		return fmt.Errorf("unknown version %d", version)
+	}
+	if !s.ReadBytes(&logID, sctLogIDLen) ||
+		!s.ReadBytes(&timestamp, sctTimestampLen) ||
+		!s.ReadUint16LengthPrefixed(&extensions) ||
+		!s.ReadUint8(&hash) ||
+		!s.ReadUint8(&signature) ||
+		!s.ReadUint16LengthPrefixed(&sig) ||
+		!s.Empty() {
+		return errors.New("malformed")
+	}
+	if len(sig) == 0 {
+		return errors.New("no signature")
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package outbound
+
+import (
+	"crypto/tls"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/cryptobyte"
+)
+
+func newTestSCT(signature []byte) []byte {
+	b := cryptobyte.NewBuilder(nil)
+	b.AddUint8(sctVersionV1)
+	b.AddBytes(make([]byte, sctLogIDLen))
+	b.AddBytes(make([]byte, sctTimestampLen))
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {})
+	b.AddUint8(4) // sha256
+	b.AddUint8(3) // ecdsa
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(signature) })
+	return b.BytesOrPanic()
+}
+
+func newTestSCTExtension(t *testing.T, scts ...[]byte) pkix.Extension {
+	b := cryptobyte.NewBuilder(nil)
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		for _, sct := range scts {
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(sct) })
+		}
+	})
+	value, err := asn1.Marshal(b.BytesOrPanic())
+	assert.Nil(t, err)
+	return pkix.Extension{Id: oidExtensionSCT, Value: value}
+}
+
+func TestParseSCT(t *testing.T) {
+	sct := newTestSCT([]byte("signature"))
+	assert.Nil(t, parseSCT(sct))
+
+	assert.NotNil(t, parseSCT(nil))
+	assert.NotNil(t, parseSCT(sct[:len(sct)-1]))
+	assert.NotNil(t, parseSCT(append(sct, 0)))
+	assert.NotNil(t, parseSCT(newTestSCT(nil)))
+
+	v2 := append([]byte{}, sct...)
+	v2[0] = 1
+	assert.NotNil(t, parseSCT(v2))
+}
+
+func TestVless_RequireSCT(t *testing.T) {
+	valid := newTestSCT([]byte("signature"))
+
+	v := newTestVless(t, VlessOption{TLS: true, SkipCertVerify: true, RequireSCT: true})
+	handshake := func(cert tls.Certificate) error {
+		return tlsHandshake(v, cert)
+	}
+	withSCTs := func(cert tls.Certificate, scts ...[]byte) tls.Certificate {
+		cert.SignedCertificateTimestamps = scts
+		return cert
+	}
+
+	// through the tls extension
+	cert := newTestCert(t, "vless.example.com")
+	assert.Nil(t, handshake(withSCTs(cert, valid)))
+	assert.ErrorIs(t, handshake(cert), errNoSCT)
+	assert.ErrorIs(t, handshake(withSCTs(cert, valid, []byte{sctVersionV1})), errNoSCT)
+	assert.ErrorIs(t, handshake(withSCTs(cert, newTestSCT(nil))), errNoSCT)
+
+	// embedded in the certificate
+	assert.Nil(t, handshake(newTestCertWithExtensions(t, "vless.example.com", newTestSCTExtension(t, valid))))
+	assert.ErrorIs(t, handshake(newTestCertWithExtensions(t, "vless.example.com", newTestSCTExtension(t))), errNoSCT)
+	assert.ErrorIs(t, handshake(newTestCertWithExtensions(t, "vless.example.com", newTestSCTExtension(t, valid[:10]))), errNoSCT)
+	malformed := pkix.Extension{Id: oidExtensionSCT, Value: []byte{0x04, 0x01, 0x00}}
+	assert.ErrorIs(t, handshake(newTestCertWithExtensions(t, "vless.example.com", malformed)), errNoSCT)
+
+	// not checked unless required
+	v = newTestVless(t, VlessOption{TLS: true, SkipCertVerify: true})
+	assert.Nil(t, tlsHandshake(v, cert))
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/Dreamacro/clash/common/structure"
 	"github.com/Dreamacro/clash/component/dialer"
+	"github.com/Dreamacro/clash/component/shadowtls"
 	C "github.com/Dreamacro/clash/constant"
 	obfs "github.com/Dreamacro/clash/transport/simple-obfs"
 	"github.com/Dreamacro/clash/transport/socks5"
@@ -22,20 +23,22 @@ type ShadowSocks struct {
 	cipher core.Cipher
 
 	// obfs
-	obfsMode    string
-	obfsOption  *simpleObfsOption
-	v2rayOption *v2rayObfs.Option
+	obfsMode        string
+	obfsOption      *simpleObfsOption
+	v2rayOption     *v2rayObfs.Option
+	shadowTLSOption *shadowtls.Option
 }
 
 type ShadowSocksOption struct {
-	Name       string                 `proxy:"name"`
-	Server     string                 `proxy:"server"`
-	Port       int                    `proxy:"port"`
-	Password   string                 `proxy:"password"`
-	Cipher     string                 `proxy:"cipher"`
-	UDP        bool                   `proxy:"udp,omitempty"`
-	Plugin     string                 `proxy:"plugin,omitempty"`
-	PluginOpts map[string]interface{} `proxy:"plugin-opts,omitempty"`
+	BasicOption `proxy:",squash"`
+	Name        string                 `proxy:"name"`
+	Server      string                 `proxy:"server"`
+	Port        int                    `proxy:"port"`
+	Password    string                 `proxy:"password"`
+	Cipher      string                 `proxy:"cipher"`
+	UDP         bool                   `proxy:"udp,omitempty"`
+	Plugin      string                 `proxy:"plugin,omitempty"`
+	PluginOpts  map[string]interface{} `proxy:"plugin-opts,omitempty"`
 }
 
 type simpleObfsOption struct {
@@ -53,6 +56,13 @@ type v2rayObfsOption struct {
 	Mux            bool              `obfs:"mux,omitempty"`
 }
 
+type shadowTLSOption struct {
+	Password       string `obfs:"password"`
+	Host           string `obfs:"host"`
+	Version        int    `obfs:"version,omitempty"`
+	SkipCertVerify bool   `obfs:"skip-cert-verify,omitempty"`
+}
+
 // StreamConn implements C.ProxyAdapter
 func (ss *ShadowSocks) StreamConn(c net.Conn, metadata *C.Metadata) (net.Conn, error) {
 	switch ss.obfsMode {
@@ -67,6 +77,12 @@ func (ss *ShadowSocks) StreamConn(c net.Conn, metadata *C.Metadata) (net.Conn, e
 		if err != nil {
 			return nil, fmt.Errorf("%s connect error: %w", ss.addr, err)
 		}
+	case "shadow-tls":
+		var err error
+		c, err = shadowtls.NewShadowTLS(c, ss.shadowTLSOption)
+		if err != nil {
+			return nil, fmt.Errorf("%s connect error: %w", ss.addr, err)
+		}
 	}
 	c = ss.cipher.StreamConn(c)
 	_, err := c.Write(serializesSocksAddr(metadata))
@@ -75,7 +91,7 @@ func (ss *ShadowSocks) StreamConn(c net.Conn, metadata *C.Metadata) (net.Conn, e
 
 // DialContext implements C.ProxyAdapter
 func (ss *ShadowSocks) DialContext(ctx context.Context, metadata *C.Metadata) (_ C.Conn, err error) {
-	c, err := dialer.DialContext(ctx, "tcp", ss.addr)
+	c, err := dialer.DialContextWithOptions(ctx, "tcp", ss.addr, ss.DialOptions()...)
 	if err != nil {
 		return nil, fmt.Errorf("%s connect error: %w", ss.addr, err)
 	}
@@ -89,12 +105,12 @@ func (ss *ShadowSocks) DialContext(ctx context.Context, metadata *C.Metadata) (_
 
 // DialUDP implements C.ProxyAdapter
 func (ss *ShadowSocks) DialUDP(metadata *C.Metadata) (C.PacketConn, error) {
-	pc, err := dialer.ListenPacket("udp", "")
+	pc, err := dialer.ListenPacketWithOptions("udp", "", ss.DialOptions()...)
 	if err != nil {
 		return nil, err
 	}
 
-	addr, err := resolveUDPAddr("udp", ss.addr)
+	addr, err := resolveUDPAddr("udp", ss.addr, ss.DialOptions()...)
 	if err != nil {
 		pc.Close()
 		return nil, err
@@ -115,6 +131,7 @@ func NewShadowSocks(option ShadowSocksOption) (*ShadowSocks, error) {
 
 	var v2rayOption *v2rayObfs.Option
 	var obfsOption *simpleObfsOption
+	var shadowTLS *shadowtls.Option
 	obfsMode := ""
 
 	decoder := structure.NewDecoder(structure.Option{TagName: "obfs", WeaklyTypedInput: true})
@@ -150,20 +167,42 @@ func NewShadowSocks(option ShadowSocksOption) (*ShadowSocks, error) {
 			v2rayOption.TLS = true
 			v2rayOption.SkipCertVerify = opts.SkipCertVerify
 		}
+	} else if option.Plugin == "shadow-tls" {
+		opts := shadowTLSOption{Version: 3}
+		if err := decoder.Decode(option.PluginOpts, &opts); err != nil {
+			return nil, fmt.Errorf("ss %s initialize shadow-tls error: %w", addr, err)
+		}
+
+		if opts.Version != 3 {
+			return nil, fmt.Errorf("ss %s shadow-tls version error: %d, only 3 is supported", addr, opts.Version)
+		}
+		obfsMode = option.Plugin
+		shadowTLS = &shadowtls.Option{
+			Password:       opts.Password,
+			Host:           opts.Host,
+			SkipCertVerify: opts.SkipCertVerify,
+		}
+	}
+
+	dialOptions, err := option.dialOptions()
+	if err != nil {
+		return nil, err
 	}
 
 	return &ShadowSocks{
 		Base: &Base{
-			name: option.Name,
-			addr: addr,
-			tp:   C.Shadowsocks,
-			udp:  option.UDP,
+			name:        option.Name,
+			addr:        addr,
+			tp:          C.Shadowsocks,
+			udp:         option.UDP,
+			dialOptions: dialOptions,
 		},
 		cipher: ciph,
 
-		obfsMode:    obfsMode,
-		v2rayOption: v2rayOption,
-		obfsOption:  obfsOption,
+		obfsMode:        obfsMode,
+		v2rayOption:     v2rayOption,
+		obfsOption:      obfsOption,
+		shadowTLSOption: shadowTLS,
 	}, nil
 }
 
@@ -24,6 +24,7 @@ type ShadowSocksR struct {
 }
 
 type ShadowSocksROption struct {
+	BasicOption   `proxy:",squash"`
 	Name          string `proxy:"name"`
 	Server        string `proxy:"server"`
 	Port          int    `proxy:"port"`
@@ -60,7 +61,7 @@ func (ssr *ShadowSocksR) StreamConn(c net.Conn, metadata *C.Metadata) (net.Conn,
 
 // DialContext implements C.ProxyAdapter
 func (ssr *ShadowSocksR) DialContext(ctx context.Context, metadata *C.Metadata) (_ C.Conn, err error) {
-	c, err := dialer.DialContext(ctx, "tcp", ssr.addr)
+	c, err := dialer.DialContextWithOptions(ctx, "tcp", ssr.addr, ssr.DialOptions()...)
 	if err != nil {
 		return nil, fmt.Errorf("%s connect error: %w", ssr.addr, err)
 	}
@@ -74,12 +75,12 @@ func (ssr *ShadowSocksR) DialContext(ctx context.Context, metadata *C.Metadata)
 
 // DialUDP implements C.ProxyAdapter
 func (ssr *ShadowSocksR) DialUDP(metadata *C.Metadata) (C.PacketConn, error) {
-	pc, err := dialer.ListenPacket("udp", "")
+	pc, err := dialer.ListenPacketWithOptions("udp", "", ssr.DialOptions()...)
 	if err != nil {
 		return nil, err
 	}
 
-	addr, err := resolveUDPAddr("udp", ssr.addr)
+	addr, err := resolveUDPAddr("udp", ssr.addr, ssr.DialOptions()...)
 	if err != nil {
 		pc.Close()
 		return nil, err
@@ -134,12 +135,18 @@ func NewShadowSocksR(option ShadowSocksROption) (*ShadowSocksR, error) {
 		return nil, fmt.Errorf("ssr %s initialize protocol error: %w", addr, err)
 	}
 
+	dialOptions, err := option.dialOptions()
+	if err != nil {
+		return nil, err
+	}
+
 	return &ShadowSocksR{
 		Base: &Base{
-			name: option.Name,
-			addr: addr,
-			tp:   C.ShadowsocksR,
-			udp:  option.UDP,
+			name:        option.Name,
+			addr:        addr,
+			tp:          C.ShadowsocksR,
+			udp:         option.UDP,
+			dialOptions: dialOptions,
 		},
 		cipher:   coreCiph,
 		obfs:     obfs,
@@ -22,12 +22,13 @@ type Snell struct {
 }
 
 type SnellOption struct {
-	Name     string                 `proxy:"name"`
-	Server   string                 `proxy:"server"`
-	Port     int                    `proxy:"port"`
-	Psk      string                 `proxy:"psk"`
-	Version  int                    `proxy:"version,omitempty"`
-	ObfsOpts map[string]interface{} `proxy:"obfs-opts,omitempty"`
+	BasicOption `proxy:",squash"`
+	Name        string                 `proxy:"name"`
+	Server      string                 `proxy:"server"`
+	Port        int                    `proxy:"port"`
+	Psk         string                 `proxy:"psk"`
+	Version     int                    `proxy:"version,omitempty"`
+	ObfsOpts    map[string]interface{} `proxy:"obfs-opts,omitempty"`
 }
 
 type streamOption struct {
@@ -72,7 +73,7 @@ func (s *Snell) DialContext(ctx context.Context, metadata *C.Metadata) (_ C.Conn
 		return NewConn(c, s), err
 	}
 
-	c, err := dialer.DialContext(ctx, "tcp", s.addr)
+	c, err := dialer.DialContextWithOptions(ctx, "tcp", s.addr, s.DialOptions()...)
 	if err != nil {
 		return nil, fmt.Errorf("%s connect error: %w", s.addr, err)
 	}
@@ -109,11 +110,17 @@ func NewSnell(option SnellOption) (*Snell, error) {
 		return nil, fmt.Errorf("snell version error: %d", option.Version)
 	}
 
+	dialOptions, err := option.dialOptions()
+	if err != nil {
+		return nil, err
+	}
+
 	s := &Snell{
 		Base: &Base{
-			name: option.Name,
-			addr: addr,
-			tp:   C.Snell,
+			name:        option.Name,
+			addr:        addr,
+			tp:          C.Snell,
+			dialOptions: dialOptions,
 		},
 		psk:        psk,
 		obfsOption: obfsOption,
@@ -122,7 +129,7 @@ func NewSnell(option SnellOption) (*Snell, error) {
 
 	if option.Version == snell.Version2 {
 		s.pool = snell.NewPool(func(ctx context.Context) (*snell.Snell, error) {
-			c, err := dialer.DialContext(ctx, "tcp", addr)
+			c, err := dialer.DialContextWithOptions(ctx, "tcp", addr, s.DialOptions()...)
 			if err != nil {
 				return nil, err
 			}
@@ -25,6 +25,7 @@ type Socks5 struct {
 }
 
 type Socks5Option struct {
+	BasicOption    `proxy:",squash"`
 	Name           string `proxy:"name"`
 	Server         string `proxy:"server"`
 	Port           int    `proxy:"port"`
@@ -61,7 +62,7 @@ func (ss *Socks5) StreamConn(c net.Conn, metadata *C.Metadata) (net.Conn, error)
 
 // DialContext implements C.ProxyAdapter
 func (ss *Socks5) DialContext(ctx context.Context, metadata *C.Metadata) (_ C.Conn, err error) {
-	c, err := dialer.DialContext(ctx, "tcp", ss.addr)
+	c, err := dialer.DialContextWithOptions(ctx, "tcp", ss.addr, ss.DialOptions()...)
 	if err != nil {
 		return nil, fmt.Errorf("%s connect error: %w", ss.addr, err)
 	}
@@ -81,7 +82,7 @@ func (ss *Socks5) DialContext(ctx context.Context, metadata *C.Metadata) (_ C.Co
 func (ss *Socks5) DialUDP(metadata *C.Metadata) (_ C.PacketConn, err error) {
 	ctx, cancel := context.WithTimeout(context.Background(), C.DefaultTCPTimeout)
 	defer cancel()
-	c, err := dialer.DialContext(ctx, "tcp", ss.addr)
+	c, err := dialer.DialContextWithOptions(ctx, "tcp", ss.addr, ss.DialOptions()...)
 	if err != nil {
 		err = fmt.Errorf("%s connect error: %w", ss.addr, err)
 		return
@@ -110,7 +111,7 @@ func (ss *Socks5) DialUDP(metadata *C.Metadata) (_ C.PacketConn, err error) {
 		return
 	}
 
-	pc, err := dialer.ListenPacket("udp", "")
+	pc, err := dialer.ListenPacketWithOptions("udp", "", ss.DialOptions()...)
 	if err != nil {
 		return
 	}
@@ -129,7 +130,7 @@ func (ss *Socks5) DialUDP(metadata *C.Metadata) (_ C.PacketConn, err error) {
 		err = errors.New("invalid UDP bind address")
 		return
 	} else if bindUDPAddr.IP.IsUnspecified() {
-		serverAddr, err := resolveUDPAddr("udp", ss.Addr())
+		serverAddr, err := resolveUDPAddr("udp", ss.Addr(), ss.DialOptions()...)
 		if err != nil {
 			return nil, err
 		}
@@ -140,7 +141,12 @@ func (ss *Socks5) DialUDP(metadata *C.Metadata) (_ C.PacketConn, err error) {
 	return newPacketConn(&socksPacketConn{PacketConn: pc, rAddr: bindUDPAddr, tcpConn: c}, ss), nil
 }
 
-func NewSocks5(option Socks5Option) *Socks5 {
+func NewSocks5(option Socks5Option) (*Socks5, error) {
+	dialOptions, err := option.dialOptions()
+	if err != nil {
+		return nil, err
+	}
+
 	var tlsConfig *tls.Config
 	if option.TLS {
 		tlsConfig = &tls.Config{
@@ -151,17 +157,18 @@ func NewSocks5(option Socks5Option) *Socks5 {
 
 	return &Socks5{
 		Base: &Base{
-			name: option.Name,
-			addr: net.JoinHostPort(option.Server, strconv.Itoa(option.Port)),
-			tp:   C.Socks5,
-			udp:  option.UDP,
+			name:        option.Name,
+			addr:        net.JoinHostPort(option.Server, strconv.Itoa(option.Port)),
+			tp:          C.Socks5,
+			udp:         option.UDP,
+			dialOptions: dialOptions,
 		},
 		user:           option.UserName,
 		pass:           option.Password,
 		tls:            option.TLS,
 		skipCertVerify: option.SkipCertVerify,
 		tlsConfig:      tlsConfig,
-	}
+	}, nil
 }
 
 type socksPacketConn struct {
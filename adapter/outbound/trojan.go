@@ -3,11 +3,13 @@ package outbound
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
 	"strconv"
 
 	"github.com/Dreamacro/clash/component/dialer"
+	"github.com/Dreamacro/clash/component/fingerprint"
 	C "github.com/Dreamacro/clash/constant"
 	"github.com/Dreamacro/clash/transport/gun"
 	"github.com/Dreamacro/clash/transport/trojan"
@@ -27,6 +29,7 @@ type Trojan struct {
 }
 
 type TrojanOption struct {
+	BasicOption    `proxy:",squash"`
 	Name           string      `proxy:"name"`
 	Server         string      `proxy:"server"`
 	Port           int         `proxy:"port"`
@@ -38,6 +41,8 @@ type TrojanOption struct {
 	UDP            bool        `proxy:"udp,omitempty"`
 	Network        string      `proxy:"network,omitempty"`
 	GrpcOpts       GrpcOptions `proxy:"grpc-opts,omitempty"`
+	// ClientFingerprint presents the ClientHello of a browser
+	ClientFingerprint string `proxy:"client-fingerprint,omitempty"`
 }
 
 // StreamConn implements C.ProxyAdapter
@@ -86,7 +91,7 @@ func (t *Trojan) DialContext(ctx context.Context, metadata *C.Metadata) (_ C.Con
 		return NewConn(c, t), nil
 	}
 
-	c, err := dialer.DialContext(ctx, "tcp", t.addr)
+	c, err := dialer.DialContextWithOptions(ctx, "tcp", t.addr, t.DialOptions()...)
 	if err != nil {
 		return nil, fmt.Errorf("%s connect error: %w", t.addr, err)
 	}
@@ -120,7 +125,7 @@ func (t *Trojan) DialUDP(metadata *C.Metadata) (_ C.PacketConn, err error) {
 	} else {
 		ctx, cancel := context.WithTimeout(context.Background(), C.DefaultTCPTimeout)
 		defer cancel()
-		c, err = dialer.DialContext(ctx, "tcp", t.addr)
+		c, err = dialer.DialContextWithOptions(ctx, "tcp", t.addr, t.DialOptions()...)
 		if err != nil {
 			return nil, fmt.Errorf("%s connect error: %w", t.addr, err)
 		}
@@ -145,30 +150,51 @@ func NewTrojan(option TrojanOption) (*Trojan, error) {
 	addr := net.JoinHostPort(option.Server, strconv.Itoa(option.Port))
 
 	tOption := &trojan.Option{
-		Password:            option.Password,
-		Flow:                option.Flow,
-		ALPN:                option.ALPN,
-		ServerName:          option.Server,
-		SkipCertVerify:      option.SkipCertVerify,
+		Password:       option.Password,
+		Flow:           option.Flow,
+		ALPN:           option.ALPN,
+		ServerName:     option.Server,
+		SkipCertVerify: option.SkipCertVerify,
 	}
 
 	if option.SNI != "" {
 		tOption.ServerName = option.SNI
 	}
 
+	clientHello, err := fingerprint.Parse(option.ClientFingerprint)
+	if err != nil {
+		return nil, err
+	}
+	if clientHello != nil {
+		switch {
+		case option.Network == "grpc":
+			return nil, errors.New("client-fingerprint is not supported with grpc")
+		case option.Flow != "":
+			return nil, fmt.Errorf("client-fingerprint is not supported with %s", option.Flow)
+		}
+		tOption.ClientFingerprint = clientHello
+		tOption.ClientSessionCache = getClientUSessionCache()
+	}
+
+	dialOptions, err := option.dialOptions()
+	if err != nil {
+		return nil, err
+	}
+
 	t := &Trojan{
 		Base: &Base{
-			name: option.Name,
-			addr: addr,
-			tp:   C.Trojan,
-			udp:  option.UDP,
+			name:        option.Name,
+			addr:        addr,
+			tp:          C.Trojan,
+			udp:         option.UDP,
+			dialOptions: dialOptions,
 		},
 		instance: trojan.New(tOption),
 	}
 
 	if option.Network == "grpc" {
 		dialFn := func(network, addr string) (net.Conn, error) {
-			c, err := dialer.DialContext(context.Background(), "tcp", t.addr)
+			c, err := dialer.DialContextWithOptions(context.Background(), "tcp", t.addr, t.DialOptions()...)
 			if err != nil {
 				return nil, fmt.Errorf("%s connect error: %s", t.addr, err.Error())
 			}
@@ -3,27 +3,58 @@ package outbound
 import (
 	"bytes"
 	"crypto/tls"
+	"encoding/json"
+	"fmt"
 	"net"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/Dreamacro/clash/component/resolver"
+	"github.com/Dreamacro/clash/component/dialer"
 	C "github.com/Dreamacro/clash/constant"
 	"github.com/Dreamacro/clash/transport/socks5"
+	utls "github.com/refraction-networking/utls"
 	xtls "github.com/xtls/go"
 )
 
 const (
 	tcpTimeout = 5 * time.Second
+
+	// placeholder for credentials in option dumps
+	redactedSecret = "<redacted>"
 )
 
 var (
-	globalClientSessionCache tls.ClientSessionCache
+	globalClientSessionCache  tls.ClientSessionCache
 	globalClientXSessionCache xtls.ClientSessionCache
+	globalClientUSessionCache utls.ClientSessionCache
 	once                      sync.Once
 )
 
+// the session caches are shared by all nodes, both are created together on
+// first use so concurrent first dials can't race on them
+func initSessionCaches() {
+	globalClientSessionCache = tls.NewLRUClientSessionCache(128)
+	globalClientXSessionCache = xtls.NewLRUClientSessionCache(128)
+	globalClientUSessionCache = utls.NewLRUClientSessionCache(128)
+}
+
+func getClientSessionCache() tls.ClientSessionCache {
+	once.Do(initSessionCaches)
+	return globalClientSessionCache
+}
+
+func getClientXSessionCache() xtls.ClientSessionCache {
+	once.Do(initSessionCaches)
+	return globalClientXSessionCache
+}
+
+func getClientUSessionCache() utls.ClientSessionCache {
+	once.Do(initSessionCaches)
+	return globalClientUSessionCache
+}
+
 func tcpKeepAlive(c net.Conn) {
 	if tcp, ok := c.(*net.TCPConn); ok {
 		tcp.SetKeepAlive(true)
@@ -51,13 +82,13 @@ func serializesSocksAddr(metadata *C.Metadata) []byte {
 	return bytes.Join(buf, nil)
 }
 
-func resolveUDPAddr(network, address string) (*net.UDPAddr, error) {
+func resolveUDPAddr(network, address string, options ...dialer.Option) (*net.UDPAddr, error) {
 	host, port, err := net.SplitHostPort(address)
 	if err != nil {
 		return nil, err
 	}
 
-	ip, err := resolver.ResolveIP(host)
+	ip, err := dialer.ResolveIP(host, options...)
 	if err != nil {
 		return nil, err
 	}
@@ -69,3 +100,89 @@ func safeConnClose(c net.Conn, err error) {
 		c.Close()
 	}
 }
+
+func copyStringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// credentialHeaderWords are what the names of headers carrying credentials
+// are made of, matched case-insensitively
+var credentialHeaderWords = []string{"auth", "cookie", "token", "secret", "key", "password", "session"}
+
+// redactHeaders copies the headers m, with the values of those that look like
+// credentials redacted
+func redactHeaders(m map[string]string) map[string]string {
+	c := copyStringMap(m)
+	for k := range c {
+		name := strings.ToLower(k)
+		for _, word := range credentialHeaderWords {
+			if strings.Contains(name, word) {
+				c[k] = redactedSecret
+				break
+			}
+		}
+	}
+	return c
+}
+
+// Duration is a timeout option. It takes a Go duration string ("10s",
+// "500ms"), or a bare integer in the unit the option historically used.
+type Duration struct {
+	dur time.Duration
+	n   int
+}
+
+// DurationOf returns a Duration that resolves to d regardless of unit
+func DurationOf(d time.Duration) Duration {
+	return Duration{dur: d}
+}
+
+// Of resolves the duration, bare integers are taken as multiples of unit
+func (d Duration) Of(unit time.Duration) time.Duration {
+	if d.dur != 0 {
+		return d.dur
+	}
+	return time.Duration(d.n) * unit
+}
+
+// IsSet reports whether a positive duration was configured
+func (d Duration) IsSet() bool {
+	return d.dur > 0 || d.n > 0
+}
+
+// UnmarshalStructure implements structure.Unmarshaler
+func (d *Duration) UnmarshalStructure(data interface{}) error {
+	switch v := data.(type) {
+	case int:
+		*d = Duration{n: v}
+	case string:
+		if n, err := strconv.Atoi(v); err == nil {
+			*d = Duration{n: n}
+			return nil
+		}
+		dur, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = Duration{dur: dur}
+	default:
+		return fmt.Errorf("expected duration, got %T", data)
+	}
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler
+func (d Duration) MarshalJSON() ([]byte, error) {
+	if d.dur != 0 {
+		return json.Marshal(d.dur.String())
+	}
+	return json.Marshal(d.n)
+}
@@ -0,0 +1,37 @@
+package outbound
+
+import (
+	"crypto/tls"
+	"net"
+
+	"github.com/Dreamacro/clash/component/fingerprint"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+// utlsConfig is the uTLS counterpart of config, sharing the session cache
+// of the uTLS conns of every adapter
+func utlsConfig(config *tls.Config) *utls.Config {
+	uconfig := fingerprint.Config(config)
+	uconfig.ClientSessionCache = getClientUSessionCache()
+	return uconfig
+}
+
+// utlsHandshake runs a TLS handshake on c with the ClientHello of a browser,
+// alpn as for fingerprint.Handshake.
+func (v *Vless) utlsHandshake(c net.Conn, alpn []string) (*utls.UConn, error) {
+	uconn, err := fingerprint.Handshake(c, utlsConfig(v.tlsConfig), *v.clientHello, alpn)
+	if err != nil {
+		return nil, err
+	}
+
+	// uTLS has no VerifyConnection, check the certificate transparency here
+	if v.option.RequireSCT {
+		cs := uconn.ConnectionState()
+		if err := v.verifyConnection(cs.PeerCertificates, cs.SignedCertificateTimestamps); err != nil {
+			uconn.Close()
+			return nil, err
+		}
+	}
+	return uconn, nil
+}
@@ -1,75 +1,340 @@
 package outbound
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
 	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/Dreamacro/clash/common/pool"
 	"github.com/Dreamacro/clash/component/dialer"
+	"github.com/Dreamacro/clash/component/fingerprint"
 	"github.com/Dreamacro/clash/component/resolver"
-	"github.com/Dreamacro/clash/transport/gun"
 	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/log"
+	"github.com/Dreamacro/clash/transport/gun"
 	"github.com/Dreamacro/clash/transport/vless"
 	"github.com/Dreamacro/clash/transport/vmess"
+	"github.com/Dreamacro/clash/tunnel/statistic"
+	utls "github.com/refraction-networking/utls"
 	xtls "github.com/xtls/go"
+	"go.uber.org/atomic"
 
 	"golang.org/x/net/http2"
 )
 
 const (
+	// failed resumptions before a cached tls session is dropped
+	maxTicketRejections = 2
+
 	// max packet length
 	maxLength = 8192
 )
 
-var bufPool = sync.Pool{New: func() interface{} { return &bytes.Buffer{} }}
+var (
+	bufPool = sync.Pool{New: func() interface{} { return &bytes.Buffer{} }}
+
+	errNoSCT = errors.New("server provided no well formed signed certificate timestamp")
+
+	errTLSDowngrade = errors.New("tls is enabled but the connection has no tls session")
+
+	errHandshakeTooLarge = errors.New("server sent too much data during the handshake, it doesn't look like a vless server")
+
+	// ErrEarlyClose is returned by DialContext when the server closes the
+	// connection within early-close-window after the handshake.
+	ErrEarlyClose = errors.New("vless server closed the connection right after the handshake")
+)
+
+// Dialer opens the connections to vless servers.
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// optionDialer is a Dialer that honors the interface-name and routing-mark
+// of a node. A Dialer set by SetDefaultDialer without it dials them all
+// the same.
+type optionDialer interface {
+	DialContextWithOptions(ctx context.Context, network, address string, options ...dialer.Option) (net.Conn, error)
+}
+
+type systemDialer struct{}
+
+func (systemDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return dialer.DialContext(ctx, network, address)
+}
+
+func (systemDialer) DialContextWithOptions(ctx context.Context, network, address string, options ...dialer.Option) (net.Conn, error) {
+	return dialer.DialContextWithOptions(ctx, network, address, options...)
+}
+
+type dialerFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+func (f dialerFunc) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return f(ctx, network, address)
+}
+
+type dialerHolder struct {
+	Dialer
+}
+
+var defaultDialer atomic.Value
+
+func init() {
+	defaultDialer.Store(dialerHolder{systemDialer{}})
+}
+
+// SetDefaultDialer replaces the dialer used by vless nodes to reach their
+// servers, nil restores the system dialer. It returns a function restoring
+// the previous one, so tests can simulate network conditions:
+//
+//	restore := outbound.SetDefaultDialer(flakyDialer)
+//	defer restore()
+func SetDefaultDialer(d Dialer) (restore func()) {
+	if d == nil {
+		d = systemDialer{}
+	}
+
+	prev := defaultDialer.Load().(dialerHolder)
+	defaultDialer.Store(dialerHolder{d})
+	return func() {
+		defaultDialer.Store(prev)
+	}
+}
+
+func loadDefaultDialer() Dialer {
+	return defaultDialer.Load().(dialerHolder).Dialer
+}
 
 type Vless struct {
 	*Base
 	client *vless.Client
 	option *VlessOption
 
+	tlsConfig  *tls.Config
+	xtlsConfig *xtls.Config
+
+	// set when the chain is verified by verifyPeerCertificate
+	verifyChain bool
+	certSerial  *big.Int
+	pinSHA256   []byte
+
 	// for gun mux
 	gunTLSConfig *tls.Config
 	gunConfig    *gun.Config
 	transport    *http2.Transport
+
+	// guards the endpoint (addr, option.Server, option.Port and transport),
+	// which can be changed by UpdateServer
+	serverMux sync.RWMutex
+
+	// connect latency EWMA in nanoseconds, addr -> *atomic.Int64
+	latencies sync.Map
+
+	metrics  *adapterMetrics
+	fragment []vless.Fragment
+	forceDst *C.Metadata
+	rootCAs  *x509.CertPool
+
+	// browser ClientHello for client-fingerprint, nil for crypto/tls
+	clientHello *utls.ClientHelloID
+
+	// nil unless mux is enabled
+	muxPool *muxPool
+
+	// failed resumptions per session cache key
+	ticketMux        sync.Mutex
+	ticketRejections map[string]int
+
+	// for least-load, clients[0] is the primary uuid followed by uuids.
+	// loads counts the active connections per server and client index
+	clients []*vless.Client
+	loadMux sync.Mutex
+	loads   map[loadKey]int
 }
 
 type VlessOption struct {
-	Name           string            `proxy:"name"`
-	Server         string            `proxy:"server"`
-	Port           int               `proxy:"port"`
-	UUID           string            `proxy:"uuid"`
-	UDP            bool              `proxy:"udp,omitempty"`
-	TLS            bool              `proxy:"tls,omitempty"`
-	Network        string            `proxy:"network,omitempty"`
-	WSOpts         WSOptions         `proxy:"ws-opts,omitempty"`
-	WSPath         string            `proxy:"ws-path,omitempty"`
-	WSHeaders      map[string]string `proxy:"ws-headers,omitempty"`
-	SkipCertVerify bool              `proxy:"skip-cert-verify,omitempty"`
-	ServerName     string            `proxy:"servername,omitempty"`
-	Flow           string            `proxy:"flow,omitempty"`
-	GrpcOpts       GrpcOptions       `proxy:"grpc-opts,omitempty"`
+	BasicOption           `proxy:",squash"`
+	Name                  string            `proxy:"name"`
+	Server                string            `proxy:"server"`
+	Port                  int               `proxy:"port"`
+	UUID                  string            `proxy:"uuid"`
+	UDP                   bool              `proxy:"udp,omitempty"`
+	TLS                   bool              `proxy:"tls,omitempty"`
+	Network               string            `proxy:"network,omitempty"`
+	WSOpts                WSOptions         `proxy:"ws-opts,omitempty"`
+	WSPath                string            `proxy:"ws-path,omitempty"`
+	WSHeaders             map[string]string `proxy:"ws-headers,omitempty"`
+	SkipCertVerify        bool              `proxy:"skip-cert-verify,omitempty"`
+	ServerName            string            `proxy:"servername,omitempty"`
+	Flow                  string            `proxy:"flow,omitempty"`
+	GrpcOpts              GrpcOptions       `proxy:"grpc-opts,omitempty"`
+	RequireSCT            bool              `proxy:"require-sct,omitempty"`
+	VerifyHost            string            `proxy:"verify-host,omitempty"`
+	LogRejected           bool              `proxy:"log-rejected,omitempty"`
+	UDPReadAhead          int               `proxy:"udp-read-ahead,omitempty"`
+	CertSerial            string            `proxy:"cert-serial,omitempty"`
+	Servers               []string          `proxy:"servers,omitempty"`
+	MultiServerStrategy   string            `proxy:"multi-server-strategy,omitempty"`
+	EarlyCloseWindow      Duration          `proxy:"early-close-window,omitempty"`
+	UDPPadTo              int               `proxy:"udp-pad-to,omitempty"`
+	Migrate               bool              `proxy:"migrate,omitempty"`
+	FakeIPMapping         string            `proxy:"fake-ip-mapping,omitempty"`
+	MaxConnectionLifetime Duration          `proxy:"max-connection-lifetime,omitempty"`
+	FragmentPattern       string            `proxy:"fragment-pattern,omitempty"`
+	ForceDestination      string            `proxy:"force-destination,omitempty"`
+	CA                    string            `proxy:"ca,omitempty"`
+	UseSystemCA           bool              `proxy:"use-system-ca,omitempty"`
+	MaxHandshakeBytes     int               `proxy:"max-handshake-bytes,omitempty"`
+	UUIDs                 []string          `proxy:"uuids,omitempty"`
+	GrpcServiceName       string            `proxy:"grpc-service-name,omitempty"`
+	ClientFingerprint     string            `proxy:"client-fingerprint,omitempty"`
+	ALPN                  []string          `proxy:"alpn,omitempty"`
+	MaxEarlyData          int               `proxy:"ws-max-early-data,omitempty"`
+	EarlyDataHeaderName   string            `proxy:"ws-early-data-header-name,omitempty"`
+	PinSHA256             string            `proxy:"pin-sha256,omitempty"`
+	Mux                   *MuxOption        `proxy:"mux,omitempty"`
+	HandshakeTimeout      Duration          `proxy:"handshake-timeout,omitempty"`
 }
 
+// StreamConn implements C.ProxyAdapter
 func (v *Vless) StreamConn(c net.Conn, metadata *C.Metadata) (net.Conn, error) {
-	var err error
-	switch v.option.Network {
-	case "ws":
-		if v.option.WSOpts.Path == "" {
-			v.option.WSOpts.Path = v.option.WSPath
+	return v.streamConn(c, v.client, metadata)
+}
+
+func (v *Vless) streamConn(c net.Conn, client *vless.Client, metadata *C.Metadata) (_ net.Conn, err error) {
+	// a server stalling after the accept mustn't hold the dial, the TLS and
+	// websocket handshakes included
+	raw := c
+	raw.SetDeadline(time.Now().Add(v.handshakeTimeout()))
+	defer func() {
+		if err == nil {
+			raw.SetDeadline(time.Time{})
 		}
-		if len(v.option.WSOpts.Headers) == 0 {
-			v.option.WSOpts.Headers = v.option.WSHeaders
+	}()
+
+	c, err = v.streamTransport(c)
+	if err != nil {
+		return nil, err
+	}
+
+	// never send the uuid in the clear because tls got lost on the way
+	if v.option.TLS && !v.tlsEstablished(c) {
+		c.Close()
+		return nil, errTLSDowngrade
+	}
+
+	if v.option.MaxHandshakeBytes > 0 {
+		c = &handshakeLimitConn{Conn: c, limit: int64(v.option.MaxHandshakeBytes)}
+	}
+	return v.streamVless(c, client, metadata)
+}
+
+// handshakeTimeout bounds a handshake with the server, tcpTimeout unless
+// handshake-timeout is set.
+func (v *Vless) handshakeTimeout() time.Duration {
+	if timeout := v.option.HandshakeTimeout.Of(time.Second); timeout > 0 {
+		return timeout
+	}
+	return tcpTimeout
+}
+
+// tlsEstablished reports whether c runs over a completed TLS handshake, the
+// websocket and gRPC conns tell by the ConnectionState of the conn under
+// them.
+func (v *Vless) tlsEstablished(c net.Conn) bool {
+	switch conn := c.(type) {
+	case *tls.Conn:
+		return conn.ConnectionState().HandshakeComplete
+	case *xtls.Conn:
+		return conn.ConnectionState().HandshakeComplete
+	case *utls.UConn:
+		return conn.ConnectionState().HandshakeComplete
+	case interface{ ConnectionState() tls.ConnectionState }:
+		return conn.ConnectionState().HandshakeComplete
+	}
+	return false
+}
+
+// streamVless sends the vless request for metadata over c, a nil metadata
+// requests a mux.cool session.
+func (v *Vless) streamVless(c net.Conn, client *vless.Client, metadata *C.Metadata) (net.Conn, error) {
+	if metadata == nil {
+		return client.StreamMuxConn(c)
+	}
+
+	dst, err := v.destination(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.StreamConn(c, parseVmessAddr(dst))
+}
+
+// destination returns the metadata the vless request is built from. A fake
+// IP is mapped back to its domain, so the server resolves it instead of
+// getting an unroutable address. With fake-ip-mapping: force any IP known to
+// the host mapper is, disable sends IPs as they are. A force-destination
+// replaces metadata altogether.
+func (v *Vless) destination(metadata *C.Metadata) (*C.Metadata, error) {
+	if v.forceDst != nil {
+		dst := *v.forceDst
+		dst.NetWork = metadata.NetWork
+		return &dst, nil
+	}
+
+	if v.option.FakeIPMapping == "disable" || metadata.Host != "" || metadata.DstIP == nil {
+		return metadata, nil
+	}
+
+	fake := resolver.IsFakeIP(metadata.DstIP)
+	if !fake && v.option.FakeIPMapping != "force" {
+		return metadata, nil
+	}
+
+	host, exist := resolver.FindHostByIP(metadata.DstIP)
+	if !exist {
+		if fake {
+			return nil, fmt.Errorf("fake ip %s has no domain mapping", metadata.DstIP.String())
 		}
+		return metadata, nil
+	}
+
+	dst := *metadata
+	dst.Host = host
+	dst.AddrType = C.AtypDomainName
+	return &dst, nil
+}
 
-		host, port, _ := net.SplitHostPort(v.addr)
+// streamTransport sets up the transport (tls, xtls, ws or grpc) the vless
+// request is sent over.
+func (v *Vless) streamTransport(c net.Conn) (_ net.Conn, err error) {
+	if v.option.TLS {
+		key := v.sessionCacheKey(c)
+		defer func() { v.handshakeDone(key, err) }()
+	}
+
+	c = vless.NewFragmentConn(c, v.fragment)
+	switch v.option.Network {
+	case "ws":
+		host, port, _ := net.SplitHostPort(v.Addr())
 		wsOpts := &vmess.WebsocketConfig{
 			Host:                host,
 			Port:                port,
@@ -80,219 +345,1185 @@ func (v *Vless) StreamConn(c net.Conn, metadata *C.Metadata) (net.Conn, error) {
 
 		if len(v.option.WSOpts.Headers) != 0 {
 			header := http.Header{}
-			for key, value := range v.option.WSHeaders {
+			for key, value := range v.option.WSOpts.Headers {
 				header.Add(key, value)
 			}
 			wsOpts.Headers = header
 		}
 
 		if v.option.TLS {
-			wsOpts.TLS = true
-			wsOpts.SkipCertVerify = v.option.SkipCertVerify
-			wsOpts.ServerName = v.option.ServerName
+			// the websocket runs in the clear over our own TLS, done before
+			// the upgrade even when early data puts that off
+			if v.clientHello != nil {
+				alpn := v.option.ALPN
+				if len(alpn) == 0 {
+					alpn = []string{"http/1.1"}
+				}
+				c, err = v.utlsHandshake(c, alpn)
+			} else {
+				tlsConfig := v.tlsConfig
+				if len(tlsConfig.NextProtos) == 0 {
+					tlsConfig = tlsConfig.Clone()
+					tlsConfig.NextProtos = []string{"http/1.1"}
+				}
+				tlsConn := tls.Client(c, tlsConfig)
+				c, err = tlsConn, tlsConn.Handshake()
+			}
+			if err != nil {
+				return nil, err
+			}
 		}
 		c, err = vmess.StreamWebsocketConn(c, wsOpts)
 	case "grpc":
 		c, err = gun.StreamGunWithConn(c, v.gunTLSConfig, v.gunConfig)
 	default:
 		// handle TLS
-		if v.option.TLS {
-			host, _, _ := net.SplitHostPort(v.addr)
+		if v.xtlsConfig != nil {
+			xtlsConn := xtls.Client(c, v.xtlsConfig)
+			if err = xtlsConn.Handshake(); err != nil {
+				return nil, err
+			}
 
-			if v.option.Flow == vless.XRO || v.option.Flow == vless.XROU || v.option.Flow == vless.XRS || v.option.Flow == vless.XRSU || v.option.Flow == vless.XRD || v.option.Flow == vless.XRDU {
-				xtlsConfig := &xtls.Config{
-					ServerName:         host,
-					InsecureSkipVerify: v.option.SkipCertVerify,
-				}
+			c = xtlsConn
+		} else if v.clientHello != nil {
+			if c, err = v.utlsHandshake(c, v.option.ALPN); err != nil {
+				return nil, err
+			}
+		} else if v.tlsConfig != nil {
+			tlsConn := tls.Client(c, v.tlsConfig)
+			if err = tlsConn.Handshake(); err != nil {
+				return nil, err
+			}
 
-				if v.option.ServerName != "" {
-					xtlsConfig.ServerName = v.option.ServerName
-				}
-				xtlsConn := xtls.Client(c, xtlsConfig)
-				if err = xtlsConn.Handshake(); err != nil {
-					return nil, err
-				}
+			c = tlsConn
+		}
+	}
 
-				c = xtlsConn
-			} else {
-				tlsConfig := &tls.Config{
-					ServerName:         host,
-					InsecureSkipVerify: v.option.SkipCertVerify,
-				}
-				if v.option.ServerName != "" {
-					tlsConfig.ServerName = v.option.ServerName
-				}
-				tlsConn := tls.Client(c, tlsConfig)
-				if err = tlsConn.Handshake(); err != nil {
-					return nil, err
-				}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// DialTiming is the breakdown of a dial made by DiagnoseDial.
+type DialTiming struct {
+	DNS       time.Duration // resolving the server address
+	Connect   time.Duration // TCP connect
+	Transport time.Duration // TLS handshake, websocket upgrade or gRPC stream setup
+	Handshake time.Duration // sending the VLESS request
+	FirstByte time.Duration // from sending the URL request to the first byte of the response
+	Total     time.Duration
+}
+
+// DiagnoseDial dials the server step by step and reports where the time went.
+// If rawURL is not empty it is requested through the connection, FirstByte
+// then covers the server reaching the destination. With websocket early data
+// the upgrade is deferred to the first write and accounted in FirstByte.
+func (v *Vless) DiagnoseDial(ctx context.Context, rawURL string) (timing *DialTiming, err error) {
+	timing = &DialTiming{}
+	start := time.Now()
+	defer func() {
+		timing.Total = time.Since(start)
+	}()
+
+	metadata := &C.Metadata{
+		NetWork:  C.TCP,
+		AddrType: C.AtypDomainName,
+		Host:     "www.gstatic.com",
+		DstPort:  "80",
+	}
 
-				c = tlsConn
+	var u *url.URL
+	if rawURL != "" {
+		if u, err = url.Parse(rawURL); err != nil {
+			return
+		}
+
+		metadata.Host = u.Hostname()
+		metadata.DstPort = u.Port()
+		if metadata.DstPort == "" {
+			metadata.DstPort = "80"
+			if u.Scheme == "https" {
+				metadata.DstPort = "443"
+			}
+		}
+		if ip := net.ParseIP(metadata.Host); ip != nil {
+			metadata.DstIP = ip
+			metadata.AddrType = C.AtypIPv4
+			if ip.To4() == nil {
+				metadata.AddrType = C.AtypIPv6
 			}
+		}
+	}
+
+	host, port, _ := net.SplitHostPort(v.Addr())
+	t := time.Now()
+	ips, err := v.resolveServer(host)
+	if err != nil {
+		return timing, fmt.Errorf("resolve %s error: %w", host, err)
+	}
+	ip := ips[0]
+	timing.DNS = time.Since(t)
+
+	t = time.Now()
+	c, err := v.dial(ctx, net.JoinHostPort(ip.String(), port))
+	if err != nil {
+		return timing, fmt.Errorf("%s connect error: %w", v.Addr(), err)
+	}
+	defer c.Close()
+	timing.Connect = time.Since(t)
 
+	if deadline, ok := ctx.Deadline(); ok {
+		c.SetDeadline(deadline)
+	}
+
+	t = time.Now()
+	if c, err = v.streamTransport(c); err != nil {
+		return
+	}
+	defer c.Close()
+	timing.Transport = time.Since(t)
+	if v.option.TLS && !v.tlsEstablished(c) {
+		err = errTLSDowngrade
+		return
+	}
+
+	t = time.Now()
+	if c, err = v.client.StreamConn(c, parseVmessAddr(metadata)); err != nil {
+		return
+	}
+	timing.Handshake = time.Since(t)
+
+	if u == nil {
+		return
+	}
+
+	t = time.Now()
+	if u.Scheme == "https" {
+		tlsConn := tls.Client(c, &tls.Config{ServerName: metadata.Host})
+		if err = tlsConn.Handshake(); err != nil {
+			return
 		}
+		c = tlsConn
+	}
+
+	req, err := http.NewRequest(http.MethodHead, u.String(), nil)
+	if err != nil {
+		return
+	}
+	req.Close = true
+	if err = req.Write(c); err != nil {
+		return
+	}
+
+	br := bufio.NewReader(c)
+	if _, err = br.Peek(1); err != nil {
+		return
+	}
+	timing.FirstByte = time.Since(t)
+
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return
 	}
+	resp.Body.Close()
+	return
+}
 
+// DialContext implements C.ProxyAdapter
+func (v *Vless) DialContext(ctx context.Context, metadata *C.Metadata) (C.Conn, error) {
+	emitConnEvent(v.name, "tcp", EventDialStart, nil)
+	start := time.Now()
+	c, err := v.dialContext(ctx, metadata)
+	v.metrics.dialed(start, err)
 	if err != nil {
+		emitConnEvent(v.name, "tcp", EventError, err)
 		return nil, err
 	}
+	emitConnEvent(v.name, "tcp", EventHandshakeOK, nil)
 
-	return v.client.StreamConn(c, parseVmessAddr(metadata))
+	c = &eventConn{Conn: v.metrics.wrapConn(c), proxy: v.name}
+	if v.option.MaxConnectionLifetime.IsSet() {
+		c = newLifetimeConn(c, v.option.MaxConnectionLifetime.Of(time.Second))
+	}
+	return NewConn(c, v), nil
 }
 
-func (v *Vless) DialContext(ctx context.Context, metadata *C.Metadata) (_ C.Conn, err error) {
+func (v *Vless) dialContext(ctx context.Context, metadata *C.Metadata) (_ net.Conn, err error) {
+	if v.muxPool != nil {
+		dst, err := v.destination(metadata)
+		if err != nil {
+			return nil, err
+		}
+		return v.muxPool.openStream(ctx, parseVmessAddr(dst))
+	}
+
 	// gun transport
-	if v.transport != nil {
-		c, err := gun.StreamGunWithTransport(v.transport, v.gunConfig)
+	if transport := v.gunTransport(); transport != nil {
+		c, err := gun.StreamGunWithTransport(transport, v.gunConfig)
 		if err != nil {
 			return nil, err
 		}
 		defer safeConnClose(c, err)
 
-		c, err = v.client.StreamConn(c, parseVmessAddr(metadata))
+		c = v.withLoad(c, v.Addr())
+		vc, err := v.streamVless(c, v.clientOf(c), metadata)
 		if err != nil {
+			c.Close()
 			return nil, err
 		}
-
-		return NewConn(c, v), nil
+		return vc, nil
 	}
 
-	c, err := dialer.DialContext(ctx, "tcp", v.addr)
+	c, err := v.dialServer(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("%s connect error: %s", v.addr, err.Error())
+		return nil, err
 	}
-	tcpKeepAlive(c)
 	defer safeConnClose(c, err)
+	client := v.clientOf(c)
+	if lc, ok := c.(*loadConn); ok {
+		// give the slot back if the handshake fails
+		defer func() {
+			if err != nil {
+				lc.Close()
+			}
+		}()
+	}
 
-	c, err = v.StreamConn(c, metadata)
-	return NewConn(c, v), err
+	// xtls reads the raw connection itself in direct mode, leave it alone
+	var pc *probeConn
+	if v.option.EarlyCloseWindow.IsSet() && v.xtlsConfig == nil {
+		pc = &probeConn{Conn: c}
+		c = pc
+	}
+
+	c, err = v.streamConn(c, client, metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	if pc != nil {
+		if err = pc.probe(v.option.EarlyCloseWindow.Of(time.Millisecond)); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
 }
 
-func (v *Vless) DialUDP(metadata *C.Metadata) (_ C.PacketConn, err error) {
-	if (v.option.Flow == vless.XRO || v.option.Flow == vless.XRS || v.option.Flow == vless.XRD) && metadata.DstPort == "443" {
-		return nil, fmt.Errorf("%s stopped UDP/443", v.option.Flow)
+// dialMuxSession dials a server for a new mux.cool session.
+func (v *Vless) dialMuxSession(ctx context.Context) (*vless.MuxSession, error) {
+	if transport := v.gunTransport(); transport != nil {
+		c, err := gun.StreamGunWithTransport(transport, v.gunConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		c = v.withLoad(c, v.Addr())
+		mc, err := v.streamVless(c, v.clientOf(c), nil)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		return vless.NewMuxSession(mc), nil
+	}
+
+	c, err := v.dialServer(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	mc, err := v.streamConn(c, v.clientOf(c), nil)
+	if err != nil {
+		c.Close()
+		return nil, err
 	}
+	return vless.NewMuxSession(mc), nil
+}
 
+// DialUDP implements C.ProxyAdapter. UDP doesn't go over mux, every
+// destination of the association dials its own conn.
+func (v *Vless) DialUDP(metadata *C.Metadata) (_ C.PacketConn, err error) {
 	// vless use stream-oriented udp, so clash needs a net.UDPAddr
 	if !metadata.Resolved() {
-		ip, err := resolver.ResolveIP(metadata.Host)
+		ip, err := v.resolveDestination(metadata.Host)
 		if err != nil {
 			return nil, errors.New("can't resolve ip")
 		}
 		metadata.DstIP = ip
 	}
 
-	var c net.Conn
+	emitConnEvent(v.name, "udp", EventDialStart, nil)
+	pc, err := v.dialUDPDestination(metadata)
+	if err != nil {
+		emitConnEvent(v.name, "udp", EventError, err)
+		return nil, err
+	}
+	emitConnEvent(v.name, "udp", EventHandshakeOK, nil)
+
+	pc = newNATPacketConn(pc, metadata, v.dialUDPDestination)
+	pc = &eventPacketConn{PacketConn: v.metrics.wrapPacketConn(pc), proxy: v.name}
+	if v.option.MaxConnectionLifetime.IsSet() {
+		pc = newLifetimePacketConn(pc, v.option.MaxConnectionLifetime.Of(time.Second))
+	}
+	return newPacketConn(pc, v), nil
+}
+
+// Metrics returns the counters of the node, labeled with its transport.
+func (v *Vless) Metrics() statistic.NodeMetrics {
+	transport := v.option.Network
+	if transport == "" {
+		transport = "tcp"
+	}
+	return v.metrics.snapshot(v.name, "vless", transport)
+}
+
+// dialUDPDestination opens the conn of a destination of a UDP association,
+// for the first one and for those the association reaches later
+func (v *Vless) dialUDPDestination(metadata *C.Metadata) (net.PacketConn, error) {
+	if (v.option.Flow == vless.XRO || v.option.Flow == vless.XRS || v.option.Flow == vless.XRD || v.option.Flow == vless.XRV) && metadata.DstPort == "443" {
+		return nil, v.reject(metadata, fmt.Sprintf("%s stopped UDP/443", v.option.Flow))
+	}
+
+	start := time.Now()
+	pc, err := v.dialPacketConn(metadata)
+	v.metrics.dialed(start, err)
+	if err != nil {
+		return nil, err
+	}
+
+	if v.option.Migrate {
+		pc = newMigratePacketConn(pc, func() (net.PacketConn, error) {
+			return v.dialPacketConn(metadata)
+		})
+	}
+	return pc, nil
+}
+
+func (v *Vless) dialPacketConn(metadata *C.Metadata) (_ net.PacketConn, err error) {
+	var c, raw net.Conn
 	// gun transport
-	if v.transport != nil {
-		c, err = gun.StreamGunWithTransport(v.transport, v.gunConfig)
+	if transport := v.gunTransport(); transport != nil {
+		c, err = gun.StreamGunWithTransport(transport, v.gunConfig)
 		if err != nil {
 			return nil, err
 		}
 		defer safeConnClose(c, err)
 
-		c, err = v.client.StreamConn(c, parseVmessAddr(metadata))
+		c = v.withLoad(c, v.Addr())
+		raw = c
+		c, err = v.streamVless(c, v.clientOf(c), metadata)
 	} else {
-		ctx, cancel := context.WithTimeout(context.Background(), C.DefaultTCPTimeout)
+		ctx, cancel := context.WithTimeout(context.Background(), v.handshakeTimeout())
 		defer cancel()
-		c, err = dialer.DialContext(ctx, "tcp", v.addr)
+		c, err = v.dialServer(ctx)
 		if err != nil {
-			return nil, fmt.Errorf("%s connect error: %s", v.addr, err.Error())
+			return nil, err
 		}
-		tcpKeepAlive(c)
 		defer safeConnClose(c, err)
 
-		c, err = v.StreamConn(c, metadata)
+		raw = c
+		c, err = v.streamConn(c, v.clientOf(c), metadata)
 	}
 
 	if err != nil {
+		// give the least-load slot back
+		if _, ok := raw.(*loadConn); ok {
+			raw.Close()
+		}
 		return nil, fmt.Errorf("new vless client error: %v", err)
 	}
 
-	return newPacketConn(newVlessPacketConn(c, metadata.UDPAddr()), v), nil
+	var pc net.PacketConn = newVlessPacketConn(c, metadata.UDPAddr(), v.option.UDPPadTo)
+	if v.option.UDPReadAhead > 0 {
+		pc = newReadAheadPacketConn(pc, v.option.UDPReadAhead)
+	}
+	return pc, nil
 }
 
-func NewVless(option VlessOption) (*Vless, error) {
-	var addons *vless.Addons
-	if option.TLS && option.Network != "ws" && option.Flow != "" {
-		switch option.Flow {
-		case vless.XRO, vless.XRD, vless.XRS, vless.XROU, vless.XRDU, vless.XRSU:
-			addons = &vless.Addons{
-				Flow: option.Flow,
-			}
-		default:
-			return nil, fmt.Errorf("unsupported vless flow type: %s", option.Flow)
+// Addr implements C.ProxyAdapter
+func (v *Vless) Addr() string {
+	v.serverMux.RLock()
+	defer v.serverMux.RUnlock()
+	return v.addr
+}
+
+// dialServer connects to the server, trying the extra endpoints of servers
+// according to multi-server-strategy. The gRPC transport keeps its own pooled
+// connection to server and doesn't use it.
+func (v *Vless) dialServer(ctx context.Context) (net.Conn, error) {
+	addrs := append([]string{v.Addr()}, v.option.Servers...)
+	if v.option.MultiServerStrategy == "least-load" {
+		addr, idx := v.acquire(addrs)
+		c, err := v.dialEndpoint(ctx, addr)
+		if err != nil {
+			v.release(addr, idx)
+			return nil, err
 		}
+		return newLoadConn(c, v, addr, idx), nil
 	}
 
-	client, err := vless.NewClient(option.UUID, addons)
-	if err != nil {
-		return nil, err
+	if len(addrs) == 1 {
+		return v.dialEndpoint(ctx, addrs[0])
 	}
 
-	switch option.Network {
-	case "h2", "grpc":
-		if !option.TLS {
-			return nil, fmt.Errorf("TLS must be true with h2/grpc network")
-		}
+	switch v.option.MultiServerStrategy {
+	case "parallel":
+		return v.dialParallel(ctx, addrs)
+	case "latency":
+		sort.SliceStable(addrs, func(i, j int) bool {
+			return v.latency(addrs[i]) < v.latency(addrs[j])
+		})
 	}
 
-	v, err := &Vless{
-		Base: &Base{
-			name: option.Name,
-			addr: net.JoinHostPort(option.Server, strconv.Itoa(option.Port)),
-			tp:   C.Vless,
-			udp:  true,
-		},
-		client: client,
-		option: &option,
-	}, nil
-
-	switch option.Network {
-	case "grpc":
-		dialFn := func(network, addr string) (net.Conn, error) {
-			c, err := dialer.DialContext(context.Background(), "tcp", v.addr)
-			if err != nil {
-				return nil, fmt.Errorf("%s connect error: %s", v.addr, err.Error())
-			}
-			tcpKeepAlive(c)
+	var errs []string
+	for _, addr := range addrs {
+		c, err := v.dialEndpoint(ctx, addr)
+		if err == nil {
 			return c, nil
 		}
 
-		gunConfig := &gun.Config{
-			ServiceName: v.option.GrpcOpts.GrpcServiceName,
-			Host:        v.option.ServerName,
-		}
-		tlsConfig := &tls.Config{
-			InsecureSkipVerify: v.option.SkipCertVerify,
-			ServerName:         v.option.ServerName,
+		errs = append(errs, err.Error())
+		if ctx.Err() != nil {
+			break
 		}
+	}
+	return nil, fmt.Errorf("all servers failed: %s", strings.Join(errs, "; "))
+}
 
-		if v.option.ServerName == "" {
-			host, _, _ := net.SplitHostPort(v.addr)
-			tlsConfig.ServerName = host
-			gunConfig.Host = host
-		}
+// acquire picks the (server, uuid) pair with the fewest active connections
+// and counts one more on it. Ties go to the earlier server, then uuid.
+func (v *Vless) acquire(addrs []string) (addr string, idx int) {
+	v.loadMux.Lock()
+	defer v.loadMux.Unlock()
 
-		v.gunTLSConfig = tlsConfig
-		v.gunConfig = gunConfig
-		v.transport = gun.NewHTTP2Client(dialFn, tlsConfig)
+	best := -1
+	for _, a := range addrs {
+		for i := range v.clients {
+			if load := v.loads[loadKey{a, i}]; best == -1 || load < best {
+				best, addr, idx = load, a, i
+			}
+		}
+	}
+	v.loads[loadKey{addr, idx}]++
+	return
+}
+
+func (v *Vless) release(addr string, idx int) {
+	v.loadMux.Lock()
+	defer v.loadMux.Unlock()
+
+	key := loadKey{addr, idx}
+	if v.loads[key]--; v.loads[key] <= 0 {
+		delete(v.loads, key)
+	}
+}
+
+// withLoad accounts c to the least loaded uuid of addr, for transports that
+// don't go through dialServer.
+func (v *Vless) withLoad(c net.Conn, addr string) net.Conn {
+	if v.option.MultiServerStrategy != "least-load" {
+		return c
+	}
+	_, idx := v.acquire([]string{addr})
+	return newLoadConn(c, v, addr, idx)
+}
+
+// clientOf returns the client for the uuid c was accounted to
+func (v *Vless) clientOf(c net.Conn) *vless.Client {
+	if lc, ok := c.(*loadConn); ok {
+		return v.clients[lc.idx]
+	}
+	return v.client
+}
+
+// dialParallel races all endpoints and keeps the first connection
+// established, the late ones are closed.
+func (v *Vless) dialParallel(ctx context.Context, addrs []string) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+
+	results := make(chan dialResult, len(addrs))
+	for _, addr := range addrs {
+		go func(addr string) {
+			c, err := v.dialEndpoint(ctx, addr)
+			results <- dialResult{c, err}
+		}(addr)
+	}
+
+	var errs []string
+	for i := range addrs {
+		r := <-results
+		if r.err != nil {
+			errs = append(errs, r.err.Error())
+			continue
+		}
+
+		go func(remain int) {
+			for ; remain > 0; remain-- {
+				if r := <-results; r.conn != nil {
+					r.conn.Close()
+				}
+			}
+		}(len(addrs) - i - 1)
+		return r.conn, nil
+	}
+	return nil, fmt.Errorf("all servers failed: %s", strings.Join(errs, "; "))
+}
+
+// dial opens a TCP conn to a server, TCP and UDP relays alike. Through the
+// system dialer the addresses of the server race each other, a Dialer set
+// by SetDefaultDialer gets addr as is and resolves it itself.
+func (v *Vless) dial(ctx context.Context, addr string) (net.Conn, error) {
+	d := loadDefaultDialer()
+	if _, ok := d.(systemDialer); !ok {
+		return v.dialWith(ctx, d, v.tcpNetwork(nil), addr)
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := v.resolveServer(host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 1 {
+		return v.dialWith(ctx, d, v.tcpNetwork(ips[0]), net.JoinHostPort(ips[0].String(), port))
+	}
+	return v.dialHappyEyeballs(ctx, d, ips, port)
+}
+
+func (v *Vless) dialWith(ctx context.Context, d Dialer, network, addr string) (net.Conn, error) {
+	if od, ok := d.(optionDialer); ok && len(v.dialOptions) != 0 {
+		return od.DialContextWithOptions(ctx, network, addr, v.dialOptions...)
+	}
+	return d.DialContext(ctx, network, addr)
+}
+
+// tcpNetwork is the network to dial ip on, either family if unknown.
+func (v *Vless) tcpNetwork(ip net.IP) string {
+	switch {
+	case ip != nil && ip.To4() != nil, v.IPVersion() == dialer.IPv4Only:
+		return "tcp4"
+	case ip != nil, v.IPVersion() == dialer.IPv6Only:
+		return "tcp6"
+	}
+	return "tcp"
+}
+
+// resolveServer returns the addresses of a server host allowed by
+// ip-version, in the order to try them.
+func (v *Vless) resolveServer(host string) ([]net.IP, error) {
+	return dialer.ResolveAllIP(host, v.dialOptions...)
+}
+
+// resolveDestination resolves the destination of a UDP relay with the
+// family ip-version asks for.
+func (v *Vless) resolveDestination(host string) (net.IP, error) {
+	return dialer.ResolveIP(host, v.dialOptions...)
+}
+
+// dialHappyEyeballs races the addresses of a server through d, see
+// dialer.HappyEyeballs.
+func (v *Vless) dialHappyEyeballs(ctx context.Context, d Dialer, ips []net.IP, port string) (net.Conn, error) {
+	return dialer.HappyEyeballs(ctx, dialer.SortIPs(ips, v.IPVersion()), func(ctx context.Context, ip net.IP) (net.Conn, error) {
+		return v.dialWith(ctx, d, v.tcpNetwork(ip), net.JoinHostPort(ip.String(), port))
+	})
+}
+
+func (v *Vless) dialEndpoint(ctx context.Context, addr string) (net.Conn, error) {
+	start := time.Now()
+	c, err := v.dial(ctx, addr)
+	if err != nil {
+		// a dial canceled by the caller says nothing about the endpoint
+		if ctx.Err() == nil {
+			v.recordLatency(addr, tcpTimeout)
+		}
+		return nil, fmt.Errorf("%s connect error: %s", addr, err.Error())
+	}
+	v.recordLatency(addr, time.Since(start))
+	tcpKeepAlive(c)
+	return c, nil
+}
+
+// latency returns the smoothed connect time of an endpoint, 0 if unknown so
+// new endpoints get measured first. Failed dials count as tcpTimeout.
+func (v *Vless) latency(addr string) time.Duration {
+	if l, ok := v.latencies.Load(addr); ok {
+		return time.Duration(l.(*atomic.Int64).Load())
+	}
+	return 0
+}
+
+func (v *Vless) recordLatency(addr string, d time.Duration) {
+	l, loaded := v.latencies.LoadOrStore(addr, atomic.NewInt64(int64(d)))
+	if !loaded {
+		return
+	}
+
+	ewma := l.(*atomic.Int64)
+	for {
+		old := ewma.Load()
+		if ewma.CAS(old, old+(int64(d)-old)/4) {
+			return
+		}
+	}
+}
+
+func (v *Vless) gunTransport() *http2.Transport {
+	v.serverMux.RLock()
+	defer v.serverMux.RUnlock()
+	return v.transport
+}
+
+func (v *Vless) newGunTransport(addr string) *http2.Transport {
+	dialFn := func(network, _ string) (net.Conn, error) {
+		c, err := v.dial(context.Background(), addr)
+		if err != nil {
+			return nil, fmt.Errorf("%s connect error: %s", addr, err.Error())
+		}
+		tcpKeepAlive(c)
+		return vless.NewFragmentConn(c, v.fragment), nil
+	}
+
+	return gun.NewHTTP2Client(dialFn, v.gunTLSConfig)
+}
+
+// UpdateServer changes the endpoint new connections are dialed to. Existing
+// connections keep using the old one, gRPC streams included: new streams get
+// a fresh transport instead of the pooled connection to the previous server.
+// Likewise pooled mux sessions are retired, their streams run on.
+// TLS settings (servername, verify-host) are left as they are.
+func (v *Vless) UpdateServer(host string, port int) error {
+	if host == "" {
+		return errors.New("empty server")
+	}
+	if port <= 0 || port > 65535 {
+		return fmt.Errorf("invalid port: %d", port)
+	}
+
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+
+	v.serverMux.Lock()
+	v.addr = addr
+	v.option.Server = host
+	v.option.Port = port
+	if v.transport != nil {
+		v.transport = v.newGunTransport(addr)
+	}
+	v.serverMux.Unlock()
+
+	if v.muxPool != nil {
+		v.muxPool.retire()
+	}
+	return nil
+}
+
+// reject refuses a dial by policy, logging it when log-rejected is set.
+func (v *Vless) reject(metadata *C.Metadata, reason string) error {
+	if v.option.LogRejected {
+		log.Infoln("[Vless] %s rejected %s %s --> %s: %s", v.name, metadata.NetWork.String(), metadata.SourceAddress(), metadata.RemoteAddress(), reason)
+	}
+	return errors.New(reason)
+}
+
+func NewVless(option VlessOption) (*Vless, error) {
+	if option.Flow == vless.XRV && (!option.TLS || option.Network != "" && option.Network != "tcp") {
+		return nil, fmt.Errorf("%s requires tls over tcp", vless.XRV)
+	}
+
+	var addons *vless.Addons
+	if option.TLS && option.Network != "ws" && option.Flow != "" {
+		switch option.Flow {
+		case vless.XRO, vless.XRD, vless.XRS, vless.XROU, vless.XRDU, vless.XRSU, vless.XRV:
+			addons = &vless.Addons{
+				Flow: option.Flow,
+			}
+		default:
+			return nil, fmt.Errorf("unsupported vless flow type: %s", option.Flow)
+		}
+	}
+
+	uid, err := vless.NormalizeUUID(option.UUID)
+	if err != nil {
+		return nil, err
+	}
+	option.UUID = uid
+
+	client, err := vless.NewClient(option.UUID, addons)
+	if err != nil {
+		return nil, err
+	}
+
+	if option.UDPPadTo < 0 || option.UDPPadTo > maxLength {
+		return nil, fmt.Errorf("udp-pad-to must be between 0 and %d", maxLength)
+	}
+	client.UDPPadTo = option.UDPPadTo
+
+	if option.UDPReadAhead < 0 {
+		return nil, errors.New("udp-read-ahead must not be negative")
+	}
+
+	clients := []*vless.Client{client}
+	for i, uuid := range option.UUIDs {
+		if uuid, err = vless.NormalizeUUID(uuid); err != nil {
+			return nil, err
+		}
+		option.UUIDs[i] = uuid
+
+		c, err := vless.NewClient(uuid, addons)
+		if err != nil {
+			return nil, err
+		}
+		c.UDPPadTo = option.UDPPadTo
+		clients = append(clients, c)
+	}
+
+	for _, server := range option.Servers {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			return nil, fmt.Errorf("invalid servers entry %s: %w", server, err)
+		}
+	}
+
+	switch option.MultiServerStrategy {
+	case "", "sequential", "parallel", "latency", "least-load":
+	default:
+		return nil, fmt.Errorf("unsupported multi-server-strategy: %s", option.MultiServerStrategy)
+	}
+	if len(option.UUIDs) != 0 && option.MultiServerStrategy != "least-load" {
+		return nil, errors.New("uuids requires multi-server-strategy: least-load")
+	}
+
+	if option.Mux != nil && option.Mux.Enabled && option.Flow != "" {
+		return nil, fmt.Errorf("mux is not supported with %s", option.Flow)
+	}
+	// the flows take the tls conn as is, they can't go through the limit
+	if option.MaxHandshakeBytes > 0 && option.Flow != "" {
+		return nil, fmt.Errorf("max-handshake-bytes is not supported with %s", option.Flow)
+	}
+
+	switch option.FakeIPMapping {
+	case "", "auto", "force", "disable":
+	default:
+		return nil, fmt.Errorf("unsupported fake-ip-mapping: %s", option.FakeIPMapping)
+	}
+
+	fragment, err := vless.ParseFragmentPattern(option.FragmentPattern)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, proto := range option.ALPN {
+		if proto == "" {
+			return nil, errors.New("alpn entries can't be empty")
+		}
+	}
+
+	clientHello, err := fingerprint.Parse(option.ClientFingerprint)
+	if err != nil {
+		return nil, err
+	}
+	if clientHello != nil {
+		switch {
+		case !option.TLS:
+			return nil, errors.New("client-fingerprint requires tls")
+		case option.Network == "grpc" || option.Network == "h2":
+			return nil, fmt.Errorf("client-fingerprint is not supported with %s", option.Network)
+		case addons != nil && addons.Flow != vless.XRV:
+			return nil, fmt.Errorf("client-fingerprint is not supported with %s", addons.Flow)
+		}
+	}
+
+	var forceDst *C.Metadata
+	if option.ForceDestination != "" {
+		if forceDst, err = parseForceDestination(option.ForceDestination); err != nil {
+			return nil, err
+		}
+	}
+
+	switch option.Network {
+	case "h2", "grpc":
+		if !option.TLS {
+			return nil, fmt.Errorf("TLS must be true with h2/grpc network")
+		}
+		if option.GrpcOpts.GrpcServiceName == "" {
+			option.GrpcOpts.GrpcServiceName = option.GrpcServiceName
+		}
+	case "ws":
+		if option.WSOpts.Path == "" {
+			option.WSOpts.Path = option.WSPath
+		}
+		if len(option.WSOpts.Headers) == 0 {
+			option.WSOpts.Headers = option.WSHeaders
+		}
+		if option.WSOpts.MaxEarlyData == 0 {
+			option.WSOpts.MaxEarlyData = option.MaxEarlyData
+		}
+		if option.WSOpts.EarlyDataHeaderName == "" {
+			option.WSOpts.EarlyDataHeaderName = option.EarlyDataHeaderName
+		}
+	}
+
+	if option.TLS && option.ServerName == "" {
+		option.ServerName = option.Server
+		if option.Network == "ws" {
+			header := http.Header{}
+			for key, value := range option.WSOpts.Headers {
+				header.Add(key, value)
+			}
+			if host := header.Get("Host"); host != "" {
+				option.ServerName = host
+			}
+		}
+	}
+
+	if option.TLS && option.VerifyHost == "" {
+		option.VerifyHost = option.ServerName
+	}
+
+	dialOptions, err := option.dialOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := &Vless{
+		Base: &Base{
+			name: option.Name,
+			addr: net.JoinHostPort(option.Server, strconv.Itoa(option.Port)),
+			tp:   C.Vless,
+			udp:  true,
+
+			dialOptions: dialOptions,
+		},
+		client:   client,
+		option:   &option,
+		metrics:  newAdapterMetrics(),
+		fragment: fragment,
+		forceDst: forceDst,
+		clients:  clients,
+		loads:    map[loadKey]int{},
+
+		clientHello: clientHello,
+	}, nil
+
+	if option.Mux != nil && option.Mux.Enabled {
+		v.muxPool = newMuxPool(option.Mux, v.dialMuxSession)
+	}
+
+	if option.TLS {
+		if option.CertSerial != "" {
+			if v.certSerial, err = parseCertSerial(option.CertSerial); err != nil {
+				return nil, err
+			}
+		}
+		if option.PinSHA256 != "" {
+			if v.pinSHA256, err = parsePinSHA256(option.PinSHA256); err != nil {
+				return nil, err
+			}
+		}
+
+		if v.rootCAs, err = loadRootCAs(option); err != nil {
+			return nil, err
+		}
+
+		v.initTLSConfig(addons != nil && addons.Flow != vless.XRV)
+	}
+
+	switch option.Network {
+	case "grpc":
+		gunConfig := &gun.Config{
+			ServiceName: v.option.GrpcOpts.GrpcServiceName,
+			Host:        v.option.ServerName,
+		}
+
+		v.gunTLSConfig = v.tlsConfig
+		v.gunConfig = gunConfig
+		v.transport = v.newGunTransport(v.addr)
 	}
 
 	return v, nil
 }
 
-func newVlessPacketConn(c net.Conn, addr net.Addr) *vlessPacketConn {
+// initTLSConfig builds the TLS config shared by every transport. When the
+// certificate has to be verified against a name other than the SNI, the
+// standard verification is turned off and done by verifyPeerCertificate.
+func (v *Vless) initTLSConfig(xtlsFlow bool) {
+	insecure := v.option.SkipCertVerify
+	if !insecure && v.option.VerifyHost != v.option.ServerName {
+		insecure = true
+		v.verifyChain = true
+	}
+
+	var verifyPeer func([][]byte, [][]*x509.Certificate) error
+	if v.verifyChain || v.certSerial != nil || v.pinSHA256 != nil {
+		verifyPeer = v.verifyPeerCertificate
+	}
+
+	v.tlsConfig = &tls.Config{
+		ServerName:            v.option.ServerName,
+		InsecureSkipVerify:    insecure,
+		VerifyPeerCertificate: verifyPeer,
+		ClientSessionCache:    getClientSessionCache(),
+		RootCAs:               v.rootCAs,
+		NextProtos:            v.option.ALPN,
+	}
+	if v.option.RequireSCT {
+		v.tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			return v.verifyConnection(cs.PeerCertificates, cs.SignedCertificateTimestamps)
+		}
+	}
+
+	if xtlsFlow {
+		v.xtlsConfig = &xtls.Config{
+			ServerName:            v.option.ServerName,
+			InsecureSkipVerify:    insecure,
+			VerifyPeerCertificate: verifyPeer,
+			ClientSessionCache:    getClientXSessionCache(),
+			RootCAs:               v.rootCAs,
+			NextProtos:            v.option.ALPN,
+		}
+		if v.option.RequireSCT {
+			v.xtlsConfig.VerifyConnection = func(cs xtls.ConnectionState) error {
+				return v.verifyConnection(cs.PeerCertificates, cs.SignedCertificateTimestamps)
+			}
+		}
+	}
+}
+
+// loadRootCAs returns the pool server certificates are verified against,
+// nil leaves it to the system pool. With use-system-ca off only ca is
+// trusted, and a node that verifies certificates must then set one: some
+// embedded systems ship an empty system pool and nothing would verify.
+func loadRootCAs(option VlessOption) (*x509.CertPool, error) {
+	if option.CA == "" {
+		if !option.UseSystemCA && !option.SkipCertVerify {
+			return nil, errors.New("use-system-ca is disabled and no ca is set, the server certificate can't be verified")
+		}
+		return nil, nil
+	}
+
+	pem, err := ioutil.ReadFile(C.Path.Resolve(option.CA))
+	if err != nil {
+		return nil, fmt.Errorf("load ca error: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if option.UseSystemCA {
+		if pool, err = x509.SystemCertPool(); err != nil {
+			pool = x509.NewCertPool()
+		}
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in ca %s", option.CA)
+	}
+	return pool, nil
+}
+
+// parseForceDestination parses the host:port every request is sent to
+func parseForceDestination(addr string) (*C.Metadata, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid force-destination %s: %w", addr, err)
+	}
+	if p, err := strconv.Atoi(port); err != nil || p <= 0 || p > 65535 {
+		return nil, fmt.Errorf("invalid force-destination port: %s", port)
+	}
+	if host == "" {
+		return nil, fmt.Errorf("invalid force-destination %s: empty host", addr)
+	}
+
+	metadata := &C.Metadata{DstPort: port}
+	if ip := net.ParseIP(host); ip != nil {
+		metadata.DstIP = ip
+		metadata.AddrType = C.AtypIPv6
+		if ip.To4() != nil {
+			metadata.AddrType = C.AtypIPv4
+		}
+	} else {
+		metadata.Host = host
+		metadata.AddrType = C.AtypDomainName
+	}
+	return metadata, nil
+}
+
+// sessionCacheKey is the key crypto/tls stores the session of c under
+func (v *Vless) sessionCacheKey(c net.Conn) string {
+	if v.option.ServerName != "" {
+		return v.option.ServerName
+	}
+	return c.RemoteAddr().String()
+}
+
+// hasCachedSession reports whether the next handshake to key resumes
+func (v *Vless) hasCachedSession(key string) bool {
+	if v.clientHello != nil {
+		_, ok := getClientUSessionCache().Get(key)
+		return ok
+	}
+	if v.xtlsConfig != nil {
+		_, ok := v.xtlsConfig.ClientSessionCache.Get(key)
+		return ok
+	}
+	_, ok := v.tlsConfig.ClientSessionCache.Get(key)
+	return ok
+}
+
+// handshakeDone counts failed handshakes that offered a cached session. A
+// server that rotated its ticket keys may abort on the stale ticket instead
+// of falling back to a full handshake, so after maxTicketRejections failures
+// in a row the session is evicted.
+func (v *Vless) handshakeDone(key string, err error) {
+	v.ticketMux.Lock()
+	defer v.ticketMux.Unlock()
+
+	if err == nil {
+		delete(v.ticketRejections, key)
+		return
+	}
+	if !v.hasCachedSession(key) {
+		return
+	}
+
+	if v.ticketRejections == nil {
+		v.ticketRejections = map[string]int{}
+	}
+	v.ticketRejections[key]++
+	if v.ticketRejections[key] < maxTicketRejections {
+		return
+	}
+
+	delete(v.ticketRejections, key)
+	if v.clientHello != nil {
+		getClientUSessionCache().Put(key, nil)
+	} else if v.xtlsConfig != nil {
+		v.xtlsConfig.ClientSessionCache.Put(key, nil)
+	} else {
+		v.tlsConfig.ClientSessionCache.Put(key, nil)
+	}
+	log.Infoln("[Vless] %s evicted the cached tls session for %s", v.Name(), key)
+}
+
+// verifyPeerCertificate verifies the server chain against VerifyHost when
+// the standard verification is off, and checks the pinned serial number.
+func (v *Vless) verifyPeerCertificate(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return errors.New("server sent no certificate")
+	}
+
+	opts := x509.VerifyOptions{
+		DNSName:       v.option.VerifyHost,
+		Roots:         v.rootCAs,
+		Intermediates: x509.NewCertPool(),
+	}
+
+	var leaf *x509.Certificate
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+
+		if i == 0 {
+			leaf = cert
+		} else {
+			opts.Intermediates.AddCert(cert)
+		}
+	}
+
+	if v.certSerial != nil && leaf.SerialNumber.Cmp(v.certSerial) != 0 {
+		return fmt.Errorf("certificate serial %X doesn't match the pinned one", leaf.SerialNumber)
+	}
+
+	if v.pinSHA256 != nil {
+		if pin := sha256.Sum256(leaf.RawSubjectPublicKeyInfo); !bytes.Equal(pin[:], v.pinSHA256) {
+			return fmt.Errorf("certificate public key %s doesn't match the pinned one", base64.StdEncoding.EncodeToString(pin[:]))
+		}
+	}
+
+	if v.verifyChain {
+		if _, err := leaf.Verify(opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseCertSerial parses a hex serial number, as shown by most certificate
+// viewers, with optional colons, spaces or 0x prefix.
+func parseCertSerial(str string) (*big.Int, error) {
+	s := strings.NewReplacer(":", "", " ", "").Replace(str)
+	s = strings.TrimPrefix(strings.ToLower(s), "0x")
+
+	serial, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		return nil, fmt.Errorf("invalid cert-serial: %s", str)
+	}
+	return serial, nil
+}
+
+// parsePinSHA256 parses the base64 SHA-256 of a SubjectPublicKeyInfo, the
+// form of HPKP pins and `openssl ... | openssl dgst -sha256 -binary | base64`.
+func parsePinSHA256(str string) ([]byte, error) {
+	pin, err := base64.StdEncoding.DecodeString(str)
+	if err != nil || len(pin) != sha256.Size {
+		return nil, fmt.Errorf("invalid pin-sha256: %s", str)
+	}
+	return pin, nil
+}
+
+// verifyConnection runs the checks that go beyond the standard chain
+// verification, for both crypto/tls and xtls handshakes.
+func (v *Vless) verifyConnection(certs []*x509.Certificate, scts [][]byte) error {
+	if v.option.RequireSCT {
+		return checkSCTs(certs, scts)
+	}
+	return nil
+}
+
+// ResolvedOption returns the option the adapter actually uses, after legacy
+// fields and defaults have been applied. The UUIDs and the values of the
+// headers that look like credentials are redacted.
+func (v *Vless) ResolvedOption() VlessOption {
+	v.serverMux.RLock()
+	option := *v.option
+	v.serverMux.RUnlock()
+
+	option.UUID = redactedSecret
+	if len(option.UUIDs) != 0 {
+		option.UUIDs = make([]string, len(option.UUIDs))
+		for i := range option.UUIDs {
+			option.UUIDs[i] = redactedSecret
+		}
+	}
+	option.WSHeaders = redactHeaders(option.WSHeaders)
+	option.WSOpts.Headers = redactHeaders(option.WSOpts.Headers)
+	return option
+}
+
+func newVlessPacketConn(c net.Conn, addr net.Addr, padTo int) *vlessPacketConn {
 	return &vlessPacketConn{Conn: c,
 		rAddr: addr,
+		padTo: padTo,
 		cache: make([]byte, 0, maxLength+2),
 	}
 }
 
+// vlessPacketConn frames datagrams as [length][payload]. With padTo set the
+// payload sent is followed by zeros up to padTo bytes, the server learns it
+// from the request addons. Frames received are only taken as padded when
+// the server acknowledged it, see vless.Conn.DownlinkPadTo.
 type vlessPacketConn struct {
 	net.Conn
 	rAddr  net.Addr
 	remain int
+	pad    int
+	padTo  int
 	mux    sync.Mutex
 	cache  []byte
 }
@@ -304,8 +1535,13 @@ func (c *vlessPacketConn) writePacket(b []byte, addr net.Addr) (int, error) {
 	}()
 	c.cache = append(c.cache, byte(length>>8), byte(length))
 	c.cache = append(c.cache, b...)
+	for i := length; i < c.padTo; i++ {
+		c.cache = append(c.cache, 0)
+	}
 	n, err := c.Conn.Write(c.cache)
-	if n > 2 {
+	if n > 2+length {
+		return length, err
+	} else if n > 2 {
 		return n - 2, err
 	}
 
@@ -346,12 +1582,15 @@ func (c *vlessPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
 			length = c.remain
 		}
 
-		n, err := c.Conn.Read(b[:length])
+		n, err := io.ReadFull(c.Conn, b[:length])
 		if err != nil {
 			return 0, nil, err
 		}
 
 		c.remain -= n
+		if c.remain == 0 {
+			return n, c.rAddr, c.discardPadding()
+		}
 		return n, c.rAddr, nil
 	}
 
@@ -361,10 +1600,432 @@ func (c *vlessPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
 	}
 
 	remain := int(packetLength)
-	n, err := c.Conn.Read(b[:length])
+	if remain < length {
+		// don't read into the padding or the next frame
+		length = remain
+	}
+	if padTo := c.downlinkPadTo(); remain < padTo {
+		c.pad = padTo - remain
+	}
+
+	// a stream read may come back short, the rest of the frame mustn't be
+	// taken for the next one
+	n, err := io.ReadFull(c.Conn, b[:length])
 	remain -= n
 	if remain > 0 {
 		c.remain = remain
+	} else if err == nil {
+		err = c.discardPadding()
 	}
 	return n, c.rAddr, err
 }
+
+// downlinkPadTo is the size the server pads its frames to, known once the
+// response is read with the first frame length
+func (c *vlessPacketConn) downlinkPadTo() int {
+	if p, ok := c.Conn.(interface{ DownlinkPadTo() int }); ok {
+		return p.DownlinkPadTo()
+	}
+	return 0
+}
+
+func (c *vlessPacketConn) discardPadding() error {
+	if c.pad == 0 {
+		return nil
+	}
+
+	_, err := io.CopyN(ioutil.Discard, c.Conn, int64(c.pad))
+	c.pad = 0
+	return err
+}
+
+type loadKey struct {
+	addr string
+	idx  int
+}
+
+// loadConn counts as an active connection of its (server, uuid) pair until
+// closed.
+type loadConn struct {
+	net.Conn
+	idx     int
+	release func()
+	once    sync.Once
+}
+
+func newLoadConn(c net.Conn, v *Vless, addr string, idx int) *loadConn {
+	return &loadConn{
+		Conn:    c,
+		idx:     idx,
+		release: func() { v.release(addr, idx) },
+	}
+}
+
+func (c *loadConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
+
+// handshakeLimitConn fails reads once the server sent more than limit bytes
+// over the transport before the vless response header is parsed, so a
+// misconfigured endpoint serving a web page gives a clear error rather than
+// a huge read.
+type handshakeLimitConn struct {
+	net.Conn
+	limit int64
+	read  int64
+	done  atomic.Bool
+}
+
+// HandshakeDone implements vless.HandshakeWatcher
+func (c *handshakeLimitConn) HandshakeDone() {
+	c.done.Store(true)
+}
+
+func (c *handshakeLimitConn) Read(b []byte) (int, error) {
+	if c.done.Load() {
+		return c.Conn.Read(b)
+	}
+
+	if remain := c.limit - c.read + 1; int64(len(b)) > remain {
+		b = b[:remain]
+	}
+	n, err := c.Conn.Read(b)
+	c.read += int64(n)
+	if c.read > c.limit {
+		return 0, fmt.Errorf("%w: more than %d bytes", errHandshakeTooLarge, c.limit)
+	}
+	return n, err
+}
+
+// lifetimeCloseGrace bounds the wait of an expired lifetimeConn for the
+// Write in progress, a stalled peer mustn't keep the conn alive.
+const lifetimeCloseGrace = time.Second
+
+// lifetimeConn closes the connection once its lifetime is over, whatever the
+// activity. A Write in progress is given lifetimeCloseGrace to finish first,
+// so the frame it carries isn't cut in half.
+type lifetimeConn struct {
+	net.Conn
+	wMux  sync.Mutex
+	timer *time.Timer
+}
+
+func newLifetimeConn(c net.Conn, lifetime time.Duration) *lifetimeConn {
+	lc := &lifetimeConn{Conn: c}
+	lc.timer = time.AfterFunc(lifetime, lc.expire)
+	return lc
+}
+
+func (c *lifetimeConn) expire() {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.wMux.Lock()
+		defer c.wMux.Unlock()
+		c.Conn.Close()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(lifetimeCloseGrace):
+		c.Conn.Close()
+	}
+}
+
+func (c *lifetimeConn) Write(b []byte) (int, error) {
+	c.wMux.Lock()
+	defer c.wMux.Unlock()
+	return c.Conn.Write(b)
+}
+
+func (c *lifetimeConn) Close() error {
+	c.timer.Stop()
+	return c.Conn.Close()
+}
+
+// lifetimePacketConn is lifetimeConn for UDP, datagrams are written whole
+// so there's nothing to wait for.
+type lifetimePacketConn struct {
+	net.PacketConn
+	timer *time.Timer
+}
+
+func newLifetimePacketConn(pc net.PacketConn, lifetime time.Duration) *lifetimePacketConn {
+	return &lifetimePacketConn{
+		PacketConn: pc,
+		timer:      time.AfterFunc(lifetime, func() { pc.Close() }),
+	}
+}
+
+func (c *lifetimePacketConn) Close() error {
+	c.timer.Stop()
+	return c.PacketConn.Close()
+}
+
+// probeConn wraps the raw server connection so DialContext can check the
+// server didn't hang up right after the handshake, without losing anything
+// it may have sent already.
+type probeConn struct {
+	net.Conn
+	buf []byte
+}
+
+func (c *probeConn) Read(b []byte) (int, error) {
+	if len(c.buf) != 0 {
+		n := copy(b, c.buf)
+		c.buf = c.buf[n:]
+		return n, nil
+	}
+	return c.Conn.Read(b)
+}
+
+// probe waits up to window for the server to close or reset the connection.
+// It must be called before anything else reads from the connection.
+func (c *probeConn) probe(window time.Duration) error {
+	buf := make([]byte, 512)
+	c.Conn.SetReadDeadline(time.Now().Add(window))
+	n, err := c.Conn.Read(buf)
+	c.Conn.SetReadDeadline(time.Time{})
+	c.buf = buf[:n]
+
+	// anything received means the server is alive, a close after it is
+	// seen again by the next Read
+	if err == nil || n != 0 {
+		return nil
+	}
+	if isTimeout(err) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrEarlyClose, err.Error())
+}
+
+// how often migratePacketConn checks its local address is still there
+const migrateCheckInterval = 5 * time.Second
+
+// migratePacketConn re-establishes the vless UDP stream when it breaks or
+// when the local address it was bound to goes away, e.g. after switching
+// Wi-Fi networks. Datagrams in flight at that moment are lost.
+type migratePacketConn struct {
+	dial   func() (net.PacketConn, error)
+	mux    sync.Mutex
+	pc     net.PacketConn
+	closed *atomic.Bool
+	done   chan struct{}
+
+	// carried over to the new stream
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+func newMigratePacketConn(pc net.PacketConn, dial func() (net.PacketConn, error)) *migratePacketConn {
+	c := &migratePacketConn{
+		dial:   dial,
+		pc:     pc,
+		closed: atomic.NewBool(false),
+		done:   make(chan struct{}),
+	}
+	go c.watch()
+	return c
+}
+
+func (c *migratePacketConn) current() net.PacketConn {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.pc
+}
+
+// migrate replaces broken with a new stream, unless it was replaced already.
+func (c *migratePacketConn) migrate(broken net.PacketConn) (net.PacketConn, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.closed.Load() {
+		return nil, net.ErrClosed
+	}
+	if c.pc != broken {
+		return c.pc, nil
+	}
+
+	pc, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	pc.SetReadDeadline(c.readDeadline)
+	pc.SetWriteDeadline(c.writeDeadline)
+	broken.Close()
+	c.pc = pc
+	log.Debugln("[Vless] udp stream migrated to %s", pc.LocalAddr().String())
+	return pc, nil
+}
+
+// watch closes the stream once its local address disappears, so the next
+// read or write migrates it.
+func (c *migratePacketConn) watch() {
+	ticker := time.NewTicker(migrateCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+
+		pc := c.current()
+		if addr, ok := pc.LocalAddr().(*net.TCPAddr); ok && !localIPExists(addr.IP) {
+			pc.Close()
+		}
+	}
+}
+
+func (c *migratePacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	pc := c.current()
+	n, addr, err := pc.ReadFrom(b)
+	if err == nil || c.closed.Load() || isTimeout(err) {
+		return n, addr, err
+	}
+
+	if pc, err = c.migrate(pc); err != nil {
+		return 0, nil, err
+	}
+	return pc.ReadFrom(b)
+}
+
+func (c *migratePacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	pc := c.current()
+	n, err := pc.WriteTo(b, addr)
+	if err == nil || c.closed.Load() || isTimeout(err) {
+		return n, err
+	}
+
+	if pc, err = c.migrate(pc); err != nil {
+		return 0, err
+	}
+	return pc.WriteTo(b, addr)
+}
+
+func (c *migratePacketConn) Close() error {
+	if c.closed.CAS(false, true) {
+		close(c.done)
+	}
+	return c.current().Close()
+}
+
+func (c *migratePacketConn) LocalAddr() net.Addr {
+	return c.current().LocalAddr()
+}
+
+func (c *migratePacketConn) SetDeadline(t time.Time) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.readDeadline, c.writeDeadline = t, t
+	return c.pc.SetDeadline(t)
+}
+
+func (c *migratePacketConn) SetReadDeadline(t time.Time) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.readDeadline = t
+	return c.pc.SetReadDeadline(t)
+}
+
+func (c *migratePacketConn) SetWriteDeadline(t time.Time) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.writeDeadline = t
+	return c.pc.SetWriteDeadline(t)
+}
+
+// isTimeout reports whether err is a deadline passing, which says nothing
+// about the stream
+func isTimeout(err error) bool {
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
+
+func localIPExists(ip net.IP) bool {
+	if ip == nil || ip.IsUnspecified() || ip.IsLoopback() {
+		return true
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return true
+	}
+
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+type readAheadPacket struct {
+	buf  []byte
+	n    int
+	addr net.Addr
+}
+
+// readAheadPacketConn drains a stream-framed PacketConn from one goroutine
+// into a queue of up to size datagrams, udp-read-ahead, so decoding the
+// stream is not blocked by the consumer. It is not parallel: the stream can
+// only be decoded sequentially, which keeps datagrams in order.
+type readAheadPacketConn struct {
+	net.PacketConn
+	packets chan *readAheadPacket
+	done    chan struct{}
+	once    sync.Once
+	err     error
+}
+
+func newReadAheadPacketConn(pc net.PacketConn, size int) *readAheadPacketConn {
+	c := &readAheadPacketConn{
+		PacketConn: pc,
+		packets:    make(chan *readAheadPacket, size),
+		done:       make(chan struct{}),
+	}
+	go c.readLoop()
+	return c
+}
+
+func (c *readAheadPacketConn) readLoop() {
+	defer close(c.packets)
+	for {
+		buf := pool.Get(pool.RelayBufferSize)
+		n, addr, err := c.PacketConn.ReadFrom(buf)
+		if err != nil {
+			pool.Put(buf)
+			c.err = err
+			return
+		}
+
+		select {
+		case c.packets <- &readAheadPacket{buf: buf, n: n, addr: addr}:
+		case <-c.done:
+			pool.Put(buf)
+			return
+		}
+	}
+}
+
+func (c *readAheadPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	p, ok := <-c.packets
+	if !ok {
+		if c.err == nil {
+			return 0, nil, net.ErrClosed
+		}
+		return 0, nil, c.err
+	}
+	defer pool.Put(p.buf)
+
+	n := copy(b, p.buf[:p.n])
+	return n, p.addr, nil
+}
+
+func (c *readAheadPacketConn) Close() error {
+	c.once.Do(func() { close(c.done) })
+	return c.PacketConn.Close()
+}
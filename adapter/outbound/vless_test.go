@@ -0,0 +1,1233 @@
+package outbound
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/common/structure"
+	"github.com/Dreamacro/clash/component/dialer"
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/transport/vless"
+	"github.com/Dreamacro/clash/transport/vmess"
+
+	"github.com/stretchr/testify/assert"
+	xtls "github.com/xtls/go"
+	"go.uber.org/atomic"
+	"golang.org/x/net/http2"
+)
+
+const testVlessUUID = "b831381d-6324-4d53-ad4f-8cda48b30811"
+
+func newTestVless(t *testing.T, option VlessOption) *Vless {
+	option.Name = "test"
+	option.UUID = testVlessUUID
+	option.UseSystemCA = true
+	if option.Server == "" {
+		option.Server = "vless.example.com"
+		option.Port = 443
+	}
+
+	v, err := NewVless(option)
+	assert.Nil(t, err)
+	return v
+}
+
+func testMetadata() *C.Metadata {
+	return &C.Metadata{
+		NetWork:  C.TCP,
+		Host:     "example.com",
+		DstPort:  "80",
+		AddrType: C.AtypDomainName,
+	}
+}
+
+func TestVless_SetDefaultDialer(t *testing.T) {
+	var dialed string
+	restore := SetDefaultDialer(dialerFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialed = address
+		local, remote := net.Pipe()
+		go func() {
+			defer remote.Close()
+			remote.Read(make([]byte, 1024))
+			remote.Write([]byte{0, 0, 'o', 'k'})
+		}()
+		return local, nil
+	}))
+
+	v := newTestVless(t, VlessOption{})
+	c, err := v.DialContext(context.Background(), testMetadata())
+	assert.Nil(t, err)
+	defer c.Close()
+	assert.Equal(t, "vless.example.com:443", dialed)
+
+	buf := make([]byte, 2)
+	_, err = io.ReadFull(c, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "ok", string(buf))
+
+	restore()
+	errReset := errors.New("connection reset")
+	restore = SetDefaultDialer(dialerFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+		return nil, errReset
+	}))
+	defer restore()
+
+	_, err = v.DialContext(context.Background(), testMetadata())
+	assert.NotNil(t, err)
+
+	metrics := v.Metrics()
+	assert.Equal(t, "test", metrics.Proxy)
+	assert.Equal(t, "vless", metrics.Type)
+	assert.Equal(t, "tcp", metrics.Transport)
+	assert.Equal(t, int64(1), metrics.Connections)
+	assert.Equal(t, int64(1), metrics.ActiveConnections)
+	assert.Equal(t, int64(1), metrics.HandshakeFailures)
+	assert.Equal(t, int64(len("ok")), metrics.DownloadBytes)
+
+	c.Close()
+	assert.Equal(t, int64(0), v.Metrics().ActiveConnections)
+}
+
+func TestVlessOption_Duration(t *testing.T) {
+	decoder := structure.NewDecoder(structure.Option{TagName: "proxy", WeaklyTypedInput: true})
+	decode := func(mapping map[string]interface{}) (VlessOption, error) {
+		option := VlessOption{}
+		err := decoder.Decode(mapping, &option)
+		return option, err
+	}
+	base := func(extra map[string]interface{}) map[string]interface{} {
+		mapping := map[string]interface{}{
+			"name":   "test",
+			"server": "127.0.0.1",
+			"port":   443,
+			"uuid":   testVlessUUID,
+		}
+		for k, v := range extra {
+			mapping[k] = v
+		}
+		return mapping
+	}
+
+	option, err := decode(base(map[string]interface{}{
+		"early-close-window":      500,
+		"max-connection-lifetime": "10",
+	}))
+	assert.Nil(t, err)
+	assert.Equal(t, 500*time.Millisecond, option.EarlyCloseWindow.Of(time.Millisecond))
+	assert.Equal(t, 10*time.Second, option.MaxConnectionLifetime.Of(time.Second))
+
+	option, err = decode(base(map[string]interface{}{
+		"early-close-window":      "1s",
+		"max-connection-lifetime": "90m",
+	}))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Second, option.EarlyCloseWindow.Of(time.Millisecond))
+	assert.Equal(t, 90*time.Minute, option.MaxConnectionLifetime.Of(time.Second))
+
+	_, err = decode(base(map[string]interface{}{"early-close-window": "soon"}))
+	assert.NotNil(t, err)
+}
+
+func TestVless_TLSDowngrade(t *testing.T) {
+	v := newTestVless(t, VlessOption{TLS: true})
+	client, server := net.Pipe()
+	defer server.Close()
+
+	assert.False(t, v.tlsEstablished(client))
+	assert.False(t, v.tlsEstablished(tls.Client(client, &tls.Config{})))
+
+	v.tlsConfig = nil
+	_, err := v.StreamConn(client, testMetadata())
+	assert.ErrorIs(t, err, errTLSDowngrade)
+}
+
+func TestVless_EvictRejectedSession(t *testing.T) {
+	v := newTestVless(t, VlessOption{TLS: true, ServerName: "rotated.example.com"})
+	cache := v.tlsConfig.ClientSessionCache
+	cache.Put("rotated.example.com", &tls.ClientSessionState{})
+
+	handshake := func() error {
+		client, server := net.Pipe()
+		go func() {
+			io.CopyN(io.Discard, server, 5)
+			server.Close()
+		}()
+		_, err := v.streamTransport(client)
+		return err
+	}
+
+	assert.NotNil(t, handshake())
+	_, ok := cache.Get("rotated.example.com")
+	assert.True(t, ok)
+
+	assert.NotNil(t, handshake())
+	_, ok = cache.Get("rotated.example.com")
+	assert.False(t, ok)
+}
+
+func TestVless_ConnEvents(t *testing.T) {
+	sub := SubscribeConnEvents()
+	defer UnSubscribeConnEvents(sub)
+
+	restore := SetDefaultDialer(dialerFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+		return nil, errors.New("unreachable")
+	}))
+	defer restore()
+
+	// events of the other tests may still be in flight
+	v, err := NewVless(VlessOption{
+		Name:   "events",
+		Server: "vless.example.com",
+		Port:   443,
+		UUID:   testVlessUUID,
+	})
+	assert.Nil(t, err)
+	_, err = v.DialContext(context.Background(), testMetadata())
+	assert.NotNil(t, err)
+
+	var types []string
+	for len(types) < 2 {
+		select {
+		case elm := <-sub:
+			event := elm.(*ConnEvent)
+			if event.Proxy == "events" {
+				types = append(types, event.Type)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("missing events")
+		}
+	}
+	assert.Equal(t, []string{EventDialStart, EventError}, types)
+}
+
+func TestVless_ForceDestination(t *testing.T) {
+	v := newTestVless(t, VlessOption{ForceDestination: "10.0.0.1:8443"})
+	dst, err := v.destination(testMetadata())
+	assert.Nil(t, err)
+	assert.Equal(t, C.AtypIPv4, dst.AddrType)
+	assert.Equal(t, "10.0.0.1", dst.DstIP.String())
+	assert.Equal(t, "8443", dst.DstPort)
+	assert.Equal(t, C.TCP, dst.NetWork)
+
+	v = newTestVless(t, VlessOption{ForceDestination: "relay.example.com:443"})
+	dst, err = v.destination(testMetadata())
+	assert.Nil(t, err)
+	assert.Equal(t, "relay.example.com", dst.Host)
+
+	for _, addr := range []string{"relay.example.com", ":443", "relay.example.com:0", "relay.example.com:http"} {
+		_, err := NewVless(VlessOption{
+			Name:             "test",
+			Server:           "vless.example.com",
+			Port:             443,
+			UUID:             testVlessUUID,
+			ForceDestination: addr,
+		})
+		assert.NotNil(t, err, addr)
+	}
+}
+
+func TestVless_SharedSessionCache(t *testing.T) {
+	const n = 32
+	caches := make([]tls.ClientSessionCache, n)
+	xcaches := make([]xtls.ClientSessionCache, n)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			option := VlessOption{TLS: true}
+			if i%2 == 1 {
+				option.Flow = vless.XRD
+			}
+			v := newTestVless(t, option)
+
+			client, server := net.Pipe()
+			go server.Close()
+			_, err := v.StreamConn(client, testMetadata())
+			assert.NotNil(t, err)
+
+			caches[i] = v.tlsConfig.ClientSessionCache
+			if v.xtlsConfig != nil {
+				xcaches[i] = v.xtlsConfig.ClientSessionCache
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < n; i++ {
+		assert.True(t, caches[i] == getClientSessionCache())
+		if i%2 == 1 {
+			assert.True(t, xcaches[i] == getClientXSessionCache())
+		}
+	}
+	assert.NotNil(t, getClientSessionCache())
+	assert.NotNil(t, getClientXSessionCache())
+}
+
+// newTestCert returns a self-signed certificate for host
+func newTestCert(t *testing.T, host string) tls.Certificate {
+	return newTestCertWithExtensions(t, host)
+}
+
+func newTestCertWithExtensions(t *testing.T, host string, extensions ...pkix.Extension) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: host},
+		DNSNames:              []string{host},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		ExtraExtensions:       extensions,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+
+	leaf, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestVless_UseSystemCA(t *testing.T) {
+	option := VlessOption{
+		Name:   "test",
+		Server: "vless.example.com",
+		Port:   443,
+		UUID:   testVlessUUID,
+		TLS:    true,
+	}
+
+	_, err := NewVless(option)
+	assert.NotNil(t, err)
+
+	option.SkipCertVerify = true
+	_, err = NewVless(option)
+	assert.Nil(t, err)
+
+	cert := newTestCert(t, "vless.example.com")
+	option.SkipCertVerify = false
+	option.CA = writeTestCA(t, cert)
+
+	v, err := NewVless(option)
+	assert.Nil(t, err)
+	_, err = cert.Leaf.Verify(x509.VerifyOptions{DNSName: "vless.example.com", Roots: v.tlsConfig.RootCAs})
+	assert.Nil(t, err)
+	assert.Len(t, v.tlsConfig.RootCAs.Subjects(), 1)
+
+	option.CA = filepath.Join(t.TempDir(), "missing.pem")
+	_, err = NewVless(option)
+	assert.NotNil(t, err)
+}
+
+func TestVless_MaxHandshakeBytes(t *testing.T) {
+	v := newTestVless(t, VlessOption{MaxHandshakeBytes: 64})
+
+	// addons of a response header past the limit
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		server.Read(make([]byte, 1024))
+		server.Write(append([]byte{0, 255}, make([]byte, 255)...))
+	}()
+
+	c, err := v.StreamConn(client, testMetadata())
+	assert.Nil(t, err)
+	_, err = c.Read(make([]byte, 1024))
+	assert.ErrorIs(t, err, errHandshakeTooLarge)
+
+	// the limit is gone once the response header is parsed, the read
+	// going on with the payload included
+	client, server = net.Pipe()
+	defer server.Close()
+	payload := make([]byte, 4096)
+	go func() {
+		server.Read(make([]byte, 1024))
+		server.Write(append([]byte{0, 0}, payload...))
+	}()
+
+	c, err = v.StreamConn(client, testMetadata())
+	assert.Nil(t, err)
+	buf := make([]byte, len(payload))
+	_, err = io.ReadFull(c, buf)
+	assert.Nil(t, err)
+
+	_, err = NewVless(VlessOption{
+		Name:              "vless",
+		Server:            "vless.example.com",
+		Port:              443,
+		UUID:              testVlessUUID,
+		TLS:               true,
+		Flow:              vless.XRV,
+		MaxHandshakeBytes: 64,
+	})
+	assert.NotNil(t, err)
+}
+
+func TestVless_LeastLoad(t *testing.T) {
+	const secondUUID = "a3482e88-686a-4a58-8126-99c9df64b7bf"
+
+	type dial struct {
+		addr string
+		uuid string
+	}
+	dials := make(chan dial, 8)
+	restore := SetDefaultDialer(dialerFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+		local, remote := net.Pipe()
+		go func() {
+			defer remote.Close()
+			buf := make([]byte, 1024)
+			n, _ := remote.Read(buf)
+			if n >= 17 {
+				dials <- dial{address, uuidString(buf[1:17])}
+			}
+			remote.Read(buf)
+		}()
+		return local, nil
+	}))
+	defer restore()
+
+	v := newTestVless(t, VlessOption{
+		Servers:             []string{"backup.example.com:443"},
+		UUIDs:               []string{secondUUID},
+		MultiServerStrategy: "least-load",
+	})
+
+	var conns []C.Conn
+	for i := 0; i < 4; i++ {
+		c, err := v.DialContext(context.Background(), testMetadata())
+		assert.Nil(t, err)
+		conns = append(conns, c)
+	}
+	// the fake servers report concurrently, order aside every pair got one
+	assert.ElementsMatch(t, []dial{
+		{"vless.example.com:443", testVlessUUID},
+		{"vless.example.com:443", secondUUID},
+		{"backup.example.com:443", testVlessUUID},
+		{"backup.example.com:443", secondUUID},
+	}, []dial{<-dials, <-dials, <-dials, <-dials})
+
+	conns[2].Close()
+	c, err := v.DialContext(context.Background(), testMetadata())
+	assert.Nil(t, err)
+	defer c.Close()
+	assert.Equal(t, dial{"backup.example.com:443", testVlessUUID}, <-dials)
+
+	for _, c := range conns {
+		c.Close()
+	}
+
+	_, err = NewVless(VlessOption{
+		Name:   "test",
+		Server: "vless.example.com",
+		Port:   443,
+		UUID:   testVlessUUID,
+		UUIDs:  []string{secondUUID},
+	})
+	assert.NotNil(t, err)
+}
+
+func uuidString(b []byte) string {
+	h := hex.EncodeToString(b)
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+func TestVless_GrpcServiceName(t *testing.T) {
+	v := newTestVless(t, VlessOption{TLS: true, Network: "grpc", GrpcServiceName: "tunnel"})
+	assert.Equal(t, "tunnel", v.gunConfig.ServiceName)
+	assert.Equal(t, "vless.example.com", v.gunConfig.Host)
+
+	v = newTestVless(t, VlessOption{
+		TLS:             true,
+		Network:         "grpc",
+		GrpcServiceName: "tunnel",
+		GrpcOpts:        GrpcOptions{GrpcServiceName: "nested"},
+	})
+	assert.Equal(t, "nested", v.gunConfig.ServiceName)
+}
+
+func TestVless_VisionFlow(t *testing.T) {
+	v := newTestVless(t, VlessOption{TLS: true, Flow: vless.XRV})
+	assert.Nil(t, v.xtlsConfig)
+	assert.NotNil(t, v.tlsConfig)
+
+	_, err := NewVless(VlessOption{
+		Name:        "test",
+		Server:      "vless.example.com",
+		Port:        443,
+		UUID:        testVlessUUID,
+		TLS:         true,
+		UseSystemCA: true,
+		Network:     "ws",
+		Flow:        vless.XRV,
+	})
+	assert.NotNil(t, err)
+
+	_, err = v.DialUDP(&C.Metadata{NetWork: C.UDP, DstIP: net.ParseIP("1.1.1.1"), DstPort: "443", AddrType: C.AtypIPv4})
+	assert.NotNil(t, err)
+}
+
+func TestVless_ClientFingerprint(t *testing.T) {
+	option := VlessOption{
+		Name:              "test",
+		Server:            "vless.example.com",
+		Port:              443,
+		UUID:              testVlessUUID,
+		TLS:               true,
+		UseSystemCA:       true,
+		ClientFingerprint: "netscape",
+	}
+	_, err := NewVless(option)
+	assert.NotNil(t, err)
+
+	option.ClientFingerprint = "chrome"
+	option.Flow = vless.XRD
+	_, err = NewVless(option)
+	assert.NotNil(t, err)
+
+	option.Flow = ""
+	option.TLS = false
+	_, err = NewVless(option)
+	assert.NotNil(t, err)
+
+	v := newTestVless(t, VlessOption{TLS: true, SkipCertVerify: true, ClientFingerprint: "chrome"})
+	assert.NotNil(t, v.clientHello)
+
+	client, server := net.Pipe()
+	defer server.Close()
+	hello := make(chan string, 1)
+	go func() {
+		tlsConn := tls.Server(server, &tls.Config{
+			Certificates: []tls.Certificate{newTestCert(t, "vless.example.com")},
+			GetConfigForClient: func(info *tls.ClientHelloInfo) (*tls.Config, error) {
+				hello <- info.ServerName
+				return nil, nil
+			},
+		})
+		if tlsConn.Handshake() != nil {
+			return
+		}
+		io.Copy(io.Discard, tlsConn)
+	}()
+
+	c, err := v.streamTransport(client)
+	assert.Nil(t, err)
+	assert.True(t, v.tlsEstablished(c))
+	assert.Equal(t, "vless.example.com", <-hello)
+	c.Close()
+}
+
+// chunkedConn hands out its data a few bytes per Read
+type chunkedConn struct {
+	net.Conn
+	data  []byte
+	sizes []int
+}
+
+func (c *chunkedConn) Read(b []byte) (int, error) {
+	if len(c.data) == 0 {
+		return 0, io.EOF
+	}
+	size := 1
+	if len(c.sizes) > 0 {
+		size, c.sizes = c.sizes[0], c.sizes[1:]
+	}
+	if size > len(b) {
+		size = len(b)
+	}
+	if size > len(c.data) {
+		size = len(c.data)
+	}
+	n := copy(b, c.data[:size])
+	c.data = c.data[n:]
+	return n, nil
+}
+
+// paddedConn is a vless conn whose server acknowledged the udp padding
+type paddedConn struct {
+	*chunkedConn
+	padTo int
+}
+
+func (c *paddedConn) DownlinkPadTo() int {
+	return c.padTo
+}
+
+func TestVlessPacketConn_FragmentedRead(t *testing.T) {
+	packets := [][]byte{[]byte("hello"), []byte("vless"), []byte("datagram boundaries")}
+
+	for _, padTo := range []int{0, 32} {
+		var stream []byte
+		for _, p := range packets {
+			stream = append(stream, byte(len(p)>>8), byte(len(p)))
+			stream = append(stream, p...)
+			for i := len(p); i < padTo; i++ {
+				stream = append(stream, 0)
+			}
+		}
+
+		conn := &paddedConn{
+			chunkedConn: &chunkedConn{data: stream, sizes: []int{1, 4, 3, 2, 5, 1, 1, 7}},
+			padTo:       padTo,
+		}
+		pc := newVlessPacketConn(conn, nil, padTo)
+		buf := make([]byte, 1024)
+		for _, p := range packets {
+			n, _, err := pc.ReadFrom(buf)
+			assert.Nil(t, err)
+			assert.Equal(t, p, buf[:n])
+		}
+		_, _, err := pc.ReadFrom(buf)
+		assert.ErrorIs(t, err, io.EOF)
+	}
+}
+
+func TestVless_ALPN(t *testing.T) {
+	v := newTestVless(t, VlessOption{TLS: true})
+	assert.Nil(t, v.tlsConfig.NextProtos)
+
+	v = newTestVless(t, VlessOption{TLS: true, Flow: vless.XRD, ALPN: []string{"h2", "http/1.1"}})
+	assert.Equal(t, []string{"h2", "http/1.1"}, v.tlsConfig.NextProtos)
+	assert.Equal(t, []string{"h2", "http/1.1"}, v.xtlsConfig.NextProtos)
+
+	_, err := NewVless(VlessOption{
+		Name:        "test",
+		Server:      "vless.example.com",
+		Port:        443,
+		UUID:        testVlessUUID,
+		TLS:         true,
+		UseSystemCA: true,
+		ALPN:        []string{"h2", ""},
+	})
+	assert.NotNil(t, err)
+}
+
+func TestVless_WSEarlyData(t *testing.T) {
+	v := newTestVless(t, VlessOption{
+		Network:             "ws",
+		MaxEarlyData:        2048,
+		EarlyDataHeaderName: "Sec-WebSocket-Protocol",
+	})
+	assert.Equal(t, 2048, v.option.WSOpts.MaxEarlyData)
+
+	client, server := net.Pipe()
+	defer server.Close()
+	earlyData := make(chan []byte, 1)
+	go func() {
+		req, err := http.ReadRequest(bufio.NewReader(server))
+		if err != nil {
+			return
+		}
+		data, _ := base64.RawURLEncoding.DecodeString(req.Header.Get("Sec-WebSocket-Protocol"))
+		earlyData <- data
+
+		accept := sha1.Sum([]byte(req.Header.Get("Sec-WebSocket-Key") + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+		server.Write([]byte("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+			"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(accept[:]) + "\r\n\r\n"))
+		io.Copy(io.Discard, server)
+	}()
+
+	c, err := v.StreamConn(client, testMetadata())
+	assert.Nil(t, err)
+
+	data := <-earlyData
+	// the vless request header rides in the upgrade request
+	assert.True(t, len(data) > 17)
+	assert.Equal(t, byte(0), data[0])
+	assert.Equal(t, testVlessUUID, uuidString(data[1:17]))
+	c.Close()
+}
+
+type recordingOptionDialer struct {
+	dialerFunc
+	options chan int
+}
+
+func (d recordingOptionDialer) DialContextWithOptions(ctx context.Context, network, address string, options ...dialer.Option) (net.Conn, error) {
+	d.options <- len(options)
+	return d.dialerFunc(ctx, network, address)
+}
+
+func TestVless_DialerOptions(t *testing.T) {
+	errDial := errors.New("dial")
+	d := recordingOptionDialer{
+		dialerFunc: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return nil, errDial
+		},
+		options: make(chan int, 4),
+	}
+	restore := SetDefaultDialer(d)
+	defer restore()
+
+	v := newTestVless(t, VlessOption{})
+	_, err := v.DialContext(context.Background(), testMetadata())
+	assert.NotNil(t, err)
+	assert.Len(t, d.options, 0)
+
+	v = newTestVless(t, VlessOption{BasicOption: BasicOption{Interface: "eth1", RoutingMark: 255}})
+	assert.Len(t, v.dialOptions, 2)
+	_, err = v.DialContext(context.Background(), testMetadata())
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, <-d.options)
+
+	_, err = v.DialUDP(&C.Metadata{NetWork: C.UDP, DstIP: net.ParseIP("1.1.1.1"), DstPort: "53", AddrType: C.AtypIPv4})
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, <-d.options)
+}
+
+func TestVless_HappyEyeballs(t *testing.T) {
+	ip4, ip4b, ip6 := net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2"), net.ParseIP("2001:db8::1")
+	assert.Equal(t, []net.IP{ip6, ip4, ip4b}, dialer.SortIPs([]net.IP{ip4, ip4b, ip6}, dialer.DualStack))
+
+	canceled := make(chan string, 1)
+	d := dialerFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+		if network == "tcp6" {
+			// a dead ipv6 route
+			<-ctx.Done()
+			canceled <- address
+			return nil, ctx.Err()
+		}
+		local, remote := net.Pipe()
+		go remote.Close()
+		return local, nil
+	})
+
+	v := newTestVless(t, VlessOption{})
+	start := time.Now()
+	c, err := v.dialHappyEyeballs(context.Background(), d, []net.IP{ip4, ip6}, "443")
+	assert.Nil(t, err)
+	c.Close()
+	assert.True(t, time.Since(start) >= dialer.ConnectionAttemptDelay)
+	assert.Equal(t, "[2001:db8::1]:443", <-canceled)
+
+	v = newTestVless(t, VlessOption{BasicOption: BasicOption{IPVersion: "ipv4"}})
+	assert.Equal(t, "tcp4", v.tcpNetwork(nil))
+	ips, err := v.resolveServer("2001:db8::1")
+	assert.NotNil(t, err)
+	assert.Nil(t, ips)
+
+	_, err = NewVless(VlessOption{
+		Name:        "test",
+		Server:      "vless.example.com",
+		Port:        443,
+		UUID:        testVlessUUID,
+		BasicOption: BasicOption{IPVersion: "ipv5"},
+	})
+	assert.NotNil(t, err)
+}
+
+// tlsHandshake runs the tls handshake of v against a server with cert
+func tlsHandshake(v *Vless, cert tls.Certificate) error {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		tls.Server(server, &tls.Config{Certificates: []tls.Certificate{cert}}).Handshake()
+	}()
+	_, err := v.streamTransport(client)
+	return err
+}
+
+// writeTestCA writes cert to a ca file and returns its path
+func writeTestCA(t *testing.T, cert tls.Certificate) string {
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o644)
+	assert.Nil(t, err)
+	return path
+}
+
+func TestVless_PinSHA256(t *testing.T) {
+	cert := newTestCert(t, "vless.example.com")
+	spki := sha256.Sum256(cert.Leaf.RawSubjectPublicKeyInfo)
+	pin := base64.StdEncoding.EncodeToString(spki[:])
+	other := base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+
+	v := newTestVless(t, VlessOption{TLS: true, SkipCertVerify: true, PinSHA256: pin})
+	assert.Nil(t, tlsHandshake(v, cert))
+
+	v = newTestVless(t, VlessOption{TLS: true, SkipCertVerify: true, PinSHA256: other})
+	assert.NotNil(t, tlsHandshake(v, cert))
+
+	for _, malformed := range []string{"not base64!", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		_, err := NewVless(VlessOption{
+			Name:           "test",
+			Server:         "vless.example.com",
+			Port:           443,
+			UUID:           testVlessUUID,
+			TLS:            true,
+			SkipCertVerify: true,
+			PinSHA256:      malformed,
+		})
+		assert.NotNil(t, err)
+	}
+}
+
+func TestVless_VerifyHost(t *testing.T) {
+	cert := newTestCert(t, "origin.example.com")
+	ca := writeTestCA(t, cert)
+
+	// the sni of a cdn, the certificate of the origin
+	v := newTestVless(t, VlessOption{TLS: true, CA: ca, ServerName: "cdn.example.com", VerifyHost: "origin.example.com"})
+	assert.Nil(t, tlsHandshake(v, cert))
+
+	v = newTestVless(t, VlessOption{TLS: true, CA: ca, ServerName: "cdn.example.com", VerifyHost: "other.example.com"})
+	assert.NotNil(t, tlsHandshake(v, cert))
+
+	// verified against the sni without verify-host
+	v = newTestVless(t, VlessOption{TLS: true, CA: ca, ServerName: "cdn.example.com"})
+	assert.NotNil(t, tlsHandshake(v, cert))
+
+	v = newTestVless(t, VlessOption{TLS: true, CA: ca, ServerName: "origin.example.com"})
+	assert.Nil(t, tlsHandshake(v, cert))
+
+	// the chain is still verified
+	v = newTestVless(t, VlessOption{TLS: true, CA: writeTestCA(t, newTestCert(t, "origin.example.com")), ServerName: "cdn.example.com", VerifyHost: "origin.example.com"})
+	assert.NotNil(t, tlsHandshake(v, cert))
+}
+
+func TestVless_CertSerial(t *testing.T) {
+	cert := newTestCert(t, "vless.example.com")
+
+	for _, serial := range []string{"01", "0x01", "00:01"} {
+		v := newTestVless(t, VlessOption{TLS: true, SkipCertVerify: true, CertSerial: serial})
+		assert.Nil(t, tlsHandshake(v, cert), serial)
+	}
+
+	v := newTestVless(t, VlessOption{TLS: true, SkipCertVerify: true, CertSerial: "02"})
+	assert.NotNil(t, tlsHandshake(v, cert))
+
+	// on top of the chain verification
+	v = newTestVless(t, VlessOption{TLS: true, CA: writeTestCA(t, cert), CertSerial: "01"})
+	assert.Nil(t, tlsHandshake(v, cert))
+
+	v = newTestVless(t, VlessOption{TLS: true, CA: writeTestCA(t, newTestCert(t, "vless.example.com")), CertSerial: "01"})
+	assert.NotNil(t, tlsHandshake(v, cert))
+
+	_, err := NewVless(VlessOption{
+		Name:           "test",
+		Server:         "vless.example.com",
+		Port:           443,
+		UUID:           testVlessUUID,
+		TLS:            true,
+		SkipCertVerify: true,
+		CertSerial:     "not hex",
+	})
+	assert.NotNil(t, err)
+}
+
+func TestVless_Mux(t *testing.T) {
+	dials := make(chan net.Conn, 4)
+	restore := SetDefaultDialer(dialerFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+		local, remote := net.Pipe()
+		dials <- remote
+		go func() {
+			// the mux request, then swallow the frames
+			header := make([]byte, 1+16+1+1)
+			if _, err := io.ReadFull(remote, header); err != nil || header[18] != vless.CommandMux {
+				remote.Close()
+				return
+			}
+			remote.Write([]byte{vless.Version, 0})
+			io.Copy(io.Discard, remote)
+		}()
+		return local, nil
+	}))
+	defer restore()
+
+	v := newTestVless(t, VlessOption{Mux: &MuxOption{Enabled: true, Concurrency: 2}})
+	assert.Equal(t, 2, v.muxPool.concurrency)
+
+	var conns []C.Conn
+	for i := 0; i < 3; i++ {
+		c, err := v.DialContext(context.Background(), testMetadata())
+		assert.Nil(t, err)
+		conns = append(conns, c)
+	}
+	// two streams share the first conn, the third one needs another
+	assert.Len(t, dials, 2)
+	first := <-dials
+	<-dials
+
+	// the streams of a broken conn end, its place goes to a new one once
+	// the live conn is full
+	first.Close()
+	_, err := conns[0].Read(make([]byte, 1))
+	assert.NotNil(t, err)
+	for i := 0; i < 2; i++ {
+		c, err := v.DialContext(context.Background(), testMetadata())
+		assert.Nil(t, err)
+		conns = append(conns, c)
+	}
+	assert.Len(t, dials, 1)
+	<-dials
+
+	// a new server retires the sessions to the old one
+	assert.Nil(t, v.UpdateServer("new.example.com", 443))
+	c, err := v.DialContext(context.Background(), testMetadata())
+	assert.Nil(t, err)
+	conns = append(conns, c)
+	assert.Len(t, dials, 1)
+	<-dials
+
+	// streams coming during a dial wait for it
+	v = newTestVless(t, VlessOption{Mux: &MuxOption{Enabled: true, Concurrency: 4}})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c, err := v.DialContext(context.Background(), testMetadata())
+			assert.Nil(t, err)
+			c.Close()
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, dials, 1)
+
+	for _, c := range conns {
+		c.Close()
+	}
+
+	_, err = NewVless(VlessOption{
+		Name:        "test",
+		Server:      "vless.example.com",
+		Port:        443,
+		UUID:        testVlessUUID,
+		TLS:         true,
+		UseSystemCA: true,
+		Flow:        vless.XRV,
+		Mux:         &MuxOption{Enabled: true},
+	})
+	assert.NotNil(t, err)
+}
+
+func TestVless_HandshakeTimeout(t *testing.T) {
+	timeout := DurationOf(100 * time.Millisecond)
+	v := newTestVless(t, VlessOption{TLS: true, SkipCertVerify: true, HandshakeTimeout: timeout})
+	assert.Equal(t, 100*time.Millisecond, v.handshakeTimeout())
+	assert.Equal(t, tcpTimeout, newTestVless(t, VlessOption{}).handshakeTimeout())
+
+	// accepts, then never answers the client hello
+	client, server := net.Pipe()
+	defer server.Close()
+	go io.Copy(io.Discard, server)
+
+	start := time.Now()
+	_, err := v.StreamConn(client, testMetadata())
+	assert.NotNil(t, err)
+	assert.True(t, time.Since(start) < time.Second)
+
+	// the deadline is gone once the handshake is done
+	v = newTestVless(t, VlessOption{HandshakeTimeout: timeout})
+	client, server = net.Pipe()
+	defer server.Close()
+	go func() {
+		server.Read(make([]byte, 1024))
+		time.Sleep(200 * time.Millisecond)
+		server.Write([]byte{0, 0, 'o', 'k'})
+	}()
+
+	c, err := v.StreamConn(client, testMetadata())
+	assert.Nil(t, err)
+	buf := make([]byte, 2)
+	_, err = io.ReadFull(c, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "ok", string(buf))
+}
+
+func TestVless_MigrateDeadline(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	dials := 0
+	c := newMigratePacketConn(pc, func() (net.PacketConn, error) {
+		dials++
+		return net.ListenPacket("udp", "127.0.0.1:0")
+	})
+	defer c.Close()
+
+	// a timeout isn't a broken stream
+	c.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, _, err = c.ReadFrom(make([]byte, 16))
+	assert.True(t, errors.Is(err, os.ErrDeadlineExceeded))
+	assert.Equal(t, 0, dials)
+
+	// the deadline goes along with a migration
+	pc.Close()
+	c.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err = c.WriteTo([]byte("ping"), pc.LocalAddr())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, dials)
+	_, _, err = c.ReadFrom(make([]byte, 16))
+	assert.True(t, errors.Is(err, os.ErrDeadlineExceeded))
+}
+
+func TestVless_LifetimeStalledWrite(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	c := newLifetimeConn(client, 50*time.Millisecond)
+
+	// nobody reads, the write only ends with the conn
+	start := time.Now()
+	_, err := c.Write([]byte("stalled"))
+	assert.NotNil(t, err)
+	assert.True(t, time.Since(start) < lifetimeCloseGrace+time.Second)
+}
+
+func TestVless_TransportTLSState(t *testing.T) {
+	cert := newTestCert(t, "vless.example.com")
+	serve := func(server net.Conn, alpn string) {
+		tlsConn := tls.Server(server, &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: []string{alpn}})
+		if tlsConn.Handshake() != nil {
+			return
+		}
+		if alpn == http2.NextProtoTLS {
+			(&http2.Server{}).ServeConn(tlsConn, &http2.ServeConnOpts{
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					io.Copy(io.Discard, r.Body)
+				}),
+			})
+			return
+		}
+		io.Copy(io.Discard, tlsConn)
+	}
+
+	// the websocket upgrade is put off by early data, not the handshake
+	v := newTestVless(t, VlessOption{TLS: true, SkipCertVerify: true, Network: "ws", WSOpts: WSOptions{MaxEarlyData: 2048}})
+	client, server := net.Pipe()
+	defer server.Close()
+	go serve(server, "http/1.1")
+	c, err := v.streamTransport(client)
+	assert.Nil(t, err)
+	assert.True(t, v.tlsEstablished(c))
+	c.Close()
+
+	v = newTestVless(t, VlessOption{TLS: true, SkipCertVerify: true, Network: "grpc"})
+	client, server = net.Pipe()
+	defer server.Close()
+	go serve(server, http2.NextProtoTLS)
+	c, err = v.streamTransport(client)
+	assert.Nil(t, err)
+	assert.True(t, v.tlsEstablished(c))
+	c.Close()
+
+	// plain ws tells no tls
+	v = newTestVless(t, VlessOption{Network: "ws", WSOpts: WSOptions{MaxEarlyData: 2048}})
+	client, server = net.Pipe()
+	defer server.Close()
+	c, err = v.streamTransport(client)
+	assert.Nil(t, err)
+	assert.False(t, v.tlsEstablished(c))
+	c.Close()
+}
+
+func TestVlessPacketConn_PadTo(t *testing.T) {
+	// the request addons carry field 100 with the size, 32
+	padAddon := []byte{0xa0, 0x06, 0x20}
+
+	for _, ack := range []bool{false, true} {
+		client, err := vless.NewClient(testVlessUUID, nil)
+		assert.Nil(t, err)
+		client.UDPPadTo = 32
+
+		local, remote := net.Pipe()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			defer remote.Close()
+			r := bufio.NewReader(remote)
+
+			// version, uuid, addons, udp command, port, ipv4 address
+			header := make([]byte, 1+16+1)
+			io.ReadFull(r, header)
+			addons := make([]byte, header[17])
+			io.ReadFull(r, addons)
+			assert.Equal(t, padAddon, addons)
+			io.ReadFull(r, make([]byte, 1+2+1+4))
+
+			// the uplink frame is padded, the length keeps the size
+			frame := make([]byte, 2+32)
+			_, err := io.ReadFull(r, frame)
+			assert.Nil(t, err)
+			assert.Equal(t, []byte{0, 5}, frame[:2])
+			assert.Equal(t, "query", string(frame[2:7]))
+
+			response := []byte{vless.Version, 0}
+			if ack {
+				response = append([]byte{vless.Version, byte(len(padAddon))}, padAddon...)
+			}
+			remote.Write(response)
+			for _, p := range []string{"first", "second"} {
+				frame := append([]byte{0, byte(len(p))}, p...)
+				if ack {
+					frame = append(frame, make([]byte, 32-len(p))...)
+				}
+				remote.Write(frame)
+			}
+		}()
+
+		conn, err := client.StreamConn(local, &vmess.DstAddr{
+			UDP:      true,
+			AddrType: vmess.AtypIPv4,
+			Addr:     net.IPv4(1, 1, 1, 1).To4(),
+			Port:     53,
+		})
+		if !assert.Nil(t, err) {
+			return
+		}
+		pc := newVlessPacketConn(conn, &net.UDPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 53}, 32)
+		_, err = pc.WriteTo([]byte("query"), nil)
+		assert.Nil(t, err)
+
+		// a server without the ack sends frames unpadded, they aren't eaten
+		buf := make([]byte, 64)
+		for _, p := range []string{"first", "second"} {
+			n, _, err := pc.ReadFrom(buf)
+			assert.Nil(t, err, ack)
+			assert.Equal(t, p, string(buf[:n]), ack)
+		}
+		pc.Close()
+		<-done
+	}
+}
+
+// countingPacketConn returns the datagrams 0, 1, ... up to n, then io.EOF,
+// counting the reads
+type countingPacketConn struct {
+	net.PacketConn
+	n     int
+	reads *atomic.Int32
+}
+
+func (c *countingPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	i := int(c.reads.Inc()) - 1
+	if i >= c.n {
+		return 0, nil, io.EOF
+	}
+	b[0] = byte(i)
+	return 1, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: i}, nil
+}
+
+func (c *countingPacketConn) Close() error {
+	return nil
+}
+
+func TestReadAheadPacketConn(t *testing.T) {
+	src := &countingPacketConn{n: 16, reads: atomic.NewInt32(0)}
+	pc := newReadAheadPacketConn(src, 4)
+
+	// the queue holds 4 datagrams, the loop blocks on the fifth
+	assert.Eventually(t, func() bool { return src.reads.Load() == 5 }, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, int32(5), src.reads.Load())
+
+	buf := make([]byte, 16)
+	for i := 0; i < src.n; i++ {
+		n, addr, err := pc.ReadFrom(buf)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, n)
+		assert.Equal(t, byte(i), buf[0])
+		assert.Equal(t, i, addr.(*net.UDPAddr).Port)
+	}
+	_, _, err := pc.ReadFrom(buf)
+	assert.ErrorIs(t, err, io.EOF)
+
+	// close frees a loop blocked on a full queue
+	src = &countingPacketConn{n: 16, reads: atomic.NewInt32(0)}
+	pc = newReadAheadPacketConn(src, 1)
+	assert.Eventually(t, func() bool { return src.reads.Load() == 2 }, time.Second, time.Millisecond)
+	assert.Nil(t, pc.Close())
+	assert.Eventually(t, func() bool {
+		_, _, err := pc.ReadFrom(buf)
+		return errors.Is(err, net.ErrClosed)
+	}, time.Second, time.Millisecond)
+
+	_, err = NewVless(VlessOption{
+		Name:         "test",
+		Server:       "vless.example.com",
+		Port:         443,
+		UUID:         testVlessUUID,
+		UDPReadAhead: -1,
+	})
+	assert.NotNil(t, err)
+}
+
+func TestVless_ResolvedOption(t *testing.T) {
+	const secondUUID = "a3482e88-686a-4a58-8126-99c9df64b7bf"
+
+	v := newTestVless(t, VlessOption{
+		Servers:             []string{"backup.example.com:443"},
+		UUIDs:               []string{secondUUID},
+		MultiServerStrategy: "least-load",
+		Network:             "ws",
+		WSHeaders: map[string]string{
+			"Host":          "cdn.example.com",
+			"Authorization": "Bearer secret",
+		},
+		WSOpts: WSOptions{
+			Path: "/ws",
+			Headers: map[string]string{
+				"User-Agent":   "Mozilla/5.0",
+				"cookie":       "session=secret",
+				"X-Auth-Token": "secret",
+				"X-Api-Key":    "secret",
+			},
+		},
+	})
+
+	option := v.ResolvedOption()
+	assert.Equal(t, redactedSecret, option.UUID)
+	assert.Equal(t, []string{redactedSecret}, option.UUIDs)
+	assert.Equal(t, map[string]string{
+		"Host":          "cdn.example.com",
+		"Authorization": redactedSecret,
+	}, option.WSHeaders)
+	assert.Equal(t, map[string]string{
+		"User-Agent":   "Mozilla/5.0",
+		"cookie":       redactedSecret,
+		"X-Auth-Token": redactedSecret,
+		"X-Api-Key":    redactedSecret,
+	}, option.WSOpts.Headers)
+	assert.Equal(t, "/ws", option.WSOpts.Path)
+
+	buf, err := json.Marshal(option)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(buf), testVlessUUID)
+	assert.NotContains(t, string(buf), secondUUID)
+	assert.NotContains(t, string(buf), "secret")
+
+	// the adapter keeps the real ones
+	assert.Equal(t, testVlessUUID, v.option.UUID)
+	assert.Equal(t, "Bearer secret", v.option.WSHeaders["Authorization"])
+	assert.Equal(t, "secret", v.option.WSOpts.Headers["X-Auth-Token"])
+}
@@ -11,11 +11,13 @@ import (
 	"strings"
 
 	"github.com/Dreamacro/clash/component/dialer"
+	"github.com/Dreamacro/clash/component/fingerprint"
 	"github.com/Dreamacro/clash/component/resolver"
 	C "github.com/Dreamacro/clash/constant"
 	"github.com/Dreamacro/clash/transport/gun"
 	"github.com/Dreamacro/clash/transport/vmess"
 
+	utls "github.com/refraction-networking/utls"
 	"golang.org/x/net/http2"
 )
 
@@ -23,6 +25,8 @@ type Vmess struct {
 	*Base
 	client *vmess.Client
 	option *VmessOption
+	// TLS presents this ClientHello if set
+	clientHello *utls.ClientHelloID
 
 	// for gun mux
 	gunTLSConfig *tls.Config
@@ -31,6 +35,7 @@ type Vmess struct {
 }
 
 type VmessOption struct {
+	BasicOption    `proxy:",squash"`
 	Name           string            `proxy:"name"`
 	Server         string            `proxy:"server"`
 	Port           int               `proxy:"port"`
@@ -48,6 +53,8 @@ type VmessOption struct {
 	WSHeaders      map[string]string `proxy:"ws-headers,omitempty"`
 	SkipCertVerify bool              `proxy:"skip-cert-verify,omitempty"`
 	ServerName     string            `proxy:"servername,omitempty"`
+	// ClientFingerprint presents the ClientHello of a browser
+	ClientFingerprint string `proxy:"client-fingerprint,omitempty"`
 }
 
 type HTTPOptions struct {
@@ -95,32 +102,27 @@ func (v *Vmess) StreamConn(c net.Conn, metadata *C.Metadata) (net.Conn, error) {
 
 		if len(v.option.WSOpts.Headers) != 0 {
 			header := http.Header{}
-			for key, value := range v.option.WSHeaders {
+			for key, value := range v.option.WSOpts.Headers {
 				header.Add(key, value)
 			}
 			wsOpts.Headers = header
 		}
 
-		if v.option.TLS {
+		if v.option.TLS && v.clientHello != nil {
+			// the websocket runs in the clear over our own TLS
+			c, err = v.streamTLS(c, []string{"http/1.1"})
+			if err != nil {
+				return nil, err
+			}
+		} else if v.option.TLS {
 			wsOpts.TLS = true
 			wsOpts.SkipCertVerify = v.option.SkipCertVerify
 			wsOpts.ServerName = v.option.ServerName
 		}
 		c, err = vmess.StreamWebsocketConn(c, wsOpts)
 	case "http":
-		// readability first, so just copy default TLS logic
 		if v.option.TLS {
-			host, _, _ := net.SplitHostPort(v.addr)
-			tlsOpts := &vmess.TLSConfig{
-				Host:           host,
-				SkipCertVerify: v.option.SkipCertVerify,
-			}
-
-			if v.option.ServerName != "" {
-				tlsOpts.Host = v.option.ServerName
-			}
-
-			c, err = vmess.StreamTLSConn(c, tlsOpts)
+			c, err = v.streamTLS(c, nil)
 			if err != nil {
 				return nil, err
 			}
@@ -136,18 +138,7 @@ func (v *Vmess) StreamConn(c net.Conn, metadata *C.Metadata) (net.Conn, error) {
 
 		c = vmess.StreamHTTPConn(c, httpOpts)
 	case "h2":
-		host, _, _ := net.SplitHostPort(v.addr)
-		tlsOpts := vmess.TLSConfig{
-			Host:           host,
-			SkipCertVerify: v.option.SkipCertVerify,
-			NextProtos:     []string{"h2"},
-		}
-
-		if v.option.ServerName != "" {
-			tlsOpts.Host = v.option.ServerName
-		}
-
-		c, err = vmess.StreamTLSConn(c, &tlsOpts)
+		c, err = v.streamTLS(c, []string{"h2"})
 		if err != nil {
 			return nil, err
 		}
@@ -163,17 +154,7 @@ func (v *Vmess) StreamConn(c net.Conn, metadata *C.Metadata) (net.Conn, error) {
 	default:
 		// handle TLS
 		if v.option.TLS {
-			host, _, _ := net.SplitHostPort(v.addr)
-			tlsOpts := &vmess.TLSConfig{
-				Host:           host,
-				SkipCertVerify: v.option.SkipCertVerify,
-			}
-
-			if v.option.ServerName != "" {
-				tlsOpts.Host = v.option.ServerName
-			}
-
-			c, err = vmess.StreamTLSConn(c, tlsOpts)
+			c, err = v.streamTLS(c, nil)
 		}
 	}
 
@@ -184,6 +165,35 @@ func (v *Vmess) StreamConn(c net.Conn, metadata *C.Metadata) (net.Conn, error) {
 	return v.client.StreamConn(c, parseVmessAddr(metadata))
 }
 
+// streamTLS runs the TLS handshake of the tcp, http, h2 and, with a client
+// fingerprint, ws networks
+func (v *Vmess) streamTLS(c net.Conn, nextProtos []string) (net.Conn, error) {
+	host, _, _ := net.SplitHostPort(v.addr)
+	if v.option.ServerName != "" {
+		host = v.option.ServerName
+	}
+
+	if v.clientHello != nil {
+		uconfig := &utls.Config{
+			ServerName:         host,
+			InsecureSkipVerify: v.option.SkipCertVerify,
+			NextProtos:         nextProtos,
+			ClientSessionCache: getClientUSessionCache(),
+		}
+		uconn, err := fingerprint.Handshake(c, uconfig, *v.clientHello, nextProtos)
+		if err != nil {
+			return nil, err
+		}
+		return uconn, nil
+	}
+
+	return vmess.StreamTLSConn(c, &vmess.TLSConfig{
+		Host:           host,
+		SkipCertVerify: v.option.SkipCertVerify,
+		NextProtos:     nextProtos,
+	})
+}
+
 // DialContext implements C.ProxyAdapter
 func (v *Vmess) DialContext(ctx context.Context, metadata *C.Metadata) (_ C.Conn, err error) {
 	// gun transport
@@ -202,7 +212,7 @@ func (v *Vmess) DialContext(ctx context.Context, metadata *C.Metadata) (_ C.Conn
 		return NewConn(c, v), nil
 	}
 
-	c, err := dialer.DialContext(ctx, "tcp", v.addr)
+	c, err := dialer.DialContextWithOptions(ctx, "tcp", v.addr, v.DialOptions()...)
 	if err != nil {
 		return nil, fmt.Errorf("%s connect error: %s", v.addr, err.Error())
 	}
@@ -224,6 +234,15 @@ func (v *Vmess) DialUDP(metadata *C.Metadata) (_ C.PacketConn, err error) {
 		metadata.DstIP = ip
 	}
 
+	pc, err := v.dialPacketConn(metadata)
+	if err != nil {
+		return nil, err
+	}
+	return newPacketConn(newNATPacketConn(pc, metadata, v.dialPacketConn), v), nil
+}
+
+// dialPacketConn opens the conn of a destination of a UDP association
+func (v *Vmess) dialPacketConn(metadata *C.Metadata) (_ net.PacketConn, err error) {
 	var c net.Conn
 	// gun transport
 	if v.transport != nil {
@@ -237,7 +256,7 @@ func (v *Vmess) DialUDP(metadata *C.Metadata) (_ C.PacketConn, err error) {
 	} else {
 		ctx, cancel := context.WithTimeout(context.Background(), C.DefaultTCPTimeout)
 		defer cancel()
-		c, err = dialer.DialContext(ctx, "tcp", v.addr)
+		c, err = dialer.DialContextWithOptions(ctx, "tcp", v.addr, v.DialOptions()...)
 		if err != nil {
 			return nil, fmt.Errorf("%s connect error: %s", v.addr, err.Error())
 		}
@@ -251,7 +270,7 @@ func (v *Vmess) DialUDP(metadata *C.Metadata) (_ C.PacketConn, err error) {
 		return nil, fmt.Errorf("new vmess client error: %v", err)
 	}
 
-	return newPacketConn(&vmessPacketConn{Conn: c, rAddr: metadata.UDPAddr()}, v), nil
+	return &vmessPacketConn{Conn: c, rAddr: metadata.UDPAddr()}, nil
 }
 
 func NewVmess(option VmessOption) (*Vmess, error) {
@@ -275,15 +294,35 @@ func NewVmess(option VmessOption) (*Vmess, error) {
 		}
 	}
 
+	clientHello, err := fingerprint.Parse(option.ClientFingerprint)
+	if err != nil {
+		return nil, err
+	}
+	if clientHello != nil {
+		switch {
+		case !option.TLS:
+			return nil, errors.New("client-fingerprint requires tls")
+		case option.Network == "grpc":
+			return nil, errors.New("client-fingerprint is not supported with grpc")
+		}
+	}
+
+	dialOptions, err := option.dialOptions()
+	if err != nil {
+		return nil, err
+	}
+
 	v := &Vmess{
 		Base: &Base{
-			name: option.Name,
-			addr: net.JoinHostPort(option.Server, strconv.Itoa(option.Port)),
-			tp:   C.Vmess,
-			udp:  option.UDP,
+			name:        option.Name,
+			addr:        net.JoinHostPort(option.Server, strconv.Itoa(option.Port)),
+			tp:          C.Vmess,
+			udp:         option.UDP,
+			dialOptions: dialOptions,
 		},
-		client: client,
-		option: &option,
+		client:      client,
+		option:      &option,
+		clientHello: clientHello,
 	}
 
 	switch option.Network {
@@ -293,7 +332,7 @@ func NewVmess(option VmessOption) (*Vmess, error) {
 		}
 	case "grpc":
 		dialFn := func(network, addr string) (net.Conn, error) {
-			c, err := dialer.DialContext(context.Background(), "tcp", v.addr)
+			c, err := dialer.DialContextWithOptions(context.Background(), "tcp", v.addr, v.DialOptions()...)
 			if err != nil {
 				return nil, fmt.Errorf("%s connect error: %s", v.addr, err.Error())
 			}
@@ -0,0 +1,61 @@
+package outbound
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVmess_ClientFingerprint(t *testing.T) {
+	option := VmessOption{
+		Name:              "test",
+		Server:            "vmess.example.com",
+		Port:              443,
+		UUID:              testVlessUUID,
+		Cipher:            "auto",
+		TLS:               true,
+		SkipCertVerify:    true,
+		ClientFingerprint: "netscape",
+	}
+	_, err := NewVmess(option)
+	assert.NotNil(t, err)
+
+	option.ClientFingerprint = "chrome"
+	option.TLS = false
+	_, err = NewVmess(option)
+	assert.NotNil(t, err)
+
+	option.TLS = true
+	option.Network = "ws"
+	v, err := NewVmess(option)
+	assert.Nil(t, err)
+	assert.NotNil(t, v.clientHello)
+
+	client, server := net.Pipe()
+	defer server.Close()
+	hello := make(chan *tls.ClientHelloInfo, 1)
+	go func() {
+		tlsConn := tls.Server(server, &tls.Config{
+			Certificates: []tls.Certificate{newTestCert(t, "vmess.example.com")},
+			GetConfigForClient: func(info *tls.ClientHelloInfo) (*tls.Config, error) {
+				hello <- info
+				return nil, nil
+			},
+		})
+		if tlsConn.Handshake() != nil {
+			return
+		}
+		io.Copy(io.Discard, tlsConn)
+	}()
+
+	// the websocket upgrade goes over http/1.1 only
+	c, err := v.streamTLS(client, []string{"http/1.1"})
+	assert.Nil(t, err)
+	info := <-hello
+	assert.Equal(t, "vmess.example.com", info.ServerName)
+	assert.Equal(t, []string{"http/1.1"}, info.SupportedProtos)
+	c.Close()
+}
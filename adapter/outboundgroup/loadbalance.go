@@ -13,6 +13,7 @@ import (
 	C "github.com/Dreamacro/clash/constant"
 	"github.com/Dreamacro/clash/constant/provider"
 
+	"go.uber.org/atomic"
 	"golang.org/x/net/publicsuffix"
 )
 
@@ -23,6 +24,7 @@ type LoadBalance struct {
 	disableUDP bool
 	single     *singledo.Single
 	providers  []provider.ProxyProvider
+	strategy   string
 	strategyFn strategyFn
 }
 
@@ -101,12 +103,12 @@ func (lb *LoadBalance) SupportUDP() bool {
 }
 
 func strategyRoundRobin() strategyFn {
-	idx := 0
+	idx := atomic.NewUint32(0)
 	return func(proxies []C.Proxy, metadata *C.Metadata) C.Proxy {
 		length := len(proxies)
+		start := int(idx.Inc() % uint32(length))
 		for i := 0; i < length; i++ {
-			idx = (idx + 1) % length
-			proxy := proxies[idx]
+			proxy := proxies[(start+i)%length]
 			if proxy.Alive() {
 				return proxy
 			}
@@ -154,8 +156,9 @@ func (lb *LoadBalance) MarshalJSON() ([]byte, error) {
 		all = append(all, proxy.Name())
 	}
 	return json.Marshal(map[string]interface{}{
-		"type": lb.Type().String(),
-		"all":  all,
+		"type":     lb.Type().String(),
+		"strategy": lb.strategy,
+		"all":      all,
 	})
 }
 
@@ -173,6 +176,7 @@ func NewLoadBalance(options *GroupCommonOption, providers []provider.ProxyProvid
 		Base:       outbound.NewBase(options.Name, "", C.LoadBalance, false),
 		single:     singledo.NewSingle(defaultGetProxiesDuration),
 		providers:  providers,
+		strategy:   strategy,
 		strategyFn: strategyFn,
 		disableUDP: options.DisableUDP,
 	}, nil
@@ -109,7 +109,10 @@ func ParseProxyGroup(config map[string]interface{}, proxyMap map[string]C.Proxy,
 	var group C.ProxyAdapter
 	switch groupOption.Type {
 	case "url-test":
-		opts := parseURLTestOption(config)
+		opts, err := parseURLTestOption(config)
+		if err != nil {
+			return nil, err
+		}
 		group = NewURLTest(groupOption, providers, opts...)
 	case "select":
 		group = NewSelector(groupOption, providers)
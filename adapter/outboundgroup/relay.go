@@ -87,7 +87,10 @@ func (r *Relay) rawProxies(touch bool) []C.Proxy {
 }
 
 func (r *Relay) proxies(metadata *C.Metadata, touch bool) []C.Proxy {
-	proxies := r.rawProxies(touch)
+	// the raw slice is shared by every dial until single expires, unwrap a copy
+	rawProxies := r.rawProxies(touch)
+	proxies := make([]C.Proxy, len(rawProxies))
+	copy(proxies, rawProxies)
 
 	for n, proxy := range proxies {
 		subproxy := proxy.Unwrap(metadata)
@@ -3,12 +3,14 @@ package outboundgroup
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"time"
 
 	"github.com/Dreamacro/clash/adapter/outbound"
 	"github.com/Dreamacro/clash/common/singledo"
 	C "github.com/Dreamacro/clash/constant"
 	"github.com/Dreamacro/clash/constant/provider"
+	"github.com/Dreamacro/clash/log"
 )
 
 type urlTestOption func(*URLTest)
@@ -19,9 +21,16 @@ func urlTestWithTolerance(tolerance uint16) urlTestOption {
 	}
 }
 
+func urlTestWithSamples(samples int) urlTestOption {
+	return func(u *URLTest) {
+		u.samples = samples
+	}
+}
+
 type URLTest struct {
 	*outbound.Base
 	tolerance  uint16
+	samples    int
 	disableUDP bool
 	fastNode   C.Proxy
 	single     *singledo.Single
@@ -68,7 +77,7 @@ func (u *URLTest) fast(touch bool) C.Proxy {
 	elm, _, _ := u.fastSingle.Do(func() (interface{}, error) {
 		proxies := u.proxies(touch)
 		fast := proxies[0]
-		min := fast.LastDelay()
+		min := u.score(fast)
 		fastNotExist := true
 
 		for _, proxy := range proxies[1:] {
@@ -80,7 +89,7 @@ func (u *URLTest) fast(touch bool) C.Proxy {
 				continue
 			}
 
-			delay := proxy.LastDelay()
+			delay := u.score(proxy)
 			if delay < min {
 				fast = proxy
 				min = delay
@@ -88,7 +97,7 @@ func (u *URLTest) fast(touch bool) C.Proxy {
 		}
 
 		// tolerance
-		if u.fastNode == nil || fastNotExist || !u.fastNode.Alive() || u.fastNode.LastDelay() > fast.LastDelay()+u.tolerance {
+		if u.fastNode == nil || fastNotExist || !u.fastNode.Alive() || int(u.score(u.fastNode)) > int(min)+int(u.tolerance) {
 			u.fastNode = fast
 		}
 
@@ -98,6 +107,18 @@ func (u *URLTest) fast(touch bool) C.Proxy {
 	return elm.(C.Proxy)
 }
 
+// score is the delay proxy is ranked by, the last one, or with samples set the
+// mean of that many last ones plus their jitter, so a node has to be faster
+// for a while, and steadily, to be switched to
+func (u *URLTest) score(proxy C.Proxy) uint16 {
+	if u.samples <= 1 || !proxy.Alive() {
+		return proxy.LastDelay()
+	}
+
+	stat := delayStatOf(proxy.DelayHistory(), u.samples)
+	return stat.score()
+}
+
 // SupportUDP implements C.ProxyAdapter
 func (u *URLTest) SupportUDP() bool {
 	if u.disableUDP {
@@ -110,17 +131,22 @@ func (u *URLTest) SupportUDP() bool {
 // MarshalJSON implements C.ProxyAdapter
 func (u *URLTest) MarshalJSON() ([]byte, error) {
 	var all []string
+	stats := map[string]delayStat{}
 	for _, proxy := range u.proxies(false) {
 		all = append(all, proxy.Name())
+		stats[proxy.Name()] = delayStatOf(proxy.DelayHistory(), u.samples)
 	}
 	return json.Marshal(map[string]interface{}{
-		"type": u.Type().String(),
-		"now":  u.Now(),
-		"all":  all,
+		"type":      u.Type().String(),
+		"now":       u.Now(),
+		"all":       all,
+		"tolerance": u.tolerance,
+		"samples":   u.samples,
+		"stats":     stats,
 	})
 }
 
-func parseURLTestOption(config map[string]interface{}) []urlTestOption {
+func parseURLTestOption(config map[string]interface{}) ([]urlTestOption, error) {
 	opts := []urlTestOption{}
 
 	// tolerance
@@ -130,7 +156,22 @@ func parseURLTestOption(config map[string]interface{}) []urlTestOption {
 		}
 	}
 
-	return opts
+	// delay samples a node is ranked by
+	if elm, ok := config["samples"]; ok {
+		if samples, ok := elm.(int); ok {
+			if samples < 0 {
+				return nil, fmt.Errorf("invalid samples: %d", samples)
+			}
+			// no proxy keeps more delays than that
+			if samples > C.MaxDelayHistory {
+				log.Warnln("url-test %v: samples %d is more than the %d delays kept, using %d", config["name"], samples, C.MaxDelayHistory, C.MaxDelayHistory)
+				samples = C.MaxDelayHistory
+			}
+			opts = append(opts, urlTestWithSamples(samples))
+		}
+	}
+
+	return opts, nil
 }
 
 func NewURLTest(commonOptions *GroupCommonOption, providers []provider.ProxyProvider, options ...urlTestOption) *URLTest {
@@ -148,3 +189,68 @@ func NewURLTest(commonOptions *GroupCommonOption, providers []provider.ProxyProv
 
 	return urlTest
 }
+
+// delayStat sums up the last delay samples of a node
+type delayStat struct {
+	// mean of the successful samples
+	Delay  uint16 `json:"delay"`
+	Jitter uint16 `json:"jitter"`
+	// samples taken, failed ones included
+	Samples int `json:"samples"`
+	Failed  int `json:"failed"`
+}
+
+// delayStatOf sums up the last samples of history, jitter is the mean
+// difference between successive successful ones
+func delayStatOf(history []C.DelayHistory, samples int) delayStat {
+	if samples < 1 {
+		samples = 1
+	}
+	if len(history) > samples {
+		history = history[len(history)-samples:]
+	}
+
+	stat := delayStat{Samples: len(history)}
+	sum, diffs, last := 0, 0, 0
+	for _, h := range history {
+		if h.Delay == 0 {
+			stat.Failed++
+			continue
+		}
+
+		delay := int(h.Delay)
+		if sum != 0 {
+			if delay > last {
+				diffs += delay - last
+			} else {
+				diffs += last - delay
+			}
+		}
+		sum += delay
+		last = delay
+	}
+
+	if succeeded := stat.Samples - stat.Failed; succeeded > 0 {
+		stat.Delay = uint16(sum / succeeded)
+		if succeeded > 1 {
+			stat.Jitter = uint16(diffs / (succeeded - 1))
+		}
+	}
+	return stat
+}
+
+// score takes a failed sample as the max delay, a node failing now and then
+// ranks below a steady one
+func (s delayStat) score() uint16 {
+	var max = 0xffff
+	succeeded := s.Samples - s.Failed
+	if succeeded == 0 {
+		return uint16(max)
+	}
+
+	score := (int(s.Delay)*succeeded+max*s.Failed)/s.Samples + int(s.Jitter)
+	if score > max {
+		return uint16(max)
+	}
+	return uint16(score)
+}
@@ -0,0 +1,68 @@
+package outboundgroup
+
+import (
+	"testing"
+
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func delays(ds ...uint16) []C.DelayHistory {
+	history := make([]C.DelayHistory, 0, len(ds))
+	for _, d := range ds {
+		history = append(history, C.DelayHistory{Delay: d})
+	}
+	return history
+}
+
+func TestDelayStat(t *testing.T) {
+	stat := delayStatOf(delays(500, 100, 120, 80), 3)
+	assert.Equal(t, delayStat{Delay: 100, Jitter: 30, Samples: 3}, stat)
+	assert.Equal(t, uint16(130), stat.score())
+
+	// only the last sample without samples set
+	stat = delayStatOf(delays(100, 300), 0)
+	assert.Equal(t, delayStat{Delay: 300, Samples: 1}, stat)
+
+	stat = delayStatOf(delays(100, 0, 100, 100), 4)
+	assert.Equal(t, delayStat{Delay: 100, Samples: 4, Failed: 1}, stat)
+	assert.Equal(t, uint16((100*3+0xffff)/4), stat.score())
+
+	assert.Equal(t, uint16(0xffff), delayStatOf(delays(0, 0), 2).score())
+	assert.Equal(t, uint16(0xffff), delayStatOf(nil, 2).score())
+}
+
+func TestDelayStat_Steady(t *testing.T) {
+	// a node a few ms faster on average but jumpy ranks below a steady one
+	steady := delayStatOf(delays(102, 101, 103, 102, 102), 5)
+	jumpy := delayStatOf(delays(60, 140, 50, 130, 60), 5)
+	assert.True(t, jumpy.Delay < steady.Delay)
+	assert.True(t, jumpy.score() > steady.score())
+}
+
+func TestParseURLTestOption_Samples(t *testing.T) {
+	parse := func(samples int) (*URLTest, error) {
+		opts, err := parseURLTestOption(map[string]interface{}{"name": "auto", "samples": samples})
+		if err != nil {
+			return nil, err
+		}
+		u := &URLTest{}
+		for _, opt := range opts {
+			opt(u)
+		}
+		return u, nil
+	}
+
+	u, err := parse(5)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, u.samples)
+
+	// no more than the delays a proxy keeps
+	u, err = parse(C.MaxDelayHistory + 5)
+	assert.Nil(t, err)
+	assert.Equal(t, C.MaxDelayHistory, u.samples)
+
+	_, err = parse(-1)
+	assert.NotNil(t, err)
+}
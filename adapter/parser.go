@@ -40,14 +40,14 @@ func ParseProxy(mapping map[string]interface{}) (C.Proxy, error) {
 		if err != nil {
 			break
 		}
-		proxy = outbound.NewSocks5(*socksOption)
+		proxy, err = outbound.NewSocks5(*socksOption)
 	case "http":
 		httpOption := &outbound.HttpOption{}
 		err = decoder.Decode(mapping, httpOption)
 		if err != nil {
 			break
 		}
-		proxy = outbound.NewHttp(*httpOption)
+		proxy, err = outbound.NewHttp(*httpOption)
 	case "vmess":
 		vmessOption := &outbound.VmessOption{
 			HTTPOpts: outbound.HTTPOptions{
@@ -61,7 +61,9 @@ func ParseProxy(mapping map[string]interface{}) (C.Proxy, error) {
 		}
 		proxy, err = outbound.NewVmess(*vmessOption)
 	case "vless":
-		vlessOption := &outbound.VlessOption{}
+		vlessOption := &outbound.VlessOption{
+			UseSystemCA: true,
+		}
 		err = decoder.Decode(mapping, vlessOption)
 		if err != nil {
 			break
@@ -81,6 +83,13 @@ func ParseProxy(mapping map[string]interface{}) (C.Proxy, error) {
 			break
 		}
 		proxy, err = outbound.NewTrojan(*trojanOption)
+	case "hysteria2":
+		hysteria2Option := &outbound.Hysteria2Option{}
+		err = decoder.Decode(mapping, hysteria2Option)
+		if err != nil {
+			break
+		}
+		proxy, err = outbound.NewHysteria2(*hysteria2Option)
 	default:
 		return nil, fmt.Errorf("unsupport proxy type: %s", proxyType)
 	}
@@ -25,6 +25,7 @@ type HealthCheck struct {
 	interval  uint
 	lazy      bool
 	lastTouch *atomic.Int64
+	lastCheck *atomic.Int64
 	done      chan struct{}
 }
 
@@ -70,6 +71,17 @@ func (hc *HealthCheck) check() {
 		})
 	}
 	b.Wait()
+	hc.lastCheck.Store(time.Now().UnixNano())
+}
+
+// checkedAt returns when the last check finished, nil before the first one
+func (hc *HealthCheck) checkedAt() *time.Time {
+	last := hc.lastCheck.Load()
+	if last == 0 {
+		return nil
+	}
+	t := time.Unix(0, last)
+	return &t
 }
 
 func (hc *HealthCheck) close() {
@@ -83,6 +95,7 @@ func NewHealthCheck(proxies []C.Proxy, url string, interval uint, lazy bool) *He
 		interval:  interval,
 		lazy:      lazy,
 		lastTouch: atomic.NewInt64(0),
+		lastCheck: atomic.NewInt64(0),
 		done:      make(chan struct{}, 1),
 	}
 }
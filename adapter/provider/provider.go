@@ -40,6 +40,7 @@ func (pp *proxySetProvider) MarshalJSON() ([]byte, error) {
 		"vehicleType": pp.VehicleType().String(),
 		"proxies":     pp.Proxies(),
 		"updatedAt":   pp.updatedAt,
+		"checkedAt":   pp.healthCheck.checkedAt(),
 	})
 }
 
@@ -162,6 +163,7 @@ func (cp *compatibleProvider) MarshalJSON() ([]byte, error) {
 		"type":        cp.Type().String(),
 		"vehicleType": cp.VehicleType().String(),
 		"proxies":     cp.Proxies(),
+		"checkedAt":   cp.healthCheck.checkedAt(),
 	})
 }
 
@@ -163,6 +163,20 @@ func (c *LruCache) CloneTo(n *LruCache) {
 	}
 }
 
+// Range calls fn for every element from the least recently used one on, until
+// fn returns false. fn must not use the cache.
+func (c *LruCache) Range(fn func(key, value interface{}) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for e := c.lru.Front(); e != nil; e = e.Next() {
+		elm := e.Value.(*entry)
+		if !fn(elm.key, elm.value) {
+			return
+		}
+	}
+}
+
 func (c *LruCache) get(key interface{}) *entry {
 	c.mu.Lock()
 	defer c.mu.Unlock()
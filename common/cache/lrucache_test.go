@@ -182,3 +182,18 @@ func TestCloneTo(t *testing.T) {
 	n.Set("5", 5)
 	assert.False(t, n.Exist("1"))
 }
+
+func TestRange(t *testing.T) {
+	c := NewLRUCache()
+	for _, e := range entries {
+		c.Set(e.key, e.value)
+	}
+	c.Get("1")
+
+	keys := []string{}
+	c.Range(func(key, value interface{}) bool {
+		keys = append(keys, key.(string))
+		return len(keys) < 4
+	})
+	assert.Equal(t, []string{"2", "3", "4", "5"}, keys)
+}
@@ -15,6 +15,11 @@ type Option struct {
 	WeaklyTypedInput bool
 }
 
+// Unmarshaler is implemented by types that decode themselves from a raw value
+type Unmarshaler interface {
+	UnmarshalStructure(data interface{}) error
+}
+
 // Decoder is the core of structure
 type Decoder struct {
 	option *Option
@@ -44,6 +49,14 @@ func (d *Decoder) Decode(src map[string]interface{}, dst interface{}) error {
 		omitempty := false
 		if len(str) > 1 {
 			omitempty = str[1] == "omitempty"
+
+			// the fields of a squashed struct are read from src itself
+			if str[1] == "squash" && field.Type.Kind() == reflect.Struct {
+				if err := d.Decode(src, v.Field(idx).Addr().Interface()); err != nil {
+					return err
+				}
+				continue
+			}
 		}
 
 		value, ok := src[key]
@@ -63,6 +76,15 @@ func (d *Decoder) Decode(src map[string]interface{}, dst interface{}) error {
 }
 
 func (d *Decoder) decode(name string, data interface{}, val reflect.Value) error {
+	if val.CanAddr() {
+		if u, ok := val.Addr().Interface().(Unmarshaler); ok {
+			if err := u.UnmarshalStructure(data); err != nil {
+				return fmt.Errorf("cannot parse '%s': %s", name, err)
+			}
+			return nil
+		}
+	}
+
 	switch val.Kind() {
 	case reflect.Int:
 		return d.decodeInt(name, data, val)
@@ -78,11 +100,23 @@ func (d *Decoder) decode(name string, data interface{}, val reflect.Value) error
 		return d.setInterface(name, data, val)
 	case reflect.Struct:
 		return d.decodeStruct(name, data, val)
+	case reflect.Ptr:
+		return d.decodePtr(name, data, val)
 	default:
 		return fmt.Errorf("type %s not support", val.Kind().String())
 	}
 }
 
+// decodePtr decodes into a new value, a missing field leaves the pointer nil
+func (d *Decoder) decodePtr(name string, data interface{}, val reflect.Value) error {
+	elem := reflect.New(val.Type().Elem())
+	if err := d.decode(name, data, elem.Elem()); err != nil {
+		return err
+	}
+	val.Set(elem)
+	return nil
+}
+
 func (d *Decoder) decodeInt(name string, data interface{}, val reflect.Value) (err error) {
 	dataVal := reflect.ValueOf(data)
 	kind := dataVal.Kind()
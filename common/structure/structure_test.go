@@ -1,7 +1,9 @@
 package structure
 
 import (
+	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -18,6 +20,21 @@ type BazSlice struct {
 	Bar []string `test:"bar"`
 }
 
+type Upper string
+
+func (u *Upper) UnmarshalStructure(data interface{}) error {
+	str, ok := data.(string)
+	if !ok {
+		return fmt.Errorf("expected string, got %T", data)
+	}
+	*u = Upper(strings.ToUpper(str))
+	return nil
+}
+
+type BazUnmarshaler struct {
+	Foo Upper `test:"foo"`
+}
+
 type BazOptional struct {
 	Foo int    `test:"foo,omitempty"`
 	Bar string `test:"bar,omitempty"`
@@ -139,3 +156,60 @@ func TestStructure_WeakType(t *testing.T) {
 		t.Fatalf("bad: %#v", s)
 	}
 }
+
+func TestStructure_Unmarshaler(t *testing.T) {
+	s := &BazUnmarshaler{}
+	err := decoder.Decode(map[string]interface{}{"foo": "bar"}, s)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if s.Foo != "BAR" {
+		t.Fatalf("bad: %#v", s)
+	}
+
+	err = decoder.Decode(map[string]interface{}{"foo": 1}, s)
+	if err == nil {
+		t.Fatalf("should throw error: %#v", s)
+	}
+}
+
+type BazPtr struct {
+	Foo *Baz `test:"foo,omitempty"`
+}
+
+func TestStructure_Ptr(t *testing.T) {
+	s := &BazPtr{}
+	err := decoder.Decode(map[string]interface{}{"foo": map[string]interface{}{"foo": 1, "bar": "bar"}}, s)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !reflect.DeepEqual(s.Foo, &Baz{Foo: 1, Bar: "bar"}) {
+		t.Fatalf("bad: %#v", s)
+	}
+
+	s = &BazPtr{}
+	err = decoder.Decode(map[string]interface{}{}, s)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if s.Foo != nil {
+		t.Fatalf("bad: %#v", s)
+	}
+}
+
+type BazSquash struct {
+	BazOptional `test:",squash"`
+	Extra       bool `test:"extra"`
+}
+
+func TestStructure_Squash(t *testing.T) {
+	s := &BazSquash{}
+	err := decoder.Decode(map[string]interface{}{"foo": 1, "extra": true}, s)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	goal := &BazSquash{BazOptional: BazOptional{Foo: 1}, Extra: true}
+	if !reflect.DeepEqual(s, goal) {
+		t.Fatalf("bad: %#v", s)
+	}
+}
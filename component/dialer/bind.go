@@ -3,13 +3,21 @@ package dialer
 import (
 	"errors"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/Dreamacro/clash/common/singledo"
 )
 
 // In some OS, such as Windows, it takes a little longer to get interface information
-var ifaceSingle = singledo.NewSingle(time.Second * 20)
+var ifaceSingles sync.Map // interface name -> *singledo.Single
+
+// ifaceSingle caches the lookups of one interface, proxies may bind to
+// different ones
+func ifaceSingle(name string) *singledo.Single {
+	single, _ := ifaceSingles.LoadOrStore(name, singledo.NewSingle(time.Second*20))
+	return single.(*singledo.Single)
+}
 
 var (
 	errPlatformNotSupport = errors.New("unsupport platform")
@@ -62,7 +70,7 @@ func fallbackBindToDialer(dialer *net.Dialer, network string, ip net.IP, name st
 		return nil
 	}
 
-	iface, err, _ := ifaceSingle.Do(func() (interface{}, error) {
+	iface, err, _ := ifaceSingle(name).Do(func() (interface{}, error) {
 		return net.InterfaceByName(name)
 	})
 	if err != nil {
@@ -93,7 +101,7 @@ func fallbackBindToDialer(dialer *net.Dialer, network string, ip net.IP, name st
 }
 
 func fallbackBindToListenConfig(name string) (string, error) {
-	iface, err, _ := ifaceSingle.Do(func() (interface{}, error) {
+	iface, err, _ := ifaceSingle(name).Do(func() (interface{}, error) {
 		return net.InterfaceByName(name)
 	})
 	if err != nil {
@@ -29,7 +29,7 @@ func bindControl(ifaceIdx int) controlFn {
 }
 
 func bindIfaceToDialer(dialer *net.Dialer, ifaceName string) error {
-	iface, err, _ := ifaceSingle.Do(func() (interface{}, error) {
+	iface, err, _ := ifaceSingle(ifaceName).Do(func() (interface{}, error) {
 		return net.InterfaceByName(ifaceName)
 	})
 	if err != nil {
@@ -41,7 +41,7 @@ func bindIfaceToDialer(dialer *net.Dialer, ifaceName string) error {
 }
 
 func bindIfaceToListenConfig(lc *net.ListenConfig, ifaceName string) error {
-	iface, err, _ := ifaceSingle.Do(func() (interface{}, error) {
+	iface, err, _ := ifaceSingle(ifaceName).Do(func() (interface{}, error) {
 		return net.InterfaceByName(ifaceName)
 	})
 	if err != nil {
@@ -24,6 +24,13 @@ func Dial(network, address string) (net.Conn, error) {
 }
 
 func DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return DialContextWithOptions(ctx, network, address)
+}
+
+// DialContextWithOptions is DialContext with per-dial options like the
+// interface to bind.
+func DialContextWithOptions(ctx context.Context, network, address string, options ...Option) (net.Conn, error) {
+	opt := newOption(options)
 	switch network {
 	case "tcp4", "tcp6", "udp4", "udp6":
 		host, port, err := net.SplitHostPort(address)
@@ -31,11 +38,6 @@ func DialContext(ctx context.Context, network, address string) (net.Conn, error)
 			return nil, err
 		}
 
-		dialer, err := Dialer()
-		if err != nil {
-			return nil, err
-		}
-
 		var ip net.IP
 		switch network {
 		case "tcp4", "udp4":
@@ -48,20 +50,31 @@ func DialContext(ctx context.Context, network, address string) (net.Conn, error)
 			return nil, err
 		}
 
-		if DialHook != nil {
-			if err := DialHook(dialer, network, ip); err != nil {
-				return nil, err
-			}
-		}
-		return dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		return dialIP(ctx, network, ip, port, opt)
 	case "tcp", "udp":
-		return dualStackDialContext(ctx, network, address)
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+
+		return happyEyeballsHost(ctx, host, opt.ipVersion, func(ctx context.Context, ip net.IP) (net.Conn, error) {
+			if ip.To4() != nil {
+				return dialIP(ctx, network+"4", ip, port, opt)
+			}
+			return dialIP(ctx, network+"6", ip, port, opt)
+		})
 	default:
 		return nil, errors.New("network invalid")
 	}
 }
 
 func ListenPacket(network, address string) (net.PacketConn, error) {
+	return ListenPacketWithOptions(network, address)
+}
+
+// ListenPacketWithOptions is ListenPacket with per-dial options like the
+// interface to bind.
+func ListenPacketWithOptions(network, address string, options ...Option) (net.PacketConn, error) {
 	cfg := &net.ListenConfig{}
 	if ListenPacketHook != nil {
 		var err error
@@ -71,89 +84,29 @@ func ListenPacket(network, address string) (net.PacketConn, error) {
 		}
 	}
 
-	return cfg.ListenPacket(context.Background(), network, address)
-}
-
-func dualStackDialContext(ctx context.Context, network, address string) (net.Conn, error) {
-	host, port, err := net.SplitHostPort(address)
+	address, err := newOption(options).applyToListenConfig(cfg, address)
 	if err != nil {
 		return nil, err
 	}
 
-	returned := make(chan struct{})
-	defer close(returned)
+	return cfg.ListenPacket(context.Background(), network, address)
+}
 
-	type dialResult struct {
-		net.Conn
-		error
-		resolved bool
-		ipv6     bool
-		done     bool
+// dialIP dials a resolved address through the global hooks and the per-dial
+// options
+func dialIP(ctx context.Context, network string, ip net.IP, port string, opt *option) (net.Conn, error) {
+	dialer, err := Dialer()
+	if err != nil {
+		return nil, err
 	}
-	results := make(chan dialResult)
-	var primary, fallback dialResult
-
-	startRacer := func(ctx context.Context, network, host string, ipv6 bool) {
-		result := dialResult{ipv6: ipv6, done: true}
-		defer func() {
-			select {
-			case results <- result:
-			case <-returned:
-				if result.Conn != nil {
-					result.Conn.Close()
-				}
-			}
-		}()
-
-		dialer, err := Dialer()
-		if err != nil {
-			result.error = err
-			return
-		}
 
-		var ip net.IP
-		if ipv6 {
-			ip, result.error = resolver.ResolveIPv6(host)
-		} else {
-			ip, result.error = resolver.ResolveIPv4(host)
-		}
-		if result.error != nil {
-			return
-		}
-		result.resolved = true
-
-		if DialHook != nil {
-			if result.error = DialHook(dialer, network, ip); result.error != nil {
-				return
-			}
+	if DialHook != nil {
+		if err := DialHook(dialer, network, ip); err != nil {
+			return nil, err
 		}
-		result.Conn, result.error = dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
 	}
-
-	go startRacer(ctx, network+"4", host, false)
-	go startRacer(ctx, network+"6", host, true)
-
-	for res := range results {
-		if res.error == nil {
-			return res.Conn, nil
-		}
-
-		if !res.ipv6 {
-			primary = res
-		} else {
-			fallback = res
-		}
-
-		if primary.done && fallback.done {
-			if primary.resolved {
-				return nil, primary.error
-			} else if fallback.resolved {
-				return nil, fallback.error
-			} else {
-				return nil, primary.error
-			}
-		}
+	if err := opt.applyToDialer(dialer, network, ip); err != nil {
+		return nil, err
 	}
-
-	return nil, errors.New("never touched")
+	return dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
 }
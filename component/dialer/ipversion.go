@@ -0,0 +1,365 @@
+package dialer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/Dreamacro/clash/component/resolver"
+)
+
+// IPVersion picks the address families a dial tries and their order
+type IPVersion int
+
+const (
+	// DualStack races both families the RFC 8305 way, ipv6 first
+	DualStack IPVersion = iota
+	IPv4Only
+	IPv6Only
+	// IPv4Prefer races both families with ipv4 first
+	IPv4Prefer
+	// IPv6Prefer races both families with ipv6 first
+	IPv6Prefer
+)
+
+const (
+	// ConnectionAttemptDelay is the wait before racing the next address,
+	// RFC 8305 section 5
+	ConnectionAttemptDelay = 250 * time.Millisecond
+	// ResolutionDelay is the wait for the preferred family once the other
+	// one resolved, RFC 8305 section 3
+	ResolutionDelay = 50 * time.Millisecond
+)
+
+// the lookups of a family, tests replace them
+var (
+	lookupIPv4 = resolver.ResolveAllIPv4
+	lookupIPv6 = resolver.ResolveAllIPv6
+)
+
+var (
+	// DefaultIPVersion is the ip-version of dials which don't set one
+	DefaultIPVersion = DualStack
+
+	// IPVersionMapping is a mapping for IPVersion enum
+	IPVersionMapping = map[string]IPVersion{
+		DualStack.String():  DualStack,
+		IPv4Only.String():   IPv4Only,
+		IPv6Only.String():   IPv6Only,
+		IPv4Prefer.String(): IPv4Prefer,
+		IPv6Prefer.String(): IPv6Prefer,
+	}
+)
+
+// ParseIPVersion parses an ip-version, empty is dual
+func ParseIPVersion(s string) (IPVersion, error) {
+	if s == "" {
+		return DualStack, nil
+	}
+	version, exist := IPVersionMapping[strings.ToLower(s)]
+	if !exist {
+		return DualStack, fmt.Errorf("unsupported ip-version: %s", s)
+	}
+	return version, nil
+}
+
+// UnmarshalJSON unserialize IPVersion
+func (v *IPVersion) UnmarshalJSON(data []byte) error {
+	var tp string
+	json.Unmarshal(data, &tp)
+	version, err := ParseIPVersion(tp)
+	if err != nil {
+		return err
+	}
+	*v = version
+	return nil
+}
+
+// UnmarshalYAML unserialize IPVersion with yaml
+func (v *IPVersion) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var tp string
+	unmarshal(&tp)
+	version, err := ParseIPVersion(tp)
+	if err != nil {
+		return err
+	}
+	*v = version
+	return nil
+}
+
+// MarshalJSON serialize IPVersion
+func (v IPVersion) MarshalJSON() ([]byte, error) {
+	return json.Marshal(v.String())
+}
+
+// MarshalYAML serialize IPVersion with yaml
+func (v IPVersion) MarshalYAML() (interface{}, error) {
+	return v.String(), nil
+}
+
+func (v IPVersion) String() string {
+	switch v {
+	case DualStack:
+		return "dual"
+	case IPv4Only:
+		return "ipv4"
+	case IPv6Only:
+		return "ipv6"
+	case IPv4Prefer:
+		return "ipv4-prefer"
+	case IPv6Prefer:
+		return "ipv6-prefer"
+	default:
+		return "Unknown"
+	}
+}
+
+// ResolveIP resolves host to a single address the ip-version of options
+// allows, for the dials which can't race, like the udp ones
+func ResolveIP(host string, options ...Option) (net.IP, error) {
+	switch newOption(options).ipVersion {
+	case IPv4Only:
+		return resolver.ResolveIPv4(host)
+	case IPv6Only:
+		return resolver.ResolveIPv6(host)
+	case IPv6Prefer:
+		if ip, err := resolver.ResolveIPv6(host); err == nil {
+			return ip, nil
+		}
+		return resolver.ResolveIPv4(host)
+	default:
+		return resolver.ResolveIP(host)
+	}
+}
+
+// IPVersionOf returns the ip-version a dial with options goes by
+func IPVersionOf(options ...Option) IPVersion {
+	return newOption(options).ipVersion
+}
+
+// ResolveAllIP resolves host to the addresses the ip-version of options
+// allows, in the order to try them
+func ResolveAllIP(host string, options ...Option) ([]net.IP, error) {
+	version := newOption(options).ipVersion
+	var ips []net.IP
+	var err error
+	for r := range resolveFamilies(host, version) {
+		if r.err != nil {
+			err = r.err
+			continue
+		}
+		ips = append(ips, r.ips...)
+	}
+	if len(ips) == 0 {
+		return nil, err
+	}
+	return SortIPs(ips, version), nil
+}
+
+// resolved is the answer for a family of a host
+type resolved struct {
+	ips []net.IP
+	err error
+}
+
+// resolveFamilies looks up the families version allows concurrently, each
+// answer is sent as it comes, but the other family waits up to
+// ResolutionDelay for the preferred one. The channel is closed once both
+// are in.
+func resolveFamilies(host string, version IPVersion) <-chan resolved {
+	ch := make(chan resolved, 2)
+	switch version {
+	case IPv4Only:
+		ips, err := lookupIPv4(host)
+		ch <- resolved{ips, err}
+		close(ch)
+		return ch
+	case IPv6Only:
+		ips, err := lookupIPv6(host)
+		ch <- resolved{ips, err}
+		close(ch)
+		return ch
+	}
+
+	// hosts and literals answer as they are, whatever their family
+	if node := resolver.DefaultHosts.Search(host); node != nil {
+		ch <- resolved{ips: []net.IP{node.Data.(net.IP)}}
+		close(ch)
+		return ch
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		ch <- resolved{ips: []net.IP{ip}}
+		close(ch)
+		return ch
+	}
+
+	preferred, other := lookupIPv6, lookupIPv4
+	if version == IPv4Prefer {
+		preferred, other = lookupIPv4, lookupIPv6
+	}
+
+	preferredDone := make(chan struct{})
+	go func() {
+		defer close(preferredDone)
+		ips, err := preferred(host)
+		ch <- resolved{ips, err}
+	}()
+	go func() {
+		ips, err := other(host)
+		if err == nil {
+			select {
+			case <-preferredDone:
+			case <-time.After(ResolutionDelay):
+			}
+		}
+		ch <- resolved{ips, err}
+		<-preferredDone
+		close(ch)
+	}()
+	return ch
+}
+
+// SortIPs returns the ips version allows in the order to try them. Dual
+// interleaves the families ipv6 first, the -prefer ones try every address
+// of their family first. The order within a family is kept.
+func SortIPs(ips []net.IP, version IPVersion) []net.IP {
+	var ip4s, ip6s []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			ip4s = append(ip4s, ip)
+		} else {
+			ip6s = append(ip6s, ip)
+		}
+	}
+
+	result := make([]net.IP, 0, len(ips))
+	switch version {
+	case IPv4Only:
+		return append(result, ip4s...)
+	case IPv6Only:
+		return append(result, ip6s...)
+	case IPv4Prefer:
+		return append(append(result, ip4s...), ip6s...)
+	case IPv6Prefer:
+		return append(append(result, ip6s...), ip4s...)
+	}
+
+	for i := 0; i < len(ip4s) || i < len(ip6s); i++ {
+		if i < len(ip6s) {
+			result = append(result, ip6s[i])
+		}
+		if i < len(ip4s) {
+			result = append(result, ip4s[i])
+		}
+	}
+	return result
+}
+
+// HappyEyeballs dials ips in turn the RFC 8305 way: a new attempt starts
+// whenever the previous one fails or hasn't connected within
+// ConnectionAttemptDelay. The first conn wins and the others are canceled.
+func HappyEyeballs(ctx context.Context, ips []net.IP, dial func(ctx context.Context, ip net.IP) (net.Conn, error)) (net.Conn, error) {
+	if len(ips) == 0 {
+		return nil, resolver.ErrIPNotFound
+	}
+
+	ch := make(chan resolved, 1)
+	ch <- resolved{ips: ips}
+	close(ch)
+	return race(ctx, ch, func(queue, ips []net.IP) []net.IP { return ips }, dial)
+}
+
+// happyEyeballsHost is HappyEyeballs over the addresses of host, the race
+// starts with the first family resolved and takes in the other one as it
+// comes.
+func happyEyeballsHost(ctx context.Context, host string, version IPVersion, dial func(ctx context.Context, ip net.IP) (net.Conn, error)) (net.Conn, error) {
+	return race(ctx, resolveFamilies(host, version), func(queue, ips []net.IP) []net.IP {
+		return SortIPs(append(queue, ips...), version)
+	}, dial)
+}
+
+// race runs the attempts of HappyEyeballs over the addresses coming from
+// answers, merge puts new ones among those not tried yet.
+func race(ctx context.Context, answers <-chan resolved, merge func(queue, ips []net.IP) []net.IP, dial func(ctx context.Context, ip net.IP) (net.Conn, error)) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+
+	results := make(chan dialResult)
+	// the attempts still running once race returns are closed as they end
+	closePending := func(remain int) {
+		for ; remain > 0; remain-- {
+			if r := <-results; r.conn != nil {
+				r.conn.Close()
+			}
+		}
+	}
+
+	var queue []net.IP
+	pending := 0
+	// ready is set once the next attempt may start
+	ready := true
+	var delay <-chan time.Time
+
+	var resolveErr error
+	var errs []string
+	for {
+		if len(queue) > 0 && (pending == 0 || ready) {
+			ip := queue[0]
+			queue = queue[1:]
+			pending++
+			go func() {
+				c, err := dial(ctx, ip)
+				results <- dialResult{c, err}
+			}()
+			ready = false
+			delay = time.After(ConnectionAttemptDelay)
+		}
+		if pending == 0 && len(queue) == 0 && answers == nil {
+			break
+		}
+
+		select {
+		case r, ok := <-answers:
+			if !ok {
+				answers = nil
+			} else if r.err != nil {
+				resolveErr = r.err
+			} else {
+				queue = merge(queue, r.ips)
+			}
+		case r := <-results:
+			pending--
+			if r.err != nil {
+				errs = append(errs, r.err.Error())
+				ready = true
+				continue
+			}
+
+			go closePending(pending)
+			return r.conn, nil
+		case <-delay:
+			delay = nil
+			ready = true
+		case <-ctx.Done():
+			go closePending(pending)
+			return nil, ctx.Err()
+		}
+	}
+
+	if len(errs) == 0 {
+		if resolveErr == nil {
+			resolveErr = resolver.ErrIPNotFound
+		}
+		return nil, resolveErr
+	}
+	return nil, errors.New(strings.Join(errs, "; "))
+}
@@ -0,0 +1,153 @@
+package dialer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	ip4  = net.ParseIP("192.0.2.1").To4()
+	ip4b = net.ParseIP("192.0.2.2").To4()
+	ip6  = net.ParseIP("2001:db8::1")
+	ip6b = net.ParseIP("2001:db8::2")
+)
+
+// stubLookups answers the lookups of a family with ips after delay, or with
+// an error for no ips
+func stubLookups(t *testing.T, ip4s []net.IP, delay4 time.Duration, ip6s []net.IP, delay6 time.Duration) {
+	lookup := func(ips []net.IP, delay time.Duration) func(string) ([]net.IP, error) {
+		return func(string) ([]net.IP, error) {
+			time.Sleep(delay)
+			if len(ips) == 0 {
+				return nil, errors.New("no such host")
+			}
+			return ips, nil
+		}
+	}
+
+	orig4, orig6 := lookupIPv4, lookupIPv6
+	lookupIPv4, lookupIPv6 = lookup(ip4s, delay4), lookup(ip6s, delay6)
+	t.Cleanup(func() {
+		lookupIPv4, lookupIPv6 = orig4, orig6
+	})
+}
+
+// pipeDial connects the addresses of ok and never the others, reporting
+// every dialed address
+func pipeDial(ok net.IP, dialed chan<- net.IP) func(context.Context, net.IP) (net.Conn, error) {
+	return func(ctx context.Context, ip net.IP) (net.Conn, error) {
+		dialed <- ip
+		if !ip.Equal(ok) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		local, remote := net.Pipe()
+		remote.Close()
+		return local, nil
+	}
+}
+
+func TestParseIPVersion(t *testing.T) {
+	for s, version := range map[string]IPVersion{
+		"":            DualStack,
+		"dual":        DualStack,
+		"ipv4":        IPv4Only,
+		"IPv6":        IPv6Only,
+		"ipv4-prefer": IPv4Prefer,
+		"ipv6-prefer": IPv6Prefer,
+	} {
+		v, err := ParseIPVersion(s)
+		assert.Nil(t, err)
+		assert.Equal(t, version, v)
+	}
+
+	_, err := ParseIPVersion("ipv5")
+	assert.NotNil(t, err)
+}
+
+func TestSortIPs(t *testing.T) {
+	ips := []net.IP{ip4, ip4b, ip6, ip6b}
+	assert.Equal(t, []net.IP{ip6, ip4, ip6b, ip4b}, SortIPs(ips, DualStack))
+	assert.Equal(t, []net.IP{ip4, ip4b, ip6, ip6b}, SortIPs(ips, IPv4Prefer))
+	assert.Equal(t, []net.IP{ip6, ip6b, ip4, ip4b}, SortIPs(ips, IPv6Prefer))
+	assert.Equal(t, []net.IP{ip4, ip4b}, SortIPs(ips, IPv4Only))
+	assert.Equal(t, []net.IP{ip6, ip6b}, SortIPs(ips, IPv6Only))
+	assert.Equal(t, []net.IP{ip4, ip4b}, SortIPs([]net.IP{ip4, ip4b}, DualStack))
+}
+
+func TestHappyEyeballs(t *testing.T) {
+	// the first address hangs, the second one goes after the attempt delay
+	dialed := make(chan net.IP, 4)
+	start := time.Now()
+	c, err := HappyEyeballs(context.Background(), []net.IP{ip6, ip4}, pipeDial(ip4, dialed))
+	assert.Nil(t, err)
+	c.Close()
+	assert.True(t, time.Since(start) >= ConnectionAttemptDelay)
+	assert.Equal(t, ip6, <-dialed)
+	assert.Equal(t, ip4, <-dialed)
+
+	// a failed attempt doesn't wait for the delay
+	start = time.Now()
+	c, err = HappyEyeballs(context.Background(), []net.IP{ip6, ip4}, func(ctx context.Context, ip net.IP) (net.Conn, error) {
+		if ip.Equal(ip6) {
+			return nil, errors.New("unreachable")
+		}
+		local, remote := net.Pipe()
+		remote.Close()
+		return local, nil
+	})
+	assert.Nil(t, err)
+	c.Close()
+	assert.True(t, time.Since(start) < ConnectionAttemptDelay)
+
+	_, err = HappyEyeballs(context.Background(), []net.IP{ip6, ip4}, func(ctx context.Context, ip net.IP) (net.Conn, error) {
+		return nil, errors.New("unreachable")
+	})
+	assert.NotNil(t, err)
+
+	_, err = HappyEyeballs(context.Background(), nil, pipeDial(ip4, dialed))
+	assert.NotNil(t, err)
+}
+
+func TestHappyEyeballs_ResolutionDelay(t *testing.T) {
+	// a slow AAAA doesn't hold the A answer longer than the resolution delay
+	stubLookups(t, []net.IP{ip4}, 0, []net.IP{ip6}, time.Second)
+	dialed := make(chan net.IP, 4)
+	start := time.Now()
+	c, err := happyEyeballsHost(context.Background(), "example.com", DualStack, pipeDial(ip4, dialed))
+	assert.Nil(t, err)
+	c.Close()
+	assert.True(t, time.Since(start) >= ResolutionDelay)
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, ip4, <-dialed)
+
+	// an A answer coming late joins the race started on AAAA
+	stubLookups(t, []net.IP{ip4}, 100*time.Millisecond, []net.IP{ip6}, 0)
+	start = time.Now()
+	c, err = happyEyeballsHost(context.Background(), "example.com", DualStack, pipeDial(ip4, dialed))
+	assert.Nil(t, err)
+	c.Close()
+	assert.True(t, time.Since(start) >= ConnectionAttemptDelay)
+	assert.Equal(t, ip6, <-dialed)
+	assert.Equal(t, ip4, <-dialed)
+
+	// with ipv4 preferred the AAAA answer is the one held
+	stubLookups(t, []net.IP{ip4}, 20*time.Millisecond, []net.IP{ip6}, 0)
+	c, err = happyEyeballsHost(context.Background(), "example.com", IPv4Prefer, pipeDial(ip4, dialed))
+	assert.Nil(t, err)
+	c.Close()
+	assert.Equal(t, ip4, <-dialed)
+
+	stubLookups(t, nil, 0, nil, 0)
+	_, err = happyEyeballsHost(context.Background(), "example.com", DualStack, pipeDial(ip4, dialed))
+	assert.NotNil(t, err)
+
+	ips, err := ResolveAllIP("example.com")
+	assert.Nil(t, ips)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,34 @@
+package dialer
+
+import (
+	"net"
+	"syscall"
+)
+
+func bindMarkToDialer(mark int, dialer *net.Dialer) {
+	dialer.Control = markControl(mark, dialer.Control)
+}
+
+func bindMarkToListenConfig(mark int, lc *net.ListenConfig) {
+	lc.Control = markControl(mark, lc.Control)
+}
+
+// markControl sets SO_MARK after control, the interface binding if any
+func markControl(mark int, control func(network, address string, c syscall.RawConn) error) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		if control != nil {
+			if err := control(network, address, c); err != nil {
+				return err
+			}
+		}
+
+		var innerErr error
+		err := c.Control(func(fd uintptr) {
+			innerErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_MARK, mark)
+		})
+		if err != nil {
+			return err
+		}
+		return innerErr
+	}
+}
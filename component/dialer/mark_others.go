@@ -0,0 +1,25 @@
+//go:build !linux
+// +build !linux
+
+package dialer
+
+import (
+	"net"
+	"sync"
+
+	"github.com/Dreamacro/clash/log"
+)
+
+var printMarkWarn = sync.Once{}
+
+func bindMarkToDialer(mark int, dialer *net.Dialer) {
+	printMarkWarn.Do(func() {
+		log.Warnln("Routing mark on socket is not supported on current platform")
+	})
+}
+
+func bindMarkToListenConfig(mark int, lc *net.ListenConfig) {
+	printMarkWarn.Do(func() {
+		log.Warnln("Routing mark on socket is not supported on current platform")
+	})
+}
@@ -0,0 +1,78 @@
+package dialer
+
+import (
+	"net"
+)
+
+// DefaultRoutingMark is the routing-mark of dials which don't set one, 0
+// for none.
+var DefaultRoutingMark int
+
+type option struct {
+	interfaceName string
+	routingMark   int
+	ipVersion     IPVersion
+}
+
+// Option customizes a single dial on top of the global hooks.
+type Option func(opt *option)
+
+// WithInterface binds the connection to the network interface name.
+func WithInterface(name string) Option {
+	return func(opt *option) {
+		opt.interfaceName = name
+	}
+}
+
+// WithRoutingMark sets SO_MARK on the socket for policy routing, Linux
+// only.
+func WithRoutingMark(mark int) Option {
+	return func(opt *option) {
+		opt.routingMark = mark
+	}
+}
+
+// WithIPVersion picks the address families of the dial, in place of
+// DefaultIPVersion.
+func WithIPVersion(version IPVersion) Option {
+	return func(opt *option) {
+		opt.ipVersion = version
+	}
+}
+
+func newOption(options []Option) *option {
+	opt := &option{ipVersion: DefaultIPVersion, routingMark: DefaultRoutingMark}
+	for _, o := range options {
+		o(opt)
+	}
+	return opt
+}
+
+// applyToDialer runs after DialHook, so a per-dial interface wins over the
+// global one.
+func (opt *option) applyToDialer(dialer *net.Dialer, network string, ip net.IP) error {
+	if opt.interfaceName != "" {
+		if err := DialerWithInterface(opt.interfaceName)(dialer, network, ip); err != nil {
+			return err
+		}
+	}
+	if opt.routingMark != 0 {
+		bindMarkToDialer(opt.routingMark, dialer)
+	}
+	return nil
+}
+
+// applyToListenConfig is applyToDialer for packet conns, it returns the
+// address to listen on.
+func (opt *option) applyToListenConfig(lc *net.ListenConfig, address string) (string, error) {
+	if opt.interfaceName != "" {
+		var err error
+		if address, err = ListenPacketWithInterface(opt.interfaceName)(lc, address); err != nil {
+			return "", err
+		}
+	}
+	if opt.routingMark != 0 {
+		bindMarkToListenConfig(opt.routingMark, lc)
+	}
+	return address, nil
+}
@@ -3,22 +3,51 @@ package fakeip
 import (
 	"errors"
 	"net"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/Dreamacro/clash/common/cache"
+	"github.com/Dreamacro/clash/component/geodata"
 	"github.com/Dreamacro/clash/component/trie"
 )
 
-// Pool is a implementation about fake ip generator without storage
+// saveDelay gathers the new mappings of a while into one save
+const saveDelay = 5 * time.Second
+
+// Store keeps the mappings of a pool, host to ip, across restarts
+type Store interface {
+	LoadFakeIP() map[string]net.IP
+	SaveFakeIP(mappings map[string]net.IP)
+}
+
+// Pool is a implementation about fake ip generator
 type Pool struct {
-	max     uint32
-	min     uint32
-	gateway uint32
-	offset  uint32
-	mux     sync.Mutex
-	host    *trie.DomainTrie
-	ipnet   *net.IPNet
-	cache   *cache.LruCache
+	max       uint32
+	min       uint32
+	gateway   uint32
+	offset    uint32
+	mux       sync.Mutex
+	host      *trie.DomainTrie
+	sites     []*geodata.Matcher
+	ipnet     *net.IPNet
+	cache     *cache.LruCache
+	store     Store
+	saveTimer *time.Timer
+}
+
+// Options of a Pool
+type Options struct {
+	IPNet *net.IPNet
+	// Host are the domains that skip fake ip
+	Host *trie.DomainTrie
+	// Sites are the geosite categories that skip fake ip
+	Sites []*geodata.Matcher
+	// Size sets the maximum number of hosts the pool maps
+	Size int
+	// Store restores the mappings and saves them as they change, nil keeps
+	// them in memory only
+	Store Store
 }
 
 // Lookup return a fake ip with host
@@ -37,6 +66,7 @@ func (p *Pool) Lookup(host string) net.IP {
 
 	ip := p.get(host)
 	p.cache.Set(host, ip)
+	p.scheduleSave()
 	return ip
 }
 
@@ -65,10 +95,19 @@ func (p *Pool) LookBack(ip net.IP) (string, bool) {
 
 // LookupHost return if domain in host
 func (p *Pool) LookupHost(domain string) bool {
-	if p.host == nil {
-		return false
+	if p.host != nil && p.host.Search(domain) != nil {
+		return true
+	}
+
+	if len(p.sites) != 0 {
+		domain = strings.ToLower(domain)
+		for _, site := range p.sites {
+			if site.Match(domain) {
+				return true
+			}
+		}
 	}
-	return p.host.Search(domain) != nil
+	return false
 }
 
 // Exist returns if given ip exists in fake-ip pool
@@ -100,6 +139,54 @@ func (p *Pool) PatchFrom(o *Pool) {
 	o.cache.CloneTo(p.cache)
 }
 
+// restore puts back the mappings of the store, those out of the range of the
+// pool or taken already are left out.
+func (p *Pool) restore() {
+	for host, ip := range p.store.LoadFakeIP() {
+		if ip = ip.To4(); ip == nil {
+			continue
+		}
+		n := ipToUint(ip)
+		if n < p.min || n > p.max {
+			continue
+		}
+
+		offset := n - p.min + 1
+		if p.cache.Exist(host) || p.cache.Exist(offset) {
+			continue
+		}
+		p.cache.Set(offset, host)
+		p.cache.Set(host, ip)
+		if offset > p.offset {
+			p.offset = offset
+		}
+	}
+}
+
+// scheduleSave saves the mappings a while later, unless a save is on the
+// way already. p.mux must be held.
+func (p *Pool) scheduleSave() {
+	if p.store == nil || p.saveTimer != nil {
+		return
+	}
+	p.saveTimer = time.AfterFunc(saveDelay, p.save)
+}
+
+func (p *Pool) save() {
+	p.mux.Lock()
+	p.saveTimer = nil
+	mappings := map[string]net.IP{}
+	p.cache.Range(func(key, value interface{}) bool {
+		if host, ok := key.(string); ok {
+			mappings[host] = value.(net.IP)
+		}
+		return true
+	})
+	p.mux.Unlock()
+
+	p.store.SaveFakeIP(mappings)
+}
+
 func (p *Pool) get(host string) net.IP {
 	current := p.offset
 	for {
@@ -131,10 +218,10 @@ func uintToIP(v uint32) net.IP {
 }
 
 // New return Pool instance
-func New(ipnet *net.IPNet, size int, host *trie.DomainTrie) (*Pool, error) {
-	min := ipToUint(ipnet.IP) + 2
+func New(options Options) (*Pool, error) {
+	min := ipToUint(options.IPNet.IP) + 2
 
-	ones, bits := ipnet.Mask.Size()
+	ones, bits := options.IPNet.Mask.Size()
 	total := 1<<uint(bits-ones) - 2
 
 	if total <= 0 {
@@ -142,12 +229,18 @@ func New(ipnet *net.IPNet, size int, host *trie.DomainTrie) (*Pool, error) {
 	}
 
 	max := min + uint32(total) - 1
-	return &Pool{
+	pool := &Pool{
 		min:     min,
 		max:     max,
 		gateway: min - 1,
-		host:    host,
-		ipnet:   ipnet,
-		cache:   cache.NewLRUCache(cache.WithSize(size * 2)),
-	}, nil
+		host:    options.Host,
+		sites:   options.Sites,
+		ipnet:   options.IPNet,
+		cache:   cache.NewLRUCache(cache.WithSize(options.Size * 2)),
+		store:   options.Store,
+	}
+	if pool.store != nil {
+		pool.restore()
+	}
+	return pool, nil
 }
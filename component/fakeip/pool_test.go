@@ -4,12 +4,29 @@ import (
 	"net"
 	"testing"
 
+	"github.com/Dreamacro/clash/component/trie"
+
 	"github.com/stretchr/testify/assert"
 )
 
+type memoryStore struct {
+	mappings map[string]net.IP
+}
+
+func (s *memoryStore) LoadFakeIP() map[string]net.IP {
+	return s.mappings
+}
+
+func (s *memoryStore) SaveFakeIP(mappings map[string]net.IP) {
+	s.mappings = mappings
+}
+
 func TestPool_Basic(t *testing.T) {
 	_, ipnet, _ := net.ParseCIDR("192.168.0.1/29")
-	pool, _ := New(ipnet, 10, nil)
+	pool, _ := New(Options{
+		IPNet: ipnet,
+		Size:  10,
+	})
 
 	first := pool.Lookup("foo.com")
 	last := pool.Lookup("bar.com")
@@ -23,7 +40,10 @@ func TestPool_Basic(t *testing.T) {
 
 func TestPool_Cycle(t *testing.T) {
 	_, ipnet, _ := net.ParseCIDR("192.168.0.1/30")
-	pool, _ := New(ipnet, 10, nil)
+	pool, _ := New(Options{
+		IPNet: ipnet,
+		Size:  10,
+	})
 
 	first := pool.Lookup("foo.com")
 	same := pool.Lookup("baz.com")
@@ -33,7 +53,10 @@ func TestPool_Cycle(t *testing.T) {
 
 func TestPool_MaxCacheSize(t *testing.T) {
 	_, ipnet, _ := net.ParseCIDR("192.168.0.1/24")
-	pool, _ := New(ipnet, 2, nil)
+	pool, _ := New(Options{
+		IPNet: ipnet,
+		Size:  2,
+	})
 
 	first := pool.Lookup("foo.com")
 	pool.Lookup("bar.com")
@@ -45,7 +68,10 @@ func TestPool_MaxCacheSize(t *testing.T) {
 
 func TestPool_DoubleMapping(t *testing.T) {
 	_, ipnet, _ := net.ParseCIDR("192.168.0.1/24")
-	pool, _ := New(ipnet, 2, nil)
+	pool, _ := New(Options{
+		IPNet: ipnet,
+		Size:  2,
+	})
 
 	// fill cache
 	fooIP := pool.Lookup("foo.com")
@@ -72,7 +98,64 @@ func TestPool_DoubleMapping(t *testing.T) {
 
 func TestPool_Error(t *testing.T) {
 	_, ipnet, _ := net.ParseCIDR("192.168.0.1/31")
-	_, err := New(ipnet, 10, nil)
+	_, err := New(Options{
+		IPNet: ipnet,
+		Size:  10,
+	})
 
 	assert.Error(t, err)
 }
+
+func TestPool_LookupHost(t *testing.T) {
+	host := trie.New()
+	host.Insert("+.lan", true)
+	host.Insert("time.*.com", true)
+
+	_, ipnet, _ := net.ParseCIDR("192.168.0.1/29")
+	pool, _ := New(Options{
+		IPNet: ipnet,
+		Host:  host,
+		Size:  10,
+	})
+
+	assert.True(t, pool.LookupHost("lan"))
+	assert.True(t, pool.LookupHost("router.lan"))
+	assert.True(t, pool.LookupHost("time.apple.com"))
+	assert.False(t, pool.LookupHost("apple.com"))
+}
+
+func TestPool_Store(t *testing.T) {
+	store := &memoryStore{mappings: map[string]net.IP{
+		"foo.com": {192, 168, 0, 3},
+		// out of the range
+		"bar.com": {10, 0, 0, 1},
+	}}
+
+	_, ipnet, _ := net.ParseCIDR("192.168.0.1/29")
+	pool, _ := New(Options{
+		IPNet: ipnet,
+		Size:  10,
+		Store: store,
+	})
+
+	assert.True(t, pool.Lookup("foo.com").Equal(net.IP{192, 168, 0, 3}))
+	host, exist := pool.LookBack(net.IP{192, 168, 0, 3})
+	assert.True(t, exist)
+	assert.Equal(t, "foo.com", host)
+
+	// bar.com gets a new ip after the restored one
+	assert.True(t, pool.Lookup("bar.com").Equal(net.IP{192, 168, 0, 4}))
+
+	pool.save()
+	assert.Len(t, store.mappings, 2)
+	assert.True(t, store.mappings["bar.com"].Equal(net.IP{192, 168, 0, 4}))
+
+	restored, _ := New(Options{
+		IPNet: ipnet,
+		Size:  10,
+		Store: store,
+	})
+	host, exist = restored.LookBack(net.IP{192, 168, 0, 4})
+	assert.True(t, exist)
+	assert.Equal(t, "bar.com", host)
+}
@@ -0,0 +1,72 @@
+// Package fingerprint makes TLS handshakes that look like those of
+// browsers, with uTLS.
+package fingerprint
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+// client-fingerprint values
+var clientHelloIDs = map[string]utls.ClientHelloID{
+	"chrome":  utls.HelloChrome_Auto,
+	"firefox": utls.HelloFirefox_Auto,
+	"safari":  utls.HelloSafari_Auto,
+	"ios":     utls.HelloIOS_Auto,
+	"edge":    utls.HelloEdge_Auto,
+	"random":  utls.HelloRandomized,
+}
+
+// Parse returns the ClientHello of a client-fingerprint, nil for none.
+func Parse(fingerprint string) (*utls.ClientHelloID, error) {
+	if fingerprint == "" {
+		return nil, nil
+	}
+
+	id, ok := clientHelloIDs[fingerprint]
+	if !ok {
+		return nil, fmt.Errorf("unsupported client-fingerprint: %s", fingerprint)
+	}
+	return &id, nil
+}
+
+// Config carries the settings of config uTLS knows about over, the session
+// cache is left to the caller as the two don't share a type.
+func Config(config *tls.Config) *utls.Config {
+	return &utls.Config{
+		ServerName:            config.ServerName,
+		InsecureSkipVerify:    config.InsecureSkipVerify,
+		VerifyPeerCertificate: config.VerifyPeerCertificate,
+		RootCAs:               config.RootCAs,
+		NextProtos:            config.NextProtos,
+		MinVersion:            config.MinVersion,
+	}
+}
+
+// Handshake runs a TLS handshake on c with the ClientHello of a browser.
+// A non-empty alpn replaces the protocols of the preset, a websocket
+// upgrade needs http/1.1 where browsers offer h2 first.
+func Handshake(c net.Conn, config *utls.Config, id utls.ClientHelloID, alpn []string) (*utls.UConn, error) {
+	uconn := utls.UClient(c, config, id)
+	if len(alpn) != 0 {
+		if err := uconn.BuildHandshakeState(); err != nil {
+			return nil, err
+		}
+		for _, extension := range uconn.Extensions {
+			if ext, ok := extension.(*utls.ALPNExtension); ok {
+				ext.AlpnProtocols = alpn
+			}
+		}
+		if err := uconn.MarshalClientHello(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := uconn.Handshake(); err != nil {
+		return nil, err
+	}
+	return uconn, nil
+}
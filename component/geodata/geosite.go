@@ -0,0 +1,262 @@
+// Package geodata reads the GeoSite.dat of v2fly/domain-list-community.
+package geodata
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	C "github.com/Dreamacro/clash/constant"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// domain types of the dat
+const (
+	domainPlain  = 0 // keyword
+	domainRegex  = 1
+	domainSuffix = 2 // the domain and its subdomains
+	domainFull   = 3
+)
+
+type domain struct {
+	tp         uint64
+	value      string
+	attributes []string
+}
+
+// siteList keeps the entries of a dat undecoded, a category is only
+// decoded when a rule asks for it
+type siteList struct {
+	modTime time.Time
+	entries map[string][]byte // upper case code -> GeoSite message
+}
+
+var (
+	mux   sync.Mutex
+	cache = map[string]*siteList{}
+)
+
+// loadSiteList reads the dat at path, cached until the file changes
+func loadSiteList(path string) (*siteList, error) {
+	mux.Lock()
+	defer mux.Unlock()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if list, ok := cache[path]; ok && list.modTime.Equal(info.ModTime()) {
+		return list, nil
+	}
+
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &siteList{modTime: info.ModTime(), entries: map[string][]byte{}}
+	// GeoSiteList: repeated GeoSite entry = 1
+	err = eachField(buf, func(num protowire.Number, b []byte, _ uint64) error {
+		if num != 1 {
+			return nil
+		}
+		// GeoSite: string country_code = 1
+		return eachField(b, func(num protowire.Number, code []byte, _ uint64) error {
+			if num == 1 {
+				list.entries[strings.ToUpper(string(code))] = b
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid geosite file %s: %w", path, err)
+	}
+
+	cache[path] = list
+	return list, nil
+}
+
+func (l *siteList) domains(code string) ([]domain, error) {
+	entry, ok := l.entries[strings.ToUpper(code)]
+	if !ok {
+		return nil, fmt.Errorf("geosite category %s not found", code)
+	}
+
+	var domains []domain
+	// GeoSite: repeated Domain domain = 2
+	err := eachField(entry, func(num protowire.Number, b []byte, _ uint64) error {
+		if num != 2 {
+			return nil
+		}
+
+		// Domain: Type type = 1, string value = 2, repeated Attribute attribute = 3
+		d := domain{}
+		err := eachField(b, func(num protowire.Number, b []byte, x uint64) error {
+			switch num {
+			case 1:
+				d.tp = x
+			case 2:
+				d.value = string(b)
+			case 3:
+				// Attribute: string key = 1
+				return eachField(b, func(num protowire.Number, key []byte, _ uint64) error {
+					if num == 1 {
+						d.attributes = append(d.attributes, strings.ToLower(string(key)))
+					}
+					return nil
+				})
+			}
+			return nil
+		})
+		domains = append(domains, d)
+		return err
+	})
+	return domains, err
+}
+
+// eachField calls fn for every field of a message with its bytes or varint
+func eachField(b []byte, fn func(num protowire.Number, b []byte, x uint64) error) error {
+	for len(b) > 0 {
+		num, tp, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		var value []byte
+		var x uint64
+		switch tp {
+		case protowire.BytesType:
+			value, n = protowire.ConsumeBytes(b)
+		case protowire.VarintType:
+			x, n = protowire.ConsumeVarint(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, tp, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if err := fn(num, value, x); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Matcher matches domains against a geosite category
+type Matcher struct {
+	full     map[string]struct{}
+	suffixes map[string]struct{}
+	keywords []string
+	regexps  []*regexp.Regexp
+}
+
+// NewMatcher returns the matcher of a category of the GeoSite.dat in the
+// home directory. Attributes filter it, google@ads has the domains of
+// google with the ads attribute.
+func NewMatcher(category string) (*Matcher, error) {
+	return newMatcher(C.Path.GeoSite(), category)
+}
+
+func newMatcher(path, category string) (*Matcher, error) {
+	parts := strings.Split(category, "@")
+	code, attributes := parts[0], parts[1:]
+
+	list, err := loadSiteList(path)
+	if err != nil {
+		return nil, err
+	}
+	domains, err := list.domains(code)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Matcher{
+		full:     map[string]struct{}{},
+		suffixes: map[string]struct{}{},
+	}
+	for _, d := range domains {
+		if !d.hasAttributes(attributes) {
+			continue
+		}
+
+		value := strings.ToLower(d.value)
+		switch d.tp {
+		case domainPlain:
+			m.keywords = append(m.keywords, value)
+		case domainRegex:
+			re, err := regexp.Compile(d.value)
+			if err != nil {
+				return nil, fmt.Errorf("geosite category %s: %w", code, err)
+			}
+			m.regexps = append(m.regexps, re)
+		case domainSuffix:
+			m.suffixes[value] = struct{}{}
+		case domainFull:
+			m.full[value] = struct{}{}
+		}
+	}
+
+	if m.Len() == 0 {
+		return nil, fmt.Errorf("geosite category %s has no domains", category)
+	}
+	return m, nil
+}
+
+func (d *domain) hasAttributes(attributes []string) bool {
+	for _, attr := range attributes {
+		found := false
+		for _, a := range d.attributes {
+			if strings.EqualFold(a, attr) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Len returns the number of domains in the matcher
+func (m *Matcher) Len() int {
+	return len(m.full) + len(m.suffixes) + len(m.keywords) + len(m.regexps)
+}
+
+// Match reports whether domain, in lower case, belongs to the category
+func (m *Matcher) Match(domain string) bool {
+	if _, ok := m.full[domain]; ok {
+		return true
+	}
+
+	for suffix := domain; ; {
+		if _, ok := m.suffixes[suffix]; ok {
+			return true
+		}
+		idx := strings.IndexByte(suffix, '.')
+		if idx == -1 {
+			break
+		}
+		suffix = suffix[idx+1:]
+	}
+
+	for _, keyword := range m.keywords {
+		if strings.Contains(domain, keyword) {
+			return true
+		}
+	}
+
+	for _, re := range m.regexps {
+		if re.MatchString(domain) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package geodata
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func encodeDomain(tp uint64, value string, attributes ...string) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, tp)
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendString(b, value)
+	for _, attr := range attributes {
+		var a []byte
+		a = protowire.AppendTag(a, 1, protowire.BytesType)
+		a = protowire.AppendString(a, attr)
+		a = protowire.AppendTag(a, 2, protowire.VarintType)
+		a = protowire.AppendVarint(a, 1)
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendBytes(b, a)
+	}
+	return b
+}
+
+func encodeSite(code string, domains ...[]byte) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, code)
+	for _, d := range domains {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, d)
+	}
+	return b
+}
+
+func writeSiteList(t *testing.T, sites ...[]byte) string {
+	var b []byte
+	for _, site := range sites {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, site)
+	}
+
+	path := filepath.Join(t.TempDir(), "GeoSite.dat")
+	assert.NoError(t, os.WriteFile(path, b, 0o644))
+	return path
+}
+
+func TestGeoSite_Match(t *testing.T) {
+	path := writeSiteList(t,
+		encodeSite("GOOGLE",
+			encodeDomain(domainSuffix, "google.com"),
+			encodeDomain(domainFull, "ads.example.com", "ads"),
+			encodeDomain(domainPlain, "gstatic"),
+			encodeDomain(domainRegex, `^www\.google\.[a-z]{2}$`),
+		),
+		encodeSite("CN", encodeDomain(domainSuffix, "cn")),
+	)
+
+	m, err := newMatcher(path, "google")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, m.Len())
+	assert.True(t, m.Match("google.com"))
+	assert.True(t, m.Match("mail.google.com"))
+	assert.False(t, m.Match("notgoogle.com"))
+	assert.True(t, m.Match("ads.example.com"))
+	assert.False(t, m.Match("www.ads.example.com"))
+	assert.True(t, m.Match("fonts.gstatic.com"))
+	assert.True(t, m.Match("www.google.de"))
+	assert.False(t, m.Match("example.com"))
+
+	m, err = newMatcher(path, "GOOGLE@ads")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, m.Len())
+	assert.True(t, m.Match("ads.example.com"))
+	assert.False(t, m.Match("google.com"))
+
+	m, err = newMatcher(path, "cn")
+	assert.NoError(t, err)
+	assert.True(t, m.Match("baidu.cn"))
+
+	_, err = newMatcher(path, "netflix")
+	assert.Error(t, err)
+	_, err = newMatcher(path, "cn@ads")
+	assert.Error(t, err)
+}
+
+func TestGeoSite_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "GeoSite.dat")
+	assert.NoError(t, os.WriteFile(path, []byte{0x0a, 0xff}, 0o644))
+
+	_, err := newMatcher(path, "google")
+	assert.Error(t, err)
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/gob"
 	"io/ioutil"
+	"net"
 	"os"
 	"sync"
 
@@ -20,6 +21,7 @@ var (
 
 type cache struct {
 	Selected map[string]string
+	FakeIP   map[string]net.IP
 }
 
 // CacheFile store and update the cache file
@@ -41,16 +43,7 @@ func (c *CacheFile) SetSelected(group, selected string) {
 	model := c.element()
 
 	model.Selected[group] = selected
-	c.buf.Reset()
-	if err := gob.NewEncoder(c.buf).Encode(model); err != nil {
-		log.Warnln("[CacheFile] encode gob failed: %s", err.Error())
-		return
-	}
-
-	if err := ioutil.WriteFile(c.path, c.buf.Bytes(), fileMode); err != nil {
-		log.Warnln("[CacheFile] write cache to %s failed: %s", c.path, err.Error())
-		return
-	}
+	c.flush(model)
 }
 
 func (c *CacheFile) SelectedMap() map[string]string {
@@ -70,6 +63,44 @@ func (c *CacheFile) SelectedMap() map[string]string {
 	return mapping
 }
 
+// SaveFakeIP replaces the stored fake ip mappings, host to ip
+func (c *CacheFile) SaveFakeIP(mappings map[string]net.IP) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	model := c.element()
+
+	model.FakeIP = mappings
+	c.flush(model)
+}
+
+// LoadFakeIP returns the stored fake ip mappings, host to ip
+func (c *CacheFile) LoadFakeIP() map[string]net.IP {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	model := c.element()
+
+	mapping := map[string]net.IP{}
+	for k, v := range model.FakeIP {
+		mapping[k] = v
+	}
+	return mapping
+}
+
+func (c *CacheFile) flush(model *cache) {
+	c.buf.Reset()
+	if err := gob.NewEncoder(c.buf).Encode(model); err != nil {
+		log.Warnln("[CacheFile] encode gob failed: %s", err.Error())
+		return
+	}
+
+	if err := ioutil.WriteFile(c.path, c.buf.Bytes(), fileMode); err != nil {
+		log.Warnln("[CacheFile] write cache to %s failed: %s", c.path, err.Error())
+		return
+	}
+}
+
 func (c *CacheFile) element() *cache {
 	if c.model != nil {
 		return c.model
@@ -77,6 +108,7 @@ func (c *CacheFile) element() *cache {
 
 	model := &cache{
 		Selected: map[string]string{},
+		FakeIP:   map[string]net.IP{},
 	}
 
 	if buf, err := ioutil.ReadFile(c.path); err == nil {
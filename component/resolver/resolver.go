@@ -40,22 +40,36 @@ type Resolver interface {
 
 // ResolveIPv4 with a host, return ipv4
 func ResolveIPv4(host string) (net.IP, error) {
+	ips, err := ResolveAllIPv4(host)
+	if err != nil {
+		return nil, err
+	}
+	return ips[rand.Intn(len(ips))], nil
+}
+
+// ResolveAllIPv4 with a host, return the ipv4 addresses. A custom resolver
+// answers one, the system one all of them.
+func ResolveAllIPv4(host string) ([]net.IP, error) {
 	if node := DefaultHosts.Search(host); node != nil {
 		if ip := node.Data.(net.IP).To4(); ip != nil {
-			return ip, nil
+			return []net.IP{ip}, nil
 		}
 	}
 
 	ip := net.ParseIP(host)
 	if ip != nil {
 		if !strings.Contains(host, ":") {
-			return ip, nil
+			return []net.IP{ip}, nil
 		}
 		return nil, ErrIPVersion
 	}
 
 	if DefaultResolver != nil {
-		return DefaultResolver.ResolveIPv4(host)
+		ip, err := DefaultResolver.ResolveIPv4(host)
+		if err != nil {
+			return nil, err
+		}
+		return []net.IP{ip}, nil
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), DefaultDNSTimeout)
@@ -67,31 +81,45 @@ func ResolveIPv4(host string) (net.IP, error) {
 		return nil, ErrIPNotFound
 	}
 
-	return ipAddrs[rand.Intn(len(ipAddrs))], nil
+	return ipAddrs, nil
 }
 
 // ResolveIPv6 with a host, return ipv6
 func ResolveIPv6(host string) (net.IP, error) {
+	ips, err := ResolveAllIPv6(host)
+	if err != nil {
+		return nil, err
+	}
+	return ips[rand.Intn(len(ips))], nil
+}
+
+// ResolveAllIPv6 with a host, return the ipv6 addresses. A custom resolver
+// answers one, the system one all of them.
+func ResolveAllIPv6(host string) ([]net.IP, error) {
 	if DisableIPv6 {
 		return nil, ErrIPv6Disabled
 	}
 
 	if node := DefaultHosts.Search(host); node != nil {
 		if ip := node.Data.(net.IP).To16(); ip != nil {
-			return ip, nil
+			return []net.IP{ip}, nil
 		}
 	}
 
 	ip := net.ParseIP(host)
 	if ip != nil {
 		if strings.Contains(host, ":") {
-			return ip, nil
+			return []net.IP{ip}, nil
 		}
 		return nil, ErrIPVersion
 	}
 
 	if DefaultResolver != nil {
-		return DefaultResolver.ResolveIPv6(host)
+		ip, err := DefaultResolver.ResolveIPv6(host)
+		if err != nil {
+			return nil, err
+		}
+		return []net.IP{ip}, nil
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), DefaultDNSTimeout)
@@ -103,7 +131,7 @@ func ResolveIPv6(host string) (net.IP, error) {
 		return nil, ErrIPNotFound
 	}
 
-	return ipAddrs[rand.Intn(len(ipAddrs))], nil
+	return ipAddrs, nil
 }
 
 // ResolveIPWithResolver same as ResolveIP, but with a resolver
@@ -138,3 +166,54 @@ func ResolveIPWithResolver(host string, r Resolver) (net.IP, error) {
 func ResolveIP(host string) (net.IP, error) {
 	return ResolveIPWithResolver(host, DefaultResolver)
 }
+
+// ResolveAllIP with a host, return the ipv6 and ipv4 addresses to race. A
+// custom resolver answers one address per family, the system one all of
+// them.
+func ResolveAllIP(host string) ([]net.IP, error) {
+	if node := DefaultHosts.Search(host); node != nil {
+		return []net.IP{node.Data.(net.IP)}, nil
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	if DefaultResolver == nil {
+		network := "ip"
+		if DisableIPv6 {
+			network = "ip4"
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultDNSTimeout)
+		defer cancel()
+		ipAddrs, err := net.DefaultResolver.LookupIP(ctx, network, host)
+		if err != nil {
+			return nil, err
+		} else if len(ipAddrs) == 0 {
+			return nil, ErrIPNotFound
+		}
+		return ipAddrs, nil
+	}
+
+	ch := make(chan net.IP, 1)
+	go func() {
+		defer close(ch)
+		if ip, err := ResolveIPv6(host); err == nil {
+			ch <- ip
+		}
+	}()
+
+	var ips []net.IP
+	ip, err := ResolveIPv4(host)
+	if ip6, ok := <-ch; ok {
+		ips = append(ips, ip6)
+	}
+	if err == nil {
+		ips = append(ips, ip)
+	}
+	if len(ips) == 0 {
+		return nil, err
+	}
+	return ips, nil
+}
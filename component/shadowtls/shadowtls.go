@@ -0,0 +1,284 @@
+// Package shadowtls is the client of the ShadowTLS v3 protocol: the TLS
+// handshake is done with a real host through the server, which then tells
+// the data of the client apart from that of the handshake by HMAC.
+package shadowtls
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+const (
+	tlsHeaderSize     = 5
+	tlsRandomSize     = 32
+	tlsSessionIDSize  = 32
+	hmacSize          = 4
+	tlsHmacHeaderSize = tlsHeaderSize + hmacSize
+
+	// max payload of a data frame
+	maxPayloadSize = 16384
+
+	recordAlert           = 21
+	recordHandshake       = 22
+	recordApplicationData = 23
+
+	handshakeServerHello = 2
+
+	// offset of the random in the record of a ServerHello
+	serverRandomIndex = tlsHeaderSize + 1 + 3 + 2
+	// offset of the session id in a ClientHello message
+	sessionIDIndex = 1 + 3 + 2 + tlsRandomSize + 1
+)
+
+var (
+	// ErrNotAuthorized is returned when the handshake data didn't come
+	// from a ShadowTLS server, or host doesn't speak TLS 1.3
+	ErrNotAuthorized = errors.New("shadow-tls: the handshake is hijacked or host doesn't support tls 1.3")
+
+	errVerifyFailed = errors.New("shadow-tls: data frame verification failed")
+	errRemoteAlert  = errors.New("shadow-tls: remote sent an alert")
+)
+
+// Option is options of shadow-tls
+type Option struct {
+	Password       string
+	Host           string
+	SkipCertVerify bool
+}
+
+// NewShadowTLS does the handshake with the host of option on conn and
+// returns the conn carrying the data after it
+func NewShadowTLS(conn net.Conn, option *Option) (net.Conn, error) {
+	hc := &handshakeConn{Conn: conn, password: option.Password}
+	uconn := utls.UClient(hc, &utls.Config{
+		ServerName:         option.Host,
+		InsecureSkipVerify: option.SkipCertVerify,
+	}, utls.HelloChrome_Auto)
+	if err := uconn.BuildHandshakeState(); err != nil {
+		return nil, err
+	}
+	if err := signSessionID(uconn, option.Password); err != nil {
+		return nil, err
+	}
+
+	// the tls conn is dropped as is, a close_notify would end the session
+	if err := uconn.Handshake(); err != nil {
+		return nil, fmt.Errorf("shadow-tls handshake error: %w", err)
+	}
+	if !hc.authorized {
+		return nil, ErrNotAuthorized
+	}
+
+	return &Conn{
+		Conn:       conn,
+		writeHMAC:  newHMAC(option.Password, hc.serverRandom, []byte("C")),
+		readHMAC:   newHMAC(option.Password, hc.serverRandom, []byte("S")),
+		ignoreHMAC: hc.readHMAC,
+	}, nil
+}
+
+// signSessionID puts the HMAC of the ClientHello in the last bytes of its
+// session id, which the server authenticates the client by
+func signSessionID(uconn *utls.UConn, password string) error {
+	sessionID := make([]byte, tlsSessionIDSize)
+	if _, err := rand.Read(sessionID[:tlsSessionIDSize-hmacSize]); err != nil {
+		return err
+	}
+
+	hello := uconn.HandshakeState.Hello
+	hello.SessionId = sessionID
+	if err := uconn.MarshalClientHello(); err != nil {
+		return err
+	}
+	if len(hello.Raw) < sessionIDIndex+tlsSessionIDSize {
+		return errors.New("shadow-tls: unexpected ClientHello length")
+	}
+
+	h := hmac.New(sha1.New, []byte(password))
+	h.Write(hello.Raw)
+	copy(sessionID[tlsSessionIDSize-hmacSize:], h.Sum(nil)[:hmacSize])
+	return uconn.MarshalClientHello()
+}
+
+// handshakeConn hands the records of the handshake to the tls client a
+// whole one at a time, restoring those the server signed
+type handshakeConn struct {
+	net.Conn
+	password string
+	buf      []byte
+
+	serverRandom []byte
+	readHMAC     hash.Hash
+	readKey      []byte
+	// whether the last application data came from the server
+	authorized bool
+}
+
+func (hc *handshakeConn) Read(b []byte) (int, error) {
+	if len(hc.buf) == 0 {
+		record, err := readRecord(hc.Conn)
+		if err != nil {
+			return 0, err
+		}
+
+		switch record[0] {
+		case recordHandshake:
+			if len(record) > serverRandomIndex+tlsRandomSize && record[tlsHeaderSize] == handshakeServerHello {
+				hc.serverRandom = append([]byte(nil), record[serverRandomIndex:serverRandomIndex+tlsRandomSize]...)
+				hc.readHMAC = newHMAC(hc.password, hc.serverRandom, nil)
+				hc.readKey = kdf(hc.password, hc.serverRandom)
+			}
+		case recordApplicationData:
+			hc.authorized = false
+			if hc.readHMAC != nil && len(record) > tlsHmacHeaderSize {
+				hc.readHMAC.Write(record[tlsHmacHeaderSize:])
+				if hmac.Equal(hc.readHMAC.Sum(nil)[:hmacSize], record[tlsHeaderSize:tlsHmacHeaderSize]) {
+					xorSlice(record[tlsHmacHeaderSize:], hc.readKey)
+					copy(record[hmacSize:], record[:tlsHeaderSize])
+					record = record[hmacSize:]
+					binary.BigEndian.PutUint16(record[3:tlsHeaderSize], uint16(len(record)-tlsHeaderSize))
+					hc.authorized = true
+				}
+			}
+		}
+		hc.buf = record
+	}
+
+	n := copy(b, hc.buf)
+	hc.buf = hc.buf[n:]
+	return n, nil
+}
+
+// Conn is the conn after the handshake, its data goes in application data
+// frames signed by a chained HMAC
+type Conn struct {
+	net.Conn
+	writeHMAC hash.Hash
+	readHMAC  hash.Hash
+	// frames of the handshake may still come before the data, nil after
+	ignoreHMAC hash.Hash
+	buf        []byte
+}
+
+// Read implements net.Conn
+func (c *Conn) Read(b []byte) (int, error) {
+	for len(c.buf) == 0 {
+		record, err := readRecord(c.Conn)
+		if err != nil {
+			return 0, err
+		}
+
+		switch record[0] {
+		case recordAlert:
+			return 0, errRemoteAlert
+		case recordApplicationData:
+		default:
+			return 0, fmt.Errorf("shadow-tls: unexpected record type: %d", record[0])
+		}
+
+		if c.ignoreHMAC != nil {
+			if verifyFrame(record, c.ignoreHMAC, false) {
+				continue
+			}
+			c.ignoreHMAC = nil
+		}
+		if !verifyFrame(record, c.readHMAC, true) {
+			return 0, errVerifyFailed
+		}
+		c.buf = record[tlsHmacHeaderSize:]
+	}
+
+	n := copy(b, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+// Write implements net.Conn
+func (c *Conn) Write(b []byte) (int, error) {
+	n := 0
+	for len(b) > 0 {
+		payload := b
+		if len(payload) > maxPayloadSize {
+			payload = payload[:maxPayloadSize]
+		}
+
+		frame := make([]byte, tlsHmacHeaderSize+len(payload))
+		frame[0] = recordApplicationData
+		frame[1], frame[2] = 3, 3
+		binary.BigEndian.PutUint16(frame[3:tlsHeaderSize], uint16(hmacSize+len(payload)))
+		copy(frame[tlsHmacHeaderSize:], payload)
+
+		c.writeHMAC.Write(payload)
+		tag := c.writeHMAC.Sum(nil)[:hmacSize]
+		c.writeHMAC.Write(tag)
+		copy(frame[tlsHeaderSize:], tag)
+
+		if _, err := c.Conn.Write(frame); err != nil {
+			return n, err
+		}
+		n += len(payload)
+		b = b[len(payload):]
+	}
+	return n, nil
+}
+
+// verifyFrame checks the tag of an application data frame, chaining it into
+// h when update is set
+func verifyFrame(frame []byte, h hash.Hash, update bool) bool {
+	if frame[1] != 3 || frame[2] != 3 || len(frame) < tlsHmacHeaderSize {
+		return false
+	}
+
+	h.Write(frame[tlsHmacHeaderSize:])
+	tag := h.Sum(nil)[:hmacSize]
+	if update {
+		h.Write(tag)
+	}
+	return bytes.Equal(frame[tlsHeaderSize:tlsHmacHeaderSize], tag)
+}
+
+func readRecord(r io.Reader) ([]byte, error) {
+	header := make([]byte, tlsHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	length := int(binary.BigEndian.Uint16(header[3:]))
+	record := make([]byte, tlsHeaderSize+length)
+	copy(record, header)
+	if _, err := io.ReadFull(r, record[tlsHeaderSize:]); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func newHMAC(password string, serverRandom []byte, suffix []byte) hash.Hash {
+	h := hmac.New(sha1.New, []byte(password))
+	h.Write(serverRandom)
+	h.Write(suffix)
+	return h
+}
+
+func kdf(password string, serverRandom []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte(password))
+	h.Write(serverRandom)
+	return h.Sum(nil)
+}
+
+func xorSlice(data []byte, key []byte) {
+	for i := range data {
+		data[i] ^= key[i%len(key)]
+	}
+}
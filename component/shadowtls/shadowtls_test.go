@@ -0,0 +1,169 @@
+package shadowtls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"hash"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testPassword = "password"
+
+func newTestCert(t *testing.T, host string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// serveShadowTLS is the server side of a ShadowTLS v3 session on c: the
+// handshake is relayed to a tls server, then c echoes the data it's sent.
+// Clients of other passwords only get the handshake relayed.
+func serveShadowTLS(t *testing.T, c net.Conn, cert tls.Certificate) {
+	defer c.Close()
+
+	relay, hs := net.Pipe()
+	hsDone := make(chan struct{})
+	go func() {
+		defer close(hsDone)
+		defer hs.Close()
+		tls.Server(hs, &tls.Config{Certificates: []tls.Certificate{cert}}).Handshake()
+	}()
+
+	hello, err := readRecord(c)
+	if !assert.Nil(t, err) {
+		return
+	}
+	sessionID := append([]byte(nil), hello[tlsHeaderSize+sessionIDIndex:tlsHeaderSize+sessionIDIndex+tlsSessionIDSize]...)
+	msg := append([]byte(nil), hello[tlsHeaderSize:]...)
+	copy(msg[sessionIDIndex+tlsSessionIDSize-hmacSize:], make([]byte, hmacSize))
+	h := hmac.New(sha1.New, []byte(testPassword))
+	h.Write(msg)
+	authorized := hmac.Equal(h.Sum(nil)[:hmacSize], sessionID[tlsSessionIDSize-hmacSize:])
+	relay.Write(hello)
+
+	var writeMux sync.Mutex
+	var serverRandom []byte
+	randomReady := make(chan struct{})
+	relayed := make(chan struct{})
+	go func() {
+		defer close(relayed)
+		var readHMAC hash.Hash
+		var key []byte
+		for {
+			record, err := readRecord(relay)
+			if err != nil {
+				return
+			}
+			switch record[0] {
+			case recordHandshake:
+				if record[tlsHeaderSize] == handshakeServerHello {
+					serverRandom = record[serverRandomIndex : serverRandomIndex+tlsRandomSize]
+					readHMAC = newHMAC(testPassword, serverRandom, nil)
+					key = kdf(testPassword, serverRandom)
+					close(randomReady)
+				}
+			case recordApplicationData:
+				if !authorized {
+					break
+				}
+				payload := record[tlsHeaderSize:]
+				xorSlice(payload, key)
+				readHMAC.Write(payload)
+				frame := make([]byte, tlsHmacHeaderSize+len(payload))
+				copy(frame, record[:tlsHeaderSize])
+				binary.BigEndian.PutUint16(frame[3:], uint16(hmacSize+len(payload)))
+				copy(frame[tlsHeaderSize:], readHMAC.Sum(nil)[:hmacSize])
+				copy(frame[tlsHmacHeaderSize:], payload)
+				record = frame
+			}
+			writeMux.Lock()
+			c.Write(record)
+			writeMux.Unlock()
+		}
+	}()
+
+	// the handshake ends with the Finished of the client
+	for {
+		record, err := readRecord(c)
+		if err != nil {
+			return
+		}
+		relay.Write(record)
+		if record[0] == recordApplicationData {
+			break
+		}
+	}
+	<-hsDone
+	relay.Close()
+	<-relayed
+	<-randomReady
+	if !authorized {
+		return
+	}
+
+	verify := newHMAC(testPassword, serverRandom, []byte("C"))
+	echo := &Conn{Conn: c, writeHMAC: newHMAC(testPassword, serverRandom, []byte("S")), readHMAC: verify}
+	io.Copy(echo, echo)
+}
+
+func TestShadowTLS(t *testing.T) {
+	cert := newTestCert(t, "www.example.com")
+	client, server := net.Pipe()
+	go serveShadowTLS(t, server, cert)
+
+	c, err := NewShadowTLS(client, &Option{
+		Password:       testPassword,
+		Host:           "www.example.com",
+		SkipCertVerify: true,
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer c.Close()
+
+	payload := make([]byte, maxPayloadSize+100)
+	rand.Read(payload)
+	go c.Write(payload)
+
+	buf := make([]byte, len(payload))
+	_, err = io.ReadFull(c, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, payload, buf)
+}
+
+func TestShadowTLS_WrongPassword(t *testing.T) {
+	cert := newTestCert(t, "www.example.com")
+	client, server := net.Pipe()
+	go serveShadowTLS(t, server, cert)
+
+	_, err := NewShadowTLS(client, &Option{
+		Password:       "wrong",
+		Host:           "www.example.com",
+		SkipCertVerify: true,
+	})
+	assert.Equal(t, ErrNotAuthorized, err)
+	client.Close()
+}
@@ -0,0 +1,69 @@
+package sniffer
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+)
+
+var (
+	errNotHTTP      = errors.New("not an http request")
+	errNoServerName = errors.New("no server name")
+
+	methods = []string{"GET", "POST", "HEAD", "PUT", "DELETE", "OPTIONS", "PATCH", "TRACE", "CONNECT"}
+)
+
+// SniffHTTP returns the Host header of the HTTP/1 request that b starts with
+func SniffHTTP(b []byte) (string, error) {
+	i := bytes.IndexByte(b, ' ')
+	if i < 0 {
+		if len(b) < len("OPTIONS ") {
+			return "", ErrNoClue
+		}
+		return "", errNotHTTP
+	}
+	if !isMethod(string(b[:i])) {
+		return "", errNotHTTP
+	}
+
+	lines := bytes.Split(b, []byte("\r\n"))
+	if len(lines) < 2 || !bytes.Contains(lines[0], []byte(" HTTP/1.")) {
+		return "", ErrNoClue
+	}
+	// the last line may be cut short
+	for _, line := range lines[1 : len(lines)-1] {
+		if len(line) == 0 {
+			break
+		}
+		colon := bytes.IndexByte(line, ':')
+		if colon < 0 || !strings.EqualFold(string(line[:colon]), "host") {
+			continue
+		}
+
+		host := strings.TrimSpace(string(line[colon+1:]))
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		return validHost(host)
+	}
+	return "", errNoServerName
+}
+
+func isMethod(s string) bool {
+	for _, m := range methods {
+		if s == m {
+			return true
+		}
+	}
+	return false
+}
+
+// validHost takes a domain, an IP tells nothing the metadata doesn't have
+func validHost(host string) (string, error) {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" || strings.ContainsAny(host, " /\\[]") || net.ParseIP(host) != nil {
+		return "", errNoServerName
+	}
+	return host, nil
+}
@@ -0,0 +1,221 @@
+package sniffer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+
+	"github.com/lucas-clemente/quic-go/quicvarint"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	quicVersion1       = 0x00000001
+	quicVersion2       = 0x6b3343cf
+	quicVersionDraft29 = 0xff00001d
+
+	frameTypePadding = 0x00
+	frameTypePing    = 0x01
+	frameTypeAck     = 0x02
+	frameTypeAckECN  = 0x03
+	frameTypeCrypto  = 0x06
+)
+
+var (
+	errNotQUICInitial = errors.New("not a quic initial packet")
+
+	// the initial salts of RFC 9001 section 5.2, draft-29 and RFC 9369
+	saltVersion1 = []byte{0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17, 0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a}
+	saltDraft29  = []byte{0xaf, 0xbf, 0xec, 0x28, 0x99, 0x93, 0xd2, 0x4c, 0x9e, 0x97, 0x86, 0xf1, 0x9c, 0x61, 0x11, 0xe0, 0x43, 0x90, 0xa8, 0x99}
+	saltVersion2 = []byte{0x0d, 0xed, 0xe3, 0xde, 0xf7, 0x00, 0xa6, 0xdb, 0x81, 0x93, 0x81, 0xbe, 0x6e, 0x26, 0x9d, 0xcb, 0xf9, 0xbd, 0x2e, 0xd9}
+)
+
+// SniffQUIC returns the server name of the ClientHello in the QUIC Initial
+// packet b. A ClientHello that goes on in the next packet can't be read,
+// as with the larger ones of post-quantum key shares.
+func SniffQUIC(b []byte) (string, error) {
+	// long header with the fixed bit
+	if len(b) < 7 || b[0]&0xc0 != 0xc0 {
+		return "", errNotQUICInitial
+	}
+
+	version := binary.BigEndian.Uint32(b[1:])
+	var salt []byte
+	keyLabel, ivLabel, hpLabel := "quic key", "quic iv", "quic hp"
+	initialType := byte(0)
+	switch version {
+	case quicVersion1:
+		salt = saltVersion1
+	case quicVersionDraft29:
+		salt = saltDraft29
+	case quicVersion2:
+		salt = saltVersion2
+		keyLabel, ivLabel, hpLabel = "quicv2 key", "quicv2 iv", "quicv2 hp"
+		initialType = 1
+	default:
+		return "", errNotQUICInitial
+	}
+	if (b[0]&0x30)>>4 != initialType {
+		return "", errNotQUICInitial
+	}
+
+	r := bytes.NewReader(b[5:])
+	dcidLen, _ := r.ReadByte()
+	dcid := make([]byte, dcidLen)
+	if _, err := io.ReadFull(r, dcid); err != nil {
+		return "", errNotQUICInitial
+	}
+	scidLen, err := r.ReadByte()
+	if err != nil || int(scidLen) > r.Len() {
+		return "", errNotQUICInitial
+	}
+	r.Seek(int64(scidLen), io.SeekCurrent)
+	tokenLen, err := quicvarint.Read(r)
+	if err != nil || tokenLen > uint64(r.Len()) {
+		return "", errNotQUICInitial
+	}
+	r.Seek(int64(tokenLen), io.SeekCurrent)
+	length, err := quicvarint.Read(r)
+	if err != nil || length > uint64(r.Len()) {
+		return "", errNotQUICInitial
+	}
+
+	pnOffset := len(b) - r.Len()
+	if length < 4+16 {
+		return "", errNotQUICInitial
+	}
+
+	initialSecret := hkdf.Extract(crypto.SHA256.New, dcid, salt)
+	clientSecret := hkdfExpandLabel(initialSecret, "client in", 32)
+	key := hkdfExpandLabel(clientSecret, keyLabel, 16)
+	iv := hkdfExpandLabel(clientSecret, ivLabel, 12)
+	hp := hkdfExpandLabel(clientSecret, hpLabel, 16)
+
+	// header protection of RFC 9001 section 5.4, on a copy of the header
+	block, err := aes.NewCipher(hp)
+	if err != nil {
+		return "", err
+	}
+	mask := make([]byte, aes.BlockSize)
+	block.Encrypt(mask, b[pnOffset+4:pnOffset+4+16])
+
+	header := append([]byte(nil), b[:pnOffset+4]...)
+	header[0] ^= mask[0] & 0x0f
+	pnLen := int(header[0]&0x03) + 1
+	for i := 0; i < pnLen; i++ {
+		header[pnOffset+i] ^= mask[1+i]
+	}
+	header = header[:pnOffset+pnLen]
+
+	nonce := append([]byte(nil), iv...)
+	for i := 0; i < pnLen; i++ {
+		nonce[len(nonce)-pnLen+i] ^= header[pnOffset+i]
+	}
+
+	block, err = aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	payload, err := aead.Open(nil, nonce, b[pnOffset+pnLen:pnOffset+int(length)], header)
+	if err != nil {
+		return "", errNotQUICInitial
+	}
+
+	data, err := cryptoData(payload)
+	if err != nil {
+		return "", err
+	}
+	return serverName(data)
+}
+
+// hkdfExpandLabel is HKDF-Expand-Label of TLS 1.3 with an empty context
+func hkdfExpandLabel(secret []byte, label string, length int) []byte {
+	label = "tls13 " + label
+	info := make([]byte, 0, 2+1+len(label)+1)
+	info = append(info, byte(length>>8), byte(length), byte(len(label)))
+	info = append(info, label...)
+	info = append(info, 0)
+
+	out := make([]byte, length)
+	io.ReadFull(hkdf.Expand(crypto.SHA256.New, secret, info), out)
+	return out
+}
+
+type cryptoFrame struct {
+	offset uint64
+	data   []byte
+}
+
+// cryptoData puts the CRYPTO frames of a packet together, they may come
+// in any order
+func cryptoData(payload []byte) ([]byte, error) {
+	r := bytes.NewReader(payload)
+	var frames []cryptoFrame
+	for r.Len() > 0 {
+		frameType, err := quicvarint.Read(r)
+		if err != nil {
+			return nil, ErrNoClue
+		}
+
+		switch frameType {
+		case frameTypePadding, frameTypePing:
+		case frameTypeAck, frameTypeAckECN:
+			// largest acknowledged, delay, range count, first range
+			var fields [4]uint64
+			for i := range fields {
+				if fields[i], err = quicvarint.Read(r); err != nil {
+					return nil, ErrNoClue
+				}
+			}
+			skip := 2 * fields[2]
+			if frameType == frameTypeAckECN {
+				skip += 3
+			}
+			for i := uint64(0); i < skip; i++ {
+				if _, err := quicvarint.Read(r); err != nil {
+					return nil, ErrNoClue
+				}
+			}
+		case frameTypeCrypto:
+			offset, err := quicvarint.Read(r)
+			if err != nil {
+				return nil, ErrNoClue
+			}
+			length, err := quicvarint.Read(r)
+			if err != nil || length > uint64(r.Len()) {
+				return nil, ErrNoClue
+			}
+			data := make([]byte, length)
+			r.Read(data)
+			frames = append(frames, cryptoFrame{offset, data})
+		default:
+			// nothing else is allowed in an Initial packet
+			return nil, errNotQUICInitial
+		}
+	}
+
+	sort.Slice(frames, func(i, j int) bool { return frames[i].offset < frames[j].offset })
+	var data []byte
+	for _, f := range frames {
+		if f.offset > uint64(len(data)) {
+			// a gap, the rest is in another packet
+			break
+		}
+		if end := f.offset + uint64(len(f.data)); end > uint64(len(data)) {
+			data = append(data, f.data[uint64(len(data))-f.offset:]...)
+		}
+	}
+	if len(data) == 0 {
+		return nil, ErrNoClue
+	}
+	return data, nil
+}
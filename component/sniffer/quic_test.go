@@ -0,0 +1,68 @@
+package sniffer
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// quicInitial returns the first packet of a QUIC client for serverName
+func quicInitial(t *testing.T, serverName string, version quic.VersionNumber) []byte {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go quic.DialAddrContext(ctx, server.LocalAddr().String(), &tls.Config{
+		ServerName: serverName,
+		NextProtos: []string{"h3"},
+	}, &quic.Config{Versions: []quic.VersionNumber{version}})
+
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	b := make([]byte, 2048)
+	n, _, err := server.ReadFrom(b)
+	assert.Nil(t, err)
+	return b[:n]
+}
+
+func TestSniffQUIC(t *testing.T) {
+	for _, version := range []quic.VersionNumber{quic.Version1, quic.VersionDraft29} {
+		packet := quicInitial(t, "www.example.com", version)
+		raw := append([]byte(nil), packet...)
+
+		host, err := SniffQUIC(packet)
+		assert.Nil(t, err, version)
+		assert.Equal(t, "www.example.com", host, version)
+		// the packet is forwarded as it was
+		assert.Equal(t, raw, packet)
+	}
+
+	packet := quicInitial(t, "www.example.com", quic.Version1)
+	_, err := SniffQUIC(packet[:100])
+	assert.NotNil(t, err)
+	packet[len(packet)-1] ^= 0xff
+	_, err = SniffQUIC(packet)
+	assert.NotNil(t, err)
+	_, err = SniffQUIC([]byte("\x00\x01\x02\x03 dns query"))
+	assert.NotNil(t, err)
+}
+
+func TestDispatcher_SniffPacket(t *testing.T) {
+	packet := quicInitial(t, "example.com", quic.Version1)
+
+	metadata := &C.Metadata{NetWork: C.UDP, Type: C.TPROXY, DstIP: net.IPv4(93, 184, 216, 34), DstPort: "443"}
+	NewDispatcher([]Type{QUIC}, nil, []C.Type{C.TPROXY}).SniffPacket(packet, metadata)
+	assert.Equal(t, "example.com", metadata.Host)
+
+	metadata = &C.Metadata{NetWork: C.UDP, Type: C.TPROXY, DstIP: net.IPv4(93, 184, 216, 34), DstPort: "443"}
+	NewDispatcher([]Type{TLS, HTTP}, nil, []C.Type{C.TPROXY}).SniffPacket(packet, metadata)
+	assert.Equal(t, "", metadata.Host)
+}
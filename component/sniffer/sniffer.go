@@ -0,0 +1,220 @@
+// Package sniffer finds the domain of connections that come with an IP
+// only, as those of redir and tproxy, in the first bytes the client sends.
+package sniffer
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/log"
+)
+
+const (
+	// how long a client that waits for the server to speak first is held up
+	sniffTimeout = 300 * time.Millisecond
+	// what is kept of the first bytes, ClientHellos of post-quantum key
+	// shares are near 2K
+	maxPeekLength = 4096
+)
+
+// ErrNoClue is returned when the bytes are too few to tell
+var ErrNoClue = errors.New("not enough data to sniff")
+
+type Type int
+
+const (
+	TLS Type = iota
+	HTTP
+	QUIC
+)
+
+func (t Type) String() string {
+	switch t {
+	case TLS:
+		return "TLS"
+	case HTTP:
+		return "HTTP"
+	case QUIC:
+		return "QUIC"
+	default:
+		return "Unknown"
+	}
+}
+
+// ParseType returns the sniffer named s, as tls, http or quic
+func ParseType(s string) (Type, error) {
+	switch strings.ToLower(s) {
+	case "tls":
+		return TLS, nil
+	case "http":
+		return HTTP, nil
+	case "quic":
+		return QUIC, nil
+	default:
+		return 0, fmt.Errorf("unknown sniffer: %s", s)
+	}
+}
+
+// PortRange is an inclusive range of ports
+type PortRange struct {
+	Start, End uint16
+}
+
+// ParsePortRange parses a port as 443 or a range as 8000-9000
+func ParsePortRange(s string) (PortRange, error) {
+	start, end := s, s
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		start, end = s[:i], s[i+1:]
+	}
+	first, err := strconv.ParseUint(strings.TrimSpace(start), 10, 16)
+	if err != nil {
+		return PortRange{}, fmt.Errorf("invalid port: %s", s)
+	}
+	last, err := strconv.ParseUint(strings.TrimSpace(end), 10, 16)
+	if err != nil || last < first {
+		return PortRange{}, fmt.Errorf("invalid port: %s", s)
+	}
+	return PortRange{uint16(first), uint16(last)}, nil
+}
+
+func (r PortRange) contains(port uint16) bool {
+	return port >= r.Start && port <= r.End
+}
+
+// Dispatcher sniffs the connections of some inbounds to some ports, and
+// sets the domain it finds as the host of their metadata.
+type Dispatcher struct {
+	sniffers map[Type]bool
+	// every port if empty
+	ports    []PortRange
+	inbounds map[C.Type]bool
+}
+
+func NewDispatcher(sniffers []Type, ports []PortRange, inbounds []C.Type) *Dispatcher {
+	d := &Dispatcher{
+		sniffers: map[Type]bool{},
+		ports:    ports,
+		inbounds: map[C.Type]bool{},
+	}
+	for _, t := range sniffers {
+		d.sniffers[t] = true
+	}
+	for _, t := range inbounds {
+		d.inbounds[t] = true
+	}
+	return d
+}
+
+// shouldSniff tells whether the connection has no domain yet and goes
+// through an inbound and to a port to sniff
+func (d *Dispatcher) shouldSniff(metadata *C.Metadata) bool {
+	if metadata.Host != "" || metadata.DstIP == nil || !d.inbounds[metadata.Type] {
+		return false
+	}
+	if len(d.ports) == 0 {
+		return true
+	}
+
+	port, err := strconv.ParseUint(metadata.DstPort, 10, 16)
+	if err != nil {
+		return false
+	}
+	for _, r := range d.ports {
+		if r.contains(uint16(port)) {
+			return true
+		}
+	}
+	return false
+}
+
+// SniffConn peeks at what the client sends first on conn. The conn
+// returned is to be used in place of conn, it gives the peeked bytes back.
+func (d *Dispatcher) SniffConn(conn net.Conn, metadata *C.Metadata) net.Conn {
+	if !d.shouldSniff(metadata) || !d.sniffers[TLS] && !d.sniffers[HTTP] {
+		return conn
+	}
+
+	b := peek(conn, d.sniffers[TLS])
+	if len(b) == 0 {
+		return conn
+	}
+	conn = &peekedConn{Conn: conn, buf: b}
+
+	var host string
+	var err error
+	switch {
+	case d.sniffers[TLS] && tlsRecordLength(b) != 0:
+		host, err = SniffTLS(b)
+	case d.sniffers[HTTP]:
+		host, err = SniffHTTP(b)
+	default:
+		return conn
+	}
+	d.override(metadata, host, err)
+	return conn
+}
+
+// peek reads what the client sends before sniffTimeout, and the rest of a
+// TLS record it starts if wholeRecord.
+func peek(conn net.Conn, wholeRecord bool) []byte {
+	conn.SetReadDeadline(time.Now().Add(sniffTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	buf := make([]byte, maxPeekLength)
+	n, err := conn.Read(buf)
+	if wholeRecord {
+		// a ClientHello may come in more than one segment
+		want := tlsRecordLength(buf[:n])
+		if want > len(buf) {
+			want = len(buf)
+		}
+		for err == nil && n < want {
+			var m int
+			m, err = conn.Read(buf[n:want])
+			n += m
+		}
+	}
+	return buf[:n]
+}
+
+// peekedConn gives the peeked bytes back before reading on
+type peekedConn struct {
+	net.Conn
+	buf []byte
+}
+
+func (c *peekedConn) Read(b []byte) (int, error) {
+	if len(c.buf) != 0 {
+		n := copy(b, c.buf)
+		c.buf = c.buf[n:]
+		return n, nil
+	}
+	return c.Conn.Read(b)
+}
+
+// SniffPacket sniffs the first packet of a UDP session
+func (d *Dispatcher) SniffPacket(b []byte, metadata *C.Metadata) {
+	if !d.sniffers[QUIC] || !d.shouldSniff(metadata) {
+		return
+	}
+
+	host, err := SniffQUIC(b)
+	d.override(metadata, host, err)
+}
+
+func (d *Dispatcher) override(metadata *C.Metadata, host string, err error) {
+	if err != nil {
+		log.Debugln("[Sniffer] %s --> %s: %s", metadata.SourceAddress(), metadata.RemoteAddress(), err)
+		return
+	}
+
+	log.Debugln("[Sniffer] %s --> %s sniffed %s", metadata.SourceAddress(), metadata.RemoteAddress(), host)
+	// the IP stays for the IP rules and the UDP packets
+	metadata.Host = host
+	metadata.AddrType = C.AtypDomainName
+}
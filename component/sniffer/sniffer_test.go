@@ -0,0 +1,155 @@
+package sniffer
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// clientHello returns what a TLS client sends first for serverName
+func clientHello(t *testing.T, serverName string) []byte {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	go func() {
+		tls.Client(client, &tls.Config{ServerName: serverName}).Handshake()
+		client.Close()
+	}()
+
+	b := make([]byte, maxPeekLength)
+	n, err := io.ReadAtLeast(server, b, recordHeaderLength)
+	assert.Nil(t, err)
+	for n < tlsRecordLength(b) {
+		m, err := server.Read(b[n:])
+		assert.Nil(t, err)
+		n += m
+	}
+	return b[:n]
+}
+
+func TestSniffTLS(t *testing.T) {
+	hello := clientHello(t, "www.Example.com")
+	host, err := SniffTLS(hello)
+	assert.Nil(t, err)
+	assert.Equal(t, "www.example.com", host)
+
+	_, err = SniffTLS(hello[:3])
+	assert.ErrorIs(t, err, ErrNoClue)
+	_, err = SniffTLS(hello[:20])
+	assert.ErrorIs(t, err, ErrNoClue)
+
+	// no server name for an IP
+	_, err = SniffTLS(clientHello(t, "1.1.1.1"))
+	assert.NotNil(t, err)
+
+	_, err = SniffTLS([]byte("GET / HTTP/1.1\r\n"))
+	assert.NotNil(t, err)
+}
+
+func TestSniffHTTP(t *testing.T) {
+	for _, req := range []string{
+		"GET / HTTP/1.1\r\nHost: example.com\r\nAccept: */*\r\n\r\n",
+		"POST /upload HTTP/1.1\r\nUser-Agent: curl\r\nhost:example.com:8080\r\n\r\nbody",
+		"GET / HTTP/1.1\r\nHOST: Example.com\r\nAccept: */*",
+	} {
+		host, err := SniffHTTP([]byte(req))
+		assert.Nil(t, err, req)
+		assert.Equal(t, "example.com", host, req)
+	}
+
+	for _, req := range []string{
+		"GET / HTTP/1.1\r\nHost: 10.0.0.1:80\r\n\r\n",
+		"GET / HTTP/1.1\r\nAccept: */*\r\n\r\nHost: example.com\r\n",
+		"SSH-2.0-OpenSSH_8.9\r\n",
+		"GET / HTTP/1.1\r\nHost: exam",
+	} {
+		_, err := SniffHTTP([]byte(req))
+		assert.NotNil(t, err, req)
+	}
+
+	_, err := SniffHTTP([]byte("GE"))
+	assert.ErrorIs(t, err, ErrNoClue)
+}
+
+func TestParsePortRange(t *testing.T) {
+	r, err := ParsePortRange("443")
+	assert.Nil(t, err)
+	assert.Equal(t, PortRange{443, 443}, r)
+
+	r, err = ParsePortRange("8000-9000")
+	assert.Nil(t, err)
+	assert.Equal(t, PortRange{8000, 9000}, r)
+
+	for _, s := range []string{"", "https", "9000-8000", "70000", "1-"} {
+		_, err := ParsePortRange(s)
+		assert.NotNil(t, err, s)
+	}
+}
+
+func TestDispatcher_SniffConn(t *testing.T) {
+	d := NewDispatcher([]Type{TLS, HTTP}, []PortRange{{443, 443}, {8000, 9000}}, []C.Type{C.REDIR})
+	hello := clientHello(t, "example.com")
+
+	sniff := func(metadata *C.Metadata, first []byte) net.Conn {
+		client, server := net.Pipe()
+		t.Cleanup(func() { client.Close() })
+		go func() {
+			client.Write(first)
+			client.Write([]byte("more"))
+		}()
+		return d.SniffConn(server, metadata)
+	}
+
+	metadata := &C.Metadata{Type: C.REDIR, DstIP: net.IPv4(93, 184, 216, 34), DstPort: "443", AddrType: C.AtypIPv4}
+	conn := sniff(metadata, hello)
+	assert.Equal(t, "example.com", metadata.Host)
+	assert.Equal(t, C.AtypDomainName, metadata.AddrType)
+	assert.NotNil(t, metadata.DstIP)
+
+	// nothing of what the client sent is lost
+	b := make([]byte, len(hello)+4)
+	_, err := io.ReadFull(conn, b)
+	assert.Nil(t, err)
+	assert.Equal(t, hello, b[:len(hello)])
+	assert.Equal(t, "more", string(b[len(hello):]))
+
+	metadata = &C.Metadata{Type: C.REDIR, DstIP: net.IPv4(93, 184, 216, 34), DstPort: "8080"}
+	sniff(metadata, []byte("GET / HTTP/1.1\r\nHost: example.org\r\n\r\n"))
+	assert.Equal(t, "example.org", metadata.Host)
+
+	// not a port, not an inbound to sniff, or the domain is known already
+	for _, metadata := range []*C.Metadata{
+		{Type: C.REDIR, DstIP: net.IPv4(93, 184, 216, 34), DstPort: "22"},
+		{Type: C.SOCKS5, DstIP: net.IPv4(93, 184, 216, 34), DstPort: "443"},
+		{Type: C.REDIR, DstIP: net.IPv4(93, 184, 216, 34), DstPort: "443", Host: "example.net"},
+	} {
+		host := metadata.Host
+		sniff(metadata, hello)
+		assert.Equal(t, host, metadata.Host)
+	}
+}
+
+func TestDispatcher_ServerFirst(t *testing.T) {
+	d := NewDispatcher([]Type{TLS, HTTP}, nil, []C.Type{C.TPROXY})
+	client, server := net.Pipe()
+	defer client.Close()
+
+	metadata := &C.Metadata{Type: C.TPROXY, DstIP: net.IPv4(10, 0, 0, 1), DstPort: "25"}
+	start := time.Now()
+	conn := d.SniffConn(server, metadata)
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, "", metadata.Host)
+
+	// the timeout doesn't stick to the conn
+	go client.Write([]byte("EHLO example.com\r\n"))
+	b := make([]byte, 18)
+	_, err := io.ReadFull(conn, b)
+	assert.Nil(t, err)
+	assert.Equal(t, "EHLO example.com\r\n", string(b))
+}
@@ -0,0 +1,95 @@
+package sniffer
+
+import (
+	"errors"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+const (
+	recordTypeHandshake    = 0x16
+	handshakeClientHello   = 0x01
+	extensionServerName    = 0x00
+	serverNameTypeHostName = 0x00
+
+	recordHeaderLength = 5
+)
+
+var errNotClientHello = errors.New("not a tls client hello")
+
+// SniffTLS returns the server name of the ClientHello that b starts with
+func SniffTLS(b []byte) (string, error) {
+	if len(b) < recordHeaderLength {
+		return "", ErrNoClue
+	}
+	if b[0] != recordTypeHandshake || b[1] != 0x03 {
+		return "", errNotClientHello
+	}
+
+	// the record may be cut short, the server name comes early enough
+	return serverName(b[recordHeaderLength:])
+}
+
+// tlsRecordLength is the length of the record that b starts with
+func tlsRecordLength(b []byte) int {
+	if len(b) < recordHeaderLength || b[0] != recordTypeHandshake {
+		return 0
+	}
+	return recordHeaderLength + (int(b[3])<<8 | int(b[4]))
+}
+
+// serverName parses the server name extension out of a ClientHello
+// handshake message, as in a TLS record or QUIC CRYPTO frames
+func serverName(msg []byte) (string, error) {
+	s := cryptobyte.String(msg)
+
+	var msgType uint8
+	var body cryptobyte.String
+	if !s.ReadUint8(&msgType) || msgType != handshakeClientHello {
+		return "", errNotClientHello
+	}
+	if !s.ReadUint24LengthPrefixed(&body) {
+		return "", ErrNoClue
+	}
+
+	var sessionID, cipherSuites, compression, extensions cryptobyte.String
+	if !body.Skip(2+32) ||
+		!body.ReadUint8LengthPrefixed(&sessionID) ||
+		!body.ReadUint16LengthPrefixed(&cipherSuites) ||
+		!body.ReadUint8LengthPrefixed(&compression) {
+		return "", ErrNoClue
+	}
+	if body.Empty() {
+		return "", errNoServerName
+	}
+	if !body.ReadUint16LengthPrefixed(&extensions) {
+		return "", ErrNoClue
+	}
+
+	for !extensions.Empty() {
+		var extType uint16
+		var ext cryptobyte.String
+		if !extensions.ReadUint16(&extType) || !extensions.ReadUint16LengthPrefixed(&ext) {
+			return "", ErrNoClue
+		}
+		if extType != extensionServerName {
+			continue
+		}
+
+		var names cryptobyte.String
+		if !ext.ReadUint16LengthPrefixed(&names) {
+			return "", errNoServerName
+		}
+		for !names.Empty() {
+			var nameType uint8
+			var name cryptobyte.String
+			if !names.ReadUint8(&nameType) || !names.ReadUint16LengthPrefixed(&name) {
+				return "", errNoServerName
+			}
+			if nameType == serverNameTypeHostName && len(name) != 0 {
+				return validHost(string(name))
+			}
+		}
+	}
+	return "", errNoServerName
+}
@@ -6,6 +6,7 @@ import (
 	"net"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/Dreamacro/clash/adapter"
@@ -13,11 +14,17 @@ import (
 	"github.com/Dreamacro/clash/adapter/outboundgroup"
 	"github.com/Dreamacro/clash/adapter/provider"
 	"github.com/Dreamacro/clash/component/auth"
+	"github.com/Dreamacro/clash/component/dialer"
 	"github.com/Dreamacro/clash/component/fakeip"
+	"github.com/Dreamacro/clash/component/geodata"
+	"github.com/Dreamacro/clash/component/profile/cachefile"
+	"github.com/Dreamacro/clash/component/sniffer"
 	"github.com/Dreamacro/clash/component/trie"
 	C "github.com/Dreamacro/clash/constant"
 	providerTypes "github.com/Dreamacro/clash/constant/provider"
 	"github.com/Dreamacro/clash/dns"
+	P "github.com/Dreamacro/clash/listener"
+	"github.com/Dreamacro/clash/listener/tun"
 	"github.com/Dreamacro/clash/log"
 	R "github.com/Dreamacro/clash/rule"
 	ruleProvider "github.com/Dreamacro/clash/rule/provider"
@@ -30,10 +37,12 @@ import (
 type General struct {
 	Inbound
 	Controller
-	Mode      T.TunnelMode `json:"mode"`
-	LogLevel  log.LogLevel `json:"log-level"`
-	IPv6      bool         `json:"ipv6"`
-	Interface string       `json:"-"`
+	Mode        T.TunnelMode     `json:"mode"`
+	LogLevel    log.LogLevel     `json:"log-level"`
+	IPv6        bool             `json:"ipv6"`
+	IPVersion   dialer.IPVersion `json:"ip-version"`
+	Interface   string           `json:"-"`
+	RoutingMark int              `json:"-"`
 }
 
 // Inbound
@@ -81,11 +90,20 @@ type FallbackFilter struct {
 // Profile config
 type Profile struct {
 	StoreSelected bool `yaml:"store-selected"`
+	StoreFakeIP   bool `yaml:"store-fake-ip"`
 }
 
 // Experimental config
 type Experimental struct{}
 
+// RawSniffer config
+type RawSniffer struct {
+	Enable   bool     `yaml:"enable"`
+	Sniff    []string `yaml:"sniff"`
+	Ports    []string `yaml:"ports"`
+	Inbounds []string `yaml:"inbounds"`
+}
+
 // Config is clash config manager
 type Config struct {
 	General       *General
@@ -93,11 +111,25 @@ type Config struct {
 	Experimental  *Experimental
 	Hosts         *trie.DomainTrie
 	Profile       *Profile
+	Sniffer       *sniffer.Dispatcher
 	Rules         []C.Rule
 	Users         []auth.AuthUser
+	Listeners     []*P.ListenerConfig
+	Tun           *tun.Config
 	Proxies       map[string]C.Proxy
 	Providers     map[string]providerTypes.ProxyProvider
 	RuleProviders map[string]*ruleProvider.RuleProvider
+
+	fingerprints fingerprints
+}
+
+// fingerprints of what the proxies, proxy groups and providers of a config
+// were parsed from. A config parsed against a previous one takes over those
+// which fingerprints didn't change.
+type fingerprints struct {
+	proxies       map[string]string
+	providers     map[string]string
+	ruleProviders map[string]string
 }
 
 type RawDNS struct {
@@ -122,22 +154,45 @@ type RawFallbackFilter struct {
 	Domain    []string `yaml:"domain"`
 }
 
+// RawTun is the tun section
+type RawTun struct {
+	Enable              bool     `yaml:"enable"`
+	Device              string   `yaml:"device"`
+	Stack               string   `yaml:"stack"`
+	MTU                 int      `yaml:"mtu"`
+	Inet4Address        string   `yaml:"inet4-address"`
+	DNSHijack           []string `yaml:"dns-hijack"`
+	AutoRoute           bool     `yaml:"auto-route"`
+	AutoDetectInterface bool     `yaml:"auto-detect-interface"`
+}
+
+// RawListener is an inbound of the listeners section
+type RawListener struct {
+	Name     string   `yaml:"name" json:"name"`
+	Type     string   `yaml:"type" json:"type"`
+	Listen   string   `yaml:"listen" json:"listen"`
+	Users    []string `yaml:"users" json:"users"`
+	AllowIPs []string `yaml:"allow-ips" json:"allow-ips"`
+}
+
 type RawConfig struct {
-	Port               int          `yaml:"port"`
-	SocksPort          int          `yaml:"socks-port"`
-	RedirPort          int          `yaml:"redir-port"`
-	TProxyPort         int          `yaml:"tproxy-port"`
-	MixedPort          int          `yaml:"mixed-port"`
-	Authentication     []string     `yaml:"authentication"`
-	AllowLan           bool         `yaml:"allow-lan"`
-	BindAddress        string       `yaml:"bind-address"`
-	Mode               T.TunnelMode `yaml:"mode"`
-	LogLevel           log.LogLevel `yaml:"log-level"`
-	IPv6               bool         `yaml:"ipv6"`
-	ExternalController string       `yaml:"external-controller"`
-	ExternalUI         string       `yaml:"external-ui"`
-	Secret             string       `yaml:"secret"`
-	Interface          string       `yaml:"interface-name"`
+	Port               int              `yaml:"port"`
+	SocksPort          int              `yaml:"socks-port"`
+	RedirPort          int              `yaml:"redir-port"`
+	TProxyPort         int              `yaml:"tproxy-port"`
+	MixedPort          int              `yaml:"mixed-port"`
+	Authentication     []string         `yaml:"authentication"`
+	AllowLan           bool             `yaml:"allow-lan"`
+	BindAddress        string           `yaml:"bind-address"`
+	Mode               T.TunnelMode     `yaml:"mode"`
+	LogLevel           log.LogLevel     `yaml:"log-level"`
+	IPv6               bool             `yaml:"ipv6"`
+	IPVersion          dialer.IPVersion `yaml:"ip-version"`
+	ExternalController string           `yaml:"external-controller"`
+	ExternalUI         string           `yaml:"external-ui"`
+	Secret             string           `yaml:"secret"`
+	Interface          string           `yaml:"interface-name"`
+	RoutingMark        int              `yaml:"routing-mark"`
 
 	ProxyProvider map[string]map[string]interface{} `yaml:"proxy-providers"`
 	RuleProvider  map[string]map[string]interface{} `yaml:"rule-providers"`
@@ -145,6 +200,9 @@ type RawConfig struct {
 	DNS           RawDNS                            `yaml:"dns"`
 	Experimental  Experimental                      `yaml:"experimental"`
 	Profile       Profile                           `yaml:"profile"`
+	Sniffer       RawSniffer                        `yaml:"sniffer"`
+	Listeners     []RawListener                     `yaml:"listeners"`
+	Tun           RawTun                            `yaml:"tun"`
 	Proxy         []map[string]interface{}          `yaml:"proxies"`
 	ProxyGroup    []map[string]interface{}          `yaml:"proxy-groups"`
 	Rule          []string                          `yaml:"rules"`
@@ -152,12 +210,19 @@ type RawConfig struct {
 
 // Parse config
 func Parse(buf []byte) (*Config, error) {
+	return ParseWithPrevious(buf, nil)
+}
+
+// ParseWithPrevious parses a config as Parse does, but keeps the proxies,
+// proxy groups and providers of previous that are configured the same, so
+// they go on with their connections and state. previous may be nil.
+func ParseWithPrevious(buf []byte, previous *Config) (*Config, error) {
 	rawCfg, err := UnmarshalRawConfig(buf)
 	if err != nil {
 		return nil, err
 	}
 
-	return ParseRawConfig(rawCfg)
+	return parseRawConfig(rawCfg, previous)
 }
 
 func UnmarshalRawConfig(buf []byte) (*RawConfig, error) {
@@ -189,6 +254,16 @@ func UnmarshalRawConfig(buf []byte) (*RawConfig, error) {
 		Profile: Profile{
 			StoreSelected: true,
 		},
+		Sniffer: RawSniffer{
+			Sniff:    []string{"tls", "http", "quic"},
+			Inbounds: []string{"redir", "tproxy", "tun"},
+		},
+		Tun: RawTun{
+			Device:       "clash0",
+			Stack:        "system",
+			MTU:          9000,
+			Inet4Address: "172.19.0.1/30",
+		},
 	}
 
 	if err := yaml.Unmarshal(buf, &rawCfg); err != nil {
@@ -199,6 +274,10 @@ func UnmarshalRawConfig(buf []byte) (*RawConfig, error) {
 }
 
 func ParseRawConfig(rawCfg *RawConfig) (*Config, error) {
+	return parseRawConfig(rawCfg, nil)
+}
+
+func parseRawConfig(rawCfg *RawConfig, previous *Config) (*Config, error) {
 	config := &Config{}
 
 	config.Experimental = &rawCfg.Experimental
@@ -210,14 +289,14 @@ func ParseRawConfig(rawCfg *RawConfig) (*Config, error) {
 	}
 	config.General = general
 
-	proxies, providers, err := parseProxies(rawCfg)
+	proxies, providers, err := parseProxies(rawCfg, previous, &config.fingerprints)
 	if err != nil {
 		return nil, err
 	}
 	config.Proxies = proxies
 	config.Providers = providers
 
-	rules, ruleProviders, err := parseRules(rawCfg, proxies)
+	rules, ruleProviders, err := parseRules(rawCfg, proxies, previous, &config.fingerprints)
 	if err != nil {
 		return nil, err
 	}
@@ -230,7 +309,7 @@ func ParseRawConfig(rawCfg *RawConfig) (*Config, error) {
 	}
 	config.Hosts = hosts
 
-	dnsCfg, err := parseDNS(rawCfg.DNS, hosts)
+	dnsCfg, err := parseDNS(rawCfg.DNS, hosts, rawCfg.Profile.StoreFakeIP)
 	if err != nil {
 		return nil, err
 	}
@@ -238,6 +317,24 @@ func ParseRawConfig(rawCfg *RawConfig) (*Config, error) {
 
 	config.Users = parseAuthentication(rawCfg.Authentication)
 
+	listeners, err := parseListeners(rawCfg.Listeners)
+	if err != nil {
+		return nil, err
+	}
+	config.Listeners = listeners
+
+	tunCfg, err := parseTun(rawCfg.Tun, rawCfg.Interface)
+	if err != nil {
+		return nil, err
+	}
+	config.Tun = tunCfg
+
+	snifferCfg, err := parseSniffer(rawCfg.Sniffer)
+	if err != nil {
+		return nil, err
+	}
+	config.Sniffer = snifferCfg
+
 	return config, nil
 }
 
@@ -268,14 +365,16 @@ func parseGeneral(cfg *RawConfig) (*General, error) {
 			ExternalUI:         cfg.ExternalUI,
 			Secret:             cfg.Secret,
 		},
-		Mode:      cfg.Mode,
-		LogLevel:  cfg.LogLevel,
-		IPv6:      cfg.IPv6,
-		Interface: cfg.Interface,
+		Mode:        cfg.Mode,
+		LogLevel:    cfg.LogLevel,
+		IPv6:        cfg.IPv6,
+		IPVersion:   cfg.IPVersion,
+		Interface:   cfg.Interface,
+		RoutingMark: cfg.RoutingMark,
 	}, nil
 }
 
-func parseProxies(cfg *RawConfig) (proxies map[string]C.Proxy, providersMap map[string]providerTypes.ProxyProvider, err error) {
+func parseProxies(cfg *RawConfig, previous *Config, fps *fingerprints) (proxies map[string]C.Proxy, providersMap map[string]providerTypes.ProxyProvider, err error) {
 	proxies = make(map[string]C.Proxy)
 	providersMap = make(map[string]providerTypes.ProxyProvider)
 	proxyList := []string{}
@@ -283,22 +382,39 @@ func parseProxies(cfg *RawConfig) (proxies map[string]C.Proxy, providersMap map[
 	groupsConfig := cfg.ProxyGroup
 	providersConfig := cfg.ProxyProvider
 
-	proxies["DIRECT"] = adapter.NewProxy(outbound.NewDirect())
-	proxies["REJECT"] = adapter.NewProxy(outbound.NewReject())
-	proxies["PASS"] = adapter.NewProxy(outbound.NewPass())
-	proxyList = append(proxyList, "DIRECT", "REJECT", "PASS")
+	fps.proxies = map[string]string{}
+	fps.providers = map[string]string{}
+	// providers taken over from previous, initialized already
+	reusedProviders := map[string]bool{}
+
+	for _, builtin := range []C.ProxyAdapter{outbound.NewDirect(), outbound.NewReject(), outbound.NewPass()} {
+		name := builtin.Name()
+		proxy, ok := previous.reuseProxy(name, name)
+		if !ok {
+			proxy = adapter.NewProxy(builtin)
+		}
+		proxies[name] = proxy
+		fps.proxies[name] = name
+		proxyList = append(proxyList, name)
+	}
 
 	// parse proxy
 	for idx, mapping := range proxiesConfig {
-		proxy, err := adapter.ParseProxy(mapping)
-		if err != nil {
-			return nil, nil, fmt.Errorf("proxy %d: %w", idx, err)
+		name, _ := mapping["name"].(string)
+		fp := fingerprint(mapping)
+		proxy, ok := previous.reuseProxy(name, fp)
+		if !ok {
+			proxy, err = adapter.ParseProxy(mapping)
+			if err != nil {
+				return nil, nil, fmt.Errorf("proxy %d: %w", idx, err)
+			}
 		}
 
 		if _, exist := proxies[proxy.Name()]; exist {
 			return nil, nil, fmt.Errorf("proxy %s is the duplicate name", proxy.Name())
 		}
 		proxies[proxy.Name()] = proxy
+		fps.proxies[proxy.Name()] = fp
 		proxyList = append(proxyList, proxy.Name())
 	}
 
@@ -322,6 +438,14 @@ func parseProxies(cfg *RawConfig) (proxies map[string]C.Proxy, providersMap map[
 			return nil, nil, fmt.Errorf("can not defined a provider called `%s`", provider.ReservedName)
 		}
 
+		fp := fingerprint(mapping)
+		fps.providers[name] = fp
+		if pd, ok := previous.reuseProvider(name, fp); ok {
+			providersMap[name] = pd
+			reusedProviders[name] = true
+			continue
+		}
+
 		pd, err := provider.ParseProxyProvider(name, mapping)
 		if err != nil {
 			return nil, nil, fmt.Errorf("parse proxy provider %s error: %w", name, err)
@@ -330,7 +454,11 @@ func parseProxies(cfg *RawConfig) (proxies map[string]C.Proxy, providersMap map[
 		providersMap[name] = pd
 	}
 
-	for _, provider := range providersMap {
+	for name, provider := range providersMap {
+		if reusedProviders[name] {
+			continue
+		}
+
 		log.Infoln("Start initial provider %s", provider.Name())
 		if err := provider.Initial(); err != nil {
 			return nil, nil, fmt.Errorf("initial proxy provider %s error: %w", provider.Name(), err)
@@ -339,22 +467,40 @@ func parseProxies(cfg *RawConfig) (proxies map[string]C.Proxy, providersMap map[
 
 	// parse proxy group
 	for idx, mapping := range groupsConfig {
+		groupName, _ := mapping["name"].(string)
+		fp := groupFingerprint(mapping, fps)
+		if proxy, ok := previous.reuseProxy(groupName, fp); ok {
+			if _, exist := proxies[groupName]; exist {
+				return nil, nil, fmt.Errorf("proxy group %s: the duplicate name", groupName)
+			}
+
+			proxies[groupName] = proxy
+			fps.proxies[groupName] = fp
+			// the provider of its proxies goes along
+			if pd, ok := previous.Providers[groupName]; ok && pd.VehicleType() == providerTypes.Compatible {
+				providersMap[groupName] = pd
+				reusedProviders[groupName] = true
+			}
+			continue
+		}
+
 		group, err := outboundgroup.ParseProxyGroup(mapping, proxies, providersMap)
 		if err != nil {
 			return nil, nil, fmt.Errorf("proxy group[%d]: %w", idx, err)
 		}
 
-		groupName := group.Name()
+		groupName = group.Name()
 		if _, exist := proxies[groupName]; exist {
 			return nil, nil, fmt.Errorf("proxy group %s: the duplicate name", groupName)
 		}
 
 		proxies[groupName] = adapter.NewProxy(group)
+		fps.proxies[groupName] = fp
 	}
 
 	// initial compatible provider
-	for _, pd := range providersMap {
-		if pd.VehicleType() != providerTypes.Compatible {
+	for name, pd := range providersMap {
+		if pd.VehicleType() != providerTypes.Compatible || reusedProviders[name] {
 			continue
 		}
 
@@ -365,12 +511,24 @@ func parseProxies(cfg *RawConfig) (proxies map[string]C.Proxy, providersMap map[
 	}
 
 	ps := []C.Proxy{}
+	globalFp := &strings.Builder{}
 	for _, v := range proxyList {
 		if proxies[v].Type() == C.Pass {
 			continue
 		}
 		ps = append(ps, proxies[v])
+		fmt.Fprintf(globalFp, "%s\n%s\n", v, fps.proxies[v])
+	}
+	fps.proxies["GLOBAL"] = globalFp.String()
+
+	if global, ok := previous.reuseProxy("GLOBAL", globalFp.String()); ok {
+		if pd, ok := previous.Providers[provider.ReservedName]; ok {
+			proxies["GLOBAL"] = global
+			providersMap[provider.ReservedName] = pd
+			return proxies, providersMap, nil
+		}
 	}
+
 	hc := provider.NewHealthCheck(ps, "", 0, true)
 	pd, _ := provider.NewCompatibleProvider(provider.ReservedName, ps, hc)
 	providersMap[provider.ReservedName] = pd
@@ -385,7 +543,7 @@ func parseProxies(cfg *RawConfig) (proxies map[string]C.Proxy, providersMap map[
 	return proxies, providersMap, nil
 }
 
-func parseRules(cfg *RawConfig, proxies map[string]C.Proxy) ([]C.Rule, map[string]*ruleProvider.RuleProvider, error) {
+func parseRules(cfg *RawConfig, proxies map[string]C.Proxy, previous *Config, fps *fingerprints) ([]C.Rule, map[string]*ruleProvider.RuleProvider, error) {
 	ruleProviders := map[string]*ruleProvider.RuleProvider{}
 	ruleProviderNameSet := make(map[string]interface{}, len(ruleProviders))
 
@@ -398,8 +556,20 @@ func parseRules(cfg *RawConfig, proxies map[string]C.Proxy) ([]C.Rule, map[strin
 		return R.ParseRule(ruleType, rule, "", params)
 	})
 
+	fps.ruleProviders = map[string]string{}
+	reusedProviders := map[string]bool{}
+
 	// parse rule provider
 	for name, mapping := range cfg.RuleProvider {
+		fp := fingerprint(mapping)
+		fps.ruleProviders[name] = fp
+		if rp, ok := previous.reuseRuleProvider(name, fp); ok {
+			ruleProviders[name] = rp
+			ruleProvider.SetRuleProvider(rp)
+			reusedProviders[name] = true
+			continue
+		}
+
 		rp, err := ruleProvider.ParseRuleProvider(name, mapping)
 		if err != nil {
 			return nil, nil, err
@@ -409,7 +579,11 @@ func parseRules(cfg *RawConfig, proxies map[string]C.Proxy) ([]C.Rule, map[strin
 		ruleProvider.SetRuleProvider(&rp)
 	}
 
-	for _, provider := range ruleProviders {
+	for name, provider := range ruleProviders {
+		if reusedProviders[name] {
+			continue
+		}
+
 		log.Infoln("Start initial provider %s", (*provider).Name())
 		if err := (*provider).Initial(); err != nil {
 			return nil, nil, fmt.Errorf("initial rule provider %s error: %w", (*provider).Name(), err)
@@ -535,6 +709,10 @@ func parseNameServer(servers []string) ([]dns.NameServer, error) {
 			clearURL := url.URL{Scheme: "https", Host: u.Host, Path: u.Path}
 			addr = clearURL.String()
 			dnsNetType = "https" // DNS over HTTPS
+		case "h3":
+			clearURL := url.URL{Scheme: "https", Host: u.Host, Path: u.Path}
+			addr = clearURL.String()
+			dnsNetType = "h3" // DNS over HTTPS over HTTP/3
 		case "quic":
 			addr, err = hostWithDefaultPort(u.Host, "784")
 			dnsNetType = "quic" // DNS over QUIC
@@ -588,7 +766,7 @@ func parseFallbackIPCIDR(ips []string) ([]*net.IPNet, error) {
 	return ipNets, nil
 }
 
-func parseDNS(cfg RawDNS, hosts *trie.DomainTrie) (*DNS, error) {
+func parseDNS(cfg RawDNS, hosts *trie.DomainTrie, storeFakeIP bool) (*DNS, error) {
 	if cfg.Enable && len(cfg.NameServer) == 0 {
 		return nil, fmt.Errorf("if DNS configuration is turned on, NameServer cannot be empty")
 	}
@@ -636,15 +814,37 @@ func parseDNS(cfg RawDNS, hosts *trie.DomainTrie) (*DNS, error) {
 		}
 
 		var host *trie.DomainTrie
+		var sites []*geodata.Matcher
 		// fake ip skip host filter
-		if len(cfg.FakeIPFilter) != 0 {
-			host = trie.New()
-			for _, domain := range cfg.FakeIPFilter {
-				host.Insert(domain, true)
+		for _, domain := range cfg.FakeIPFilter {
+			if strings.HasPrefix(domain, "geosite:") {
+				site, err := geodata.NewMatcher(strings.TrimPrefix(domain, "geosite:"))
+				if err != nil {
+					return nil, fmt.Errorf("fake-ip-filter %s: %w", domain, err)
+				}
+				sites = append(sites, site)
+				continue
+			}
+
+			if host == nil {
+				host = trie.New()
+			}
+			if err := host.Insert(domain, true); err != nil {
+				log.Warnln("fake-ip-filter %s is skipped: %s", domain, err.Error())
 			}
 		}
 
-		pool, err := fakeip.New(ipnet, 1000, host)
+		options := fakeip.Options{
+			IPNet: ipnet,
+			Host:  host,
+			Sites: sites,
+			Size:  1000,
+		}
+		if storeFakeIP {
+			options.Store = cachefile.Cache()
+		}
+
+		pool, err := fakeip.New(options)
 		if err != nil {
 			return nil, err
 		}
@@ -676,3 +876,161 @@ func parseAuthentication(rawRecords []string) []auth.AuthUser {
 	}
 	return users
 }
+
+func parseListeners(rawListeners []RawListener) ([]*P.ListenerConfig, error) {
+	listeners := make([]*P.ListenerConfig, 0, len(rawListeners))
+	names := map[string]bool{}
+	for idx, raw := range rawListeners {
+		listener, err := ParseListener(raw)
+		if err != nil {
+			return nil, fmt.Errorf("listener %d: %w", idx, err)
+		}
+		if names[listener.Name] {
+			return nil, fmt.Errorf("listener %s is the duplicate name", listener.Name)
+		}
+		names[listener.Name] = true
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// ParseListener parses an inbound of the listeners section
+func ParseListener(raw RawListener) (*P.ListenerConfig, error) {
+	if raw.Name == "" {
+		return nil, errors.New("missing name")
+	}
+	if !P.ValidListenerType(raw.Type) {
+		return nil, fmt.Errorf("unsupported type: %s", raw.Type)
+	}
+	if _, port, err := net.SplitHostPort(raw.Listen); err != nil || port == "0" {
+		return nil, fmt.Errorf("invalid listen address: %s", raw.Listen)
+	}
+
+	sources := make([]*net.IPNet, 0, len(raw.AllowIPs))
+	for _, cidr := range raw.AllowIPs {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allow-ips %s: %w", cidr, err)
+		}
+		sources = append(sources, ipnet)
+	}
+
+	return &P.ListenerConfig{
+		Name:    raw.Name,
+		Type:    raw.Type,
+		Listen:  raw.Listen,
+		Users:   parseAuthentication(raw.Users),
+		Sources: sources,
+	}, nil
+}
+
+func parseTun(cfg RawTun, iface string) (*tun.Config, error) {
+	if !cfg.Enable {
+		return nil, nil
+	}
+
+	if cfg.Stack != "system" {
+		return nil, fmt.Errorf("tun: unsupported stack %s", cfg.Stack)
+	}
+	if cfg.Device == "" || len(cfg.Device) >= 16 {
+		return nil, fmt.Errorf("tun: invalid device name %s", cfg.Device)
+	}
+	if cfg.MTU < 576 || cfg.MTU > 65535 {
+		return nil, fmt.Errorf("tun: invalid mtu %d", cfg.MTU)
+	}
+
+	// the stack takes another address of the subnet
+	ip, ipnet, err := net.ParseCIDR(cfg.Inet4Address)
+	if err != nil || ip.To4() == nil {
+		return nil, fmt.Errorf("tun: invalid inet4-address %s", cfg.Inet4Address)
+	}
+	if ones, _ := ipnet.Mask.Size(); ones > 30 {
+		return nil, fmt.Errorf("tun: inet4-address %s leaves no address of the subnet for the stack", cfg.Inet4Address)
+	}
+	broadcast := make(net.IP, net.IPv4len)
+	for i := range broadcast {
+		broadcast[i] = ipnet.IP[i] | ^ipnet.Mask[i]
+	}
+	if ip.Equal(ipnet.IP) || ip.Equal(broadcast) {
+		return nil, fmt.Errorf("tun: inet4-address %s is not a host address", cfg.Inet4Address)
+	}
+	ipnet.IP = ip.To4()
+
+	dnsHijack := make([]*net.UDPAddr, 0, len(cfg.DNSHijack))
+	for _, addr := range cfg.DNSHijack {
+		host, port, err := net.SplitHostPort(strings.TrimPrefix(addr, "udp://"))
+		if err != nil {
+			return nil, fmt.Errorf("tun: invalid dns-hijack %s", addr)
+		}
+		udpAddr := &net.UDPAddr{}
+		if host != "any" {
+			if udpAddr.IP = net.ParseIP(host).To4(); udpAddr.IP == nil {
+				return nil, fmt.Errorf("tun: invalid dns-hijack %s, the host is any or an ipv4 address", addr)
+			}
+		}
+		if udpAddr.Port, err = strconv.Atoi(port); err != nil || udpAddr.Port <= 0 || udpAddr.Port > 65535 {
+			return nil, fmt.Errorf("tun: invalid dns-hijack %s", addr)
+		}
+		dnsHijack = append(dnsHijack, udpAddr)
+	}
+
+	// the outbound traffic routed back to the device loops
+	if cfg.AutoRoute && !cfg.AutoDetectInterface && iface == "" {
+		return nil, errors.New("tun: auto-route needs auto-detect-interface or interface-name")
+	}
+
+	return &tun.Config{
+		Enable:              true,
+		Device:              cfg.Device,
+		Stack:               cfg.Stack,
+		MTU:                 cfg.MTU,
+		Inet4Address:        ipnet,
+		DNSHijack:           dnsHijack,
+		AutoRoute:           cfg.AutoRoute,
+		AutoDetectInterface: cfg.AutoDetectInterface,
+	}, nil
+}
+
+func parseSniffer(cfg RawSniffer) (*sniffer.Dispatcher, error) {
+	if !cfg.Enable {
+		return nil, nil
+	}
+
+	sniffers := make([]sniffer.Type, 0, len(cfg.Sniff))
+	for _, name := range cfg.Sniff {
+		t, err := sniffer.ParseType(name)
+		if err != nil {
+			return nil, err
+		}
+		sniffers = append(sniffers, t)
+	}
+
+	ports := make([]sniffer.PortRange, 0, len(cfg.Ports))
+	for _, port := range cfg.Ports {
+		r, err := sniffer.ParsePortRange(port)
+		if err != nil {
+			return nil, fmt.Errorf("sniffer ports: %w", err)
+		}
+		ports = append(ports, r)
+	}
+
+	inbounds := []C.Type{}
+	for _, name := range cfg.Inbounds {
+		switch strings.ToLower(name) {
+		case "http":
+			inbounds = append(inbounds, C.HTTP, C.HTTPCONNECT)
+		case "socks":
+			inbounds = append(inbounds, C.SOCKS4, C.SOCKS4A, C.SOCKS5)
+		case "redir":
+			inbounds = append(inbounds, C.REDIR)
+		case "tproxy":
+			inbounds = append(inbounds, C.TPROXY)
+		case "tun":
+			inbounds = append(inbounds, C.TUN)
+		default:
+			return nil, fmt.Errorf("sniffer: unknown inbound %s", name)
+		}
+	}
+
+	return sniffer.NewDispatcher(sniffers, ports, inbounds), nil
+}
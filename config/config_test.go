@@ -0,0 +1,199 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/dns"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testConfig = `
+proxies:
+  - {name: ss1, type: ss, server: 127.0.0.1, port: 8388, cipher: aes-128-gcm, password: "1"}
+  - {name: ss2, type: ss, server: 127.0.0.2, port: 8388, cipher: aes-128-gcm, password: "2"}
+proxy-groups:
+  - {name: select, type: select, proxies: [ss1, DIRECT]}
+  - {name: all, type: select, proxies: [select, ss2]}
+rules:
+  - MATCH,all
+`
+
+func TestParseWithPrevious(t *testing.T) {
+	previous, err := Parse([]byte(testConfig))
+	assert.Nil(t, err)
+
+	same, err := ParseWithPrevious([]byte(testConfig), previous)
+	assert.Nil(t, err)
+	for _, name := range []string{"DIRECT", "ss1", "ss2", "select", "all", "GLOBAL"} {
+		assert.True(t, previous.Proxies[name] == same.Proxies[name], name)
+	}
+	assert.True(t, previous.Providers["select"] == same.Providers["select"])
+
+	// ss2 changed, so did the groups using it
+	changed, err := ParseWithPrevious([]byte(strings.Replace(testConfig, `password: "2"`, `password: "3"`, 1)), previous)
+	assert.Nil(t, err)
+	for _, name := range []string{"ss1", "select"} {
+		assert.True(t, previous.Proxies[name] == changed.Proxies[name], name)
+	}
+	for _, name := range []string{"ss2", "all", "GLOBAL"} {
+		assert.False(t, previous.Proxies[name] == changed.Proxies[name], name)
+	}
+
+	fresh, err := Parse([]byte(testConfig))
+	assert.Nil(t, err)
+	assert.False(t, previous.Proxies["ss1"] == fresh.Proxies["ss1"])
+
+	// a bad config fails as without previous
+	_, err = ParseWithPrevious([]byte(testConfig+"  - MATCH,unknown\n"), previous)
+	assert.NotNil(t, err)
+}
+
+func TestParseListeners(t *testing.T) {
+	cfg, err := Parse([]byte(`
+listeners:
+  - name: lan
+    type: mixed
+    listen: 0.0.0.0:7893
+    users: ["user:pass"]
+    allow-ips: [192.168.0.0/16]
+`))
+	assert.Nil(t, err)
+	if assert.Len(t, cfg.Listeners, 1) {
+		listener := cfg.Listeners[0]
+		assert.Equal(t, "lan", listener.Name)
+		assert.Equal(t, "mixed", listener.Type)
+		assert.Equal(t, "0.0.0.0:7893", listener.Listen)
+		assert.Equal(t, "user", listener.Users[0].User)
+		assert.Equal(t, "192.168.0.0/16", listener.Sources[0].String())
+	}
+
+	for _, invalid := range []string{
+		`[{name: lan, type: redir, listen: "0.0.0.0:7893"}]`,
+		`[{name: lan, type: socks, listen: "7893"}]`,
+		`[{name: lan, type: socks, listen: "0.0.0.0:7893", allow-ips: [192.168.0.1]}]`,
+		`[{name: lan, type: socks, listen: "0.0.0.0:7893"}, {name: lan, type: http, listen: "0.0.0.0:7894"}]`,
+	} {
+		_, err := Parse([]byte("listeners: " + invalid))
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestParseDNS_FakeIPFilter(t *testing.T) {
+	cfg := RawDNS{
+		Enable:            true,
+		NameServer:        []string{"127.0.0.1"},
+		DefaultNameserver: []string{"127.0.0.1"},
+		EnhancedMode:      dns.FAKEIP,
+		FakeIPRange:       "198.18.0.1/16",
+		FakeIPFilter:      []string{"+.lan", "example.com."},
+	}
+
+	// entries the trie rejects are skipped
+	dnsCfg, err := parseDNS(cfg, nil, false)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.True(t, dnsCfg.FakeIPRange.LookupHost("foo.lan"))
+}
+
+func TestFingerprint_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("first"), 0644))
+	mapping := map[string]interface{}{"name": "vless", "ca": path}
+
+	fp := fingerprint(mapping)
+	assert.Equal(t, fp, fingerprint(mapping))
+
+	// the same option over an edited file
+	assert.Nil(t, ioutil.WriteFile(path, []byte("second"), 0644))
+	assert.NotEqual(t, fp, fingerprint(mapping))
+}
+
+func TestParseTun(t *testing.T) {
+	cfg, err := Parse([]byte(`
+tun:
+  enable: true
+  dns-hijack: [any:53, "udp://198.18.0.2:53"]
+  auto-route: true
+  auto-detect-interface: true
+`))
+	assert.Nil(t, err)
+	if assert.NotNil(t, cfg.Tun) {
+		assert.Equal(t, "clash0", cfg.Tun.Device)
+		assert.Equal(t, 9000, cfg.Tun.MTU)
+		assert.Equal(t, "172.19.0.1", cfg.Tun.Inet4Address.IP.String())
+		ones, _ := cfg.Tun.Inet4Address.Mask.Size()
+		assert.Equal(t, 30, ones)
+		if assert.Len(t, cfg.Tun.DNSHijack, 2) {
+			assert.Nil(t, cfg.Tun.DNSHijack[0].IP)
+			assert.Equal(t, "198.18.0.2:53", cfg.Tun.DNSHijack[1].String())
+		}
+	}
+
+	cfg, err = Parse([]byte(`tun: {enable: false}`))
+	assert.Nil(t, err)
+	assert.Nil(t, cfg.Tun)
+
+	for _, invalid := range []string{
+		`{enable: true, stack: gvisor}`,
+		`{enable: true, inet4-address: 172.19.0.1/31}`,
+		`{enable: true, inet4-address: 172.19.0.0/30}`,
+		`{enable: true, inet4-address: "fdfe::1/126"}`,
+		`{enable: true, dns-hijack: ["[::1]:53"]}`,
+		`{enable: true, dns-hijack: [any]}`,
+		`{enable: true, auto-route: true}`,
+	} {
+		_, err := Parse([]byte("tun: " + invalid))
+		assert.NotNil(t, err, invalid)
+	}
+}
+
+func TestParseWithPrevious_FileProvider(t *testing.T) {
+	dir := t.TempDir()
+	proxiesPath := filepath.Join(dir, "proxies.yaml")
+	rulesPath := filepath.Join(dir, "rules.yaml")
+	cfg := `
+proxy-providers:
+  nodes: {type: file, path: ` + proxiesPath + `, health-check: {enable: false, url: http://www.gstatic.com/generate_204, interval: 300}}
+rule-providers:
+  blocked: {type: file, behavior: domain, path: ` + rulesPath + `}
+proxy-groups:
+  - {name: select, type: select, use: [nodes]}
+rules:
+  - RULE-SET,blocked,REJECT
+  - MATCH,select
+`
+	writeProviders := func(proxy, domain string) {
+		assert.Nil(t, ioutil.WriteFile(proxiesPath, []byte("proxies:\n  - {name: "+proxy+", type: ss, server: 127.0.0.1, port: 8388, cipher: aes-128-gcm, password: \"1\"}\n"), 0644))
+		assert.Nil(t, ioutil.WriteFile(rulesPath, []byte("payload:\n  - "+domain+"\n"), 0644))
+	}
+
+	writeProviders("ss1", "a.example.com")
+	previous, err := Parse([]byte(cfg))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	same, err := ParseWithPrevious([]byte(cfg), previous)
+	assert.Nil(t, err)
+	assert.True(t, previous.Providers["nodes"] == same.Providers["nodes"])
+	assert.True(t, previous.RuleProviders["blocked"] == same.RuleProviders["blocked"])
+
+	// the edited files are read again
+	writeProviders("ss2", "b.example.com")
+	changed, err := ParseWithPrevious([]byte(cfg), previous)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.False(t, previous.Providers["nodes"] == changed.Providers["nodes"])
+	if proxies := changed.Providers["nodes"].Proxies(); assert.Len(t, proxies, 1) {
+		assert.Equal(t, "ss2", proxies[0].Name())
+	}
+	assert.False(t, previous.RuleProviders["blocked"] == changed.RuleProviders["blocked"])
+	assert.True(t, (*changed.RuleProviders["blocked"]).Search(&C.Metadata{Host: "b.example.com", AddrType: C.AtypDomainName}))
+}
@@ -1,11 +1,17 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io/ioutil"
 	"strings"
 
 	"github.com/Dreamacro/clash/adapter/outboundgroup"
 	"github.com/Dreamacro/clash/common/structure"
+	C "github.com/Dreamacro/clash/constant"
+	providerTypes "github.com/Dreamacro/clash/constant/provider"
+	ruleProvider "github.com/Dreamacro/clash/rule/provider"
 )
 
 func trimArr(arr []string) (r []string) {
@@ -146,3 +152,78 @@ func proxyGroupsDagSort(groupsConfig []map[string]interface{}) error {
 	}
 	return fmt.Errorf("loop is detected in ProxyGroup, please check following ProxyGroups: %v", loopElements)
 }
+
+// fileKeys are the options naming a file read when the proxy is parsed, like
+// the ca of vless
+var fileKeys = []string{"ca"}
+
+// fingerprint tells apart what a proxy or provider is parsed from, fmt
+// prints maps sorted by key. The files of fileKeys and the path of a file
+// provider are in by their content, so an edited one isn't missed.
+func fingerprint(mapping map[string]interface{}) string {
+	fp := fmt.Sprintf("%#v", mapping)
+	keys := fileKeys
+	if tp, _ := mapping["type"].(string); tp == "file" {
+		keys = append([]string{"path"}, keys...)
+	}
+	for _, key := range keys {
+		if path, ok := mapping[key].(string); ok && path != "" {
+			fp += fmt.Sprintf("\n%s file: %s", key, fileFingerprint(path))
+		}
+	}
+	return fp
+}
+
+// fileFingerprint is the hash of the file at path, an unreadable one never
+// matches a readable one and fails the parse again
+func fileFingerprint(path string) string {
+	buf, err := ioutil.ReadFile(C.Path.Resolve(path))
+	if err != nil {
+		return err.Error()
+	}
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:])
+}
+
+// groupFingerprint covers the proxies and providers a group uses, so a
+// group of changed proxies is parsed again. fps holds those already.
+func groupFingerprint(mapping map[string]interface{}, fps *fingerprints) string {
+	fp := &strings.Builder{}
+	fp.WriteString(fingerprint(mapping))
+	for _, ref := range []struct {
+		key   string
+		names map[string]string
+	}{{"proxies", fps.proxies}, {"use", fps.providers}} {
+		list, _ := mapping[ref.key].([]interface{})
+		for _, name := range list {
+			if name, ok := name.(string); ok {
+				fmt.Fprintf(fp, "\n%s %s: %s", ref.key, name, ref.names[name])
+			}
+		}
+	}
+	return fp.String()
+}
+
+func (c *Config) reuseProxy(name, fp string) (C.Proxy, bool) {
+	if c == nil || name == "" || c.fingerprints.proxies[name] != fp {
+		return nil, false
+	}
+	proxy, ok := c.Proxies[name]
+	return proxy, ok
+}
+
+func (c *Config) reuseProvider(name, fp string) (providerTypes.ProxyProvider, bool) {
+	if c == nil || c.fingerprints.providers[name] != fp {
+		return nil, false
+	}
+	pd, ok := c.Providers[name]
+	return pd, ok
+}
+
+func (c *Config) reuseRuleProvider(name, fp string) (*ruleProvider.RuleProvider, bool) {
+	if c == nil || c.fingerprints.ruleProviders[name] != fp {
+		return nil, false
+	}
+	rp, ok := c.RuleProviders[name]
+	return rp, ok
+}
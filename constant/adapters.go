@@ -21,6 +21,7 @@ const (
 	Vmess
 	Vless
 	Trojan
+	Hysteria2
 
 	Relay
 	Selector
@@ -98,6 +99,9 @@ type ProxyAdapter interface {
 	Unwrap(metadata *Metadata) Proxy
 }
 
+// MaxDelayHistory is how many delay records a proxy keeps
+const MaxDelayHistory = 10
+
 type DelayHistory struct {
 	Time  time.Time `json:"time"`
 	Delay uint16    `json:"delay"`
@@ -140,6 +144,8 @@ func (at AdapterType) String() string {
 		return "Vless"
 	case Trojan:
 		return "Trojan"
+	case Hysteria2:
+		return "Hysteria2"
 
 	case Relay:
 		return "Relay"
@@ -22,6 +22,7 @@ const (
 	SOCKS5
 	REDIR
 	TPROXY
+	TUN
 )
 
 type NetWork int
@@ -55,6 +56,8 @@ func (t Type) String() string {
 		return "Redir"
 	case TPROXY:
 		return "TProxy"
+	case TUN:
+		return "Tun"
 	default:
 		return "Unknown"
 	}
@@ -74,6 +77,9 @@ type Metadata struct {
 	DstPort  string  `json:"destinationPort"`
 	AddrType int     `json:"-"`
 	Host     string  `json:"host"`
+	// ProcessPath is the executable of the source, found by the first
+	// process rule matched against
+	ProcessPath string `json:"processPath"`
 }
 
 func (m *Metadata) RemoteAddress() string {
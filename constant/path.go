@@ -55,6 +55,10 @@ func (p *path) MMDB() string {
 	return P.Join(p.homeDir, "Country.mmdb")
 }
 
+func (p *path) GeoSite() string {
+	return P.Join(p.homeDir, "GeoSite.dat")
+}
+
 func (p *path) Cache() string {
 	return P.Join(p.homeDir, ".cache")
 }
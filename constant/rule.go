@@ -13,6 +13,8 @@ const (
 	Process
 	MATCH
 	RuleSet
+	GEOSITE
+	ProcessPath
 )
 
 type RuleType int
@@ -41,6 +43,10 @@ func (rt RuleType) String() string {
 		return "Match"
 	case RuleSet:
 		return "RuleSet"
+	case GEOSITE:
+		return "GeoSite"
+	case ProcessPath:
+		return "ProcessPath"
 	default:
 		return "Unknown"
 	}
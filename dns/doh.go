@@ -3,13 +3,18 @@ package dns
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"encoding/base64"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
 
 	"github.com/Dreamacro/clash/component/dialer"
 	"github.com/Dreamacro/clash/component/resolver"
 
+	"github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/http3"
 	D "github.com/miekg/dns"
 )
 
@@ -20,7 +25,10 @@ const (
 
 type dohClient struct {
 	url       string
-	transport *http.Transport
+	transport http.RoundTripper
+	// over HTTP/3 queries are GETs that may go in 0-RTT, a replayed
+	// query is harmless
+	http3 bool
 }
 
 func (dc *dohClient) Exchange(m *D.Msg) (msg *D.Msg, err error) {
@@ -44,12 +52,28 @@ func (dc *dohClient) newRequest(m *D.Msg) (*http.Request, error) {
 		return nil, err
 	}
 
-	req, err := http.NewRequest(http.MethodPost, dc.url, bytes.NewReader(buf))
-	if err != nil {
-		return req, err
+	var req *http.Request
+	if dc.http3 {
+		u, err := url.Parse(dc.url)
+		if err != nil {
+			return nil, err
+		}
+		query := u.Query()
+		query.Set("dns", base64.RawURLEncoding.EncodeToString(buf))
+		u.RawQuery = query.Encode()
+
+		req, err = http.NewRequest(http3.MethodGet0RTT, u.String(), nil)
+		if err != nil {
+			return req, err
+		}
+	} else {
+		req, err = http.NewRequest(http.MethodPost, dc.url, bytes.NewReader(buf))
+		if err != nil {
+			return req, err
+		}
+		req.Header.Set("content-type", dotMimeType)
 	}
 
-	req.Header.Set("content-type", dotMimeType)
 	req.Header.Set("accept", dotMimeType)
 	return req, nil
 }
@@ -58,6 +82,10 @@ func (dc *dohClient) doRequest(req *http.Request) (msg *D.Msg, err error) {
 	client := &http.Client{Transport: dc.transport}
 	resp, err := client.Do(req)
 	if err != nil {
+		if rt, ok := dc.transport.(*http3.RoundTripper); ok && req.Context().Err() == nil {
+			// http3 keeps a dead session for good, drop it to dial again
+			rt.Close()
+		}
 		return nil, err
 	}
 	defer resp.Body.Close()
@@ -92,3 +120,18 @@ func newDoHClient(url string, r *Resolver) *dohClient {
 		},
 	}
 }
+
+func newDoH3Client(url string, r *Resolver) *dohClient {
+	return &dohClient{
+		url:   url,
+		http3: true,
+		transport: &http3.RoundTripper{
+			TLSClientConfig: &tls.Config{
+				ClientSessionCache: tls.NewLRUClientSessionCache(0),
+			},
+			Dial: func(network, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlySession, error) {
+				return dialQUIC(context.Background(), addr, r, tlsCfg, cfg)
+			},
+		},
+	}
+}
@@ -0,0 +1,95 @@
+package dns
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/http3"
+	D "github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/atomic"
+)
+
+func TestDoHClient_NewRequest(t *testing.T) {
+	m := newTestQuery(1234)
+	packed, err := m.Pack()
+	assert.Nil(t, err)
+
+	dc := newDoHClient("https://dns.example.com/dns-query", nil)
+	req, err := dc.newRequest(m)
+	assert.Nil(t, err)
+	assert.Equal(t, http.MethodPost, req.Method)
+	assert.Equal(t, dotMimeType, req.Header.Get("content-type"))
+	assert.Equal(t, dotMimeType, req.Header.Get("accept"))
+	body, err := io.ReadAll(req.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, packed, body)
+
+	// over HTTP/3 the query goes in the url of a 0-RTT GET
+	dc = newDoH3Client("https://dns.example.com/dns-query?ct=1", nil)
+	req, err = dc.newRequest(m)
+	assert.Nil(t, err)
+	assert.Equal(t, http3.MethodGet0RTT, req.Method)
+	assert.Equal(t, "/dns-query", req.URL.Path)
+	assert.Equal(t, "1", req.URL.Query().Get("ct"))
+	assert.Equal(t, base64.RawURLEncoding.EncodeToString(packed), req.URL.Query().Get("dns"))
+	assert.Equal(t, dotMimeType, req.Header.Get("accept"))
+	assert.Nil(t, req.Body)
+}
+
+func TestDoHClient_HTTP3(t *testing.T) {
+	cert, pool := newTestCert(t)
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if !assert.Nil(t, err) {
+		return
+	}
+	server := &http3.Server{Server: &http.Server{
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, http.MethodGet, r.Method)
+			buf, err := base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+			assert.Nil(t, err)
+			query := &D.Msg{}
+			assert.Nil(t, query.Unpack(buf))
+
+			reply, _ := (&D.Msg{}).SetReply(query).Pack()
+			w.Header().Set("content-type", dotMimeType)
+			w.Write(reply)
+		}),
+	}}
+	go server.Serve(pc)
+	defer server.Close()
+
+	dc := newDoH3Client("https://"+pc.LocalAddr().String()+"/dns-query", nil)
+	rt := dc.transport.(*http3.RoundTripper)
+	rt.TLSClientConfig.RootCAs = pool
+
+	reply, err := dc.Exchange(newTestQuery(1234))
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(1234), reply.Id)
+	rt.Close()
+}
+
+func TestDoHClient_DropDeadSession(t *testing.T) {
+	errDial := errors.New("dial failed")
+	dials := atomic.NewInt32(0)
+	dc := newDoH3Client("https://127.0.0.1:1/dns-query", nil)
+	dc.transport.(*http3.RoundTripper).Dial = func(network, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlySession, error) {
+		dials.Inc()
+		return nil, errDial
+	}
+
+	// http3 keeps the failed client, the query after has to dial again
+	for i := 1; i <= 2; i++ {
+		_, err := dc.Exchange(newTestQuery(1234))
+		assert.ErrorIs(t, err, errDial)
+		assert.Equal(t, int32(i), dials.Load())
+	}
+}
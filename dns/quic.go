@@ -4,23 +4,55 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"net"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/Dreamacro/clash/component/dialer"
+	"github.com/Dreamacro/clash/component/resolver"
 	"github.com/Dreamacro/clash/log"
+
 	"github.com/lucas-clemente/quic-go"
 	D "github.com/miekg/dns"
 )
 
-const NextProtoDQ = "doq-i00"
+const (
+	// NextProtoDQ is draft-ietf-dprive-dnsoquic-00, messages go as is
+	NextProtoDQ = "doq-i00"
+	// nextProtoDoQ is RFC 9250, messages have a 2-byte length prefix
+	nextProtoDoQ = "doq"
+)
 
 var bytesPool = sync.Pool{New: func() interface{} { return &bytes.Buffer{} }}
 
 type quicClient struct {
-	addr         string
-	session      quic.Session
-	sync.RWMutex // protects session and bytesPool
+	addr string
+	r    *Resolver
+	// tlsConfig is cloned for every session, they share its session cache
+	tlsConfig *tls.Config
+	session   quic.EarlySession
+	// protocol the last handshake negotiated. A resumed session sends in
+	// 0-RTT before its handshake is done, with the framing of the session
+	// the ticket came from.
+	protocol     string
+	sync.RWMutex // protects session and protocol
+}
+
+func newQuicClient(addr string, r *Resolver) *quicClient {
+	return &quicClient{
+		addr: addr,
+		r:    r,
+		tlsConfig: &tls.Config{
+			NextProtos: []string{
+				nextProtoDoQ, NextProtoDQ, "http/1.1", "h2",
+			},
+			ClientSessionCache: tls.NewLRUClientSessionCache(0),
+		},
+	}
 }
 
 func (dc *quicClient) Exchange(m *D.Msg) (msg *D.Msg, err error) {
@@ -28,12 +60,15 @@ func (dc *quicClient) Exchange(m *D.Msg) (msg *D.Msg, err error) {
 }
 
 func (dc *quicClient) ExchangeContext(ctx context.Context, m *D.Msg) (msg *D.Msg, err error) {
-	stream, err := dc.openStream(ctx)
+	stream, protocol, err := dc.openStream(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open new stream to %s", dc.addr)
+		return nil, fmt.Errorf("failed to open new stream to %s: %w", dc.addr, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = stream.SetDeadline(deadline)
 	}
 
-	buf, err := m.Pack()
+	buf, err := packQuicMsg(m, protocol)
 	if err != nil {
 		return nil, err
 	}
@@ -58,12 +93,40 @@ func (dc *quicClient) ExchangeContext(ctx context.Context, m *D.Msg) (msg *D.Msg
 		return nil, err
 	}
 
-	reply := new(D.Msg)
-	err = reply.Unpack(respBuf.Bytes())
+	return unpackQuicMsg(respBuf.Bytes(), protocol, m.Id)
+}
+
+// packQuicMsg packs m the way protocol frames it. RFC 9250 wants the message
+// id to be 0 and a 2-byte length prefix.
+func packQuicMsg(m *D.Msg, protocol string) ([]byte, error) {
+	if protocol != nextProtoDoQ {
+		return m.Pack()
+	}
+
+	query := m.Copy()
+	query.Id = 0
+	buf, err := query.Pack()
 	if err != nil {
 		return nil, err
 	}
+	return append([]byte{byte(len(buf) >> 8), byte(len(buf))}, buf...), nil
+}
+
+// unpackQuicMsg unpacks the reply b framed by protocol, with the id of the
+// query it answers
+func unpackQuicMsg(b []byte, protocol string, id uint16) (*D.Msg, error) {
+	if protocol == nextProtoDoQ {
+		if len(b) < 2 || int(binary.BigEndian.Uint16(b)) != len(b)-2 {
+			return nil, errors.New("invalid DoQ response length")
+		}
+		b = b[2:]
+	}
 
+	reply := new(D.Msg)
+	if err := reply.Unpack(b); err != nil {
+		return nil, err
+	}
+	reply.Id = id
 	return reply, nil
 }
 
@@ -76,57 +139,72 @@ func isActive(s quic.Session) bool {
 	}
 }
 
-// getSession - opens or returns an existing quic.Session
-// useCached - if true and cached session exists, return it right away
-// otherwise - forcibly creates a new session
-func (dc *quicClient) getSession() (quic.Session, error) {
-	var session quic.Session
+// getSession returns the cached session while it's alive, or opens a new
+// one, with the protocol to frame messages by
+func (dc *quicClient) getSession(ctx context.Context) (quic.EarlySession, string, error) {
 	dc.RLock()
-	session = dc.session
+	session, protocol := dc.session, dc.protocol
+	dc.RUnlock()
 	if session != nil && isActive(session) {
-		dc.RUnlock()
-		return session, nil
-	}
-	if session != nil {
-		// we're recreating the session, let's create a new one
-		_ = session.CloseWithError(0, "")
+		return session, protocol, nil
 	}
-	dc.RUnlock()
 
 	dc.Lock()
 	defer dc.Unlock()
 
-	var err error
-	session, err = dc.openSession()
+	// another query may have opened one meanwhile
+	if dc.session != nil {
+		if isActive(dc.session) {
+			return dc.session, dc.protocol, nil
+		}
+		// we're recreating the session, let's create a new one
+		_ = dc.session.CloseWithError(0, "")
+		dc.session = nil
+	}
+
+	session, err := dc.openSession(ctx)
 	if err != nil {
 		// This does not look too nice, but QUIC (or maybe quic-go)
 		// doesn't seem stable enough.
 		// Maybe retransmissions aren't fully implemented in quic-go?
 		// Anyways, the simple solution is to make a second try when
 		// it fails to open the QUIC session.
-		session, err = dc.openSession()
+		session, err = dc.openSession(ctx)
 		if err != nil {
-			return nil, err
+			return nil, "", err
+		}
+	}
+
+	// without an earlier handshake to go by, wait for this one
+	if dc.protocol == "" {
+		select {
+		case <-session.HandshakeComplete().Done():
+		case <-session.Context().Done():
+			return nil, "", errors.New("QUIC session closed during handshake")
+		case <-ctx.Done():
+			_ = session.CloseWithError(0, "")
+			return nil, "", ctx.Err()
 		}
 	}
+	select {
+	case <-session.HandshakeComplete().Done():
+		dc.protocol = session.ConnectionState().TLS.NegotiatedProtocol
+	default:
+	}
+
 	dc.session = session
-	return session, nil
+	return session, dc.protocol, nil
 }
 
-func (dc *quicClient) openSession() (quic.Session, error) {
-	tlsConfig := &tls.Config{
-		NextProtos: []string{
-			"http/1.1", "h2", NextProtoDQ,
-		},
-		SessionTicketsDisabled: false,
-	}
+func (dc *quicClient) openSession(ctx context.Context) (quic.EarlySession, error) {
+	tlsConfig := dc.tlsConfig.Clone()
 	quicConfig := &quic.Config{
 		ConnectionIDLength:   12,
 		HandshakeIdleTimeout: time.Second * 8,
 	}
 
 	log.Debugln("opening session to %s", dc.addr)
-	session, err := quic.DialAddrContext(context.Background(), dc.addr, tlsConfig, quicConfig)
+	session, err := dialQUIC(ctx, dc.addr, dc.r, tlsConfig, quicConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open QUIC session: %w", err)
 	}
@@ -134,12 +212,57 @@ func (dc *quicClient) openSession() (quic.Session, error) {
 	return session, nil
 }
 
-func (dc *quicClient) openStream(ctx context.Context) (quic.Stream, error) {
-	session, err := dc.getSession()
+func (dc *quicClient) openStream(ctx context.Context) (quic.Stream, string, error) {
+	session, protocol, err := dc.getSession(ctx)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	// open a new stream
-	return session.OpenStreamSync(ctx)
+	stream, err := session.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	return stream, protocol, nil
+}
+
+// dialQUIC opens a session to addr, taking 0-RTT if tlsConfig has a ticket
+// for it. The host of addr is resolved with r, without one it must be an ip.
+func dialQUIC(ctx context.Context, addr string, r *Resolver, tlsConfig *tls.Config, quicConfig *quic.Config) (quic.EarlySession, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	portNum, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %s", port)
+	}
+
+	var ip net.IP
+	if r == nil {
+		// a default ip dns
+		if ip = net.ParseIP(host); ip == nil {
+			return nil, fmt.Errorf("default dns %s is not an ip", host)
+		}
+	} else if ip, err = resolver.ResolveIPWithResolver(host, r); err != nil {
+		return nil, fmt.Errorf("use default dns resolve failed: %w", err)
+	}
+
+	pc, err := dialer.ListenPacket("udp", "")
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := quic.DialEarlyContext(ctx, pc, &net.UDPAddr{IP: ip, Port: int(portNum)}, host, tlsConfig, quicConfig)
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+
+	// quic-go leaves a conn it didn't open to the caller
+	go func() {
+		<-session.Context().Done()
+		pc.Close()
+	}()
+	return session, nil
 }
@@ -0,0 +1,159 @@
+package dns
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+	D "github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestCert returns a self-signed certificate of 127.0.0.1 and the pool
+// trusting it
+func newTestCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+func newTestQuery(id uint16) *D.Msg {
+	m := &D.Msg{}
+	m.SetQuestion("example.com.", D.TypeA)
+	m.Id = id
+	return m
+}
+
+func TestPackQuicMsg(t *testing.T) {
+	m := newTestQuery(1234)
+
+	buf, err := packQuicMsg(m, nextProtoDoQ)
+	assert.Nil(t, err)
+	assert.Equal(t, len(buf)-2, int(binary.BigEndian.Uint16(buf)))
+	assert.Equal(t, uint16(0), binary.BigEndian.Uint16(buf[2:]))
+	assert.Equal(t, uint16(1234), m.Id)
+
+	reply, err := unpackQuicMsg(buf, nextProtoDoQ, m.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(1234), reply.Id)
+	assert.Equal(t, m.Question, reply.Question)
+
+	_, err = unpackQuicMsg(buf[:len(buf)-1], nextProtoDoQ, m.Id)
+	assert.NotNil(t, err)
+	_, err = unpackQuicMsg(buf[:1], nextProtoDoQ, m.Id)
+	assert.NotNil(t, err)
+
+	// the draft sends messages as is
+	buf, err = packQuicMsg(m, NextProtoDQ)
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(1234), binary.BigEndian.Uint16(buf))
+	reply, err = unpackQuicMsg(buf, NextProtoDQ, m.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, m.Question, reply.Question)
+}
+
+// serveQuic answers the queries on the sessions l accepts with 127.0.0.1,
+// framed by the protocol each negotiated
+func serveQuic(t *testing.T, l quic.Listener) {
+	for {
+		session, err := l.Accept(context.Background())
+		if err != nil {
+			return
+		}
+		go func() {
+			protocol := session.ConnectionState().TLS.NegotiatedProtocol
+			for {
+				stream, err := session.AcceptStream(context.Background())
+				if err != nil {
+					return
+				}
+
+				buf, _ := io.ReadAll(stream)
+				if protocol == nextProtoDoQ {
+					assert.Equal(t, len(buf)-2, int(binary.BigEndian.Uint16(buf)))
+					buf = buf[2:]
+					assert.Equal(t, uint16(0), binary.BigEndian.Uint16(buf))
+				}
+				query := &D.Msg{}
+				if !assert.Nil(t, query.Unpack(buf)) {
+					stream.Close()
+					continue
+				}
+
+				reply := (&D.Msg{}).SetReply(query)
+				reply.Answer = []D.RR{&D.A{
+					Hdr: D.RR_Header{Name: "example.com.", Rrtype: D.TypeA, Class: D.ClassINET, Ttl: 60},
+					A:   net.IPv4(127, 0, 0, 1),
+				}}
+				b, _ := packQuicMsg(reply, protocol)
+				stream.Write(b)
+				stream.Close()
+			}
+		}()
+	}
+}
+
+func TestQuicClient_Exchange(t *testing.T) {
+	cert, pool := newTestCert(t)
+
+	for _, protocol := range []string{nextProtoDoQ, NextProtoDQ} {
+		l, err := quic.ListenAddr("127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: []string{protocol}}, nil)
+		if !assert.Nil(t, err) {
+			return
+		}
+		go serveQuic(t, l)
+
+		dc := newQuicClient(l.Addr().String(), nil)
+		dc.tlsConfig.RootCAs = pool
+
+		// the second query goes on the session of the first
+		for _, id := range []uint16{1234, 4321} {
+			reply, err := dc.Exchange(newTestQuery(id))
+			if !assert.Nil(t, err, protocol) {
+				break
+			}
+			assert.Equal(t, id, reply.Id, protocol)
+			assert.Len(t, reply.Answer, 1, protocol)
+		}
+		assert.Equal(t, protocol, dc.protocol)
+
+		// a dead session is opened again
+		dc.session.CloseWithError(0, "")
+		<-dc.session.Context().Done()
+		_, err = dc.Exchange(newTestQuery(1))
+		assert.Nil(t, err, protocol)
+
+		dc.session.CloseWithError(0, "")
+		l.Close()
+	}
+}
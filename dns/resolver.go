@@ -14,6 +14,7 @@ import (
 	"github.com/Dreamacro/clash/component/fakeip"
 	"github.com/Dreamacro/clash/component/resolver"
 	"github.com/Dreamacro/clash/component/trie"
+	"github.com/Dreamacro/clash/tunnel/statistic"
 
 	D "github.com/miekg/dns"
 	"golang.org/x/sync/singleflight"
@@ -112,10 +113,13 @@ func (r *Resolver) exchangeWithoutCache(m *D.Msg) (msg *D.Msg, err error) {
 	q := m.Question[0]
 
 	ret, err, shared := r.group.Do(q.String(), func() (result interface{}, err error) {
+		start := time.Now()
 		defer func() {
 			if err != nil {
+				statistic.DefaultManager.ObserveDNSQuery("failure", time.Since(start))
 				return
 			}
+			statistic.DefaultManager.ObserveDNSQuery("success", time.Since(start))
 
 			msg := result.(*D.Msg)
 
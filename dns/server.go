@@ -47,6 +47,17 @@ func (s *Server) setHandler(handler handler) {
 	s.handler = handler
 }
 
+// ServeMsg answers msg as the dns server does, also when it doesn't listen.
+// It fails while the dns section is disabled.
+func ServeMsg(msg *D.Msg) (*D.Msg, error) {
+	handler := server.handler
+	if handler == nil {
+		return nil, errors.New("dns is disabled")
+	}
+
+	return handlerWithContext(handler, msg)
+}
+
 func ReCreateServer(addr string, resolver *Resolver, mapper *ResolverEnhancer) error {
 	if addr == address && resolver != nil {
 		handler := newHandler(resolver, mapper)
@@ -56,9 +67,12 @@ func ReCreateServer(addr string, resolver *Resolver, mapper *ResolverEnhancer) e
 
 	if server.Server != nil {
 		server.Shutdown()
-		server = &Server{}
 		address = ""
 	}
+	server = &Server{}
+	if resolver != nil {
+		server.setHandler(newHandler(resolver, mapper))
+	}
 
 	_, port, err := net.SplitHostPort(addr)
 	if port == "0" || port == "" || err != nil {
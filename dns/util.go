@@ -122,8 +122,13 @@ func transform(servers []NameServer, resolver *Resolver) []dnsClient {
 			continue
 		}
 
+		if s.Net == "h3" {
+			ret = append(ret, newDoH3Client(s.Addr, resolver))
+			continue
+		}
+
 		if s.Net == "quic" {
-			ret = append(ret, &quicClient{addr: s.Addr})
+			ret = append(ret, newQuicClient(s.Addr, resolver))
 			continue
 		}
 
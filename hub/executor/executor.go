@@ -2,6 +2,7 @@ package executor
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"sync"
@@ -13,6 +14,7 @@ import (
 	"github.com/Dreamacro/clash/component/profile"
 	"github.com/Dreamacro/clash/component/profile/cachefile"
 	"github.com/Dreamacro/clash/component/resolver"
+	"github.com/Dreamacro/clash/component/sniffer"
 	"github.com/Dreamacro/clash/component/trie"
 	"github.com/Dreamacro/clash/config"
 	C "github.com/Dreamacro/clash/constant"
@@ -20,13 +22,18 @@ import (
 	"github.com/Dreamacro/clash/dns"
 	P "github.com/Dreamacro/clash/listener"
 	authStore "github.com/Dreamacro/clash/listener/auth"
+	"github.com/Dreamacro/clash/listener/tun"
 	"github.com/Dreamacro/clash/log"
 	ruleProvider "github.com/Dreamacro/clash/rule/provider"
 	"github.com/Dreamacro/clash/tunnel"
+	"github.com/Dreamacro/clash/tunnel/statistic"
 )
 
 var (
 	mux sync.Mutex
+
+	// the config applied last, a reparsed config keeps what it shares
+	current *config.Config
 )
 
 func readConfig(path string) ([]byte, error) {
@@ -65,19 +72,50 @@ func ParseWithBytes(buf []byte) (*config.Config, error) {
 	return config.Parse(buf)
 }
 
-// ApplyConfig dispatch configure to all parts
+// ReparseWithPath parse config with custom config path, keeping the parts
+// of the config applied last that are unchanged
+func ReparseWithPath(path string) (*config.Config, error) {
+	buf, err := readConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ReparseWithBytes(buf)
+}
+
+// ReparseWithBytes config with buffer, keeping the parts of the config
+// applied last that are unchanged
+func ReparseWithBytes(buf []byte) (*config.Config, error) {
+	mux.Lock()
+	previous := current
+	mux.Unlock()
+
+	return config.ParseWithPrevious(buf, previous)
+}
+
+// ApplyConfig dispatch configure to all parts. Connections through proxies
+// the config keeps go on, force closes every connection.
 func ApplyConfig(cfg *config.Config, force bool) {
 	mux.Lock()
 	defer mux.Unlock()
 
+	previous := current
+	current = cfg
+
 	updateUsers(cfg.Users)
 	updateProxies(cfg.Proxies, cfg.Providers)
 	updateRules(cfg.Rules, cfg.RuleProviders)
 	updateHosts(cfg.Hosts)
 	updateProfile(cfg)
-	updateGeneral(cfg.General, force)
+	updateGeneral(cfg.General)
+	updateListeners(cfg.Listeners)
+	updateTun(cfg.Tun, cfg.General)
 	updateDNS(cfg.DNS)
+	updateSniffer(cfg.Sniffer)
 	updateExperimental(cfg)
+
+	closeConnections(previous, cfg, force)
+	closeProxies(previous, cfg)
 }
 
 func GetGeneral() *config.General {
@@ -98,9 +136,10 @@ func GetGeneral() *config.General {
 			AllowLan:       P.AllowLan(),
 			BindAddress:    P.BindAddress(),
 		},
-		Mode:     tunnel.Mode(),
-		LogLevel: log.Level(),
-		IPv6:     !resolver.DisableIPv6,
+		Mode:      tunnel.Mode(),
+		LogLevel:  log.Level(),
+		IPv6:      !resolver.DisableIPv6,
+		IPVersion: dialer.DefaultIPVersion,
 	}
 
 	return general
@@ -108,6 +147,10 @@ func GetGeneral() *config.General {
 
 func updateExperimental(c *config.Config) {}
 
+func updateSniffer(dispatcher *sniffer.Dispatcher) {
+	tunnel.UpdateSniffer(dispatcher)
+}
+
 func updateDNS(c *config.DNS) {
 	if !c.Enable {
 		resolver.DefaultResolver = nil
@@ -166,10 +209,12 @@ func updateRules(rules []C.Rule, ruleProviders map[string]*ruleProvider.RuleProv
 	tunnel.UpdateRules(rules, ruleProviders)
 }
 
-func updateGeneral(general *config.General, force bool) {
+func updateGeneral(general *config.General) {
 	log.SetLevel(general.LogLevel)
 	tunnel.SetMode(general.Mode)
 	resolver.DisableIPv6 = !general.IPv6
+	dialer.DefaultIPVersion = general.IPVersion
+	dialer.DefaultRoutingMark = general.RoutingMark
 
 	if general.Interface != "" {
 		dialer.DialHook = dialer.DialerWithInterface(general.Interface)
@@ -179,10 +224,7 @@ func updateGeneral(general *config.General, force bool) {
 		dialer.ListenPacketHook = nil
 	}
 
-	if !force {
-		return
-	}
-
+	// listeners on the same address are kept
 	allowLan := general.AllowLan
 	P.SetAllowLan(allowLan)
 
@@ -213,6 +255,31 @@ func updateGeneral(general *config.General, force bool) {
 	}
 }
 
+func updateListeners(listeners []*P.ListenerConfig) {
+	P.ReCreateListeners(listeners, tunnel.TCPIn(), tunnel.UDPIn())
+}
+
+func updateTun(c *tun.Config, general *config.General) {
+	if err := P.ReCreateTun(c, tunnel.TCPIn(), tunnel.UDPIn()); err != nil {
+		log.Errorln("Start TUN inbound error: %s", err.Error())
+		return
+	}
+
+	// the hooks were reset by updateGeneral
+	if c == nil || !c.AutoDetectInterface || general.Interface != "" {
+		return
+	}
+
+	iface, err := tun.DefaultInterface()
+	if err != nil {
+		log.Errorln("Auto detect interface error: %s", err.Error())
+		return
+	}
+	dialer.DialHook = dialer.DialerWithInterface(iface)
+	dialer.ListenPacketHook = dialer.ListenPacketWithInterface(iface)
+	log.Infoln("Outbound traffic is bound to interface: %s", iface)
+}
+
 func updateUsers(users []auth.AuthUser) {
 	authenticator := auth.NewAuthenticator(users)
 	authStore.SetAuthenticator(authenticator)
@@ -255,3 +322,62 @@ func patchSelectGroup(proxies map[string]C.Proxy) {
 		selector.Set(selected)
 	}
 }
+
+// closeConnections closes the connections through a proxy or group that
+// cfg dropped or parsed again, or all of them if force
+func closeConnections(previous, cfg *config.Config, force bool) {
+	if previous == nil && !force {
+		return
+	}
+
+	var old, kept map[string]C.Proxy
+	if !force {
+		old = allProxies(previous)
+		kept = allProxies(cfg)
+	}
+
+	for _, c := range statistic.DefaultManager.Snapshot().Connections {
+		stale := force
+		for _, name := range c.Chains() {
+			if stale {
+				break
+			}
+			stale = old[name] != kept[name]
+		}
+		if stale {
+			c.Close()
+		}
+	}
+}
+
+// closeProxies closes the proxies of previous that cfg dropped or parsed
+// again, after their connections
+func closeProxies(previous, cfg *config.Config) {
+	if previous == nil {
+		return
+	}
+
+	kept := allProxies(cfg)
+	for name, proxy := range allProxies(previous) {
+		if kept[name] == proxy {
+			continue
+		}
+		if closer, ok := proxy.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+}
+
+// allProxies returns the proxies of cfg, with those of the providers
+func allProxies(cfg *config.Config) map[string]C.Proxy {
+	proxies := map[string]C.Proxy{}
+	for _, pd := range cfg.Providers {
+		for _, proxy := range pd.Proxies() {
+			proxies[proxy.Name()] = proxy
+		}
+	}
+	for name, proxy := range cfg.Proxies {
+		proxies[name] = proxy
+	}
+	return proxies
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"path/filepath"
 
+	"github.com/Dreamacro/clash/component/dialer"
 	"github.com/Dreamacro/clash/component/resolver"
 	"github.com/Dreamacro/clash/config"
 	"github.com/Dreamacro/clash/constant"
@@ -35,6 +36,7 @@ type configSchema struct {
 	Mode        *tunnel.TunnelMode `json:"mode"`
 	LogLevel    *log.LogLevel      `json:"log-level"`
 	IPv6        *bool              `json:"ipv6"`
+	IPVersion   *dialer.IPVersion  `json:"ip-version"`
 }
 
 func getConfigs(w http.ResponseWriter, r *http.Request) {
@@ -89,6 +91,10 @@ func patchConfigs(w http.ResponseWriter, r *http.Request) {
 		resolver.DisableIPv6 = !*general.IPv6
 	}
 
+	if general.IPVersion != nil {
+		dialer.DefaultIPVersion = *general.IPVersion
+	}
+
 	render.NoContent(w, r)
 }
 
@@ -105,12 +111,18 @@ func updateConfigs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// without force the proxies, groups and providers that are configured
+	// the same are kept, with their connections
 	force := r.URL.Query().Get("force") == "true"
+	parseWithBytes, parseWithPath := executor.ReparseWithBytes, executor.ReparseWithPath
+	if force {
+		parseWithBytes, parseWithPath = executor.ParseWithBytes, executor.ParseWithPath
+	}
 	var cfg *config.Config
 	var err error
 
 	if req.Payload != "" {
-		cfg, err = executor.ParseWithBytes([]byte(req.Payload))
+		cfg, err = parseWithBytes([]byte(req.Payload))
 		if err != nil {
 			render.Status(r, http.StatusBadRequest)
 			render.JSON(w, r, newError(err.Error()))
@@ -126,7 +138,7 @@ func updateConfigs(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		cfg, err = executor.ParseWithPath(req.Path)
+		cfg, err = parseWithPath(req.Path)
 		if err != nil {
 			render.Status(r, http.StatusBadRequest)
 			render.JSON(w, r, newError(err.Error()))
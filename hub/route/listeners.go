@@ -0,0 +1,63 @@
+package route
+
+import (
+	"net/http"
+
+	"github.com/Dreamacro/clash/config"
+	P "github.com/Dreamacro/clash/listener"
+	"github.com/Dreamacro/clash/tunnel"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+// listeners added or removed here last until the config is reloaded, the
+// listeners section of the config takes over then
+func listenerRouter() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/", getListeners)
+	r.Put("/{name}", putListener)
+	r.Delete("/{name}", deleteListener)
+	return r
+}
+
+func getListeners(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, render.M{
+		"listeners": P.GetListeners(),
+	})
+}
+
+func putListener(w http.ResponseWriter, r *http.Request) {
+	raw := config.RawListener{}
+	if err := render.DecodeJSON(r.Body, &raw); err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, ErrBadRequest)
+		return
+	}
+	raw.Name = getEscapeParam(r, "name")
+
+	listener, err := config.ParseListener(raw)
+	if err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, newError(err.Error()))
+		return
+	}
+
+	if err := P.AddListener(listener, tunnel.TCPIn(), tunnel.UDPIn()); err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, newError(err.Error()))
+		return
+	}
+
+	render.NoContent(w, r)
+}
+
+func deleteListener(w http.ResponseWriter, r *http.Request) {
+	if !P.RemoveListener(getEscapeParam(r, "name")) {
+		render.Status(r, http.StatusNotFound)
+		render.JSON(w, r, ErrNotFound)
+		return
+	}
+
+	render.NoContent(w, r)
+}
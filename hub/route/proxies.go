@@ -1,13 +1,16 @@
 package route
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/Dreamacro/clash/adapter"
+	"github.com/Dreamacro/clash/adapter/outbound"
 	"github.com/Dreamacro/clash/adapter/outboundgroup"
 	"github.com/Dreamacro/clash/component/profile/cachefile"
 	C "github.com/Dreamacro/clash/constant"
@@ -15,6 +18,7 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/render"
+	"github.com/gorilla/websocket"
 )
 
 func proxyRouter() http.Handler {
@@ -25,6 +29,10 @@ func proxyRouter() http.Handler {
 		r.Use(parseProxyName, findProxyByName)
 		r.Get("/", getProxy)
 		r.Get("/delay", getProxyDelay)
+		r.Get("/option", getProxyOption)
+		r.Put("/server", updateProxyServer)
+		r.Get("/timing", getProxyTiming)
+		r.Get("/events", getProxyEvents)
 		r.Put("/", updateProxy)
 	})
 	return r
@@ -66,6 +74,128 @@ func getProxy(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, proxy)
 }
 
+func getProxyOption(w http.ResponseWriter, r *http.Request) {
+	proxy := r.Context().Value(CtxKeyProxy).(*adapter.Proxy)
+	vless, ok := proxy.ProxyAdapter.(*outbound.Vless)
+	if !ok {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, newError("Must be a Vless"))
+		return
+	}
+
+	render.JSON(w, r, vless.ResolvedOption())
+}
+
+func getProxyTiming(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	timeout, err := strconv.ParseInt(query.Get("timeout"), 10, 32)
+	if err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, ErrBadRequest)
+		return
+	}
+
+	proxy := r.Context().Value(CtxKeyProxy).(*adapter.Proxy)
+	vless, ok := proxy.ProxyAdapter.(*outbound.Vless)
+	if !ok {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, newError("Must be a Vless"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*time.Duration(timeout))
+	defer cancel()
+
+	timing, err := vless.DiagnoseDial(ctx, query.Get("url"))
+	resp := render.M{
+		"dns":       timing.DNS.Milliseconds(),
+		"connect":   timing.Connect.Milliseconds(),
+		"transport": timing.Transport.Milliseconds(),
+		"handshake": timing.Handshake.Milliseconds(),
+		"firstByte": timing.FirstByte.Milliseconds(),
+		"total":     timing.Total.Milliseconds(),
+	}
+	if err != nil {
+		resp["error"] = err.Error()
+	}
+	render.JSON(w, r, resp)
+}
+
+func getProxyEvents(w http.ResponseWriter, r *http.Request) {
+	proxy := r.Context().Value(CtxKeyProxy).(C.Proxy)
+
+	var wsConn *websocket.Conn
+	if websocket.IsWebSocketUpgrade(r) {
+		var err error
+		wsConn, err = upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+	}
+
+	if wsConn == nil {
+		w.Header().Set("Content-Type", "application/json")
+		render.Status(r, http.StatusOK)
+	}
+
+	sub := outbound.SubscribeConnEvents()
+	defer outbound.UnSubscribeConnEvents(sub)
+	buf := &bytes.Buffer{}
+	var err error
+	for elm := range sub {
+		event := elm.(*outbound.ConnEvent)
+		if event.Proxy != proxy.Name() {
+			continue
+		}
+
+		buf.Reset()
+		if err := json.NewEncoder(buf).Encode(event); err != nil {
+			break
+		}
+
+		if wsConn == nil {
+			_, err = w.Write(buf.Bytes())
+			w.(http.Flusher).Flush()
+		} else {
+			err = wsConn.WriteMessage(websocket.TextMessage, buf.Bytes())
+		}
+
+		if err != nil {
+			break
+		}
+	}
+}
+
+type UpdateServerRequest struct {
+	Server string `json:"server"`
+	Port   int    `json:"port"`
+}
+
+func updateProxyServer(w http.ResponseWriter, r *http.Request) {
+	req := UpdateServerRequest{}
+	if err := render.DecodeJSON(r.Body, &req); err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, ErrBadRequest)
+		return
+	}
+
+	proxy := r.Context().Value(CtxKeyProxy).(*adapter.Proxy)
+	vless, ok := proxy.ProxyAdapter.(*outbound.Vless)
+	if !ok {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, newError("Must be a Vless"))
+		return
+	}
+
+	if err := vless.UpdateServer(req.Server, req.Port); err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, newError(err.Error()))
+		return
+	}
+
+	render.NoContent(w, r)
+}
+
 type UpdateProxyRequest struct {
 	Name string `json:"name"`
 }
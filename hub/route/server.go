@@ -8,8 +8,10 @@ import (
 	"strings"
 	"time"
 
+	"github.com/Dreamacro/clash/adapter"
 	C "github.com/Dreamacro/clash/constant"
 	"github.com/Dreamacro/clash/log"
+	"github.com/Dreamacro/clash/tunnel"
 	"github.com/Dreamacro/clash/tunnel/statistic"
 
 	"github.com/go-chi/chi/v5"
@@ -65,10 +67,12 @@ func Start(addr string, secret string) {
 		r.Get("/logs", getLogs)
 		r.Get("/traffic", traffic)
 		r.Get("/version", version)
+		r.Get("/metrics", metrics)
 		r.Mount("/configs", configRouter())
 		r.Mount("/proxies", proxyRouter())
 		r.Mount("/rules", ruleRouter())
 		r.Mount("/connections", connectionRouter())
+		r.Mount("/listeners", listenerRouter())
 		r.Mount("/providers/proxies", proxyProviderRouter())
 		r.Mount("/providers/rules", ruleProviderRouter())
 	})
@@ -239,6 +243,44 @@ func getLogs(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func metrics(w http.ResponseWriter, r *http.Request) {
+	buf := &bytes.Buffer{}
+	if err := statistic.DefaultManager.WriteMetrics(buf, nodeMetrics()); err != nil {
+		render.Status(r, http.StatusInternalServerError)
+		render.JSON(w, r, newError(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(buf.Bytes())
+}
+
+// nodeMetrics returns the counters of the proxies keeping them, those of the
+// providers included
+func nodeMetrics() []statistic.NodeMetrics {
+	proxies := map[string]C.Proxy{}
+	for _, pd := range tunnel.Providers() {
+		for _, proxy := range pd.Proxies() {
+			proxies[proxy.Name()] = proxy
+		}
+	}
+	for name, proxy := range tunnel.Proxies() {
+		proxies[name] = proxy
+	}
+
+	nodes := []statistic.NodeMetrics{}
+	for _, proxy := range proxies {
+		p, ok := proxy.(*adapter.Proxy)
+		if !ok {
+			continue
+		}
+		if node, ok := p.ProxyAdapter.(interface{ Metrics() statistic.NodeMetrics }); ok {
+			nodes = append(nodes, node.Metrics())
+		}
+	}
+	return nodes
+}
+
 func version(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, render.M{"experimental": true, "version": C.Version})
 }
@@ -1,6 +1,8 @@
 package auth
 
 import (
+	"net"
+
 	"github.com/Dreamacro/clash/component/auth"
 )
 
@@ -15,3 +17,44 @@ func Authenticator() auth.Authenticator {
 func SetAuthenticator(au auth.Authenticator) {
 	authenticator = au
 }
+
+// Access is who a listener lets in. A nil Access lets in the users of the
+// global authenticator from anywhere.
+type Access struct {
+	// Authenticator checks the users, nil falls back to the global one
+	Authenticator auth.Authenticator
+	// Sources are the networks clients may come from, empty allows all
+	Sources []*net.IPNet
+}
+
+// Users returns the authenticator of the listener, nil lets in everyone
+func (a *Access) Users() auth.Authenticator {
+	if a == nil || a.Authenticator == nil {
+		return authenticator
+	}
+	return a.Authenticator
+}
+
+// Allow reports whether a client from addr may use the listener
+func (a *Access) Allow(addr net.Addr) bool {
+	if a == nil || len(a.Sources) == 0 {
+		return true
+	}
+
+	var ip net.IP
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		ip = addr.IP
+	case *net.UDPAddr:
+		ip = addr.IP
+	default:
+		return false
+	}
+
+	for _, ipnet := range a.Sources {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
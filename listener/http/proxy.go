@@ -9,12 +9,12 @@ import (
 	"github.com/Dreamacro/clash/adapter/inbound"
 	"github.com/Dreamacro/clash/common/cache"
 	N "github.com/Dreamacro/clash/common/net"
+	"github.com/Dreamacro/clash/component/auth"
 	C "github.com/Dreamacro/clash/constant"
-	authStore "github.com/Dreamacro/clash/listener/auth"
 	"github.com/Dreamacro/clash/log"
 )
 
-func HandleConn(c net.Conn, in chan<- C.ConnContext, cache *cache.Cache) {
+func HandleConn(c net.Conn, in chan<- C.ConnContext, cache *cache.Cache, authenticator auth.Authenticator) {
 	client := newClient(c.RemoteAddr(), in)
 	defer client.CloseIdleConnections()
 
@@ -36,7 +36,7 @@ func HandleConn(c net.Conn, in chan<- C.ConnContext, cache *cache.Cache) {
 		var resp *http.Response
 
 		if !trusted {
-			resp = authenticate(request, cache)
+			resp = authenticate(request, cache, authenticator)
 
 			trusted = resp == nil
 		}
@@ -95,8 +95,7 @@ func HandleConn(c net.Conn, in chan<- C.ConnContext, cache *cache.Cache) {
 	conn.Close()
 }
 
-func authenticate(request *http.Request, cache *cache.Cache) *http.Response {
-	authenticator := authStore.Authenticator()
+func authenticate(request *http.Request, cache *cache.Cache, authenticator auth.Authenticator) *http.Response {
 	if authenticator != nil {
 		credential := ParseBasicProxyAuthorization(request)
 		if credential == "" {
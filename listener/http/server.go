@@ -6,11 +6,13 @@ import (
 
 	"github.com/Dreamacro/clash/common/cache"
 	C "github.com/Dreamacro/clash/constant"
+	authStore "github.com/Dreamacro/clash/listener/auth"
 )
 
 type Listener struct {
 	listener net.Listener
 	addr     string
+	access   *authStore.Access
 	closed   bool
 }
 
@@ -35,6 +37,15 @@ func New(addr string, in chan<- C.ConnContext) (*Listener, error) {
 }
 
 func NewWithAuthenticate(addr string, in chan<- C.ConnContext, authenticate bool) (*Listener, error) {
+	return newListener(addr, in, authenticate, nil)
+}
+
+// NewWithAccess returns a listener that lets in whom access allows
+func NewWithAccess(addr string, in chan<- C.ConnContext, access *authStore.Access) (*Listener, error) {
+	return newListener(addr, in, true, access)
+}
+
+func newListener(addr string, in chan<- C.ConnContext, authenticate bool, access *authStore.Access) (*Listener, error) {
 	l, err := net.Listen("tcp", addr)
 	if err != nil {
 		return nil, err
@@ -48,6 +59,7 @@ func NewWithAuthenticate(addr string, in chan<- C.ConnContext, authenticate bool
 	hl := &Listener{
 		listener: l,
 		addr:     addr,
+		access:   access,
 	}
 	go func() {
 		for {
@@ -58,7 +70,11 @@ func NewWithAuthenticate(addr string, in chan<- C.ConnContext, authenticate bool
 				}
 				continue
 			}
-			go HandleConn(conn, in, c)
+			if !access.Allow(conn.RemoteAddr()) {
+				conn.Close()
+				continue
+			}
+			go HandleConn(conn, in, c, access.Users())
 		}
 	}()
 
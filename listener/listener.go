@@ -3,6 +3,7 @@ package proxy
 import (
 	"fmt"
 	"net"
+	"reflect"
 	"strconv"
 	"sync"
 
@@ -13,6 +14,7 @@ import (
 	"github.com/Dreamacro/clash/listener/redir"
 	"github.com/Dreamacro/clash/listener/socks"
 	"github.com/Dreamacro/clash/listener/tproxy"
+	"github.com/Dreamacro/clash/listener/tun"
 	"github.com/Dreamacro/clash/log"
 )
 
@@ -29,6 +31,7 @@ var (
 	tproxyUDPListener *tproxy.UDPListener
 	mixedListener     *mixed.Listener
 	mixedUDPLister    *socks.UDPListener
+	tunListener       *tun.Listener
 
 	// lock for recreate function
 	socksMux  sync.Mutex
@@ -36,6 +39,7 @@ var (
 	redirMux  sync.Mutex
 	tproxyMux sync.Mutex
 	mixedMux  sync.Mutex
+	tunMux    sync.Mutex
 )
 
 type Ports struct {
@@ -275,6 +279,34 @@ func ReCreateMixed(port int, tcpIn chan<- C.ConnContext, udpIn chan<- *inbound.P
 	return nil
 }
 
+// ReCreateTun runs the tun device of cfg, one configured the same as before
+// is kept
+func ReCreateTun(cfg *tun.Config, tcpIn chan<- C.ConnContext, udpIn chan<- *inbound.PacketAdapter) error {
+	tunMux.Lock()
+	defer tunMux.Unlock()
+
+	if tunListener != nil {
+		if reflect.DeepEqual(tunListener.Config(), cfg) {
+			return nil
+		}
+		tunListener.Close()
+		tunListener = nil
+	}
+
+	if cfg == nil || !cfg.Enable {
+		return nil
+	}
+
+	var err error
+	tunListener, err = tun.New(cfg, tcpIn, udpIn)
+	if err != nil {
+		return err
+	}
+
+	log.Infoln("TUN inbound listening at: %s", tunListener.Address())
+	return nil
+}
+
 // GetPorts return the ports of proxy servers
 func GetPorts() *Ports {
 	ports := &Ports{}
@@ -0,0 +1,194 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/Dreamacro/clash/adapter/inbound"
+	"github.com/Dreamacro/clash/component/auth"
+	C "github.com/Dreamacro/clash/constant"
+	authStore "github.com/Dreamacro/clash/listener/auth"
+	"github.com/Dreamacro/clash/listener/http"
+	"github.com/Dreamacro/clash/listener/mixed"
+	"github.com/Dreamacro/clash/listener/socks"
+	"github.com/Dreamacro/clash/log"
+)
+
+// ListenerConfig is an inbound of the listeners section, a socks, http or
+// mixed listener with an address and access of its own
+type ListenerConfig struct {
+	Name   string
+	Type   string
+	Listen string
+	// Users of the listener, none take the global authentication
+	Users []auth.AuthUser
+	// Sources are the networks clients may come from, none allow all
+	Sources []*net.IPNet
+}
+
+// ListenerStatus is a listener of the listeners section that is running
+type ListenerStatus struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	Listen   string   `json:"listen"`
+	Address  string   `json:"address"`
+	Users    []string `json:"users"`
+	AllowIPs []string `json:"allow-ips"`
+}
+
+type namedListener struct {
+	config *ListenerConfig
+	tcp    C.Listener
+	udp    C.Listener
+}
+
+func (l *namedListener) Close() {
+	l.tcp.Close()
+	if l.udp != nil {
+		l.udp.Close()
+	}
+}
+
+var (
+	namedListeners    = map[string]*namedListener{}
+	namedListenersMux sync.Mutex
+)
+
+// ValidListenerType reports whether tp is a type the listeners section takes
+func ValidListenerType(tp string) bool {
+	switch tp {
+	case "socks", "http", "mixed":
+		return true
+	default:
+		return false
+	}
+}
+
+// ReCreateListeners runs the listeners of configs and closes the others,
+// those configured the same as before are kept
+func ReCreateListeners(configs []*ListenerConfig, tcpIn chan<- C.ConnContext, udpIn chan<- *inbound.PacketAdapter) {
+	names := map[string]bool{}
+	for _, cfg := range configs {
+		names[cfg.Name] = true
+	}
+
+	namedListenersMux.Lock()
+	for name, l := range namedListeners {
+		if !names[name] {
+			l.Close()
+			delete(namedListeners, name)
+		}
+	}
+	namedListenersMux.Unlock()
+
+	for _, cfg := range configs {
+		if err := AddListener(cfg, tcpIn, udpIn); err != nil {
+			log.Errorln("Start listener %s error: %s", cfg.Name, err.Error())
+		}
+	}
+}
+
+// AddListener runs a listener, in place of the one of the same name
+func AddListener(cfg *ListenerConfig, tcpIn chan<- C.ConnContext, udpIn chan<- *inbound.PacketAdapter) error {
+	namedListenersMux.Lock()
+	defer namedListenersMux.Unlock()
+
+	if old, ok := namedListeners[cfg.Name]; ok {
+		if reflect.DeepEqual(old.config, cfg) {
+			return nil
+		}
+		// closed first, the new one may take the same address
+		old.Close()
+		delete(namedListeners, cfg.Name)
+	}
+
+	l, err := newNamedListener(cfg, tcpIn, udpIn)
+	if err != nil {
+		return err
+	}
+	namedListeners[cfg.Name] = l
+
+	log.Infoln("Listener %s(%s) listening at: %s", cfg.Name, cfg.Type, l.tcp.Address())
+	return nil
+}
+
+// RemoveListener closes the listener of the name, it reports whether there
+// was one
+func RemoveListener(name string) bool {
+	namedListenersMux.Lock()
+	defer namedListenersMux.Unlock()
+
+	l, ok := namedListeners[name]
+	if !ok {
+		return false
+	}
+	l.Close()
+	delete(namedListeners, name)
+	return true
+}
+
+// GetListeners returns the running listeners of the listeners section
+func GetListeners() []*ListenerStatus {
+	namedListenersMux.Lock()
+	defer namedListenersMux.Unlock()
+
+	statuses := make([]*ListenerStatus, 0, len(namedListeners))
+	for _, l := range namedListeners {
+		users := make([]string, 0, len(l.config.Users))
+		for _, user := range l.config.Users {
+			users = append(users, user.User)
+		}
+		sources := make([]string, 0, len(l.config.Sources))
+		for _, ipnet := range l.config.Sources {
+			sources = append(sources, ipnet.String())
+		}
+
+		statuses = append(statuses, &ListenerStatus{
+			Name:     l.config.Name,
+			Type:     l.config.Type,
+			Listen:   l.config.Listen,
+			Address:  l.tcp.Address(),
+			Users:    users,
+			AllowIPs: sources,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+func newNamedListener(cfg *ListenerConfig, tcpIn chan<- C.ConnContext, udpIn chan<- *inbound.PacketAdapter) (*namedListener, error) {
+	access := &authStore.Access{
+		Authenticator: auth.NewAuthenticator(cfg.Users),
+		Sources:       cfg.Sources,
+	}
+
+	l := &namedListener{config: cfg}
+	var err error
+	switch cfg.Type {
+	case "socks":
+		l.tcp, err = socks.NewWithAccess(cfg.Listen, tcpIn, access)
+	case "http":
+		l.tcp, err = http.NewWithAccess(cfg.Listen, tcpIn, access)
+	case "mixed":
+		l.tcp, err = mixed.NewWithAccess(cfg.Listen, tcpIn, access)
+	default:
+		return nil, fmt.Errorf("unsupported listener type: %s", cfg.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Type != "http" {
+		l.udp, err = socks.NewUDPWithAccess(cfg.Listen, udpIn, access)
+		if err != nil {
+			l.tcp.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
@@ -6,7 +6,9 @@ import (
 
 	"github.com/Dreamacro/clash/common/cache"
 	N "github.com/Dreamacro/clash/common/net"
+	"github.com/Dreamacro/clash/component/auth"
 	C "github.com/Dreamacro/clash/constant"
+	authStore "github.com/Dreamacro/clash/listener/auth"
 	"github.com/Dreamacro/clash/listener/http"
 	"github.com/Dreamacro/clash/listener/socks"
 	"github.com/Dreamacro/clash/transport/socks4"
@@ -17,6 +19,7 @@ type Listener struct {
 	listener net.Listener
 	addr     string
 	cache    *cache.Cache
+	access   *authStore.Access
 	closed   bool
 }
 
@@ -37,6 +40,11 @@ func (l *Listener) Close() error {
 }
 
 func New(addr string, in chan<- C.ConnContext) (*Listener, error) {
+	return NewWithAccess(addr, in, nil)
+}
+
+// NewWithAccess returns a listener that lets in whom access allows
+func NewWithAccess(addr string, in chan<- C.ConnContext, access *authStore.Access) (*Listener, error) {
 	l, err := net.Listen("tcp", addr)
 	if err != nil {
 		return nil, err
@@ -46,6 +54,7 @@ func New(addr string, in chan<- C.ConnContext) (*Listener, error) {
 		listener: l,
 		addr:     addr,
 		cache:    cache.New(30 * time.Second),
+		access:   access,
 	}
 	go func() {
 		for {
@@ -56,14 +65,18 @@ func New(addr string, in chan<- C.ConnContext) (*Listener, error) {
 				}
 				continue
 			}
-			go handleConn(c, in, ml.cache)
+			if !access.Allow(c.RemoteAddr()) {
+				c.Close()
+				continue
+			}
+			go handleConn(c, in, ml.cache, access.Users())
 		}
 	}()
 
 	return ml, nil
 }
 
-func handleConn(conn net.Conn, in chan<- C.ConnContext, cache *cache.Cache) {
+func handleConn(conn net.Conn, in chan<- C.ConnContext, cache *cache.Cache, authenticator auth.Authenticator) {
 	bufConn := N.NewBufferedConn(conn)
 	head, err := bufConn.Peek(1)
 	if err != nil {
@@ -72,10 +85,10 @@ func handleConn(conn net.Conn, in chan<- C.ConnContext, cache *cache.Cache) {
 
 	switch head[0] {
 	case socks4.Version:
-		socks.HandleSocks4(bufConn, in)
+		socks.HandleSocks4(bufConn, in, authenticator)
 	case socks5.Version:
-		socks.HandleSocks5(bufConn, in)
+		socks.HandleSocks5(bufConn, in, authenticator)
 	default:
-		http.HandleConn(bufConn, in, cache)
+		http.HandleConn(bufConn, in, cache, authenticator)
 	}
 }
@@ -7,6 +7,7 @@ import (
 
 	"github.com/Dreamacro/clash/adapter/inbound"
 	N "github.com/Dreamacro/clash/common/net"
+	"github.com/Dreamacro/clash/component/auth"
 	C "github.com/Dreamacro/clash/constant"
 	authStore "github.com/Dreamacro/clash/listener/auth"
 	"github.com/Dreamacro/clash/transport/socks4"
@@ -16,6 +17,7 @@ import (
 type Listener struct {
 	listener net.Listener
 	addr     string
+	access   *authStore.Access
 	closed   bool
 }
 
@@ -36,6 +38,11 @@ func (l *Listener) Close() error {
 }
 
 func New(addr string, in chan<- C.ConnContext) (*Listener, error) {
+	return NewWithAccess(addr, in, nil)
+}
+
+// NewWithAccess returns a listener that lets in whom access allows
+func NewWithAccess(addr string, in chan<- C.ConnContext, access *authStore.Access) (*Listener, error) {
 	l, err := net.Listen("tcp", addr)
 	if err != nil {
 		return nil, err
@@ -44,6 +51,7 @@ func New(addr string, in chan<- C.ConnContext) (*Listener, error) {
 	sl := &Listener{
 		listener: l,
 		addr:     addr,
+		access:   access,
 	}
 	go func() {
 		for {
@@ -54,14 +62,18 @@ func New(addr string, in chan<- C.ConnContext) (*Listener, error) {
 				}
 				continue
 			}
-			go handleSocks(c, in)
+			if !access.Allow(c.RemoteAddr()) {
+				c.Close()
+				continue
+			}
+			go handleSocks(c, in, access.Users())
 		}
 	}()
 
 	return sl, nil
 }
 
-func handleSocks(conn net.Conn, in chan<- C.ConnContext) {
+func handleSocks(conn net.Conn, in chan<- C.ConnContext, authenticator auth.Authenticator) {
 	bufConn := N.NewBufferedConn(conn)
 	head, err := bufConn.Peek(1)
 	if err != nil {
@@ -71,16 +83,16 @@ func handleSocks(conn net.Conn, in chan<- C.ConnContext) {
 
 	switch head[0] {
 	case socks4.Version:
-		HandleSocks4(bufConn, in)
+		HandleSocks4(bufConn, in, authenticator)
 	case socks5.Version:
-		HandleSocks5(bufConn, in)
+		HandleSocks5(bufConn, in, authenticator)
 	default:
 		conn.Close()
 	}
 }
 
-func HandleSocks4(conn net.Conn, in chan<- C.ConnContext) {
-	target, _, err := socks4.ServerHandshake(conn, authStore.Authenticator())
+func HandleSocks4(conn net.Conn, in chan<- C.ConnContext, authenticator auth.Authenticator) {
+	target, _, err := socks4.ServerHandshake(conn, authenticator)
 	if err != nil {
 		conn.Close()
 		return
@@ -95,8 +107,8 @@ func HandleSocks4(conn net.Conn, in chan<- C.ConnContext) {
 	}
 }
 
-func HandleSocks5(conn net.Conn, in chan<- C.ConnContext) {
-	target, command, err := socks5.ServerHandshake(conn, authStore.Authenticator())
+func HandleSocks5(conn net.Conn, in chan<- C.ConnContext, authenticator auth.Authenticator) {
+	target, command, err := socks5.ServerHandshake(conn, authenticator)
 	if err != nil {
 		conn.Close()
 		return
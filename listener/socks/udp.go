@@ -7,6 +7,7 @@ import (
 	"github.com/Dreamacro/clash/common/pool"
 	"github.com/Dreamacro/clash/common/sockopt"
 	C "github.com/Dreamacro/clash/constant"
+	authStore "github.com/Dreamacro/clash/listener/auth"
 	"github.com/Dreamacro/clash/log"
 	"github.com/Dreamacro/clash/transport/socks5"
 )
@@ -14,6 +15,7 @@ import (
 type UDPListener struct {
 	packetConn net.PacketConn
 	addr       string
+	access     *authStore.Access
 	closed     bool
 }
 
@@ -34,6 +36,12 @@ func (l *UDPListener) Close() error {
 }
 
 func NewUDP(addr string, in chan<- *inbound.PacketAdapter) (*UDPListener, error) {
+	return NewUDPWithAccess(addr, in, nil)
+}
+
+// NewUDPWithAccess returns a listener that takes packets from the sources
+// access allows, socks5 UDP has no users to check
+func NewUDPWithAccess(addr string, in chan<- *inbound.PacketAdapter, access *authStore.Access) (*UDPListener, error) {
 	l, err := net.ListenPacket("udp", addr)
 	if err != nil {
 		return nil, err
@@ -46,6 +54,7 @@ func NewUDP(addr string, in chan<- *inbound.PacketAdapter) (*UDPListener, error)
 	sl := &UDPListener{
 		packetConn: l,
 		addr:       addr,
+		access:     access,
 	}
 	go func() {
 		for {
@@ -58,6 +67,10 @@ func NewUDP(addr string, in chan<- *inbound.PacketAdapter) (*UDPListener, error)
 				}
 				continue
 			}
+			if !access.Allow(remoteAddr) {
+				pool.Put(buf)
+				continue
+			}
 			handleSocksUDP(l, in, buf[:n], remoteAddr)
 		}
 	}()
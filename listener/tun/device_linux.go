@@ -0,0 +1,219 @@
+package tun
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	cloneDevice = "/dev/net/tun"
+	routeFile   = "/proc/net/route"
+)
+
+// openDevice creates the tun device name, up with the address and mtu.
+// The device is removed once the file is closed.
+func openDevice(name string, mtu int, addr *net.IPNet) (*os.File, string, error) {
+	fd, err := unix.Open(cloneDevice, unix.O_RDWR|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, "", fmt.Errorf("open %s: %w", cloneDevice, err)
+	}
+
+	ifr, err := unix.NewIfreq(name)
+	if err != nil {
+		unix.Close(fd)
+		return nil, "", err
+	}
+	ifr.SetUint16(unix.IFF_TUN | unix.IFF_NO_PI)
+	if err := unix.IoctlIfreq(fd, unix.TUNSETIFF, ifr); err != nil {
+		unix.Close(fd)
+		return nil, "", fmt.Errorf("create tun %s: %w", name, err)
+	}
+	name = ifr.Name()
+
+	// the poller of the file only takes nonblocking fds
+	if err := unix.SetNonblock(fd, true); err != nil {
+		unix.Close(fd)
+		return nil, "", err
+	}
+	file := os.NewFile(uintptr(fd), cloneDevice)
+
+	if err := setupDevice(name, mtu, addr); err != nil {
+		file.Close()
+		return nil, "", fmt.Errorf("setup tun %s: %w", name, err)
+	}
+
+	return file, name, nil
+}
+
+func setupDevice(name string, mtu int, addr *net.IPNet) error {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return err
+	}
+
+	ones, _ := addr.Mask.Size()
+	ifa := unix.IfAddrmsg{
+		Family:    unix.AF_INET,
+		Prefixlen: uint8(ones),
+		Index:     uint32(iface.Index),
+	}
+	ip := addr.IP.To4()
+	err = rtnetlink(unix.RTM_NEWADDR, unix.NLM_F_CREATE|unix.NLM_F_EXCL,
+		(*[unix.SizeofIfAddrmsg]byte)(unsafe.Pointer(&ifa))[:],
+		rtAttr{unix.IFA_LOCAL, ip}, rtAttr{unix.IFA_ADDRESS, ip})
+	if err != nil {
+		return fmt.Errorf("set address: %w", err)
+	}
+
+	ifi := unix.IfInfomsg{
+		Family: unix.AF_UNSPEC,
+		Index:  int32(iface.Index),
+		Flags:  unix.IFF_UP,
+		Change: unix.IFF_UP,
+	}
+	err = rtnetlink(unix.RTM_NEWLINK, 0,
+		(*[unix.SizeofIfInfomsg]byte)(unsafe.Pointer(&ifi))[:],
+		rtAttr{unix.IFLA_MTU, nativeUint32(uint32(mtu))})
+	if err != nil {
+		return fmt.Errorf("set link up: %w", err)
+	}
+
+	return nil
+}
+
+// addRoutes routes 0.0.0.0/1 and 128.0.0.0/1 to the device, which take
+// precedence over the default route and leave it in place
+func addRoutes(name string) error {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return err
+	}
+
+	for _, dst := range []net.IP{net.IPv4(0, 0, 0, 0), net.IPv4(128, 0, 0, 0)} {
+		rtm := unix.RtMsg{
+			Family:   unix.AF_INET,
+			Dst_len:  1,
+			Table:    unix.RT_TABLE_MAIN,
+			Protocol: unix.RTPROT_BOOT,
+			Scope:    unix.RT_SCOPE_LINK,
+			Type:     unix.RTN_UNICAST,
+		}
+		err := rtnetlink(unix.RTM_NEWROUTE, unix.NLM_F_CREATE|unix.NLM_F_EXCL,
+			(*[unix.SizeofRtMsg]byte)(unsafe.Pointer(&rtm))[:],
+			rtAttr{unix.RTA_DST, dst.To4()}, rtAttr{unix.RTA_OIF, nativeUint32(uint32(iface.Index))})
+		if err != nil {
+			return fmt.Errorf("add route %s/1: %w", dst, err)
+		}
+	}
+
+	return nil
+}
+
+type rtAttr struct {
+	typ  uint16
+	data []byte
+}
+
+// rtnetlink sends a route netlink request of typ, made of msg and attrs,
+// and waits for its ack
+func rtnetlink(typ, flags uint16, msg []byte, attrs ...rtAttr) error {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	b := make([]byte, unix.SizeofNlMsghdr, unix.SizeofNlMsghdr+len(msg)+64)
+	b = append(b, msg...)
+	for _, attr := range attrs {
+		length := unix.SizeofRtAttr + len(attr.data)
+		a := make([]byte, (length+unix.RTA_ALIGNTO-1) & ^(unix.RTA_ALIGNTO-1))
+		*(*unix.RtAttr)(unsafe.Pointer(&a[0])) = unix.RtAttr{Len: uint16(length), Type: attr.typ}
+		copy(a[unix.SizeofRtAttr:], attr.data)
+		b = append(b, a...)
+	}
+	*(*unix.NlMsghdr)(unsafe.Pointer(&b[0])) = unix.NlMsghdr{
+		Len:   uint32(len(b)),
+		Type:  typ,
+		Flags: unix.NLM_F_REQUEST | unix.NLM_F_ACK | flags,
+		Seq:   1,
+	}
+
+	if err := unix.Sendto(fd, b, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, os.Getpagesize())
+	n, _, err := unix.Recvfrom(fd, buf, 0)
+	if err != nil {
+		return err
+	}
+	msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+	if err != nil {
+		return err
+	}
+	for _, m := range msgs {
+		if m.Header.Type != unix.NLMSG_ERROR || len(m.Data) < 4 {
+			continue
+		}
+		if errno := *(*int32)(unsafe.Pointer(&m.Data[0])); errno != 0 {
+			return unix.Errno(-errno)
+		}
+		return nil
+	}
+	return errors.New("no netlink ack")
+}
+
+func nativeUint32(v uint32) []byte {
+	b := make([]byte, 4)
+	*(*uint32)(unsafe.Pointer(&b[0])) = v
+	return b
+}
+
+// DefaultInterface returns the interface of the ipv4 default route with the
+// lowest metric
+func DefaultInterface() (string, error) {
+	f, err := os.Open(routeFile)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	name, metric := "", -1
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Iface Destination Gateway Flags RefCnt Use Metric Mask ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 || fields[1] != "00000000" || fields[7] != "00000000" {
+			continue
+		}
+		flags, err := strconv.ParseUint(fields[3], 16, 16)
+		if err != nil || flags&unix.RTF_UP == 0 {
+			continue
+		}
+		m, err := strconv.Atoi(fields[6])
+		if err != nil {
+			continue
+		}
+		if metric == -1 || m < metric {
+			name, metric = fields[0], m
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	if name == "" {
+		return "", errors.New("no default route")
+	}
+	return name, nil
+}
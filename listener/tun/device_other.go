@@ -0,0 +1,24 @@
+//go:build !linux
+// +build !linux
+
+package tun
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+var errPlatformNotSupport = errors.New("tun is not supported on current platform")
+
+func openDevice(name string, mtu int, addr *net.IPNet) (*os.File, string, error) {
+	return nil, "", errPlatformNotSupport
+}
+
+func addRoutes(name string) error {
+	return errPlatformNotSupport
+}
+
+func DefaultInterface() (string, error) {
+	return "", errPlatformNotSupport
+}
@@ -0,0 +1,143 @@
+package tun
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// natPortStart is the first of the ports rewritten connections come from
+	natPortStart = 10000
+	natPortCount = 1<<16 - natPortStart
+
+	// tcpIdleTimeout is how long a session without an open connection is
+	// kept after its last packet
+	tcpIdleTimeout = time.Minute
+)
+
+type natKey struct {
+	srcIP   [4]byte
+	dstIP   [4]byte
+	srcPort uint16
+	dstPort uint16
+}
+
+func newNATKey(p ipv4Packet) natKey {
+	key := natKey{srcPort: p.srcPort(), dstPort: p.dstPort()}
+	copy(key.srcIP[:], p.src())
+	copy(key.dstIP[:], p.dst())
+	return key
+}
+
+// tcpSession is a connection read from the device, rewritten to come from
+// natPort of the virtual address to the listener
+type tcpSession struct {
+	key     natKey
+	natPort uint16
+
+	// guarded by the mux of the nat
+	conns      int
+	lastActive time.Time
+}
+
+func (s *tcpSession) srcAddr() *net.TCPAddr {
+	return &net.TCPAddr{IP: net.IP(s.key.srcIP[:]), Port: int(s.key.srcPort)}
+}
+
+func (s *tcpSession) dstAddr() *net.TCPAddr {
+	return &net.TCPAddr{IP: net.IP(s.key.dstIP[:]), Port: int(s.key.dstPort)}
+}
+
+type tcpNAT struct {
+	mux      sync.Mutex
+	sessions map[natKey]*tcpSession
+	ports    map[uint16]*tcpSession
+	next     uint16
+}
+
+func newTCPNAT() *tcpNAT {
+	return &tcpNAT{
+		sessions: map[natKey]*tcpSession{},
+		ports:    map[uint16]*tcpSession{},
+		next:     natPortStart,
+	}
+}
+
+// session returns the session of key, a new key takes a free nat port.
+// It's nil when every nat port is taken.
+func (t *tcpNAT) session(key natKey) *tcpSession {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	if s, ok := t.sessions[key]; ok {
+		s.lastActive = time.Now()
+		return s
+	}
+
+	for i := 0; i < natPortCount; i++ {
+		port := t.next
+		t.next++
+		if t.next < natPortStart {
+			t.next = natPortStart
+		}
+
+		if _, taken := t.ports[port]; taken {
+			continue
+		}
+
+		s := &tcpSession{key: key, natPort: port, lastActive: time.Now()}
+		t.sessions[key] = s
+		t.ports[port] = s
+		return s
+	}
+	return nil
+}
+
+// lookup returns the session of natPort, or nil
+func (t *tcpNAT) lookup(natPort uint16) *tcpSession {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	s := t.ports[natPort]
+	if s != nil {
+		s.lastActive = time.Now()
+	}
+	return s
+}
+
+// acquire returns the session of natPort as lookup, holding it while the
+// accepted connection is open
+func (t *tcpNAT) acquire(natPort uint16) *tcpSession {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	s := t.ports[natPort]
+	if s != nil {
+		s.conns++
+	}
+	return s
+}
+
+// release lets the session idle out once the connection closed
+func (t *tcpNAT) release(s *tcpSession) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	s.conns--
+	s.lastActive = time.Now()
+}
+
+// sweep frees the sessions without an open connection that were idle since
+// before deadline
+func (t *tcpNAT) sweep(deadline time.Time) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	for key, s := range t.sessions {
+		if s.conns == 0 && s.lastActive.Before(deadline) {
+			delete(t.sessions, key)
+			delete(t.ports, s.natPort)
+		}
+	}
+}
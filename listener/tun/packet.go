@@ -0,0 +1,169 @@
+package tun
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+const (
+	protocolICMP = 1
+	protocolTCP  = 6
+	protocolUDP  = 17
+
+	ipv4HeaderLen = 20
+	tcpHeaderLen  = 20
+	udpHeaderLen  = 8
+	icmpHeaderLen = 8
+
+	icmpEchoReply   = 0
+	icmpEchoRequest = 8
+
+	defaultTTL = 64
+)
+
+// ipv4Packet is an IPv4 packet read from or written to the device
+type ipv4Packet []byte
+
+// parseIPv4 returns the IPv4 packet b starts with, it fails on other
+// versions, malformed headers and fragments
+func parseIPv4(b []byte) (ipv4Packet, bool) {
+	if len(b) < ipv4HeaderLen || b[0]>>4 != 4 {
+		return nil, false
+	}
+
+	headerLen := int(b[0]&0x0f) * 4
+	totalLen := int(binary.BigEndian.Uint16(b[2:]))
+	if headerLen < ipv4HeaderLen || totalLen < headerLen || totalLen > len(b) {
+		return nil, false
+	}
+
+	// more fragments or a fragment offset
+	if binary.BigEndian.Uint16(b[6:])&0x3fff != 0 {
+		return nil, false
+	}
+
+	return ipv4Packet(b[:totalLen]), true
+}
+
+func (p ipv4Packet) headerLen() int {
+	return int(p[0]&0x0f) * 4
+}
+
+func (p ipv4Packet) protocol() byte {
+	return p[9]
+}
+
+func (p ipv4Packet) src() net.IP {
+	return net.IP(p[12:16])
+}
+
+func (p ipv4Packet) dst() net.IP {
+	return net.IP(p[16:20])
+}
+
+func (p ipv4Packet) setSrc(ip net.IP) {
+	copy(p[12:16], ip.To4())
+}
+
+func (p ipv4Packet) setDst(ip net.IP) {
+	copy(p[16:20], ip.To4())
+}
+
+// payload is the TCP, UDP or ICMP message of the packet
+func (p ipv4Packet) payload() []byte {
+	return p[p.headerLen():]
+}
+
+// srcPort of the TCP or UDP message
+func (p ipv4Packet) srcPort() uint16 {
+	return binary.BigEndian.Uint16(p.payload())
+}
+
+// dstPort of the TCP or UDP message
+func (p ipv4Packet) dstPort() uint16 {
+	return binary.BigEndian.Uint16(p.payload()[2:])
+}
+
+func (p ipv4Packet) setSrcPort(port uint16) {
+	binary.BigEndian.PutUint16(p.payload(), port)
+}
+
+func (p ipv4Packet) setDstPort(port uint16) {
+	binary.BigEndian.PutUint16(p.payload()[2:], port)
+}
+
+// resetChecksum computes the checksums of the header and of the TCP, UDP or
+// ICMP message again, after the packet was rewritten
+func (p ipv4Packet) resetChecksum() {
+	header := p[:p.headerLen()]
+	header[10], header[11] = 0, 0
+	binary.BigEndian.PutUint16(header[10:], checksum(0, header))
+
+	payload := p.payload()
+	switch p.protocol() {
+	case protocolTCP:
+		payload[16], payload[17] = 0, 0
+		binary.BigEndian.PutUint16(payload[16:], checksum(p.pseudoHeaderSum(), payload))
+	case protocolUDP:
+		payload[6], payload[7] = 0, 0
+		sum := checksum(p.pseudoHeaderSum(), payload)
+		if sum == 0 {
+			sum = 0xffff
+		}
+		binary.BigEndian.PutUint16(payload[6:], sum)
+	case protocolICMP:
+		payload[2], payload[3] = 0, 0
+		binary.BigEndian.PutUint16(payload[2:], checksum(0, payload))
+	}
+}
+
+// pseudoHeaderSum is the sum of the pseudo header TCP and UDP checksums
+// cover
+func (p ipv4Packet) pseudoHeaderSum() uint32 {
+	s := sum(0, p[12:20])
+	s += uint32(p.protocol())
+	s += uint32(len(p.payload()))
+	return s
+}
+
+// newUDPPacket builds an IPv4 packet carrying payload from src to dst
+func newUDPPacket(src, dst *net.UDPAddr, payload []byte) ipv4Packet {
+	totalLen := ipv4HeaderLen + udpHeaderLen + len(payload)
+	p := ipv4Packet(make([]byte, totalLen))
+	p[0] = 4<<4 | ipv4HeaderLen/4
+	binary.BigEndian.PutUint16(p[2:], uint16(totalLen))
+	p[8] = defaultTTL
+	p[9] = protocolUDP
+	p.setSrc(src.IP)
+	p.setDst(dst.IP)
+
+	dgram := p.payload()
+	binary.BigEndian.PutUint16(dgram[4:], uint16(udpHeaderLen+len(payload)))
+	copy(dgram[udpHeaderLen:], payload)
+	p.setSrcPort(uint16(src.Port))
+	p.setDstPort(uint16(dst.Port))
+	p.resetChecksum()
+	return p
+}
+
+// sum adds b as 16 bits words to initial
+func sum(initial uint32, b []byte) uint32 {
+	s := initial
+	for len(b) >= 2 {
+		s += uint32(binary.BigEndian.Uint16(b))
+		b = b[2:]
+	}
+	if len(b) == 1 {
+		s += uint32(b[0]) << 8
+	}
+	return s
+}
+
+// checksum is the internet checksum of b, with initial added to the sum
+func checksum(initial uint32, b []byte) uint16 {
+	s := sum(initial, b)
+	for s>>16 != 0 {
+		s = s&0xffff + s>>16
+	}
+	return ^uint16(s)
+}
@@ -0,0 +1,297 @@
+package tun
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Dreamacro/clash/adapter/inbound"
+	"github.com/Dreamacro/clash/common/pool"
+	"github.com/Dreamacro/clash/component/resolver"
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/dns"
+	"github.com/Dreamacro/clash/log"
+	"github.com/Dreamacro/clash/transport/socks5"
+
+	D "github.com/miekg/dns"
+)
+
+// stack is the system stack. TCP read from the device is rewritten to a
+// listener on the device address and passed to the kernel, which does the
+// rest of it; UDP and ICMP echo are answered from the packets.
+type stack struct {
+	device    io.ReadWriteCloser
+	mtu       int
+	addr      net.IP
+	virtual   net.IP
+	dnsHijack []*net.UDPAddr
+
+	listener *net.TCPListener
+	port     uint16
+	nat      *tcpNAT
+
+	tcpIn chan<- C.ConnContext
+	udpIn chan<- *inbound.PacketAdapter
+
+	done chan struct{}
+}
+
+// newStack runs the stack on device. Connections are rewritten to come from
+// virtual, an address of the subnet of the device other than addr.
+func newStack(device io.ReadWriteCloser, mtu int, addr, virtual net.IP, dnsHijack []*net.UDPAddr, tcpIn chan<- C.ConnContext, udpIn chan<- *inbound.PacketAdapter) (*stack, error) {
+	l, err := net.ListenTCP("tcp4", &net.TCPAddr{IP: addr})
+	if err != nil {
+		return nil, err
+	}
+
+	s := &stack{
+		device:    device,
+		mtu:       mtu,
+		addr:      addr.To4(),
+		virtual:   virtual.To4(),
+		dnsHijack: dnsHijack,
+		listener:  l,
+		port:      uint16(l.Addr().(*net.TCPAddr).Port),
+		nat:       newTCPNAT(),
+		tcpIn:     tcpIn,
+		udpIn:     udpIn,
+		done:      make(chan struct{}),
+	}
+
+	go s.accept()
+	go s.read()
+	go s.sweep()
+	return s, nil
+}
+
+func (s *stack) Close() error {
+	close(s.done)
+	s.listener.Close()
+	return s.device.Close()
+}
+
+func (s *stack) isClosed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *stack) read() {
+	buf := make([]byte, s.mtu)
+	for {
+		n, err := s.device.Read(buf)
+		if err != nil {
+			if !s.isClosed() {
+				log.Warnln("[TUN] read from device error: %s", err)
+			}
+			return
+		}
+
+		p, ok := parseIPv4(buf[:n])
+		if !ok {
+			continue
+		}
+
+		switch p.protocol() {
+		case protocolTCP:
+			s.handleTCP(p)
+		case protocolUDP:
+			s.handleUDP(p)
+		case protocolICMP:
+			s.handleICMP(p)
+		}
+	}
+}
+
+func (s *stack) handleTCP(p ipv4Packet) {
+	if len(p.payload()) < tcpHeaderLen {
+		return
+	}
+
+	if p.src().Equal(s.addr) && p.srcPort() == s.port {
+		// the listener answering a rewritten connection
+		if !p.dst().Equal(s.virtual) {
+			return
+		}
+		session := s.nat.lookup(p.dstPort())
+		if session == nil {
+			return
+		}
+		p.setSrc(net.IP(session.key.dstIP[:]))
+		p.setSrcPort(session.key.dstPort)
+		p.setDst(net.IP(session.key.srcIP[:]))
+		p.setDstPort(session.key.srcPort)
+	} else {
+		session := s.nat.session(newNATKey(p))
+		if session == nil {
+			return
+		}
+		p.setSrc(s.virtual)
+		p.setSrcPort(session.natPort)
+		p.setDst(s.addr)
+		p.setDstPort(s.port)
+	}
+
+	p.resetChecksum()
+	s.device.Write(p)
+}
+
+func (s *stack) accept() {
+	for {
+		c, err := s.listener.AcceptTCP()
+		if err != nil {
+			if s.isClosed() {
+				return
+			}
+			continue
+		}
+		go s.handleConn(c)
+	}
+}
+
+func (s *stack) handleConn(c *net.TCPConn) {
+	remote := c.RemoteAddr().(*net.TCPAddr)
+	if !remote.IP.Equal(s.virtual) {
+		c.Close()
+		return
+	}
+
+	session := s.nat.acquire(uint16(remote.Port))
+	if session == nil {
+		c.Close()
+		return
+	}
+
+	conn := &tcpConn{TCPConn: c, release: func() { s.nat.release(session) }}
+	conn.SetKeepAlive(true)
+
+	ctx := inbound.NewSocket(socks5.ParseAddrToSocksAddr(session.dstAddr()), conn, C.TUN)
+	src := session.srcAddr()
+	ctx.Metadata().SrcIP = src.IP
+	ctx.Metadata().SrcPort = strconv.Itoa(src.Port)
+	s.tcpIn <- ctx
+}
+
+func (s *stack) sweep() {
+	ticker := time.NewTicker(tcpIdleTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			s.nat.sweep(now.Add(-tcpIdleTimeout))
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *stack) handleUDP(p ipv4Packet) {
+	dgram := p.payload()
+	if len(dgram) < udpHeaderLen {
+		return
+	}
+	length := int(dgram[4])<<8 | int(dgram[5])
+	if length < udpHeaderLen || length > len(dgram) {
+		return
+	}
+
+	dst := &net.UDPAddr{IP: append(net.IP{}, p.dst()...), Port: int(p.dstPort())}
+	if dst.IP.IsMulticast() || dst.IP.Equal(net.IPv4bcast) {
+		return
+	}
+	src := &net.UDPAddr{IP: append(net.IP{}, p.src()...), Port: int(p.srcPort())}
+
+	if resolver.DefaultResolver != nil && s.hijacked(dst) {
+		msg := append([]byte{}, dgram[udpHeaderLen:length]...)
+		go s.handleDNS(msg, src, dst)
+		return
+	}
+
+	buf := pool.Get(length - udpHeaderLen)
+	copy(buf, dgram[udpHeaderLen:length])
+	pkt := &packet{
+		stack: s,
+		lAddr: src,
+		buf:   buf,
+	}
+	s.udpIn <- inbound.NewPacket(socks5.ParseAddrToSocksAddr(dst), pkt, C.TUN)
+}
+
+func (s *stack) hijacked(dst *net.UDPAddr) bool {
+	for _, addr := range s.dnsHijack {
+		if addr.Port == dst.Port && (addr.IP == nil || addr.IP.Equal(dst.IP)) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleDNS answers the dns query b sent from src to dst with the dns server
+func (s *stack) handleDNS(b []byte, src, dst *net.UDPAddr) {
+	msg := &D.Msg{}
+	if err := msg.Unpack(b); err != nil {
+		return
+	}
+
+	resp, err := dns.ServeMsg(msg)
+	if err != nil {
+		resp = (&D.Msg{}).SetRcode(msg, D.RcodeServerFailure)
+	}
+	resp.Compress = true
+
+	buf, err := resp.Pack()
+	if err != nil {
+		return
+	}
+	s.writeUDP(buf, dst, src)
+}
+
+// writeUDP writes b from src to dst into the device
+func (s *stack) writeUDP(b []byte, src, dst *net.UDPAddr) (int, error) {
+	if src.IP.To4() == nil {
+		return 0, errors.New("tun is ipv4 only")
+	}
+	if ipv4HeaderLen+udpHeaderLen+len(b) > s.mtu {
+		return 0, errors.New("packet exceeds the mtu")
+	}
+
+	if _, err := s.device.Write(newUDPPacket(src, dst, b)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// handleICMP answers echo requests to any address
+func (s *stack) handleICMP(p ipv4Packet) {
+	msg := p.payload()
+	if len(msg) < icmpHeaderLen || msg[0] != icmpEchoRequest || msg[1] != 0 {
+		return
+	}
+
+	src, dst := append(net.IP{}, p.src()...), append(net.IP{}, p.dst()...)
+	p.setSrc(dst)
+	p.setDst(src)
+	p[8] = defaultTTL
+	msg[0] = icmpEchoReply
+	p.resetChecksum()
+	s.device.Write(p)
+}
+
+// tcpConn is an accepted connection, it frees its session on close
+type tcpConn struct {
+	*net.TCPConn
+	once    sync.Once
+	release func()
+}
+
+func (c *tcpConn) Close() error {
+	c.once.Do(c.release)
+	return c.TCPConn.Close()
+}
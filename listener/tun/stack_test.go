@@ -0,0 +1,235 @@
+package tun
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/adapter/inbound"
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	stackAddr   = net.IPv4(127, 0, 0, 1).To4()
+	stackClient = net.IPv4(127, 0, 0, 2).To4()
+)
+
+// fakeDevice passes the packets written to in to the stack, and those the
+// stack writes to out
+type fakeDevice struct {
+	in     chan []byte
+	out    chan []byte
+	closed chan struct{}
+}
+
+func newFakeDevice() *fakeDevice {
+	return &fakeDevice{
+		in:     make(chan []byte, 8),
+		out:    make(chan []byte, 8),
+		closed: make(chan struct{}),
+	}
+}
+
+func (d *fakeDevice) Read(b []byte) (int, error) {
+	select {
+	case p := <-d.in:
+		return copy(b, p), nil
+	case <-d.closed:
+		return 0, io.EOF
+	}
+}
+
+func (d *fakeDevice) Write(b []byte) (int, error) {
+	d.out <- append([]byte{}, b...)
+	return len(b), nil
+}
+
+func (d *fakeDevice) Close() error {
+	close(d.closed)
+	return nil
+}
+
+func (d *fakeDevice) written(t *testing.T) ipv4Packet {
+	select {
+	case b := <-d.out:
+		p, ok := parseIPv4(b)
+		assert.True(t, ok)
+		// the checksums sum up to zero with themselves
+		assert.Equal(t, uint16(0), checksum(0, p[:p.headerLen()]))
+		if p.protocol() == protocolICMP {
+			assert.Equal(t, uint16(0), checksum(0, p.payload()))
+		} else {
+			assert.Equal(t, uint16(0), checksum(p.pseudoHeaderSum(), p.payload()))
+		}
+		return p
+	case <-time.After(time.Second):
+		t.Fatal("nothing written to the device")
+		return nil
+	}
+}
+
+func newTestStack(t *testing.T) (*stack, *fakeDevice, chan C.ConnContext, chan *inbound.PacketAdapter) {
+	device := newFakeDevice()
+	tcpIn := make(chan C.ConnContext, 1)
+	udpIn := make(chan *inbound.PacketAdapter, 1)
+	s, err := newStack(device, 1500, stackAddr, stackClient, nil, tcpIn, udpIn)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { s.Close() })
+	return s, device, tcpIn, udpIn
+}
+
+func newTCPPacket(src, dst *net.TCPAddr) ipv4Packet {
+	p := ipv4Packet(make([]byte, ipv4HeaderLen+tcpHeaderLen))
+	p[0] = 4<<4 | ipv4HeaderLen/4
+	binary.BigEndian.PutUint16(p[2:], uint16(len(p)))
+	p[8] = defaultTTL
+	p[9] = protocolTCP
+	p.setSrc(src.IP)
+	p.setDst(dst.IP)
+	p.setSrcPort(uint16(src.Port))
+	p.setDstPort(uint16(dst.Port))
+	p.payload()[12] = tcpHeaderLen / 4 << 4
+	p.resetChecksum()
+	return p
+}
+
+func TestParseIPv4(t *testing.T) {
+	p := newUDPPacket(&net.UDPAddr{IP: stackAddr, Port: 1}, &net.UDPAddr{IP: stackClient, Port: 2}, []byte("payload"))
+	parsed, ok := parseIPv4(append(p, 0, 0))
+	assert.True(t, ok)
+	assert.Equal(t, p, parsed)
+	assert.Equal(t, "payload", string(parsed.payload()[udpHeaderLen:]))
+
+	_, ok = parseIPv4(p[:len(p)-1])
+	assert.False(t, ok)
+
+	fragment := append(ipv4Packet{}, p...)
+	fragment[6] = 0x20
+	_, ok = parseIPv4(fragment)
+	assert.False(t, ok)
+
+	ipv6 := append(ipv4Packet{}, p...)
+	ipv6[0] = 6 << 4
+	_, ok = parseIPv4(ipv6)
+	assert.False(t, ok)
+}
+
+func TestStack_TCP(t *testing.T) {
+	s, device, tcpIn, _ := newTestStack(t)
+
+	src := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1).To4(), Port: 1234}
+	dst := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1).To4(), Port: 80}
+	device.in <- newTCPPacket(src, dst)
+
+	// rewritten from the virtual address to the listener
+	p := device.written(t)
+	assert.Equal(t, stackClient, p.src())
+	assert.Equal(t, stackAddr, p.dst())
+	assert.Equal(t, s.port, p.dstPort())
+	natPort := p.srcPort()
+
+	// and the answer back to the source
+	device.in <- newTCPPacket(&net.TCPAddr{IP: stackAddr, Port: int(s.port)}, &net.TCPAddr{IP: stackClient, Port: int(natPort)})
+	p = device.written(t)
+	assert.Equal(t, dst.IP, p.src())
+	assert.Equal(t, uint16(dst.Port), p.srcPort())
+	assert.Equal(t, src.IP, p.dst())
+	assert.Equal(t, uint16(src.Port), p.dstPort())
+
+	// the connection the kernel made of it goes to the tunnel
+	d := net.Dialer{LocalAddr: &net.TCPAddr{IP: stackClient, Port: int(natPort)}}
+	c, err := d.Dial("tcp", s.listener.Addr().String())
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer c.Close()
+
+	select {
+	case ctx := <-tcpIn:
+		metadata := ctx.Metadata()
+		assert.Equal(t, C.TUN, metadata.Type)
+		assert.Equal(t, dst.IP.String(), metadata.DstIP.String())
+		assert.Equal(t, "80", metadata.DstPort)
+		assert.Equal(t, src.IP.String(), metadata.SrcIP.String())
+		assert.Equal(t, "1234", metadata.SrcPort)
+
+		// the closed connection lets the session idle out
+		ctx.Conn().Close()
+		s.nat.sweep(time.Now().Add(time.Second))
+		assert.Nil(t, s.nat.lookup(natPort))
+	case <-time.After(time.Second):
+		t.Fatal("no connection to the tunnel")
+	}
+}
+
+func TestStack_UDP(t *testing.T) {
+	_, device, _, udpIn := newTestStack(t)
+
+	src := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1).To4(), Port: 1234}
+	dst := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1).To4(), Port: 53}
+	device.in <- newUDPPacket(src, dst, []byte("query"))
+
+	select {
+	case pkt := <-udpIn:
+		metadata := pkt.Metadata()
+		assert.Equal(t, C.TUN, metadata.Type)
+		assert.Equal(t, dst.IP.String(), metadata.DstIP.String())
+		assert.Equal(t, src.IP.String(), metadata.SrcIP.String())
+		assert.Equal(t, "query", string(pkt.Data()))
+
+		_, err := pkt.WriteBack([]byte("answer"), dst)
+		assert.Nil(t, err)
+		p := device.written(t)
+		assert.Equal(t, dst.IP, p.src())
+		assert.Equal(t, uint16(dst.Port), p.srcPort())
+		assert.Equal(t, src.IP, p.dst())
+		assert.Equal(t, uint16(src.Port), p.dstPort())
+		assert.Equal(t, "answer", string(p.payload()[udpHeaderLen:]))
+
+		_, err = pkt.WriteBack([]byte("answer"), &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 53})
+		assert.NotNil(t, err)
+		pkt.Drop()
+	case <-time.After(time.Second):
+		t.Fatal("no packet to the tunnel")
+	}
+}
+
+func TestStack_ICMP(t *testing.T) {
+	_, device, _, _ := newTestStack(t)
+
+	src := net.IPv4(10, 0, 0, 1).To4()
+	dst := net.IPv4(192, 0, 2, 1).To4()
+	p := ipv4Packet(make([]byte, ipv4HeaderLen+icmpHeaderLen))
+	p[0] = 4<<4 | ipv4HeaderLen/4
+	binary.BigEndian.PutUint16(p[2:], uint16(len(p)))
+	p[8] = 1
+	p[9] = protocolICMP
+	p.setSrc(src)
+	p.setDst(dst)
+	p.payload()[0] = icmpEchoRequest
+	p.resetChecksum()
+	device.in <- p
+
+	reply := device.written(t)
+	assert.Equal(t, dst, reply.src())
+	assert.Equal(t, src, reply.dst())
+	assert.Equal(t, byte(icmpEchoReply), reply.payload()[0])
+}
+
+func TestVirtualAddress(t *testing.T) {
+	for addr, virtual := range map[string]string{
+		"172.19.0.1/30": "172.19.0.2",
+		"172.19.0.2/30": "172.19.0.1",
+		"10.0.0.1/8":    "10.0.0.2",
+	} {
+		ip, ipnet, _ := net.ParseCIDR(addr)
+		ipnet.IP = ip
+		assert.Equal(t, virtual, virtualAddress(ipnet).String())
+	}
+}
@@ -0,0 +1,94 @@
+package tun
+
+import (
+	"encoding/binary"
+	"net"
+
+	"github.com/Dreamacro/clash/adapter/inbound"
+	C "github.com/Dreamacro/clash/constant"
+)
+
+// Config of the tun section
+type Config struct {
+	Enable bool
+	// Device is the name of the tun device
+	Device string
+	Stack  string
+	MTU    int
+	// Inet4Address is the address of the device in its subnet, the subnet
+	// takes one more address for the stack
+	Inet4Address *net.IPNet
+	// DNSHijack are the addresses whose dns queries are answered by the dns
+	// server, those without ip are any address of the port
+	DNSHijack []*net.UDPAddr
+	// AutoRoute routes all ipv4 traffic to the device
+	AutoRoute bool
+	// AutoDetectInterface binds the outbound traffic to the interface of the
+	// default route, when interface-name is empty
+	AutoDetectInterface bool
+}
+
+// Listener is a tun device whose traffic goes to the tunnel
+type Listener struct {
+	config *Config
+	name   string
+	stack  *stack
+}
+
+// Config returns the config the listener runs
+func (l *Listener) Config() *Config {
+	return l.config
+}
+
+// Address returns the name of the device
+func (l *Listener) Address() string {
+	return l.name
+}
+
+// Close removes the device, and its routes with it
+func (l *Listener) Close() error {
+	return l.stack.Close()
+}
+
+func New(cfg *Config, tcpIn chan<- C.ConnContext, udpIn chan<- *inbound.PacketAdapter) (*Listener, error) {
+	device, name, err := openDevice(cfg.Device, cfg.MTU, cfg.Inet4Address)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := newStack(device, cfg.MTU, cfg.Inet4Address.IP, virtualAddress(cfg.Inet4Address), cfg.DNSHijack, tcpIn, udpIn)
+	if err != nil {
+		device.Close()
+		return nil, err
+	}
+
+	if cfg.AutoRoute {
+		if err := addRoutes(name); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+
+	return &Listener{
+		config: cfg,
+		name:   name,
+		stack:  s,
+	}, nil
+}
+
+// virtualAddress is the address next to that of the device in its subnet,
+// the one rewritten connections come from
+func virtualAddress(ipnet *net.IPNet) net.IP {
+	ip := binary.BigEndian.Uint32(ipnet.IP.To4())
+	mask := binary.BigEndian.Uint32(net.IP(ipnet.Mask).To4())
+
+	virtual := ip + 1
+	if virtual|mask == 0xffffffff {
+		// the next one is the broadcast address
+		virtual = ip - 1
+	}
+
+	b := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(b, virtual)
+	return b
+}
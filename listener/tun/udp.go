@@ -0,0 +1,38 @@
+package tun
+
+import (
+	"errors"
+	"net"
+
+	"github.com/Dreamacro/clash/common/pool"
+)
+
+// packet is a UDP packet read from the device
+type packet struct {
+	stack *stack
+	lAddr *net.UDPAddr
+	buf   []byte
+}
+
+func (c *packet) Data() []byte {
+	return c.buf
+}
+
+// WriteBack writes an UDP packet from `addr` back into the device
+func (c *packet) WriteBack(b []byte, addr net.Addr) (n int, err error) {
+	from, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, errors.New("invalid udp address")
+	}
+
+	return c.stack.writeUDP(b, from, c.lAddr)
+}
+
+// LocalAddr returns the source IP/Port of UDP Packet
+func (c *packet) LocalAddr() net.Addr {
+	return c.lAddr
+}
+
+func (c *packet) Drop() {
+	pool.Put(c.buf)
+}
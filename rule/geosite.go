@@ -0,0 +1,50 @@
+package rules
+
+import (
+	"strings"
+
+	"github.com/Dreamacro/clash/component/geodata"
+	C "github.com/Dreamacro/clash/constant"
+)
+
+type GEOSITE struct {
+	category string
+	adapter  string
+	matcher  *geodata.Matcher
+}
+
+func (gs *GEOSITE) RuleType() C.RuleType {
+	return C.GEOSITE
+}
+
+func (gs *GEOSITE) Match(metadata *C.Metadata) bool {
+	if metadata.AddrType != C.AtypDomainName {
+		return false
+	}
+	return gs.matcher.Match(strings.ToLower(metadata.Host))
+}
+
+func (gs *GEOSITE) Adapter() string {
+	return gs.adapter
+}
+
+func (gs *GEOSITE) Payload() string {
+	return gs.category
+}
+
+func (gs *GEOSITE) ShouldResolveIP() bool {
+	return false
+}
+
+func NewGEOSITE(category string, adapter string) (*GEOSITE, error) {
+	matcher, err := geodata.NewMatcher(category)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GEOSITE{
+		category: category,
+		adapter:  adapter,
+		matcher:  matcher,
+	}, nil
+}
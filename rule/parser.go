@@ -22,6 +22,8 @@ func ParseRule(tp, payload, target string, params []string) (C.Rule, error) {
 	case "GEOIP":
 		noResolve := HasNoResolve(params)
 		parsed = NewGEOIP(payload, target, noResolve)
+	case "GEOSITE":
+		parsed, parseErr = NewGEOSITE(payload, target)
 	case "IP-CIDR", "IP-CIDR6":
 		noResolve := HasNoResolve(params)
 		parsed, parseErr = NewIPCIDR(payload, target, WithIPCIDRNoResolve(noResolve))
@@ -34,6 +36,8 @@ func ParseRule(tp, payload, target string, params []string) (C.Rule, error) {
 	case "PROCESS-NAME":
 		fullMatch := HasFullMatch(params)
 		parsed, parseErr = NewProcess(payload, target, fullMatch)
+	case "PROCESS-PATH":
+		parsed, parseErr = NewProcessPath(payload, target)
 	case "RULE-SET":
 		parsed, parseErr = NewRuleSet(payload, target)
 	case "MATCH":
@@ -2,9 +2,9 @@ package rules
 
 import (
 	"fmt"
+	"path/filepath"
 	"strconv"
 	"strings"
-	"path/filepath"
 
 	"github.com/Dreamacro/clash/common/cache"
 	"github.com/Dreamacro/clash/component/process"
@@ -15,23 +15,39 @@ import (
 var processCache = cache.NewLRUCache(cache.WithAge(2), cache.WithSize(64))
 
 type Process struct {
-	adapter string
-	process string
+	adapter   string
+	process   string
 	fullMatch bool
+	ruleType  C.RuleType
 }
 
 func (ps *Process) RuleType() C.RuleType {
-	return C.Process
+	return ps.ruleType
 }
 
 func (ps *Process) Match(metadata *C.Metadata) bool {
+	processName := findProcessPath(metadata)
+	if !ps.fullMatch {
+		processName = filepath.Base(processName)
+	}
+
+	return strings.EqualFold(processName, ps.process)
+}
+
+// findProcessPath looks up the process owning the source of metadata once,
+// the path is kept in metadata for the rules after and the connection list
+func findProcessPath(metadata *C.Metadata) string {
+	if metadata.ProcessPath != "" {
+		return metadata.ProcessPath
+	}
+
 	key := fmt.Sprintf("%s:%s:%s", metadata.NetWork.String(), metadata.SrcIP.String(), metadata.SrcPort)
 	cached, hit := processCache.Get(key)
 	if !hit {
 		srcPort, err := strconv.Atoi(metadata.SrcPort)
 		if err != nil {
 			processCache.Set(key, "")
-			return false
+			return ""
 		}
 
 		name, err := process.FindProcessName(metadata.NetWork.String(), metadata.SrcIP, srcPort)
@@ -44,12 +60,8 @@ func (ps *Process) Match(metadata *C.Metadata) bool {
 		cached = name
 	}
 
-	processName := cached.(string)
-	if !ps.fullMatch {
-		processName = filepath.Base(processName)
-	}
-
-	return strings.EqualFold(processName, ps.process)
+	metadata.ProcessPath = cached.(string)
+	return metadata.ProcessPath
 }
 
 func (ps *Process) Adapter() string {
@@ -70,8 +82,19 @@ func NewProcess(process string, adapter string, fullMatch bool) (*Process, error
 	}
 
 	return &Process{
-		adapter: adapter,
-		process: process,
+		adapter:   adapter,
+		process:   process,
 		fullMatch: fullMatch,
+		ruleType:  C.Process,
+	}, nil
+}
+
+// NewProcessPath matches the full path of the executable
+func NewProcessPath(path string, adapter string) (*Process, error) {
+	return &Process{
+		adapter:   adapter,
+		process:   path,
+		fullMatch: true,
+		ruleType:  C.ProcessPath,
 	}, nil
 }
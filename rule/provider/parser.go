@@ -12,6 +12,7 @@ import (
 type ruleProviderSchema struct {
 	Type     string `provider:"type"`
 	Behavior string `provider:"behavior"`
+	Format   string `provider:"format,omitempty"`
 	Path     string `provider:"path"`
 	URL      string `provider:"url,omitempty"`
 	Interval int    `provider:"interval,omitempty"`
@@ -36,6 +37,17 @@ func ParseRuleProvider(name string, mapping map[string]interface{}) (RuleProvide
 		return nil, fmt.Errorf("unsupported behavior type: %s", schema.Behavior)
 	}
 
+	var format Format
+
+	switch schema.Format {
+	case "", "yaml":
+		format = YamlRule
+	case "text":
+		format = TextRule
+	default:
+		return nil, fmt.Errorf("unsupported format type: %s", schema.Format)
+	}
+
 	path := C.Path.Resolve(schema.Path)
 	var vehicle providerType.Vehicle
 	switch schema.Type {
@@ -47,5 +59,5 @@ func ParseRuleProvider(name string, mapping map[string]interface{}) (RuleProvide
 		return nil, fmt.Errorf("unsupported vehicle type: %s", schema.Type)
 	}
 
-	return NewRuleSetProvider(name, behavior, time.Duration(uint(schema.Interval))*time.Second, vehicle), nil
+	return NewRuleSetProvider(name, behavior, format, time.Duration(uint(schema.Interval))*time.Second, vehicle), nil
 }
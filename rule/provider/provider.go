@@ -14,6 +14,8 @@ import (
 
 type Behavior int
 
+type Format int
+
 var (
 	parse = func(ruleType, rule string, params []string) (C.Rule, error) {
 		return nil, errors.New("unimplemented function")
@@ -55,6 +57,22 @@ func (b Behavior) String() string {
 	}
 }
 
+const (
+	YamlRule Format = iota
+	TextRule
+)
+
+func (f Format) String() string {
+	switch f {
+	case YamlRule:
+		return "YamlRule"
+	case TextRule:
+		return "TextRule"
+	default:
+		return ""
+	}
+}
+
 type RuleProvider interface {
 	providerType.Provider
 	Search(metadata *C.Metadata) bool
@@ -65,6 +83,7 @@ type RuleProvider interface {
 type ruleSetProvider struct {
 	*fetcher
 	behavior       Behavior
+	format         Format
 	count          int
 	DomainRules    *trie.DomainTrie
 	IPCIDRRules    *trie.IpCidrTrie
@@ -83,9 +102,10 @@ type RulePayload struct {
 	Rules []string `yaml:"payload"`
 }
 
-func NewRuleSetProvider(name string, behavior Behavior, interval time.Duration, vehicle providerType.Vehicle) RuleProvider {
+func NewRuleSetProvider(name string, behavior Behavior, format Format, interval time.Duration, vehicle providerType.Vehicle) RuleProvider {
 	rp := &ruleSetProvider{
 		behavior: behavior,
+		format:   format,
 	}
 
 	onUpdate := func(elm interface{}) error {
@@ -99,7 +119,12 @@ func NewRuleSetProvider(name string, behavior Behavior, interval time.Duration,
 		return nil
 	}
 
-	fetcher := newFetcher(name, interval, vehicle, rulesParse, onUpdate)
+	parser := rulesParse
+	if format == TextRule {
+		parser = textRulesParse
+	}
+
+	fetcher := newFetcher(name, interval, vehicle, parser, onUpdate)
 	rp.fetcher = fetcher
 	wrapper := &RuleSetProvider{
 		rp,
@@ -181,6 +206,7 @@ func (rp ruleSetProvider) MarshalJSON() ([]byte, error) {
 	return json.Marshal(
 		map[string]interface{}{
 			"behavior":    rp.behavior.String(),
+			"format":      rp.format.String(),
 			"name":        rp.Name(),
 			"ruleCount":   rp.RuleCount(),
 			"type":        rp.Type().String(),
@@ -199,6 +225,20 @@ func rulesParse(buf []byte) (interface{}, error) {
 	return rulePayload.Rules, nil
 }
 
+// textRulesParse reads a rule per line, blank lines and # comments are skipped
+func textRulesParse(buf []byte) (interface{}, error) {
+	rules := []string{}
+	for _, line := range strings.Split(string(buf), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rules = append(rules, line)
+	}
+
+	return rules, nil
+}
+
 func constructRules(behavior Behavior, rules []string) (interface{}, error) {
 	switch behavior {
 	case Domain:
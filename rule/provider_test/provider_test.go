@@ -23,7 +23,7 @@ func setup() {
 
 func TestDomain(t *testing.T) {
 	setup()
-	domainProvider := ruleProvider.NewRuleSetProvider("test", ruleProvider.Domain,
+	domainProvider := ruleProvider.NewRuleSetProvider("test", ruleProvider.Domain, ruleProvider.YamlRule,
 		time.Duration(uint(100000)), provider.NewFileVehicle("./domain.txt"))
 	assert.Nil(t, domainProvider.Initial())
 	assert.True(t, domainProvider.Search(&constant.Metadata{Host: "youtube.com"}))
@@ -35,7 +35,7 @@ func TestDomain(t *testing.T) {
 
 func TestClassical(t *testing.T) {
 	setup()
-	classicalProvider := ruleProvider.NewRuleSetProvider("test", ruleProvider.Classical,
+	classicalProvider := ruleProvider.NewRuleSetProvider("test", ruleProvider.Classical, ruleProvider.YamlRule,
 		time.Duration(uint(100000)), provider.NewFileVehicle("./classical.txt"))
 	assert.Nil(t, classicalProvider.Initial())
 	assert.True(t, classicalProvider.Search(&constant.Metadata{Host: "www.10010.com", AddrType: constant.AtypDomainName}))
@@ -49,7 +49,7 @@ func TestClassical(t *testing.T) {
 
 func TestIpCidr(t *testing.T) {
 	setup()
-	ipCidrProvider := ruleProvider.NewRuleSetProvider("test", ruleProvider.IPCIDR,
+	ipCidrProvider := ruleProvider.NewRuleSetProvider("test", ruleProvider.IPCIDR, ruleProvider.YamlRule,
 		time.Duration(uint(100000)), provider.NewFileVehicle("./ipcidr.txt"))
 	assert.Nil(t, ipCidrProvider.Initial())
 	assert.True(t, ipCidrProvider.Search(&constant.Metadata{DstIP: net.ParseIP("91.108.22.10")}))
@@ -57,3 +57,15 @@ func TestIpCidr(t *testing.T) {
 	assert.True(t, ipCidrProvider.Search(&constant.Metadata{DstIP: net.ParseIP("2001:b28:f23f:f005::a")}))
 	assert.False(t, ipCidrProvider.Search(&constant.Metadata{DstIP: net.ParseIP("2006:b28:f23f:f005::a")}))
 }
+
+func TestTextFormat(t *testing.T) {
+	setup()
+	domainProvider := ruleProvider.NewRuleSetProvider("test", ruleProvider.Domain, ruleProvider.TextRule,
+		time.Duration(uint(100000)), provider.NewFileVehicle("./domain_text.txt"))
+	assert.Nil(t, domainProvider.Initial())
+	assert.Equal(t, 3, domainProvider.RuleCount())
+	assert.True(t, domainProvider.Search(&constant.Metadata{Host: "youtube.com"}))
+	assert.True(t, domainProvider.Search(&constant.Metadata{Host: "www.youtube.com"}))
+	assert.True(t, domainProvider.Search(&constant.Metadata{Host: "netflix.com"}))
+	assert.False(t, domainProvider.Search(&constant.Metadata{Host: "baidu.com"}))
+}
@@ -6,6 +6,7 @@ package gun
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/binary"
 	"errors"
@@ -13,6 +14,7 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"sync"
 	"time"
@@ -47,6 +49,11 @@ type Conn struct {
 	remain    int
 	br        *bufio.Reader
 
+	// closed once the http2 conn of the stream is known, or the request failed
+	connected chan struct{}
+	connOnce  sync.Once
+	tlsState  tls.ConnectionState
+
 	// deadlines
 	deadline *time.Timer
 }
@@ -61,6 +68,7 @@ func (g *Conn) initRequest() {
 	if err != nil {
 		g.err = err
 		g.writer.Close()
+		g.connOnce.Do(func() { close(g.connected) })
 		return
 	}
 
@@ -142,8 +150,26 @@ func (g *Conn) Write(b []byte) (n int, err error) {
 	return len(b), err
 }
 
+// gotConn keeps the TLS state of the http2 conn the stream goes on
+func (g *Conn) gotConn(info httptrace.GotConnInfo) {
+	g.connOnce.Do(func() {
+		if tlsConn, ok := info.Conn.(interface{ ConnectionState() tls.ConnectionState }); ok {
+			g.tlsState = tlsConn.ConnectionState()
+		}
+		close(g.connected)
+	})
+}
+
+// ConnectionState returns the state of the TLS session the stream goes on,
+// waiting for the http2 conn to be there. The zero value if there's none.
+func (g *Conn) ConnectionState() tls.ConnectionState {
+	<-g.connected
+	return g.tlsState
+}
+
 func (g *Conn) Close() error {
 	g.close.Store(true)
+	g.connOnce.Do(func() { close(g.connected) })
 	if r := g.response; r != nil {
 		r.Body.Close()
 	}
@@ -221,11 +247,14 @@ func StreamGunWithTransport(transport *http2.Transport, cfg *Config) (net.Conn,
 	}
 
 	conn := &Conn{
-		request:   request,
 		transport: transport,
 		writer:    writer,
 		close:     atomic.NewBool(false),
+		connected: make(chan struct{}),
 	}
+	conn.request = request.WithContext(httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+		GotConn: conn.gotConn,
+	}))
 
 	go conn.once.Do(conn.initRequest)
 	return conn, nil
@@ -0,0 +1,228 @@
+// Package hysteria2 is the client side of the Hysteria 2 protocol: a
+// password checked over HTTP/3, then TCP over QUIC streams and UDP over
+// QUIC datagrams of the same connection.
+package hysteria2
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/http3"
+)
+
+const (
+	// flow control windows of the Hysteria client
+	streamReceiveWindow     = 8 * 1024 * 1024
+	connectionReceiveWindow = 20 * 1024 * 1024
+
+	idleTimeout = 30 * time.Second
+)
+
+var (
+	ErrAuthFailed  = errors.New("hysteria2 authentication failed")
+	ErrUDPDisabled = errors.New("hysteria2 server doesn't support udp")
+)
+
+type Config struct {
+	Password  string
+	TLSConfig *tls.Config
+	// ObfsPassword, if set, obfuscates the packets with salamander
+	ObfsPassword string
+	// Up caps what the client sends in bytes per second. Zero leaves it to
+	// the congestion control.
+	Up uint64
+	// Down is what the client can receive in bytes per second, the server
+	// sends at that rate. Zero leaves it to the congestion control.
+	Down uint64
+	// Dial returns a conn to send the QUIC packets on and the server address
+	Dial func(ctx context.Context) (net.PacketConn, net.Addr, error)
+}
+
+// Client keeps one authenticated QUIC connection to the server, dialed
+// again once it's gone.
+type Client struct {
+	config *Config
+
+	mux  sync.Mutex
+	conn *clientConn
+}
+
+func NewClient(config *Config) *Client {
+	return &Client{config: config}
+}
+
+type clientConn struct {
+	session quic.EarlySession
+	rt      *http3.RoundTripper
+	udp     bool
+
+	udpMux      sync.Mutex
+	udpSessions map[uint32]*udpConn
+	nextID      uint32
+}
+
+func (cc *clientConn) alive() bool {
+	select {
+	case <-cc.session.Context().Done():
+		return false
+	default:
+		return true
+	}
+}
+
+func (cc *clientConn) close() {
+	cc.rt.Close()
+	cc.session.CloseWithError(0, "")
+}
+
+func (c *Client) clientConn(ctx context.Context) (*clientConn, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.conn != nil && c.conn.alive() {
+		return c.conn, nil
+	}
+
+	cc, err := c.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.conn = cc
+	return cc, nil
+}
+
+func (c *Client) connect(ctx context.Context) (*clientConn, error) {
+	pc, addr, err := c.config.Dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if c.config.Up != 0 {
+		pc = NewRateLimitedPacketConn(pc, c.config.Up)
+	}
+	if c.config.ObfsPassword != "" {
+		pc = NewSalamanderPacketConn(pc, c.config.ObfsPassword)
+	}
+
+	cc := &clientConn{udpSessions: map[uint32]*udpConn{}}
+	cc.rt = &http3.RoundTripper{
+		TLSClientConfig: c.config.TLSConfig,
+		QuicConfig: &quic.Config{
+			Versions:                       []quic.VersionNumber{quic.Version1},
+			InitialStreamReceiveWindow:     streamReceiveWindow,
+			MaxStreamReceiveWindow:         streamReceiveWindow,
+			InitialConnectionReceiveWindow: connectionReceiveWindow,
+			MaxConnectionReceiveWindow:     connectionReceiveWindow,
+			MaxIdleTimeout:                 idleTimeout,
+			KeepAlive:                      true,
+		},
+		EnableDatagrams: true,
+		Dial: func(network, _ string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlySession, error) {
+			session, err := quic.DialEarlyContext(ctx, pc, addr, tlsCfg.ServerName, tlsCfg, cfg)
+			if err != nil {
+				return nil, err
+			}
+			cc.session = session
+			return session, nil
+		},
+	}
+
+	req := &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: "https", Host: "hysteria", Path: "/auth"},
+		Header: http.Header{},
+	}
+	req.Header.Set(headerAuth, c.config.Password)
+	req.Header.Set(headerCCRX, strconv.FormatUint(c.config.Down, 10))
+	req.Header.Set(headerPadding, authRequestPadding.String())
+
+	resp, err := cc.rt.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cc.rt.Close()
+		pc.Close()
+		return nil, fmt.Errorf("hysteria2 auth request: %w", err)
+	}
+	resp.Body.Close()
+
+	// quic-go leaves a conn it didn't open to the caller
+	go func() {
+		<-cc.session.Context().Done()
+		pc.Close()
+	}()
+
+	if resp.StatusCode != statusAuthOK {
+		cc.close()
+		return nil, fmt.Errorf("%w: status %d", ErrAuthFailed, resp.StatusCode)
+	}
+
+	cc.udp = resp.Header.Get(headerUDP) == "true"
+	if cc.udp {
+		go cc.receiveLoop()
+	}
+	return cc, nil
+}
+
+// DialConn opens a TCP stream to addr, host:port, through the server.
+func (c *Client) DialConn(ctx context.Context, addr string) (net.Conn, error) {
+	cc, err := c.clientConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := cc.session.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
+	}
+	r := bufio.NewReader(stream)
+	if err := WriteTCPRequest(stream, addr); err != nil {
+		stream.CancelRead(0)
+		stream.Close()
+		return nil, err
+	}
+	if err := ReadTCPResponse(r); err != nil {
+		stream.CancelRead(0)
+		stream.Close()
+		return nil, err
+	}
+	stream.SetDeadline(time.Time{})
+
+	return &tcpConn{Stream: stream, r: r, session: cc.session}, nil
+}
+
+// ListenPacket opens a UDP session through the server.
+func (c *Client) ListenPacket(ctx context.Context) (net.PacketConn, error) {
+	cc, err := c.clientConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !cc.udp {
+		return nil, ErrUDPDisabled
+	}
+
+	return cc.newUDPConn(), nil
+}
+
+// Close closes the connection to the server with all its streams.
+func (c *Client) Close() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.conn != nil {
+		c.conn.close()
+		c.conn = nil
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+package hysteria2
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/quicvarint"
+	"github.com/marten-seemann/qpack"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testServerName = "hysteria.example.com"
+	testPassword   = "password"
+)
+
+func newTestCert(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: testServerName},
+		DNSNames:              []string{testServerName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+
+	leaf, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// testServer is as much of a Hysteria 2 server as the client needs: it
+// checks the password, echoes TCP streams and UDP messages.
+type testServer struct {
+	listener quic.Listener
+	sessions chan quic.Session
+	requests chan string
+}
+
+func newTestServer(t *testing.T, cert tls.Certificate, obfsPassword string) *testServer {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	if obfsPassword != "" {
+		pc = NewSalamanderPacketConn(pc, obfsPassword)
+	}
+
+	listener, err := quic.Listen(pc, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h3"},
+	}, &quic.Config{
+		Versions:        []quic.VersionNumber{quic.Version1},
+		EnableDatagrams: true,
+	})
+	assert.Nil(t, err)
+
+	s := &testServer{
+		listener: listener,
+		sessions: make(chan quic.Session, 4),
+		requests: make(chan string, 16),
+	}
+	go func() {
+		for {
+			session, err := listener.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			s.sessions <- session
+			go s.handleSession(session)
+		}
+	}()
+	return s
+}
+
+func (s *testServer) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+func (s *testServer) Close() {
+	s.listener.Close()
+}
+
+func (s *testServer) handleSession(session quic.Session) {
+	go s.echoUDP(session)
+	for {
+		stream, err := session.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		go s.handleStream(stream)
+	}
+}
+
+func (s *testServer) handleStream(stream quic.Stream) {
+	defer stream.Close()
+
+	r := bufio.NewReader(stream)
+	frameType, err := quicvarint.Read(r)
+	if err != nil {
+		return
+	}
+	length, err := quicvarint.Read(r)
+	if err != nil {
+		return
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return
+	}
+
+	switch frameType {
+	case 0x1: // HEADERS
+		fields, err := qpack.NewDecoder(nil).DecodeFull(payload)
+		if err != nil {
+			return
+		}
+		status := "403"
+		for _, f := range fields {
+			if f.Name == "hysteria-auth" && f.Value == testPassword {
+				status = "233"
+			}
+		}
+
+		block := &bytes.Buffer{}
+		encoder := qpack.NewEncoder(block)
+		encoder.WriteField(qpack.HeaderField{Name: ":status", Value: status})
+		encoder.WriteField(qpack.HeaderField{Name: "hysteria-udp", Value: "true"})
+		buf := &bytes.Buffer{}
+		quicvarint.Write(buf, 0x1)
+		quicvarint.Write(buf, uint64(block.Len()))
+		buf.Write(block.Bytes())
+		stream.Write(buf.Bytes())
+	case frameTypeTCPRequest:
+		paddingLen, err := quicvarint.Read(r)
+		if err != nil {
+			return
+		}
+		r.Discard(int(paddingLen))
+		s.requests <- string(payload)
+
+		buf := &bytes.Buffer{}
+		writeTCPResponse(buf, tcpStatusOK, "")
+		stream.Write(buf.Bytes())
+		io.Copy(stream, r)
+	}
+}
+
+func (s *testServer) echoUDP(session quic.Session) {
+	d := &defragger{}
+	for {
+		b, err := session.ReceiveMessage()
+		if err != nil {
+			return
+		}
+		m, err := ParseUDPMessage(b)
+		if err != nil {
+			continue
+		}
+		if m = d.feed(m); m == nil {
+			continue
+		}
+		for _, frag := range fragUDPMessage(m, maxDatagramSize) {
+			session.SendMessage(frag.Bytes())
+		}
+	}
+}
+
+func newTestClient(cert tls.Certificate, server net.Addr, password, obfsPassword string) *Client {
+	pool := x509.NewCertPool()
+	pool.AddCert(cert.Leaf)
+
+	return NewClient(&Config{
+		Password:     password,
+		TLSConfig:    &tls.Config{ServerName: testServerName, RootCAs: pool},
+		ObfsPassword: obfsPassword,
+		Dial: func(ctx context.Context) (net.PacketConn, net.Addr, error) {
+			pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+			return pc, server, err
+		},
+	})
+}
+
+func TestClient(t *testing.T) {
+	cert := newTestCert(t)
+	server := newTestServer(t, cert, "salamander")
+	defer server.Close()
+
+	client := newTestClient(cert, server.Addr(), testPassword, "salamander")
+	client.config.Up = 10 * 1000 * 1000
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, err := client.DialConn(ctx, "example.com:80")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer c.Close()
+	assert.Equal(t, "example.com:80", <-server.requests)
+
+	c.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(c, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "ping", string(buf))
+
+	pc, err := client.ListenPacket(ctx)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer pc.Close()
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	dst := &net.UDPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 53}
+	for _, payload := range [][]byte{[]byte("query"), bytes.Repeat([]byte("0123456789"), 300)} {
+		_, err = pc.WriteTo(payload, dst)
+		assert.Nil(t, err)
+
+		buf := make([]byte, 4096)
+		n, addr, err := pc.ReadFrom(buf)
+		assert.Nil(t, err)
+		assert.Equal(t, payload, buf[:n])
+		assert.Equal(t, dst.String(), addr.String())
+	}
+
+	// a closed connection gets dialed again
+	(<-server.sessions).CloseWithError(0, "")
+	time.Sleep(100 * time.Millisecond)
+	c, err = client.DialConn(ctx, "example.com:443")
+	if assert.Nil(t, err) {
+		c.Close()
+		assert.Equal(t, "example.com:443", <-server.requests)
+	}
+}
+
+func TestClient_AuthFailed(t *testing.T) {
+	cert := newTestCert(t)
+	server := newTestServer(t, cert, "")
+	defer server.Close()
+
+	client := newTestClient(cert, server.Addr(), "wrong", "")
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := client.DialConn(ctx, "example.com:80")
+	assert.ErrorIs(t, err, ErrAuthFailed)
+}
@@ -0,0 +1,172 @@
+package hysteria2
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	N "github.com/Dreamacro/clash/common/net"
+
+	"github.com/lucas-clemente/quic-go"
+)
+
+const (
+	// what a QUIC datagram of quic-go takes, with room for the headers
+	maxDatagramSize = 1100
+	// datagrams of a session nobody reads yet, more get dropped
+	udpReceiveBuffer = 128
+)
+
+// tcpConn is a TCP request stream, closing it closes both directions
+type tcpConn struct {
+	quic.Stream
+	r       *bufio.Reader
+	session quic.Session
+}
+
+func (c *tcpConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *tcpConn) Close() error {
+	c.Stream.CancelRead(0)
+	return c.Stream.Close()
+}
+
+func (c *tcpConn) LocalAddr() net.Addr {
+	return c.session.LocalAddr()
+}
+
+func (c *tcpConn) RemoteAddr() net.Addr {
+	return c.session.RemoteAddr()
+}
+
+func (cc *clientConn) newUDPConn() *udpConn {
+	cc.udpMux.Lock()
+	defer cc.udpMux.Unlock()
+
+	cc.nextID++
+	uc := &udpConn{
+		cc:           cc,
+		id:           cc.nextID,
+		ch:           make(chan *UDPMessage, udpReceiveBuffer),
+		closed:       make(chan struct{}),
+		readDeadline: N.MakePipeDeadline(),
+	}
+	cc.udpSessions[uc.id] = uc
+	return uc
+}
+
+// receiveLoop hands the datagrams of the connection to their sessions
+func (cc *clientConn) receiveLoop() {
+	for {
+		b, err := cc.session.ReceiveMessage()
+		if err != nil {
+			return
+		}
+		m, err := ParseUDPMessage(b)
+		if err != nil {
+			continue
+		}
+
+		cc.udpMux.Lock()
+		uc := cc.udpSessions[m.SessionID]
+		cc.udpMux.Unlock()
+		if uc != nil {
+			uc.feed(m)
+		}
+	}
+}
+
+// udpConn is a UDP session, its datagrams go with the address of each
+type udpConn struct {
+	cc *clientConn
+	id uint32
+
+	defragger    defragger // used by receiveLoop only
+	ch           chan *UDPMessage
+	closed       chan struct{}
+	readDeadline *N.PipeDeadline
+}
+
+func (uc *udpConn) feed(m *UDPMessage) {
+	if m = uc.defragger.feed(m); m == nil {
+		return
+	}
+	select {
+	case uc.ch <- m:
+	default:
+		// a full socket buffer drops too
+	}
+}
+
+func (uc *udpConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		select {
+		case m := <-uc.ch:
+			addr, err := net.ResolveUDPAddr("udp", m.Addr)
+			if err != nil {
+				continue
+			}
+			return copy(b, m.Data), addr, nil
+		case <-uc.closed:
+			return 0, nil, io.ErrClosedPipe
+		case <-uc.cc.session.Context().Done():
+			return 0, nil, io.ErrClosedPipe
+		case <-uc.readDeadline.Wait():
+			return 0, nil, os.ErrDeadlineExceeded
+		}
+	}
+}
+
+func (uc *udpConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	select {
+	case <-uc.closed:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+
+	m := &UDPMessage{
+		SessionID: uc.id,
+		FragCount: 1,
+		Addr:      addr.String(),
+		Data:      b,
+	}
+	for _, frag := range fragUDPMessage(m, maxDatagramSize) {
+		if err := uc.cc.session.SendMessage(frag.Bytes()); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// Close ends the session here, the server forgets it once it's idle
+func (uc *udpConn) Close() error {
+	uc.cc.udpMux.Lock()
+	defer uc.cc.udpMux.Unlock()
+
+	if _, ok := uc.cc.udpSessions[uc.id]; ok {
+		delete(uc.cc.udpSessions, uc.id)
+		close(uc.closed)
+	}
+	return nil
+}
+
+func (uc *udpConn) LocalAddr() net.Addr {
+	return uc.cc.session.LocalAddr()
+}
+
+func (uc *udpConn) SetDeadline(t time.Time) error {
+	return uc.SetReadDeadline(t)
+}
+
+func (uc *udpConn) SetReadDeadline(t time.Time) error {
+	uc.readDeadline.Set(t)
+	return nil
+}
+
+func (uc *udpConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
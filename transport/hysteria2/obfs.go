@@ -0,0 +1,63 @@
+package hysteria2
+
+import (
+	"crypto/rand"
+	"net"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+const salamanderSaltLength = 8
+
+// SalamanderPacketConn obfuscates the QUIC packets as Hysteria's salamander:
+// every packet carries a random salt and is XORed with
+// BLAKE2b-256(password + salt).
+type SalamanderPacketConn struct {
+	net.PacketConn
+	password []byte
+}
+
+func NewSalamanderPacketConn(pc net.PacketConn, password string) *SalamanderPacketConn {
+	return &SalamanderPacketConn{PacketConn: pc, password: []byte(password)}
+}
+
+func (c *SalamanderPacketConn) key(salt []byte) [blake2b.Size256]byte {
+	return blake2b.Sum256(append(append([]byte(nil), c.password...), salt...))
+}
+
+func (c *SalamanderPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	buf := make([]byte, len(b)+salamanderSaltLength)
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(buf)
+		if err != nil {
+			return 0, addr, err
+		}
+		// too short to be ours, wait for the next one
+		if n <= salamanderSaltLength {
+			continue
+		}
+
+		key := c.key(buf[:salamanderSaltLength])
+		data := buf[salamanderSaltLength:n]
+		for i := range data {
+			b[i] = data[i] ^ key[i%len(key)]
+		}
+		return len(data), addr, nil
+	}
+}
+
+func (c *SalamanderPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	buf := make([]byte, salamanderSaltLength+len(b))
+	if _, err := rand.Read(buf[:salamanderSaltLength]); err != nil {
+		return 0, err
+	}
+
+	key := c.key(buf[:salamanderSaltLength])
+	for i := range b {
+		buf[salamanderSaltLength+i] = b[i] ^ key[i%len(key)]
+	}
+	if _, err := c.PacketConn.WriteTo(buf, addr); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,47 @@
+package hysteria2
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSalamander(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer server.Close()
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer client.Close()
+
+	obfsClient := NewSalamanderPacketConn(client, "cry me a r1ver")
+	obfsServer := NewSalamanderPacketConn(server, "cry me a r1ver")
+	server.SetReadDeadline(time.Now().Add(time.Second))
+
+	_, err = obfsClient.WriteTo([]byte("hello"), server.LocalAddr())
+	assert.Nil(t, err)
+
+	// what goes over the wire isn't the packet
+	raw := make([]byte, 64)
+	n, _, err := server.ReadFrom(raw)
+	assert.Nil(t, err)
+	assert.Equal(t, salamanderSaltLength+5, n)
+	assert.NotContains(t, string(raw[:n]), "hello")
+
+	// short packets are dropped, the next one comes through
+	client.WriteTo([]byte("short"), server.LocalAddr())
+	obfsClient.WriteTo([]byte("hello"), server.LocalAddr())
+	buf := make([]byte, 64)
+	n, addr, err := obfsServer.ReadFrom(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(buf[:n]))
+	assert.Equal(t, client.LocalAddr().String(), addr.String())
+
+	// a wrong password gets garbage
+	NewSalamanderPacketConn(client, "another one").WriteTo([]byte("hello"), server.LocalAddr())
+	n, _, err = obfsServer.ReadFrom(buf)
+	assert.Nil(t, err)
+	assert.NotEqual(t, "hello", string(buf[:n]))
+}
@@ -0,0 +1,256 @@
+package hysteria2
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/lucas-clemente/quic-go/quicvarint"
+)
+
+const (
+	// frame type opening a TCP request stream, unknown to HTTP/3 so the
+	// server takes the stream over
+	frameTypeTCPRequest = 0x401
+
+	maxAddressLength = 2048
+	maxMessageLength = 2048
+	maxPaddingLength = 4096
+
+	tcpStatusOK = 0
+
+	// the auth request is answered with this status if the password is right
+	statusAuthOK = 233
+
+	// HTTP/3 auth headers
+	headerAuth    = "Hysteria-Auth"
+	headerUDP     = "Hysteria-UDP"
+	headerCCRX    = "Hysteria-CC-RX"
+	headerPadding = "Hysteria-Padding"
+)
+
+// padding lengths of what Hysteria sends, so lengths don't give it away
+var (
+	authRequestPadding = paddingRange{256, 2048}
+	tcpRequestPadding  = paddingRange{64, 512}
+)
+
+type paddingRange struct {
+	min, max int64
+}
+
+func (p paddingRange) String() string {
+	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	b := make([]byte, p.len())
+	for i := range b {
+		b[i] = letters[randInt(int64(len(letters)))]
+	}
+	return string(b)
+}
+
+func (p paddingRange) len() int {
+	return int(p.min) + randInt(p.max-p.min)
+}
+
+func randInt(n int64) int {
+	i, err := rand.Int(rand.Reader, big.NewInt(n))
+	if err != nil {
+		return 0
+	}
+	return int(i.Int64())
+}
+
+// WriteTCPRequest writes the request opening a stream to addr, host:port
+//
+//	[varint 0x401][varint address length][address][varint padding length][padding]
+func WriteTCPRequest(w io.Writer, addr string) error {
+	if len(addr) > maxAddressLength {
+		return fmt.Errorf("address %s is too long", addr)
+	}
+
+	padding := tcpRequestPadding.String()
+	buf := &bytes.Buffer{}
+	quicvarint.Write(buf, frameTypeTCPRequest)
+	quicvarint.Write(buf, uint64(len(addr)))
+	buf.WriteString(addr)
+	quicvarint.Write(buf, uint64(len(padding)))
+	buf.WriteString(padding)
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// ReadTCPResponse reads the answer to a TCP request, a refusal comes back
+// as an error with the message of the server
+//
+//	[uint8 status][varint message length][message][varint padding length][padding]
+func ReadTCPResponse(r *bufio.Reader) error {
+	status, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	msgLen, err := quicvarint.Read(r)
+	if err != nil {
+		return err
+	}
+	if msgLen > maxMessageLength {
+		return errors.New("invalid message length")
+	}
+	msg := make([]byte, msgLen)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return err
+	}
+
+	paddingLen, err := quicvarint.Read(r)
+	if err != nil {
+		return err
+	}
+	if paddingLen > maxPaddingLength {
+		return errors.New("invalid padding length")
+	}
+	if _, err := r.Discard(int(paddingLen)); err != nil {
+		return err
+	}
+
+	if status != tcpStatusOK {
+		return fmt.Errorf("server refused: %s", msg)
+	}
+	return nil
+}
+
+// UDPMessage is the datagram of a UDP session
+//
+//	[uint32 session id][uint16 packet id][uint8 fragment id][uint8 fragment count]
+//	[varint address length][address][payload]
+type UDPMessage struct {
+	SessionID uint32
+	PacketID  uint16
+	FragID    uint8
+	FragCount uint8
+	Addr      string
+	Data      []byte
+}
+
+func (m *UDPMessage) headerSize() int {
+	return 4 + 2 + 1 + 1 + int(quicvarint.Len(uint64(len(m.Addr)))) + len(m.Addr)
+}
+
+func (m *UDPMessage) Size() int {
+	return m.headerSize() + len(m.Data)
+}
+
+func (m *UDPMessage) Bytes() []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, m.Size()))
+	binary.Write(buf, binary.BigEndian, m.SessionID)
+	binary.Write(buf, binary.BigEndian, m.PacketID)
+	buf.WriteByte(m.FragID)
+	buf.WriteByte(m.FragCount)
+	quicvarint.Write(buf, uint64(len(m.Addr)))
+	buf.WriteString(m.Addr)
+	buf.Write(m.Data)
+	return buf.Bytes()
+}
+
+func ParseUDPMessage(b []byte) (*UDPMessage, error) {
+	if len(b) < 8 {
+		return nil, errors.New("udp message too short")
+	}
+
+	m := &UDPMessage{
+		SessionID: binary.BigEndian.Uint32(b),
+		PacketID:  binary.BigEndian.Uint16(b[4:]),
+		FragID:    b[6],
+		FragCount: b[7],
+	}
+	r := bytes.NewReader(b[8:])
+	addrLen, err := quicvarint.Read(r)
+	if err != nil {
+		return nil, err
+	}
+	if addrLen == 0 || addrLen > maxAddressLength || int(addrLen) > r.Len() {
+		return nil, errors.New("invalid address length")
+	}
+	rest := b[len(b)-r.Len():]
+	m.Addr = string(rest[:addrLen])
+	m.Data = rest[addrLen:]
+	return m, nil
+}
+
+// fragUDPMessage splits m into messages of at most maxSize bytes, all with
+// the address and a new packet id. A message that fits comes back as is.
+func fragUDPMessage(m *UDPMessage, maxSize int) []*UDPMessage {
+	if m.Size() <= maxSize {
+		return []*UDPMessage{m}
+	}
+
+	maxPayload := maxSize - m.headerSize()
+	count := (len(m.Data) + maxPayload - 1) / maxPayload
+	frags := make([]*UDPMessage, 0, count)
+	packetID := uint16(randInt(0xffff)) + 1
+	for i, data := 0, m.Data; len(data) > 0; i++ {
+		size := maxPayload
+		if size > len(data) {
+			size = len(data)
+		}
+		frags = append(frags, &UDPMessage{
+			SessionID: m.SessionID,
+			PacketID:  packetID,
+			FragID:    uint8(i),
+			FragCount: uint8(count),
+			Addr:      m.Addr,
+			Data:      data[:size],
+		})
+		data = data[size:]
+	}
+	return frags
+}
+
+// defragger puts a fragmented packet back together. It follows one packet
+// at a time as Hysteria does, a fragment of another one starts over.
+type defragger struct {
+	packetID uint16
+	frags    []*UDPMessage
+	count    int
+	size     int
+}
+
+// feed returns the whole message once its last fragment is in
+func (d *defragger) feed(m *UDPMessage) *UDPMessage {
+	if m.FragCount <= 1 {
+		return m
+	}
+	if m.FragID >= m.FragCount {
+		return nil
+	}
+
+	if m.PacketID != d.packetID || len(d.frags) != int(m.FragCount) {
+		d.packetID = m.PacketID
+		d.frags = make([]*UDPMessage, m.FragCount)
+		d.count = 0
+		d.size = 0
+	}
+	if d.frags[m.FragID] != nil {
+		return nil
+	}
+	d.frags[m.FragID] = m
+	d.count++
+	d.size += len(m.Data)
+	if d.count != len(d.frags) {
+		return nil
+	}
+
+	data := make([]byte, 0, d.size)
+	for _, frag := range d.frags {
+		data = append(data, frag.Data...)
+	}
+	whole := *d.frags[0]
+	whole.FragID, whole.FragCount, whole.Data = 0, 1, data
+	d.frags = nil
+	return &whole
+}
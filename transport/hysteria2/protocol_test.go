@@ -0,0 +1,119 @@
+package hysteria2
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/lucas-clemente/quic-go/quicvarint"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTCPResponse(buf *bytes.Buffer, status byte, msg string) {
+	buf.WriteByte(status)
+	quicvarint.Write(buf, uint64(len(msg)))
+	buf.WriteString(msg)
+	quicvarint.Write(buf, 3)
+	buf.WriteString("pad")
+}
+
+func TestTCPRequest(t *testing.T) {
+	buf := &bytes.Buffer{}
+	assert.Nil(t, WriteTCPRequest(buf, "example.com:443"))
+
+	r := bufio.NewReader(buf)
+	frameType, err := quicvarint.Read(r)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(frameTypeTCPRequest), frameType)
+
+	addrLen, err := quicvarint.Read(r)
+	assert.Nil(t, err)
+	addr := make([]byte, addrLen)
+	r.Read(addr)
+	assert.Equal(t, "example.com:443", string(addr))
+
+	paddingLen, err := quicvarint.Read(r)
+	assert.Nil(t, err)
+	assert.True(t, paddingLen >= 64 && paddingLen < 512)
+	assert.Equal(t, int(paddingLen), r.Buffered())
+
+	assert.NotNil(t, WriteTCPRequest(buf, string(make([]byte, maxAddressLength+1))))
+}
+
+func TestTCPResponse(t *testing.T) {
+	buf := &bytes.Buffer{}
+	writeTCPResponse(buf, tcpStatusOK, "")
+	buf.WriteString("data")
+
+	r := bufio.NewReader(buf)
+	assert.Nil(t, ReadTCPResponse(r))
+	rest, _ := r.Peek(4)
+	assert.Equal(t, "data", string(rest))
+
+	buf.Reset()
+	writeTCPResponse(buf, 1, "blocked")
+	err := ReadTCPResponse(bufio.NewReader(buf))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "blocked")
+}
+
+func TestUDPMessage(t *testing.T) {
+	m := &UDPMessage{
+		SessionID: 7,
+		PacketID:  3,
+		FragCount: 1,
+		Addr:      "1.1.1.1:53",
+		Data:      []byte("query"),
+	}
+	b := m.Bytes()
+	assert.Equal(t, m.Size(), len(b))
+
+	parsed, err := ParseUDPMessage(b)
+	assert.Nil(t, err)
+	assert.Equal(t, m, parsed)
+
+	_, err = ParseUDPMessage(b[:7])
+	assert.NotNil(t, err)
+	_, err = ParseUDPMessage(b[:10])
+	assert.NotNil(t, err)
+}
+
+func TestUDPFragment(t *testing.T) {
+	m := &UDPMessage{
+		SessionID: 1,
+		FragCount: 1,
+		Addr:      (&net.UDPAddr{IP: net.IPv4(8, 8, 8, 8), Port: 53}).String(),
+		Data:      bytes.Repeat([]byte("0123456789"), 300),
+	}
+	assert.Len(t, fragUDPMessage(m, 4000), 1)
+
+	frags := fragUDPMessage(m, 1000)
+	assert.Len(t, frags, 4)
+	for _, frag := range frags {
+		assert.True(t, frag.Size() <= 1000)
+		assert.Equal(t, frags[0].PacketID, frag.PacketID)
+	}
+
+	d := &defragger{}
+	// out of order, and a stray fragment of another packet in between
+	assert.Nil(t, d.feed(frags[1]))
+	assert.Nil(t, d.feed(frags[0]))
+	assert.Nil(t, d.feed(frags[0]))
+	assert.Nil(t, d.feed(frags[3]))
+	whole := d.feed(frags[2])
+	if assert.NotNil(t, whole) {
+		assert.Equal(t, m.Data, whole.Data)
+		assert.Equal(t, m.Addr, whole.Addr)
+		assert.Equal(t, uint8(1), whole.FragCount)
+	}
+
+	other := *frags[0]
+	other.PacketID++
+	assert.Nil(t, d.feed(frags[0]))
+	assert.Nil(t, d.feed(&other))
+	for _, frag := range frags[1:] {
+		assert.Nil(t, d.feed(frag))
+	}
+}
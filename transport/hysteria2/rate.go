@@ -0,0 +1,42 @@
+package hysteria2
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// rateBurst is how much sending time an idle conn saves up
+const rateBurst = 10 * time.Millisecond
+
+// RateLimitedPacketConn holds the packets written under rate bytes per
+// second, as Hysteria's Brutal does on the client side. QUIC's congestion
+// control still slows it down below the rate.
+type RateLimitedPacketConn struct {
+	net.PacketConn
+	rate uint64
+
+	mux sync.Mutex
+	// next is when the packets written so far are all sent at the rate
+	next time.Time
+}
+
+func NewRateLimitedPacketConn(pc net.PacketConn, rate uint64) *RateLimitedPacketConn {
+	return &RateLimitedPacketConn{PacketConn: pc, rate: rate}
+}
+
+func (c *RateLimitedPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.mux.Lock()
+	now := time.Now()
+	if earliest := now.Add(-rateBurst); c.next.Before(earliest) {
+		c.next = earliest
+	}
+	c.next = c.next.Add(time.Duration(uint64(len(b)) * uint64(time.Second) / c.rate))
+	wait := c.next.Sub(now)
+	c.mux.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+	return c.PacketConn.WriteTo(b, addr)
+}
@@ -0,0 +1,36 @@
+package hysteria2
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimitedPacketConn(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer server.Close()
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer client.Close()
+
+	// 20 packets at 100 KB/s take 200ms, less the burst
+	pc := NewRateLimitedPacketConn(client, 100*1000)
+	packet := make([]byte, 1000)
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		_, err := pc.WriteTo(packet, server.LocalAddr())
+		assert.Nil(t, err)
+	}
+	elapsed := time.Since(start)
+	assert.True(t, elapsed >= 200*time.Millisecond-rateBurst, elapsed)
+	assert.True(t, elapsed < time.Second, elapsed)
+
+	// an idle conn sends its burst right away
+	time.Sleep(rateBurst)
+	start = time.Now()
+	pc.WriteTo(packet, server.LocalAddr())
+	assert.True(t, time.Since(start) < rateBurst)
+}
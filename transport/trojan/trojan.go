@@ -11,7 +11,9 @@ import (
 	"net"
 	"sync"
 
+	"github.com/Dreamacro/clash/component/fingerprint"
 	"github.com/Dreamacro/clash/transport/socks5"
+	utls "github.com/refraction-networking/utls"
 	xtls "github.com/xtls/go"
 )
 
@@ -19,8 +21,8 @@ const (
 	// max packet length
 	maxLength = 8192
 
-	XRO = "xtls-rprx-origin"
-	XRD = "xtls-rprx-direct"
+	XRO  = "xtls-rprx-origin"
+	XRD  = "xtls-rprx-direct"
 	XROU = "xtls-rprx-origin-udp443"
 	XRDU = "xtls-rprx-direct-udp443"
 )
@@ -43,11 +45,14 @@ var (
 )
 
 type Option struct {
-	Password            string
-	Flow                string
-	ALPN                []string
-	ServerName          string
-	SkipCertVerify      bool
+	Password       string
+	Flow           string
+	ALPN           []string
+	ServerName     string
+	SkipCertVerify bool
+	// ClientFingerprint, if not nil, makes the handshake with uTLS
+	ClientFingerprint  *utls.ClientHelloID
+	ClientSessionCache utls.ClientSessionCache
 }
 
 type Trojan struct {
@@ -86,6 +91,17 @@ func (t *Trojan) StreamConn(conn net.Conn) (net.Conn, error) {
 			InsecureSkipVerify: t.option.SkipCertVerify,
 			ServerName:         t.option.ServerName,
 		}
+		if t.option.ClientFingerprint != nil {
+			uconfig := fingerprint.Config(tlsConfig)
+			uconfig.ClientSessionCache = t.option.ClientSessionCache
+			uconn, err := fingerprint.Handshake(conn, uconfig, *t.option.ClientFingerprint, alpn)
+			if err != nil {
+				return nil, err
+			}
+
+			return uconn, nil
+		}
+
 		tlsConn := tls.Client(conn, tlsConfig)
 		if err := tlsConn.Handshake(); err != nil {
 			return nil, err
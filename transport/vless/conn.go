@@ -4,22 +4,51 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
-	"io/ioutil"
 	"net"
+	"syscall"
 
 	"github.com/Dreamacro/clash/transport/vmess"
 	"github.com/gofrs/uuid"
 	xtls "github.com/xtls/go"
+	"google.golang.org/protobuf/encoding/protowire"
 	"google.golang.org/protobuf/proto"
 )
 
+// ErrVlessAuthRejected is returned by the first Read of a Conn when the
+// server closes or resets the connection before sending any response.
+//
+// VLESS servers don't answer an unknown UUID with an error, they just drop
+// the connection (or hand it to a fallback), so this almost always means the
+// UUID is wrong or has been removed on the server side rather than a network
+// failure.
+var ErrVlessAuthRejected = errors.New("vless server rejected the request, check the uuid")
+
+// HandshakeWatcher is implemented by a conn under a Conn which wants to know
+// when the response header of the server has been read
+type HandshakeWatcher interface {
+	HandshakeDone()
+}
+
 type Conn struct {
 	net.Conn
 	dst      *vmess.DstAddr
 	id       *uuid.UUID
 	addons   *Addons
+	padTo    int
 	received bool
+
+	// downlinkPadTo is what the server pads its UDP frames to, known with
+	// the response
+	downlinkPadTo int
+}
+
+// DownlinkPadTo is the size the server pads its UDP frames to. It's zero
+// until the response is read, and stays so unless the server acknowledged
+// the udp padding with the same addon.
+func (vc *Conn) DownlinkPadTo() int {
+	return vc.downlinkPadTo
 }
 
 func (vc *Conn) Read(b []byte) (int, error) {
@@ -31,6 +60,9 @@ func (vc *Conn) Read(b []byte) (int, error) {
 		return 0, err
 	}
 	vc.received = true
+	if w, ok := vc.Conn.(HandshakeWatcher); ok {
+		w.HandshakeDone()
+	}
 	return vc.Conn.Read(b)
 }
 
@@ -39,11 +71,14 @@ func (vc *Conn) sendRequest() error {
 
 	buf.WriteByte(Version)   // protocol version
 	buf.Write(vc.id.Bytes()) // 16 bytes of uuid
-	if vc.addons != nil {
+	if vc.addons != nil || vc.padTo > 0 {
 		bytes, err := proto.Marshal(vc.addons)
 		if err != nil {
 			return err
 		}
+		if vc.padTo > 0 {
+			bytes = appendUDPPadTo(bytes, vc.padTo)
+		}
 
 		buf.WriteByte(byte(len(bytes)))
 		buf.Write(bytes)
@@ -52,16 +87,21 @@ func (vc *Conn) sendRequest() error {
 	}
 
 	// command
-	if vc.dst.UDP {
+	switch {
+	case vc.dst == nil:
+		buf.WriteByte(CommandMux)
+	case vc.dst.UDP:
 		buf.WriteByte(vmess.CommandUDP)
-	} else {
+	default:
 		buf.WriteByte(vmess.CommandTCP)
 	}
 
 	// Port AddrType Addr
-	binary.Write(buf, binary.BigEndian, uint16(vc.dst.Port))
-	buf.WriteByte(vc.dst.AddrType)
-	buf.Write(vc.dst.Addr)
+	if vc.dst != nil {
+		binary.Write(buf, binary.BigEndian, uint16(vc.dst.Port))
+		buf.WriteByte(vc.dst.AddrType)
+		buf.Write(vc.dst.Addr)
+	}
 
 	_, err := vc.Conn.Write(buf.Bytes())
 	return err
@@ -72,6 +112,9 @@ func (vc *Conn) recvResponse() error {
 	buf := make([]byte, 1)
 	_, err = io.ReadFull(vc.Conn, buf)
 	if err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET) {
+			return fmt.Errorf("%w: %s", ErrVlessAuthRejected, err.Error())
+		}
 		return err
 	}
 
@@ -84,22 +127,60 @@ func (vc *Conn) recvResponse() error {
 		return err
 	}
 
-	length := int64(buf[0])
+	length := int(buf[0])
 	if length != 0 { // addon data length > 0
-		io.CopyN(ioutil.Discard, vc.Conn, length) // just discard
+		addons := make([]byte, length)
+		if _, err = io.ReadFull(vc.Conn, addons); err != nil {
+			return err
+		}
+		if vc.padTo > 0 {
+			vc.downlinkPadTo = udpPadToOf(addons)
+		}
 	}
 
 	return nil
 }
 
-// newConn return a Conn instance
+// udpPadToOf returns the UDP padding size of marshaled Addons, zero if it
+// has none
+func udpPadToOf(b []byte) int {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return 0
+		}
+		b = b[n:]
+		if num == addonUDPPadTo && typ == protowire.VarintType {
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 || v > maxUDPPadTo {
+				return 0
+			}
+			return int(v)
+		}
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return 0
+		}
+		b = b[n:]
+	}
+	return 0
+}
+
+// appendUDPPadTo appends the UDP padding size to marshaled Addons. It isn't
+// part of config.proto, servers without support skip it as an unknown field.
+func appendUDPPadTo(b []byte, padTo int) []byte {
+	b = protowire.AppendTag(b, addonUDPPadTo, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(padTo))
+}
+
+// newConn return a Conn instance, a nil dst requests a mux.cool session
 func newConn(conn net.Conn, client *Client, dst *vmess.DstAddr) (*Conn, error) {
 	c := &Conn{
 		id:   client.UUID,
 		Conn: conn,
 		dst:  dst,
 	}
-	if !dst.UDP && client.Addons != nil {
+	if dst != nil && !dst.UDP && client.Addons != nil {
 		switch client.Addons.Flow {
 		case XRO, XRD, XRS, XRSU, XROU, XRDU:
 			if xtlsConn, ok := conn.(*xtls.Conn); ok {
@@ -113,8 +194,15 @@ func newConn(conn net.Conn, client *Client, dst *vmess.DstAddr) (*Conn, error) {
 					xtlsConn.DirectMode = true
 				}
 			}
+		case XRV:
+			// vision pads inside the vless stream. UDP requests go without
+			// the flow and keep the plain length framing, as with Xray
+			c.addons = client.Addons
 		}
 	}
+	if dst != nil && dst.UDP {
+		c.padTo = client.UDPPadTo
+	}
 	if err := c.sendRequest(); err != nil {
 		return nil, err
 	}
@@ -0,0 +1,58 @@
+package vless
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/Dreamacro/clash/transport/vmess"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testUUID = "b831381d-6324-4d53-ad4f-8cda48b30811"
+
+func newTestConn(t *testing.T, server func(net.Conn)) net.Conn {
+	client, err := NewClient(testUUID, nil)
+	assert.Nil(t, err)
+
+	local, remote := net.Pipe()
+	go func() {
+		defer remote.Close()
+		server(remote)
+	}()
+
+	conn, err := client.StreamConn(local, &vmess.DstAddr{
+		AddrType: vmess.AtypDomainName,
+		Addr:     append([]byte{byte(len("example.com"))}, "example.com"...),
+		Port:     443,
+	})
+	assert.Nil(t, err)
+	return conn
+}
+
+func TestConn_AuthRejected(t *testing.T) {
+	conn := newTestConn(t, func(c net.Conn) {
+		// read the request, then hang up like a server with an unknown uuid
+		c.Read(make([]byte, 1024))
+	})
+	defer conn.Close()
+
+	_, err := conn.Read(make([]byte, 16))
+	assert.True(t, errors.Is(err, ErrVlessAuthRejected))
+}
+
+func TestConn_Response(t *testing.T) {
+	conn := newTestConn(t, func(c net.Conn) {
+		c.Read(make([]byte, 1024))
+		c.Write([]byte{Version, 0})
+		c.Write([]byte("hello"))
+	})
+	defer conn.Close()
+
+	buf := make([]byte, 5)
+	_, err := io.ReadFull(conn, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(buf))
+}
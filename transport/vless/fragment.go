@@ -0,0 +1,145 @@
+package vless
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fragment is one segment of a fragment pattern
+type Fragment struct {
+	Size  int           // bytes written in this segment
+	Delay time.Duration // pause before the next segment
+	Hello bool          // the segment runs to the end of the TLS ClientHello record
+}
+
+// ParseFragmentPattern parses a comma separated list of segment sizes, each
+// optionally followed by ":<delay in ms>". The token "tlshello" stands for
+// the rest of the ClientHello record, e.g. "1,40,tlshello:10".
+func ParseFragmentPattern(pattern string) ([]Fragment, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+
+	var fragments []Fragment
+	for _, token := range strings.Split(pattern, ",") {
+		token = strings.TrimSpace(token)
+		size, delay, hasDelay := token, "", false
+		if idx := strings.IndexByte(token, ':'); idx != -1 {
+			size, delay, hasDelay = token[:idx], token[idx+1:], true
+		}
+
+		f := Fragment{}
+		if size == "tlshello" {
+			f.Hello = true
+		} else {
+			n, err := strconv.Atoi(size)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid fragment size: %s", token)
+			}
+			f.Size = n
+		}
+
+		if hasDelay {
+			ms, err := strconv.Atoi(delay)
+			if err != nil || ms < 0 {
+				return nil, fmt.Errorf("invalid fragment delay: %s", token)
+			}
+			f.Delay = time.Duration(ms) * time.Millisecond
+		}
+		fragments = append(fragments, f)
+	}
+	return fragments, nil
+}
+
+// fragmentConn writes the first bytes of the stream in the segments of a
+// pattern, everything after goes out unchanged.
+type fragmentConn struct {
+	net.Conn
+	pattern  []Fragment
+	resolved bool
+	mux      sync.Mutex
+}
+
+// NewFragmentConn returns a conn that splits the leading bytes written to
+// conn according to pattern
+func NewFragmentConn(conn net.Conn, pattern []Fragment) net.Conn {
+	if len(pattern) == 0 {
+		return conn
+	}
+
+	return &fragmentConn{
+		Conn:    conn,
+		pattern: append([]Fragment(nil), pattern...),
+	}
+}
+
+// resolve turns tlshello segments into sizes once the start of the stream
+// is known. Without a ClientHello they are dropped.
+func (c *fragmentConn) resolve(b []byte) {
+	c.resolved = true
+
+	helloEnd := 0
+	if len(b) >= 5 && b[0] == 0x16 {
+		helloEnd = 5 + (int(b[3])<<8 | int(b[4]))
+	}
+
+	offset := 0
+	for i := range c.pattern {
+		if c.pattern[i].Hello {
+			c.pattern[i].Size = helloEnd - offset
+			if c.pattern[i].Size < 0 {
+				c.pattern[i].Size = 0
+			}
+		}
+		offset += c.pattern[i].Size
+	}
+}
+
+func (c *fragmentConn) Write(b []byte) (n int, err error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if !c.resolved {
+		c.resolve(b)
+	}
+
+	for len(b) > 0 && len(c.pattern) > 0 {
+		f := &c.pattern[0]
+		if f.Size == 0 {
+			c.pattern = c.pattern[1:]
+			continue
+		}
+
+		size := f.Size
+		if size > len(b) {
+			size = len(b)
+		}
+
+		var nn int
+		nn, err = c.Conn.Write(b[:size])
+		n += nn
+		if err != nil {
+			return
+		}
+		b = b[size:]
+
+		f.Size -= size
+		if f.Size == 0 {
+			if f.Delay > 0 {
+				time.Sleep(f.Delay)
+			}
+			c.pattern = c.pattern[1:]
+		}
+	}
+
+	if len(b) > 0 {
+		var nn int
+		nn, err = c.Conn.Write(b)
+		n += nn
+	}
+	return
+}
@@ -0,0 +1,68 @@
+package vless
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordConn keeps every Write as its own segment
+type recordConn struct {
+	net.Conn
+	writes [][]byte
+}
+
+func (c *recordConn) Write(b []byte) (int, error) {
+	c.writes = append(c.writes, append([]byte(nil), b...))
+	return len(b), nil
+}
+
+func TestParseFragmentPattern(t *testing.T) {
+	fragments, err := ParseFragmentPattern("1, 40:5,tlshello:10")
+	assert.Nil(t, err)
+	assert.Equal(t, []Fragment{
+		{Size: 1},
+		{Size: 40, Delay: 5 * time.Millisecond},
+		{Hello: true, Delay: 10 * time.Millisecond},
+	}, fragments)
+
+	fragments, err = ParseFragmentPattern("")
+	assert.Nil(t, err)
+	assert.Nil(t, fragments)
+
+	for _, pattern := range []string{"0", "a", "1,", "1:x", "1:-1", "tlshello:"} {
+		_, err := ParseFragmentPattern(pattern)
+		assert.NotNil(t, err, pattern)
+	}
+}
+
+func TestFragmentConn_ClientHello(t *testing.T) {
+	pattern, err := ParseFragmentPattern("1,3,tlshello")
+	assert.Nil(t, err)
+
+	// 5 byte record header announcing 10 bytes, followed by another record
+	hello := append([]byte{0x16, 0x03, 0x01, 0x00, 0x0a}, make([]byte, 10)...)
+	data := append(hello, 0x17, 0x03, 0x03, 0x00, 0x01, 0xff)
+
+	rc := &recordConn{}
+	n, err := NewFragmentConn(rc, pattern).Write(data)
+	assert.Nil(t, err)
+	assert.Equal(t, len(data), n)
+	assert.Equal(t, [][]byte{data[:1], data[1:4], data[4:15], data[15:]}, rc.writes)
+}
+
+func TestFragmentConn_AcrossWrites(t *testing.T) {
+	pattern, err := ParseFragmentPattern("2,tlshello")
+	assert.Nil(t, err)
+
+	rc := &recordConn{}
+	conn := NewFragmentConn(rc, pattern)
+	conn.Write([]byte("a"))
+	conn.Write([]byte("bcd"))
+	conn.Write([]byte("ef"))
+
+	// not a ClientHello, tlshello is dropped
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b"), []byte("cd"), []byte("ef")}, rc.writes)
+}
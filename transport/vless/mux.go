@@ -0,0 +1,399 @@
+package vless
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	N "github.com/Dreamacro/clash/common/net"
+	"github.com/Dreamacro/clash/transport/vmess"
+)
+
+// CommandMux asks the server for a mux.cool session. The request carries no
+// address, every stream names its own in the frames.
+const CommandMux byte = 3
+
+// mux.cool frame status
+const (
+	muxStatusNew       byte = 1
+	muxStatusKeep      byte = 2
+	muxStatusEnd       byte = 3
+	muxStatusKeepAlive byte = 4
+)
+
+// mux.cool frame options
+const (
+	muxOptionData  byte = 1
+	muxOptionError byte = 2
+)
+
+const (
+	muxNetworkTCP byte = 1
+	muxNetworkUDP byte = 2
+
+	// payload of a frame, what Xray writes
+	muxMaxPayload = 8192
+	// a session without streams for this long is closed, as with Xray
+	muxIdleTimeout = 16 * time.Second
+	// data frames queued for a stream nobody reads yet
+	muxStreamBuffer = 16
+)
+
+// ErrMuxSessionClosed is returned by the streams of a closed MuxSession.
+var ErrMuxSessionClosed = errors.New("mux session closed")
+
+// MuxSession runs many streams over one vless conn with mux.cool framing. A
+// frame is
+//
+//	[meta length][id][status][option](network port address)[data length][data]
+//
+// where the address comes with New frames only and the data with the data
+// option set.
+type MuxSession struct {
+	conn     net.Conn
+	writeMux sync.Mutex
+
+	mux       sync.Mutex
+	streams   map[uint16]*MuxStream
+	nextID    uint16
+	idleTimer *time.Timer
+	err       error
+	done      chan struct{}
+}
+
+// NewMuxSession starts a session over conn, a vless conn made with
+// Client.StreamMuxConn.
+func NewMuxSession(conn net.Conn) *MuxSession {
+	s := &MuxSession{
+		conn:    conn,
+		streams: map[uint16]*MuxStream{},
+		done:    make(chan struct{}),
+	}
+	go s.recvLoop()
+	return s
+}
+
+// NumStreams returns the number of open streams.
+func (s *MuxSession) NumStreams() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return len(s.streams)
+}
+
+// IsClosed reports whether the session is gone, with the conn under it.
+func (s *MuxSession) IsClosed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close closes the session and every stream in it.
+func (s *MuxSession) Close() error {
+	s.closeWithError(ErrMuxSessionClosed)
+	return nil
+}
+
+func (s *MuxSession) closeWithError(err error) {
+	s.mux.Lock()
+	closed := s.closeLocked(err)
+	s.mux.Unlock()
+
+	if closed {
+		s.conn.Close()
+	}
+}
+
+func (s *MuxSession) closeLocked(err error) bool {
+	if s.err != nil {
+		return false
+	}
+	s.err = err
+	if s.idleTimer != nil {
+		s.idleTimer.Stop()
+	}
+	close(s.done)
+	return true
+}
+
+// OpenStream starts a stream to dst.
+func (s *MuxSession) OpenStream(dst *vmess.DstAddr) (*MuxStream, error) {
+	s.mux.Lock()
+	if s.err != nil {
+		s.mux.Unlock()
+		return nil, s.err
+	}
+	if s.idleTimer != nil {
+		s.idleTimer.Stop()
+		s.idleTimer = nil
+	}
+
+	id := s.nextID
+	for {
+		id++
+		if _, ok := s.streams[id]; id != 0 && !ok {
+			break
+		}
+	}
+	s.nextID = id
+
+	st := &MuxStream{
+		session:      s,
+		id:           id,
+		ch:           make(chan []byte, muxStreamBuffer),
+		closed:       make(chan struct{}),
+		readDeadline: N.MakePipeDeadline(),
+	}
+	s.streams[id] = st
+	s.mux.Unlock()
+
+	network := muxNetworkTCP
+	if dst.UDP {
+		network = muxNetworkUDP
+	}
+	meta := bytes.NewBuffer(muxMeta(id, muxStatusNew, 0))
+	meta.WriteByte(network)
+	binary.Write(meta, binary.BigEndian, uint16(dst.Port))
+	meta.WriteByte(dst.AddrType)
+	meta.Write(dst.Addr)
+
+	if err := s.writeFrame(meta.Bytes(), nil); err != nil {
+		s.removeStream(id)
+		return nil, err
+	}
+	return st, nil
+}
+
+func muxMeta(id uint16, status, option byte) []byte {
+	return []byte{byte(id >> 8), byte(id), status, option}
+}
+
+// writeFrame writes a frame, with data if the meta has the data option.
+// A failed write takes the session down.
+func (s *MuxSession) writeFrame(meta, data []byte) error {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.BigEndian, uint16(len(meta)))
+	buf.Write(meta)
+	if meta[3]&muxOptionData != 0 {
+		binary.Write(buf, binary.BigEndian, uint16(len(data)))
+		buf.Write(data)
+	}
+
+	s.writeMux.Lock()
+	_, err := s.conn.Write(buf.Bytes())
+	s.writeMux.Unlock()
+	if err != nil {
+		s.closeWithError(err)
+	}
+	return err
+}
+
+func (s *MuxSession) stream(id uint16) *MuxStream {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.streams[id]
+}
+
+// removeStream forgets a stream, it reports whether it was still there.
+// The last one gone arms the idle timer.
+func (s *MuxSession) removeStream(id uint16) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if _, ok := s.streams[id]; !ok {
+		return false
+	}
+	delete(s.streams, id)
+
+	if len(s.streams) == 0 && s.err == nil {
+		var timer *time.Timer
+		timer = time.AfterFunc(muxIdleTimeout, func() {
+			// a stream opened meanwhile cleared the timer
+			s.mux.Lock()
+			closed := s.idleTimer == timer && len(s.streams) == 0 && s.closeLocked(ErrMuxSessionClosed)
+			s.mux.Unlock()
+
+			if closed {
+				s.conn.Close()
+			}
+		})
+		s.idleTimer = timer
+	}
+	return true
+}
+
+func (s *MuxSession) recvLoop() {
+	err := s.recv()
+	s.closeWithError(err)
+
+	s.mux.Lock()
+	streams := s.streams
+	s.streams = map[uint16]*MuxStream{}
+	s.mux.Unlock()
+	for _, st := range streams {
+		st.readErr = err
+		close(st.ch)
+	}
+}
+
+func (s *MuxSession) recv() error {
+	r := bufio.NewReader(s.conn)
+	for {
+		var metaLen uint16
+		if err := binary.Read(r, binary.BigEndian, &metaLen); err != nil {
+			return err
+		}
+		if metaLen < 4 {
+			return fmt.Errorf("invalid mux frame meta length %d", metaLen)
+		}
+		meta := make([]byte, metaLen)
+		if _, err := io.ReadFull(r, meta); err != nil {
+			return err
+		}
+		id := binary.BigEndian.Uint16(meta)
+		status, option := meta[2], meta[3]
+
+		var data []byte
+		if option&muxOptionData != 0 {
+			var dataLen uint16
+			if err := binary.Read(r, binary.BigEndian, &dataLen); err != nil {
+				return err
+			}
+			data = make([]byte, dataLen)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return err
+			}
+		}
+
+		switch status {
+		case muxStatusKeep:
+			st := s.stream(id)
+			if st == nil {
+				// closed here already, tell the server again
+				if err := s.writeFrame(muxMeta(id, muxStatusEnd, 0), nil); err != nil {
+					return err
+				}
+				continue
+			}
+			if len(data) != 0 {
+				select {
+				case st.ch <- data:
+				case <-st.closed:
+				case <-s.done:
+					return ErrMuxSessionClosed
+				}
+			}
+		case muxStatusEnd:
+			if st := s.stream(id); st != nil && s.removeStream(id) {
+				st.readErr = io.EOF
+				if option&muxOptionError != 0 {
+					st.readErr = io.ErrUnexpectedEOF
+				}
+				close(st.ch)
+			}
+		case muxStatusNew, muxStatusKeepAlive:
+			// servers don't open streams, nothing to do for either
+		default:
+			return fmt.Errorf("unknown mux frame status %d", status)
+		}
+	}
+}
+
+// MuxStream is a stream of a MuxSession. Deadlines apply to reads only,
+// writes share the conn with the other streams.
+type MuxStream struct {
+	session *MuxSession
+	id      uint16
+
+	// closed by the receiving loop once readErr is set
+	ch      chan []byte
+	readErr error
+	buf     []byte
+
+	closed       chan struct{}
+	closeOnce    sync.Once
+	readDeadline *N.PipeDeadline
+}
+
+func (st *MuxStream) Read(b []byte) (int, error) {
+	if len(st.buf) == 0 {
+		select {
+		case data, ok := <-st.ch:
+			if !ok {
+				return 0, st.readErr
+			}
+			st.buf = data
+		case <-st.closed:
+			return 0, io.ErrClosedPipe
+		case <-st.readDeadline.Wait():
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+
+	n := copy(b, st.buf)
+	st.buf = st.buf[n:]
+	return n, nil
+}
+
+func (st *MuxStream) Write(b []byte) (int, error) {
+	select {
+	case <-st.closed:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+
+	n := 0
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > muxMaxPayload {
+			chunk = chunk[:muxMaxPayload]
+		}
+		if err := st.session.writeFrame(muxMeta(st.id, muxStatusKeep, muxOptionData), chunk); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+		b = b[len(chunk):]
+	}
+	return n, nil
+}
+
+// Close ends the stream on both sides, the session stays.
+func (st *MuxStream) Close() (err error) {
+	st.closeOnce.Do(func() {
+		close(st.closed)
+		if st.session.removeStream(st.id) {
+			err = st.session.writeFrame(muxMeta(st.id, muxStatusEnd, 0), nil)
+		}
+	})
+	return
+}
+
+func (st *MuxStream) LocalAddr() net.Addr {
+	return st.session.conn.LocalAddr()
+}
+
+func (st *MuxStream) RemoteAddr() net.Addr {
+	return st.session.conn.RemoteAddr()
+}
+
+func (st *MuxStream) SetDeadline(t time.Time) error {
+	return st.SetReadDeadline(t)
+}
+
+func (st *MuxStream) SetReadDeadline(t time.Time) error {
+	st.readDeadline.Set(t)
+	return nil
+}
+
+func (st *MuxStream) SetWriteDeadline(t time.Time) error {
+	return nil
+}
@@ -0,0 +1,150 @@
+package vless
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/Dreamacro/clash/transport/vmess"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type muxFrame struct {
+	id     uint16
+	status byte
+	meta   []byte
+	data   []byte
+}
+
+func readMuxFrame(r io.Reader) (*muxFrame, error) {
+	var metaLen uint16
+	if err := binary.Read(r, binary.BigEndian, &metaLen); err != nil {
+		return nil, err
+	}
+	meta := make([]byte, metaLen)
+	if _, err := io.ReadFull(r, meta); err != nil {
+		return nil, err
+	}
+
+	f := &muxFrame{id: binary.BigEndian.Uint16(meta), status: meta[2], meta: meta}
+	if meta[3]&muxOptionData != 0 {
+		var dataLen uint16
+		if err := binary.Read(r, binary.BigEndian, &dataLen); err != nil {
+			return nil, err
+		}
+		f.data = make([]byte, dataLen)
+		if _, err := io.ReadFull(r, f.data); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func writeMuxFrame(w io.Writer, id uint16, status byte, data []byte) {
+	option := byte(0)
+	if data != nil {
+		option = muxOptionData
+	}
+	frame := []byte{0, 4, byte(id >> 8), byte(id), status, option}
+	if data != nil {
+		frame = append(frame, byte(len(data)>>8), byte(len(data)))
+		frame = append(frame, data...)
+	}
+	w.Write(frame)
+}
+
+func TestMuxSession(t *testing.T) {
+	client, err := NewClient(testUUID, nil)
+	assert.Nil(t, err)
+
+	local, remote := net.Pipe()
+	news := make(chan *muxFrame, 2)
+	go func() {
+		defer remote.Close()
+		r := bufio.NewReader(remote)
+
+		// version, uuid, no addons, then the mux command without address
+		header := make([]byte, 1+16+1+1)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return
+		}
+		if header[18] != CommandMux {
+			return
+		}
+		remote.Write([]byte{Version, 0})
+
+		// echo every stream back in upper case, end it on the client's end
+		for {
+			f, err := readMuxFrame(r)
+			if err != nil {
+				return
+			}
+			switch f.status {
+			case muxStatusNew:
+				news <- f
+			case muxStatusKeep:
+				reply := make([]byte, len(f.data))
+				for i, b := range f.data {
+					if 'a' <= b && b <= 'z' {
+						b -= 'a' - 'A'
+					}
+					reply[i] = b
+				}
+				writeMuxFrame(remote, f.id, muxStatusKeep, reply)
+			case muxStatusEnd:
+				writeMuxFrame(remote, f.id, muxStatusEnd, nil)
+			}
+		}
+	}()
+
+	conn, err := client.StreamMuxConn(local)
+	assert.Nil(t, err)
+	session := NewMuxSession(conn)
+	defer session.Close()
+
+	dst := &vmess.DstAddr{
+		AddrType: vmess.AtypDomainName,
+		Addr:     append([]byte{byte(len("example.com"))}, "example.com"...),
+		Port:     443,
+	}
+	a, err := session.OpenStream(dst)
+	assert.Nil(t, err)
+	b, err := session.OpenStream(dst)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, session.NumStreams())
+
+	f := <-news
+	assert.Equal(t, uint16(1), f.id)
+	// network, port, address type, address
+	assert.Equal(t, append([]byte{muxNetworkTCP, 0x01, 0xbb, vmess.AtypDomainName}, dst.Addr...), f.meta[4:])
+	assert.Equal(t, uint16(2), (<-news).id)
+
+	_, err = b.Write([]byte("bravo"))
+	assert.Nil(t, err)
+	_, err = a.Write([]byte("alpha"))
+	assert.Nil(t, err)
+
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(a, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "ALPHA", string(buf))
+	_, err = io.ReadFull(b, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "BRAVO", string(buf))
+
+	assert.Nil(t, a.Close())
+	_, err = a.Read(buf)
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, session.NumStreams())
+
+	// the server going away ends the streams left
+	remote.Close()
+	_, err = b.Read(buf)
+	assert.NotNil(t, err)
+	assert.True(t, session.IsClosed())
+	_, err = session.OpenStream(dst)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,251 @@
+package vless
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"reflect"
+	"unsafe"
+
+	"github.com/gofrs/uuid"
+)
+
+// vision padding commands
+const (
+	commandPaddingContinue byte = 0
+	commandPaddingEnd      byte = 1
+	commandPaddingDirect   byte = 2
+)
+
+const (
+	// max length of a padded frame, content and padding included
+	visionFrameSize = 8192
+	// uuid and frame header
+	visionFrameOverhead = 16 + 5
+	// packets written before padding ends if the inner traffic isn't TLS
+	visionPacketsToFilter = 8
+)
+
+var (
+	tlsClientHelloStart     = []byte{0x16, 0x03}
+	tlsApplicationDataStart = []byte{0x17, 0x03, 0x03}
+
+	errVisionDirect = errors.New("vision: server switched to direct copy without a tls conn")
+)
+
+// VisionConn pads the first packets of a connection the way the
+// xtls-rprx-vision flow does, so the length pattern of the TLS handshake
+// inside the tunnel doesn't show. Writes always end the padding with
+// padding-end and keep going through the outer TLS. The server may answer
+// with padding-direct, then its data arrives as is on the raw TCP conn and
+// reads are taken from there.
+type VisionConn struct {
+	net.Conn
+	tlsConn net.Conn // *tls.Conn or *utls.UConn
+	id      []byte
+
+	// write side
+	writeUUID   bool
+	writePad    bool
+	isTLS       bool
+	packetsLeft int
+
+	// read side
+	readFirst bool
+	readPad   bool
+	direct    bool
+	content   []byte // unpadded, not read yet
+	buffered  []byte // read ahead, not parsed yet
+	rawReader io.Reader
+}
+
+func newVisionConn(conn net.Conn, tlsConn net.Conn, id *uuid.UUID) *VisionConn {
+	return &VisionConn{
+		Conn:        conn,
+		tlsConn:     tlsConn,
+		id:          id.Bytes(),
+		writeUUID:   true,
+		writePad:    true,
+		packetsLeft: visionPacketsToFilter,
+		readFirst:   true,
+		readPad:     true,
+	}
+}
+
+func (vc *VisionConn) Write(b []byte) (int, error) {
+	if !vc.writePad {
+		return vc.Conn.Write(b)
+	}
+
+	if vc.packetsLeft > 0 {
+		vc.packetsLeft--
+		if len(b) >= 6 && bytes.HasPrefix(b, tlsClientHelloStart) && b[5] == 0x01 {
+			vc.isTLS = true
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	longPadding := vc.isTLS
+	for p := b; len(p) > 0 || buf.Len() == 0; {
+		size := len(p)
+		if size > visionFrameSize-visionFrameOverhead {
+			size = visionFrameSize - visionFrameOverhead
+		}
+		chunk := p[:size]
+		p = p[size:]
+
+		command := commandPaddingContinue
+		switch {
+		case vc.isTLS && len(chunk) >= 6 && bytes.HasPrefix(chunk, tlsApplicationDataStart):
+			// the inner handshake is over, the rest needs no cover
+			vc.writePad = false
+			longPadding = false
+		case !vc.isTLS && vc.packetsLeft <= 1, vc.packetsLeft == 0:
+			vc.writePad = false
+		}
+		if !vc.writePad && len(p) == 0 {
+			command = commandPaddingEnd
+		}
+
+		vc.writeFrame(buf, chunk, command, longPadding)
+		if len(p) == 0 {
+			break
+		}
+	}
+
+	if _, err := vc.Conn.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (vc *VisionConn) writeFrame(buf *bytes.Buffer, content []byte, command byte, longPadding bool) {
+	var padding int
+	if len(content) < 900 && longPadding {
+		padding = randInt(500) + 900 - len(content)
+	} else {
+		padding = randInt(256)
+	}
+	if max := visionFrameSize - visionFrameOverhead - len(content); padding > max {
+		padding = max
+	}
+
+	if vc.writeUUID {
+		buf.Write(vc.id)
+		vc.writeUUID = false
+	}
+	buf.WriteByte(command)
+	binary.Write(buf, binary.BigEndian, uint16(len(content)))
+	binary.Write(buf, binary.BigEndian, uint16(padding))
+	buf.Write(content)
+	buf.Write(make([]byte, padding))
+}
+
+func (vc *VisionConn) Read(b []byte) (int, error) {
+	for len(vc.content) == 0 {
+		switch {
+		case vc.direct:
+			return vc.rawReader.Read(b)
+		case vc.readFirst:
+			vc.readFirst = false
+			// a padded response starts with the uuid and a frame header,
+			// anything else comes from a server that doesn't pad
+			buf := make([]byte, visionFrameSize)
+			n, err := vc.Conn.Read(buf)
+			if n < visionFrameOverhead || !bytes.Equal(buf[:16], vc.id) {
+				vc.readPad = false
+				if n == 0 {
+					return 0, err
+				}
+				vc.content = buf[:n]
+				continue
+			}
+			vc.buffered = buf[16:n]
+		case !vc.readPad:
+			if len(vc.buffered) != 0 {
+				vc.content, vc.buffered = vc.buffered, nil
+				continue
+			}
+			return vc.Conn.Read(b)
+		default:
+			content, err := vc.readFrame()
+			if err != nil {
+				return 0, err
+			}
+			vc.content = content
+		}
+	}
+
+	n := copy(b, vc.content)
+	vc.content = vc.content[n:]
+	return n, nil
+}
+
+// readFrame reads a padded frame and returns its content
+func (vc *VisionConn) readFrame() ([]byte, error) {
+	br := bytes.NewReader(vc.buffered)
+	r := io.MultiReader(br, vc.Conn)
+
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	command := header[0]
+	contentLen := int(binary.BigEndian.Uint16(header[1:]))
+	paddingLen := int(binary.BigEndian.Uint16(header[3:]))
+
+	frame := make([]byte, contentLen+paddingLen)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+	vc.buffered = vc.buffered[len(vc.buffered)-br.Len():]
+
+	switch command {
+	case commandPaddingEnd:
+		vc.readPad = false
+	case commandPaddingDirect:
+		vc.readPad = false
+		if err := vc.switchToDirect(); err != nil {
+			return nil, err
+		}
+	}
+	return frame[:contentLen], nil
+}
+
+// switchToDirect makes reads skip the outer TLS. What was buffered comes
+// first: bytes read ahead here, decrypted bytes the tls.Conn holds, then the
+// raw bytes it read ahead.
+func (vc *VisionConn) switchToDirect() error {
+	if vc.tlsConn == nil {
+		return errVisionDirect
+	}
+
+	// both keep the same buffers, uTLS embeds its tls.Conn copy
+	v := reflect.ValueOf(vc.tlsConn).Elem()
+	if embedded := v.FieldByName("Conn"); embedded.Kind() == reflect.Ptr {
+		v = embedded.Elem()
+	}
+	field := func(name string) unsafe.Pointer {
+		return unsafe.Pointer(v.FieldByName(name).UnsafeAddr())
+	}
+	input := (*bytes.Reader)(field("input"))
+	rawInput := (*bytes.Buffer)(field("rawInput"))
+	raw := *(*net.Conn)(field("conn"))
+
+	vc.direct = true
+	vc.rawReader = io.MultiReader(bytes.NewReader(vc.buffered), input, rawInput, raw)
+	vc.buffered = nil
+	return nil
+}
+
+func randInt(n int64) int {
+	i, err := rand.Int(rand.Reader, big.NewInt(n))
+	if err != nil {
+		return 0
+	}
+	return int(i.Int64())
+}
@@ -0,0 +1,176 @@
+package vless
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/transport/vmess"
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func visionFrame(id []byte, command byte, content []byte, padding int) []byte {
+	buf := &bytes.Buffer{}
+	buf.Write(id)
+	buf.WriteByte(command)
+	binary.Write(buf, binary.BigEndian, uint16(len(content)))
+	binary.Write(buf, binary.BigEndian, uint16(padding))
+	buf.Write(content)
+	buf.Write(make([]byte, padding))
+	return buf.Bytes()
+}
+
+// readVisionFrame parses a frame written by VisionConn
+func readVisionFrame(t *testing.T, r io.Reader, id []byte) (byte, []byte) {
+	if id != nil {
+		prefix := make([]byte, 16)
+		_, err := io.ReadFull(r, prefix)
+		assert.Nil(t, err)
+		assert.Equal(t, id, prefix)
+	}
+
+	header := make([]byte, 5)
+	_, err := io.ReadFull(r, header)
+	assert.Nil(t, err)
+
+	frame := make([]byte, int(binary.BigEndian.Uint16(header[1:]))+int(binary.BigEndian.Uint16(header[3:])))
+	_, err = io.ReadFull(r, frame)
+	assert.Nil(t, err)
+	return header[0], frame[:binary.BigEndian.Uint16(header[1:])]
+}
+
+func TestVisionConn_Write(t *testing.T) {
+	id := uuid.Must(uuid.FromString(testUUID))
+	client, server := net.Pipe()
+	defer server.Close()
+	vc := newVisionConn(client, nil, &id)
+
+	// inner tls: the ClientHello is padded, application data ends padding
+	hello := append([]byte{0x16, 0x03, 0x01, 0x00, 0x05, 0x01}, make([]byte, 4)...)
+	appData := []byte{0x17, 0x03, 0x03, 0x00, 0x01, 0xff}
+	go func() {
+		vc.Write(hello)
+		vc.Write(appData)
+		vc.Write([]byte("plain"))
+	}()
+
+	command, content := readVisionFrame(t, server, id.Bytes())
+	assert.Equal(t, commandPaddingContinue, command)
+	assert.Equal(t, hello, content)
+
+	command, content = readVisionFrame(t, server, nil)
+	assert.Equal(t, commandPaddingEnd, command)
+	assert.Equal(t, appData, content)
+
+	buf := make([]byte, 5)
+	_, err := io.ReadFull(server, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "plain", string(buf))
+}
+
+func TestVisionConn_ReadPadded(t *testing.T) {
+	id := uuid.Must(uuid.FromString(testUUID))
+	client, server := net.Pipe()
+	defer server.Close()
+	vc := newVisionConn(client, nil, &id)
+
+	go func() {
+		stream := visionFrame(id.Bytes(), commandPaddingContinue, []byte("hello "), 100)
+		stream = append(stream, visionFrame(nil, commandPaddingEnd, []byte("vision "), 7)...)
+		server.Write(stream)
+		server.Write([]byte("server"))
+	}()
+
+	buf := make([]byte, len("hello vision server"))
+	_, err := io.ReadFull(vc, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello vision server", string(buf))
+}
+
+func TestVisionConn_ReadUnpadded(t *testing.T) {
+	id := uuid.Must(uuid.FromString(testUUID))
+	client, server := net.Pipe()
+	defer server.Close()
+	vc := newVisionConn(client, nil, &id)
+
+	go server.Write([]byte("not padded"))
+
+	buf := make([]byte, len("not padded"))
+	_, err := io.ReadFull(vc, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "not padded", string(buf))
+}
+
+func TestVisionConn_ReadDirect(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"vision.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+
+	id := uuid.Must(uuid.FromString(testUUID))
+	rawClient, rawServer := net.Pipe()
+	defer rawServer.Close()
+
+	go func() {
+		server := tls.Server(rawServer, &tls.Config{
+			Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		})
+		if err := server.Handshake(); err != nil {
+			return
+		}
+		server.Write(visionFrame(id.Bytes(), commandPaddingDirect, []byte("over tls "), 10))
+		// from here on the outer tls is skipped
+		rawServer.Write([]byte("and raw"))
+	}()
+
+	tlsConn := tls.Client(rawClient, &tls.Config{InsecureSkipVerify: true})
+	assert.Nil(t, tlsConn.Handshake())
+	vc := newVisionConn(tlsConn, tlsConn, &id)
+
+	buf := make([]byte, len("over tls and raw"))
+	_, err = io.ReadFull(vc, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "over tls and raw", string(buf))
+}
+
+func TestClient_VisionFlow(t *testing.T) {
+	client, err := NewClient(testUUID, &Addons{Flow: XRV})
+	assert.Nil(t, err)
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+	go io.Copy(io.Discard, remote)
+
+	conn, err := client.StreamConn(local, &vmess.DstAddr{
+		AddrType: vmess.AtypDomainName,
+		Addr:     append([]byte{byte(len("example.com"))}, "example.com"...),
+		Port:     443,
+	})
+	assert.Nil(t, err)
+	assert.IsType(t, &VisionConn{}, conn)
+
+	conn, err = client.StreamConn(local, &vmess.DstAddr{
+		UDP:      true,
+		AddrType: vmess.AtypIPv4,
+		Addr:     []byte{1, 1, 1, 1},
+		Port:     53,
+	})
+	assert.Nil(t, err)
+	assert.IsType(t, &Conn{}, conn)
+}
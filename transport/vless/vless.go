@@ -1,10 +1,16 @@
 package vless
 
 import (
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
 	"net"
+	"strings"
 
 	"github.com/Dreamacro/clash/transport/vmess"
 	"github.com/gofrs/uuid"
+	utls "github.com/refraction-networking/utls"
+	"google.golang.org/protobuf/encoding/protowire"
 )
 
 const (
@@ -14,18 +20,77 @@ const (
 	XROU         = "xtls-rprx-origin-udp443"
 	XRDU         = "xtls-rprx-direct-udp443"
 	XRSU         = "xtls-rprx-splice-udp443"
+	XRV          = "xtls-rprx-vision"
 	Version byte = 0 // protocol version. preview version is 0
+
+	// Addons field carrying the UDP padding size, far from the fields
+	// upstream may add
+	addonUDPPadTo protowire.Number = 100
+	// maxUDPPadTo is the largest padding a frame length can cover
+	maxUDPPadTo = 1<<16 - 1
 )
 
 // Client is vless connection generator
 type Client struct {
 	UUID   *uuid.UUID
 	Addons *Addons
+
+	// UDPPadTo pads the UDP frames sent to at least this many bytes, the
+	// server strips it by the length prefix. The server pads its frames only
+	// if its response carries the same addon, see Conn.DownlinkPadTo.
+	UDPPadTo int
 }
 
 // StreamConn return a Conn with net.Conn and DstAddr
 func (c *Client) StreamConn(conn net.Conn, dst *vmess.DstAddr) (net.Conn, error) {
-	return newConn(conn, c, dst)
+	vc, err := newConn(conn, c, dst)
+	if err != nil {
+		return nil, err
+	}
+
+	if vc.addons != nil && vc.addons.Flow == XRV {
+		// direct copy needs the tls conn, over anything else vision only pads
+		var tlsConn net.Conn
+		switch conn.(type) {
+		case *tls.Conn, *utls.UConn:
+			tlsConn = conn
+		}
+		return newVisionConn(vc, tlsConn, c.UUID), nil
+	}
+	return vc, nil
+}
+
+// StreamMuxConn requests a mux.cool session over conn, NewMuxSession runs
+// it. Flows don't apply to mux.
+func (c *Client) StreamMuxConn(conn net.Conn) (net.Conn, error) {
+	return newConn(conn, c, nil)
+}
+
+// NormalizeUUID returns the canonical form (lower case, dashed) of a UUID
+// written with or without dashes, in any case, optionally wrapped in braces
+// or prefixed with urn:uuid:.
+func NormalizeUUID(str string) (string, error) {
+	s := strings.ToLower(strings.TrimSpace(str))
+	s = strings.TrimPrefix(s, "urn:uuid:")
+	if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+		s = s[1 : len(s)-1]
+	}
+
+	s = strings.ReplaceAll(s, "-", "")
+	if len(s) != 32 {
+		return "", fmt.Errorf("invalid uuid %q: expected 32 hex digits, got %d", str, len(s))
+	}
+
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid uuid %q: not a hex string", str)
+	}
+
+	uid, err := uuid.FromBytes(b)
+	if err != nil {
+		return "", err
+	}
+	return uid.String(), nil
 }
 
 // NewClient return Client instance
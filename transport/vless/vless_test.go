@@ -0,0 +1,41 @@
+package vless
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeUUID(t *testing.T) {
+	const canonical = "b831381d-6324-4d53-ad4f-8cda48b30811"
+
+	for _, str := range []string{
+		canonical,
+		"B831381D-6324-4D53-AD4F-8CDA48B30811",
+		"b831381D-6324-4d53-AD4f-8cda48b30811",
+		"b831381d63244d53ad4f8cda48b30811",
+		"B831381D63244D53AD4F8CDA48B30811",
+		"{b831381d-6324-4d53-ad4f-8cda48b30811}",
+		"{B831381D63244D53AD4F8CDA48B30811}",
+		"urn:uuid:b831381d-6324-4d53-ad4f-8cda48b30811",
+		"  b831381d-6324-4d53-ad4f-8cda48b30811\n",
+	} {
+		uid, err := NormalizeUUID(str)
+		assert.Nil(t, err, str)
+		assert.Equal(t, canonical, uid, str)
+	}
+}
+
+func TestNormalizeUUID_Invalid(t *testing.T) {
+	for _, str := range []string{
+		"",
+		"b831381d-6324-4d53-ad4f",
+		"b831381d-6324-4d53-ad4f-8cda48b308110",
+		"g831381d-6324-4d53-ad4f-8cda48b30811",
+		"{b831381d-6324-4d53-ad4f-8cda48b30811",
+		"b831381d 6324 4d53 ad4f 8cda48b30811",
+	} {
+		_, err := NormalizeUUID(str)
+		assert.NotNil(t, err, str)
+	}
+}
@@ -17,8 +17,13 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+	utls "github.com/refraction-networking/utls"
 )
 
+// earlyDataWait is how long writes are gathered into the early data before
+// the upgrade goes out with less than max-early-data
+const earlyDataWait = 10 * time.Millisecond
+
 type websocketConn struct {
 	conn       *websocket.Conn
 	reader     io.Reader
@@ -28,14 +33,26 @@ type websocketConn struct {
 	rMux sync.Mutex
 	wMux sync.Mutex
 }
+
+// websocketWithEarlyDataConn puts off the upgrade until the first writes,
+// which ride in the upgrade request as early data. Writes that follow each
+// other closely are gathered, so a protocol header and the payload after it
+// go out together.
 type websocketWithEarlyDataConn struct {
 	net.Conn
 	underlay net.Conn
-	closed   bool
-	dialed   chan bool
-	cancel   context.CancelFunc
-	ctx      context.Context
 	config   *WebsocketConfig
+	ctx      context.Context
+	cancel   context.CancelFunc
+
+	mux     sync.Mutex
+	buf     bytes.Buffer
+	timer   *time.Timer
+	dialing bool
+	closed  bool
+	// closed once Conn or err is set
+	dialed chan struct{}
+	err    error
 }
 
 type WebsocketConfig struct {
@@ -48,6 +65,10 @@ type WebsocketConfig struct {
 	ServerName          string
 	MaxEarlyData        int
 	EarlyDataHeaderName string
+
+	// TLSConfig, if not nil, is used instead of the config derived from
+	// SkipCertVerify and ServerName. NextProtos defaults to http/1.1.
+	TLSConfig *tls.Config
 }
 
 // Read implements net.Conn.Read()
@@ -114,6 +135,36 @@ func (wsc *websocketConn) RemoteAddr() net.Addr {
 	return wsc.remoteAddr
 }
 
+// ConnectionState returns the state of the TLS session under the websocket,
+// the zero value for plain ws.
+func (wsc *websocketConn) ConnectionState() tls.ConnectionState {
+	return tlsConnectionState(wsc.conn.UnderlyingConn())
+}
+
+// tlsConnectionState returns the state of c as a TLS session, the zero
+// value if it isn't one
+func tlsConnectionState(c net.Conn) tls.ConnectionState {
+	switch conn := c.(type) {
+	case *tls.Conn:
+		return conn.ConnectionState()
+	case *utls.UConn:
+		state := conn.ConnectionState()
+		return tls.ConnectionState{
+			Version:            state.Version,
+			HandshakeComplete:  state.HandshakeComplete,
+			DidResume:          state.DidResume,
+			CipherSuite:        state.CipherSuite,
+			NegotiatedProtocol: state.NegotiatedProtocol,
+			ServerName:         state.ServerName,
+			PeerCertificates:   state.PeerCertificates,
+			VerifiedChains:     state.VerifiedChains,
+		}
+	case interface{ ConnectionState() tls.ConnectionState }:
+		return conn.ConnectionState()
+	}
+	return tls.ConnectionState{}
+}
+
 func (wsc *websocketConn) SetDeadline(t time.Time) error {
 	if err := wsc.SetReadDeadline(t); err != nil {
 		return err
@@ -129,82 +180,149 @@ func (wsc *websocketConn) SetWriteDeadline(t time.Time) error {
 	return wsc.conn.SetWriteDeadline(t)
 }
 
-func (wsedc *websocketWithEarlyDataConn) Dial(earlyData []byte) error {
-	base64DataBuf := &bytes.Buffer{}
-	base64EarlyDataEncoder := base64.NewEncoder(base64.RawURLEncoding, base64DataBuf)
+func (wsedc *websocketWithEarlyDataConn) Write(b []byte) (int, error) {
+	wsedc.mux.Lock()
+	if wsedc.closed {
+		wsedc.mux.Unlock()
+		return 0, io.ErrClosedPipe
+	}
 
-	earlyDataBuf := bytes.NewBuffer(earlyData)
-	if _, err := base64EarlyDataEncoder.Write(earlyDataBuf.Next(wsedc.config.MaxEarlyData)); err != nil {
-		return errors.New("failed to encode early data: " + err.Error())
+	if wsedc.dialing {
+		wsedc.mux.Unlock()
+		<-wsedc.dialed
+		if wsedc.err != nil {
+			return 0, wsedc.err
+		}
+		return wsedc.Conn.Write(b)
 	}
 
-	if errc := base64EarlyDataEncoder.Close(); errc != nil {
-		return errors.New("failed to encode early data tail: " + errc.Error())
+	wsedc.buf.Write(b)
+	if wsedc.buf.Len() < wsedc.config.MaxEarlyData {
+		if wsedc.timer == nil {
+			wsedc.timer = time.AfterFunc(earlyDataWait, wsedc.flush)
+		}
+		wsedc.mux.Unlock()
+		return len(b), nil
 	}
 
-	var err error
-	if wsedc.Conn, err = streamWebsocketConn(wsedc.underlay, wsedc.config, base64DataBuf); err != nil {
-		wsedc.Close()
-		return errors.New("failed to dial WebSocket: " + err.Error())
+	earlyData := wsedc.startDial()
+	wsedc.mux.Unlock()
+	if err := wsedc.dial(earlyData); err != nil {
+		return 0, err
 	}
+	return len(b), nil
+}
 
-	wsedc.dialed <- true
-	if earlyDataBuf.Len() != 0 {
-		_, err = wsedc.Conn.Write(earlyDataBuf.Bytes())
+// flush dials with what was gathered once the wait is over
+func (wsedc *websocketWithEarlyDataConn) flush() {
+	wsedc.mux.Lock()
+	if wsedc.closed || wsedc.dialing {
+		wsedc.mux.Unlock()
+		return
 	}
+	earlyData := wsedc.startDial()
+	wsedc.mux.Unlock()
 
-	return err
+	wsedc.dial(earlyData)
 }
 
-func (wsedc *websocketWithEarlyDataConn) Write(b []byte) (int, error) {
-	if wsedc.closed {
-		return 0, io.ErrClosedPipe
+// startDial takes the gathered early data, wsedc.mux must be held
+func (wsedc *websocketWithEarlyDataConn) startDial() []byte {
+	wsedc.dialing = true
+	if wsedc.timer != nil {
+		wsedc.timer.Stop()
 	}
-	if wsedc.Conn == nil {
-		if err := wsedc.Dial(b); err != nil {
-			return 0, err
-		}
-		return len(b), nil
+	return wsedc.buf.Bytes()
+}
+
+func (wsedc *websocketWithEarlyDataConn) dial(earlyData []byte) error {
+	n := len(earlyData)
+	if n > wsedc.config.MaxEarlyData {
+		n = wsedc.config.MaxEarlyData
 	}
 
-	return wsedc.Conn.Write(b)
+	base64DataBuf := &bytes.Buffer{}
+	base64EarlyDataEncoder := base64.NewEncoder(base64.RawURLEncoding, base64DataBuf)
+	base64EarlyDataEncoder.Write(earlyData[:n])
+	base64EarlyDataEncoder.Close()
+
+	conn, err := streamWebsocketConn(wsedc.underlay, wsedc.config, base64DataBuf)
+	if err != nil {
+		err = errors.New("failed to dial WebSocket: " + err.Error())
+		wsedc.underlay.Close()
+	} else if n < len(earlyData) {
+		_, err = conn.Write(earlyData[n:])
+	}
+
+	wsedc.mux.Lock()
+	wsedc.Conn, wsedc.err = conn, err
+	closed := wsedc.closed
+	wsedc.mux.Unlock()
+	close(wsedc.dialed)
+
+	// closed while the upgrade was on the way
+	if closed && conn != nil {
+		conn.Close()
+	}
+	return err
 }
 
 func (wsedc *websocketWithEarlyDataConn) Read(b []byte) (int, error) {
-	if wsedc.closed {
+	select {
+	case <-wsedc.ctx.Done():
 		return 0, io.ErrClosedPipe
+	case <-wsedc.dialed:
 	}
-	if wsedc.Conn == nil {
-		select {
-		case <-wsedc.ctx.Done():
-			return 0, io.ErrUnexpectedEOF
-		case <-wsedc.dialed:
-		}
+
+	if wsedc.err != nil {
+		return 0, wsedc.err
 	}
 	return wsedc.Conn.Read(b)
 }
 
 func (wsedc *websocketWithEarlyDataConn) Close() error {
+	wsedc.mux.Lock()
+	if wsedc.closed {
+		wsedc.mux.Unlock()
+		return nil
+	}
 	wsedc.closed = true
+	if wsedc.timer != nil {
+		wsedc.timer.Stop()
+	}
+	conn := wsedc.Conn
+	wsedc.mux.Unlock()
 	wsedc.cancel()
-	if wsedc.Conn == nil {
-		return nil
+
+	if conn != nil {
+		return conn.Close()
 	}
-	return wsedc.Conn.Close()
+	return wsedc.underlay.Close()
 }
 
-func (wsedc *websocketWithEarlyDataConn) LocalAddr() net.Addr {
+// conn returns the websocket conn once dialed, the underlay before
+func (wsedc *websocketWithEarlyDataConn) conn() net.Conn {
+	wsedc.mux.Lock()
+	defer wsedc.mux.Unlock()
 	if wsedc.Conn == nil {
-		return wsedc.underlay.LocalAddr()
+		return wsedc.underlay
 	}
-	return wsedc.Conn.LocalAddr()
+	return wsedc.Conn
+}
+
+func (wsedc *websocketWithEarlyDataConn) LocalAddr() net.Addr {
+	return wsedc.conn().LocalAddr()
 }
 
 func (wsedc *websocketWithEarlyDataConn) RemoteAddr() net.Addr {
-	if wsedc.Conn == nil {
-		return wsedc.underlay.RemoteAddr()
-	}
-	return wsedc.Conn.RemoteAddr()
+	return wsedc.conn().RemoteAddr()
+}
+
+// ConnectionState returns the state of the TLS session under the websocket.
+// Until the upgrade that is the one of the underlay, wss only handshakes
+// with the upgrade.
+func (wsedc *websocketWithEarlyDataConn) ConnectionState() tls.ConnectionState {
+	return tlsConnectionState(wsedc.conn())
 }
 
 func (wsedc *websocketWithEarlyDataConn) SetDeadline(t time.Time) error {
@@ -215,23 +333,17 @@ func (wsedc *websocketWithEarlyDataConn) SetDeadline(t time.Time) error {
 }
 
 func (wsedc *websocketWithEarlyDataConn) SetReadDeadline(t time.Time) error {
-	if wsedc.Conn == nil {
-		return nil
-	}
-	return wsedc.Conn.SetReadDeadline(t)
+	return wsedc.conn().SetReadDeadline(t)
 }
 
 func (wsedc *websocketWithEarlyDataConn) SetWriteDeadline(t time.Time) error {
-	if wsedc.Conn == nil {
-		return nil
-	}
-	return wsedc.Conn.SetWriteDeadline(t)
+	return wsedc.conn().SetWriteDeadline(t)
 }
 
 func streamWebsocketWithEarlyDataConn(conn net.Conn, c *WebsocketConfig) (net.Conn, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 	conn = &websocketWithEarlyDataConn{
-		dialed:   make(chan bool, 1),
+		dialed:   make(chan struct{}),
 		cancel:   cancel,
 		ctx:      ctx,
 		underlay: conn,
@@ -253,16 +365,23 @@ func streamWebsocketConn(conn net.Conn, c *WebsocketConfig, earlyData *bytes.Buf
 	scheme := "ws"
 	if c.TLS {
 		scheme = "wss"
-		dialer.TLSClientConfig = &tls.Config{
-			ServerName:         c.Host,
-			InsecureSkipVerify: c.SkipCertVerify,
-			NextProtos:         []string{"http/1.1"},
-		}
+		if c.TLSConfig != nil {
+			dialer.TLSClientConfig = c.TLSConfig.Clone()
+			if len(dialer.TLSClientConfig.NextProtos) == 0 {
+				dialer.TLSClientConfig.NextProtos = []string{"http/1.1"}
+			}
+		} else {
+			dialer.TLSClientConfig = &tls.Config{
+				ServerName:         c.Host,
+				InsecureSkipVerify: c.SkipCertVerify,
+				NextProtos:         []string{"http/1.1"},
+			}
 
-		if c.ServerName != "" {
-			dialer.TLSClientConfig.ServerName = c.ServerName
-		} else if host := c.Headers.Get("Host"); host != "" {
-			dialer.TLSClientConfig.ServerName = host
+			if c.ServerName != "" {
+				dialer.TLSClientConfig.ServerName = c.ServerName
+			} else if host := c.Headers.Get("Host"); host != "" {
+				dialer.TLSClientConfig.ServerName = host
+			}
 		}
 	}
 
@@ -287,6 +406,8 @@ func streamWebsocketConn(conn net.Conn, c *WebsocketConfig, earlyData *bytes.Buf
 		}
 	}
 
+	// the response is parsed from the websocket.Conn's own bufio.Reader, so
+	// frames coalesced with the 101 response are kept for the first Read
 	wsConn, resp, err := dialer.Dial(uri.String(), headers)
 	if err != nil {
 		reason := err.Error()
@@ -0,0 +1,119 @@
+package vmess
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// serveCoalescedUpgrade answers the upgrade with the 101 response and the
+// first websocket frame in a single write, like a server flushing both in
+// one TCP segment.
+func serveCoalescedUpgrade(conn net.Conn, payload []byte) error {
+	req, err := http.ReadRequest(bufio.NewReader(conn))
+	if err != nil {
+		return err
+	}
+
+	h := sha1.New()
+	h.Write([]byte(req.Header.Get("Sec-WebSocket-Key") + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	accept := base64.StdEncoding.EncodeToString(h.Sum(nil))
+
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + accept + "\r\n\r\n"
+
+	// unmasked binary frame
+	frame := append([]byte{0x82, byte(len(payload))}, payload...)
+	_, err = conn.Write(append([]byte(resp), frame...))
+	return err
+}
+
+func TestWebsocket_CoalescedUpgrade(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	payload := []byte("first packet")
+	go serveCoalescedUpgrade(server, payload)
+
+	conn, err := StreamWebsocketConn(client, &WebsocketConfig{
+		Host: "example.com",
+		Port: "80",
+		Path: "/",
+	})
+	assert.Nil(t, err)
+
+	buf := make([]byte, len(payload))
+	_, err = io.ReadFull(conn, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, payload, buf)
+}
+
+func TestWebsocket_CoalescedUpgradeEarlyData(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	payload := []byte("first packet")
+	go serveCoalescedUpgrade(server, payload)
+
+	conn, err := StreamWebsocketConn(client, &WebsocketConfig{
+		Host:                "example.com",
+		Port:                "80",
+		Path:                "/",
+		MaxEarlyData:        2048,
+		EarlyDataHeaderName: "Sec-WebSocket-Protocol",
+	})
+	assert.Nil(t, err)
+
+	// early data defers the upgrade to the first write
+	_, err = conn.Write([]byte("hello"))
+	assert.Nil(t, err)
+
+	buf := make([]byte, len(payload))
+	_, err = io.ReadFull(conn, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, payload, buf)
+}
+
+func TestWebsocket_EarlyDataGathered(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	protocol := make(chan string, 1)
+	go func() {
+		req, err := http.ReadRequest(bufio.NewReader(server))
+		if err != nil {
+			close(protocol)
+			return
+		}
+		protocol <- req.Header.Get("Sec-WebSocket-Protocol")
+	}()
+
+	conn, err := StreamWebsocketConn(client, &WebsocketConfig{
+		Host: "example.com",
+		Port: "80",
+		Path: "/?ed=2048",
+	})
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	// a protocol header and its payload written apart go out together
+	_, err = conn.Write([]byte("header"))
+	assert.Nil(t, err)
+	_, err = conn.Write([]byte("payload"))
+	assert.Nil(t, err)
+
+	earlyData, err := base64.RawURLEncoding.DecodeString(<-protocol)
+	assert.Nil(t, err)
+	assert.Equal(t, "headerpayload", string(earlyData))
+}
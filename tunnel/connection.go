@@ -62,8 +62,8 @@ func handleUDPToLocal(packet C.UDPPacket, pc net.PacketConn, key string, fAddr n
 	}
 }
 
-func handleSocket(ctx C.ConnContext, outbound net.Conn) {
-	relay(ctx.Conn(), outbound)
+func handleSocket(inbound net.Conn, outbound net.Conn) {
+	relay(inbound, outbound)
 }
 
 // relay copies between left and right bidirectionally.
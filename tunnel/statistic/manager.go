@@ -17,6 +17,7 @@ func init() {
 		downloadBlip:  atomic.NewInt64(0),
 		uploadTotal:   atomic.NewInt64(0),
 		downloadTotal: atomic.NewInt64(0),
+		metrics:       newMetrics(),
 	}
 
 	go DefaultManager.handle()
@@ -30,6 +31,7 @@ type Manager struct {
 	downloadBlip  *atomic.Int64
 	uploadTotal   *atomic.Int64
 	downloadTotal *atomic.Int64
+	metrics       *metrics
 }
 
 func (m *Manager) Join(c tracker) {
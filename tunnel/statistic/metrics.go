@@ -0,0 +1,267 @@
+package statistic
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	C "github.com/Dreamacro/clash/constant"
+
+	"go.uber.org/atomic"
+)
+
+// dnsBuckets are the upper bounds in seconds of the DNS query latency
+// histogram, the default buckets of Prometheus
+var dnsBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metricKey is what the traffic counters are keyed by, the proxy that carries
+// a connection and the rule it matched
+type metricKey struct {
+	proxy string
+	rule  string
+}
+
+func newMetricKey(chain C.Chain, rule C.Rule) metricKey {
+	return metricKey{
+		proxy: chain.Last(),
+		rule:  ruleName(rule),
+	}
+}
+
+// ruleName names a rule the way the logs do, Match has no payload
+func ruleName(rule C.Rule) string {
+	if rule == nil {
+		return ""
+	}
+	if rule.Payload() == "" {
+		return rule.RuleType().String()
+	}
+	return rule.RuleType().String() + "," + rule.Payload()
+}
+
+type trafficCounter struct {
+	upload   *atomic.Int64
+	download *atomic.Int64
+}
+
+type histogram struct {
+	buckets []*atomic.Int64
+	count   *atomic.Int64
+	sum     *atomic.Duration
+}
+
+func newHistogram() *histogram {
+	h := &histogram{
+		buckets: make([]*atomic.Int64, len(dnsBuckets)),
+		count:   atomic.NewInt64(0),
+		sum:     atomic.NewDuration(0),
+	}
+	for i := range h.buckets {
+		h.buckets[i] = atomic.NewInt64(0)
+	}
+	return h
+}
+
+func (h *histogram) observe(d time.Duration) {
+	for i, bound := range dnsBuckets {
+		if d.Seconds() <= bound {
+			h.buckets[i].Inc()
+			break
+		}
+	}
+	h.count.Inc()
+	h.sum.Add(d)
+}
+
+// metrics are the counters behind the Prometheus endpoint, they live as long
+// as the process, ResetStatistic leaves them be as counters only go up
+type metrics struct {
+	traffic  sync.Map // metricKey -> *trafficCounter
+	failures sync.Map // metricKey -> *atomic.Int64
+
+	dnsMux sync.Mutex
+	dns    map[string]*histogram
+}
+
+func newMetrics() *metrics {
+	return &metrics{dns: map[string]*histogram{}}
+}
+
+func (m *metrics) trafficCounter(key metricKey) *trafficCounter {
+	if counter, ok := m.traffic.Load(key); ok {
+		return counter.(*trafficCounter)
+	}
+
+	counter, _ := m.traffic.LoadOrStore(key, &trafficCounter{
+		upload:   atomic.NewInt64(0),
+		download: atomic.NewInt64(0),
+	})
+	return counter.(*trafficCounter)
+}
+
+// PushHandshakeFailure counts a connection the proxy the rule picked failed
+// to set up
+func (m *Manager) PushHandshakeFailure(proxy string, rule C.Rule) {
+	key := metricKey{proxy: proxy, rule: ruleName(rule)}
+	counter, _ := m.metrics.failures.LoadOrStore(key, atomic.NewInt64(0))
+	counter.(*atomic.Int64).Inc()
+}
+
+// ObserveDNSQuery records the latency of a DNS query sent upstream, result is
+// success or failure
+func (m *Manager) ObserveDNSQuery(result string, d time.Duration) {
+	m.metrics.dnsMux.Lock()
+	h, ok := m.metrics.dns[result]
+	if !ok {
+		h = newHistogram()
+		m.metrics.dns[result] = h
+	}
+	m.metrics.dnsMux.Unlock()
+
+	h.observe(d)
+}
+
+// WriteMetrics writes the metrics in the Prometheus text format, with the
+// counters nodes keep themselves
+func (m *Manager) WriteMetrics(w io.Writer, nodes []NodeMetrics) error {
+	mw := &metricsWriter{w: w}
+
+	upload := map[metricKey]int64{}
+	download := map[metricKey]int64{}
+	m.metrics.traffic.Range(func(key, value interface{}) bool {
+		counter := value.(*trafficCounter)
+		upload[key.(metricKey)] = counter.upload.Load()
+		download[key.(metricKey)] = counter.download.Load()
+		return true
+	})
+	mw.header("clash_upload_bytes_total", "counter", "Bytes sent by proxy and matched rule.")
+	mw.series("clash_upload_bytes_total", upload)
+	mw.header("clash_download_bytes_total", "counter", "Bytes received by proxy and matched rule.")
+	mw.series("clash_download_bytes_total", download)
+
+	active := map[metricKey]int64{}
+	m.connections.Range(func(key, value interface{}) bool {
+		active[value.(tracker).metricKey()]++
+		return true
+	})
+	mw.header("clash_active_connections", "gauge", "Open connections by proxy and matched rule.")
+	mw.series("clash_active_connections", active)
+
+	failures := map[metricKey]int64{}
+	m.metrics.failures.Range(func(key, value interface{}) bool {
+		failures[key.(metricKey)] = value.(*atomic.Int64).Load()
+		return true
+	})
+	mw.header("clash_handshake_failures_total", "counter", "Connections the proxy picked by the matched rule failed to set up.")
+	mw.series("clash_handshake_failures_total", failures)
+
+	m.metrics.dnsMux.Lock()
+	results := make([]string, 0, len(m.metrics.dns))
+	for result := range m.metrics.dns {
+		results = append(results, result)
+	}
+	histograms := m.metrics.dns
+	m.metrics.dnsMux.Unlock()
+	sort.Strings(results)
+
+	mw.header("clash_dns_query_duration_seconds", "histogram", "Latency of the DNS queries sent upstream.")
+	for _, result := range results {
+		h := histograms[result]
+		label := fmt.Sprintf(`result="%s"`, escapeLabel(result))
+		cumulative := int64(0)
+		for i, bound := range dnsBuckets {
+			cumulative += h.buckets[i].Load()
+			mw.printf("clash_dns_query_duration_seconds_bucket{%s,le=\"%g\"} %d\n", label, bound, cumulative)
+		}
+		count := h.count.Load()
+		mw.printf("clash_dns_query_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", label, count)
+		mw.printf("clash_dns_query_duration_seconds_sum{%s} %g\n", label, h.sum.Load().Seconds())
+		mw.printf("clash_dns_query_duration_seconds_count{%s} %d\n", label, count)
+	}
+
+	mw.writeNodeMetrics(nodes)
+
+	return mw.err
+}
+
+// NodeMetrics is a snapshot of the counters a node keeps itself. Proxy, Type
+// and Transport are the labels of its series.
+type NodeMetrics struct {
+	Proxy     string
+	Type      string
+	Transport string
+
+	UploadBytes       int64
+	DownloadBytes     int64
+	Connections       int64 // successful dials, TCP and UDP
+	ActiveConnections int64
+	HandshakeFailures int64
+	HandshakeSeconds  float64 // total over the successful dials
+}
+
+// writeNodeMetrics writes the series of nodes, sorted by proxy name
+func (mw *metricsWriter) writeNodeMetrics(nodes []NodeMetrics) {
+	nodes = append([]NodeMetrics{}, nodes...)
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Proxy < nodes[j].Proxy })
+
+	for _, metric := range []struct {
+		name, tp, help string
+		value          func(n NodeMetrics) interface{}
+	}{
+		{"clash_node_upload_bytes_total", "counter", "Bytes sent by the node.", func(n NodeMetrics) interface{} { return n.UploadBytes }},
+		{"clash_node_download_bytes_total", "counter", "Bytes received by the node.", func(n NodeMetrics) interface{} { return n.DownloadBytes }},
+		{"clash_node_connections_total", "counter", "Connections the node set up.", func(n NodeMetrics) interface{} { return n.Connections }},
+		{"clash_node_active_connections", "gauge", "Open connections of the node.", func(n NodeMetrics) interface{} { return n.ActiveConnections }},
+		{"clash_node_handshake_failures_total", "counter", "Connections the node failed to set up.", func(n NodeMetrics) interface{} { return n.HandshakeFailures }},
+		{"clash_node_handshake_seconds_total", "counter", "Time the node spent setting up its connections.", func(n NodeMetrics) interface{} { return n.HandshakeSeconds }},
+	} {
+		mw.header(metric.name, metric.tp, metric.help)
+		for _, n := range nodes {
+			mw.printf("%s{proxy=\"%s\",type=\"%s\",transport=\"%s\"} %v\n", metric.name, escapeLabel(n.Proxy), escapeLabel(n.Type), escapeLabel(n.Transport), metric.value(n))
+		}
+	}
+}
+
+// metricsWriter keeps the first write error, so the series are written
+// without checking every one
+type metricsWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (mw *metricsWriter) printf(format string, a ...interface{}) {
+	if mw.err != nil {
+		return
+	}
+	_, mw.err = fmt.Fprintf(mw.w, format, a...)
+}
+
+func (mw *metricsWriter) header(name, tp, help string) {
+	mw.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, tp)
+}
+
+func (mw *metricsWriter) series(name string, values map[metricKey]int64) {
+	keys := make([]metricKey, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].proxy != keys[j].proxy {
+			return keys[i].proxy < keys[j].proxy
+		}
+		return keys[i].rule < keys[j].rule
+	})
+
+	for _, key := range keys {
+		mw.printf("%s{proxy=\"%s\",rule=\"%s\"} %d\n", name, escapeLabel(key.proxy), escapeLabel(key.rule), values[key])
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
@@ -0,0 +1,49 @@
+package statistic
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManager_WriteMetrics(t *testing.T) {
+	m := &Manager{metrics: newMetrics()}
+
+	counter := m.metrics.trafficCounter(metricKey{proxy: "ss", rule: `DomainSuffix,"quoted"`})
+	counter.upload.Add(10)
+	counter.download.Add(20)
+	m.PushHandshakeFailure("Proxy", nil)
+	m.ObserveDNSQuery("success", 20*time.Millisecond)
+	m.ObserveDNSQuery("success", 3*time.Second)
+
+	nodes := []NodeMetrics{
+		{Proxy: "vless", Type: "vless", Transport: "ws", UploadBytes: 30, DownloadBytes: 40, Connections: 2, ActiveConnections: 1, HandshakeFailures: 3, HandshakeSeconds: 0.5},
+		{Proxy: "a", Type: "vless", Transport: "tcp"},
+	}
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, m.WriteMetrics(buf, nodes))
+	text := buf.String()
+
+	assert.Contains(t, text, "# TYPE clash_upload_bytes_total counter\n")
+	assert.Contains(t, text, `clash_upload_bytes_total{proxy="ss",rule="DomainSuffix,\"quoted\""} 10`+"\n")
+	assert.Contains(t, text, `clash_download_bytes_total{proxy="ss",rule="DomainSuffix,\"quoted\""} 20`+"\n")
+	assert.Contains(t, text, `clash_handshake_failures_total{proxy="Proxy",rule=""} 1`+"\n")
+	assert.Contains(t, text, `clash_dns_query_duration_seconds_bucket{result="success",le="0.01"} 0`+"\n")
+	assert.Contains(t, text, `clash_dns_query_duration_seconds_bucket{result="success",le="0.025"} 1`+"\n")
+	assert.Contains(t, text, `clash_dns_query_duration_seconds_bucket{result="success",le="5"} 2`+"\n")
+	assert.Contains(t, text, `clash_dns_query_duration_seconds_bucket{result="success",le="+Inf"} 2`+"\n")
+	assert.Contains(t, text, `clash_dns_query_duration_seconds_sum{result="success"} 3.02`+"\n")
+	assert.Contains(t, text, `clash_dns_query_duration_seconds_count{result="success"} 2`+"\n")
+
+	assert.Contains(t, text, "# TYPE clash_node_active_connections gauge\n")
+	assert.Contains(t, text, `clash_node_upload_bytes_total{proxy="a",type="vless",transport="tcp"} 0`+"\n"+
+		`clash_node_upload_bytes_total{proxy="vless",type="vless",transport="ws"} 30`+"\n")
+	assert.Contains(t, text, `clash_node_download_bytes_total{proxy="vless",type="vless",transport="ws"} 40`+"\n")
+	assert.Contains(t, text, `clash_node_connections_total{proxy="vless",type="vless",transport="ws"} 2`+"\n")
+	assert.Contains(t, text, `clash_node_active_connections{proxy="vless",type="vless",transport="ws"} 1`+"\n")
+	assert.Contains(t, text, `clash_node_handshake_failures_total{proxy="vless",type="vless",transport="ws"} 3`+"\n")
+	assert.Contains(t, text, `clash_node_handshake_seconds_total{proxy="vless",type="vless",transport="ws"} 0.5`+"\n")
+}
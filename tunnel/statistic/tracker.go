@@ -12,7 +12,9 @@ import (
 
 type tracker interface {
 	ID() string
+	Chains() C.Chain
 	Close() error
+	metricKey() metricKey
 }
 
 type trackerInfo struct {
@@ -24,6 +26,13 @@ type trackerInfo struct {
 	Chain         C.Chain       `json:"chains"`
 	Rule          string        `json:"rule"`
 	RulePayload   string        `json:"rulePayload"`
+
+	key     metricKey
+	counter *trafficCounter
+}
+
+func (ti *trackerInfo) metricKey() metricKey {
+	return ti.key
 }
 
 type tcpTracker struct {
@@ -41,6 +50,7 @@ func (tt *tcpTracker) Read(b []byte) (int, error) {
 	download := int64(n)
 	tt.manager.PushDownloaded(download)
 	tt.DownloadTotal.Add(download)
+	tt.counter.download.Add(download)
 	return n, err
 }
 
@@ -49,6 +59,7 @@ func (tt *tcpTracker) Write(b []byte) (int, error) {
 	upload := int64(n)
 	tt.manager.PushUploaded(upload)
 	tt.UploadTotal.Add(upload)
+	tt.counter.upload.Add(upload)
 	return n, err
 }
 
@@ -78,6 +89,8 @@ func NewTCPTracker(conn C.Conn, manager *Manager, metadata *C.Metadata, rule C.R
 		t.trackerInfo.Rule = rule.RuleType().String()
 		t.trackerInfo.RulePayload = rule.Payload()
 	}
+	t.key = newMetricKey(t.Chain, rule)
+	t.counter = manager.metrics.trafficCounter(t.key)
 
 	manager.Join(t)
 	return t
@@ -98,6 +111,7 @@ func (ut *udpTracker) ReadFrom(b []byte) (int, net.Addr, error) {
 	download := int64(n)
 	ut.manager.PushDownloaded(download)
 	ut.DownloadTotal.Add(download)
+	ut.counter.download.Add(download)
 	return n, addr, err
 }
 
@@ -106,6 +120,7 @@ func (ut *udpTracker) WriteTo(b []byte, addr net.Addr) (int, error) {
 	upload := int64(n)
 	ut.manager.PushUploaded(upload)
 	ut.UploadTotal.Add(upload)
+	ut.counter.upload.Add(upload)
 	return n, err
 }
 
@@ -135,6 +150,8 @@ func NewUDPTracker(conn C.PacketConn, manager *Manager, metadata *C.Metadata, ru
 		ut.trackerInfo.Rule = rule.RuleType().String()
 		ut.trackerInfo.RulePayload = rule.Payload()
 	}
+	ut.key = newMetricKey(ut.Chain, rule)
+	ut.counter = manager.metrics.trafficCounter(ut.key)
 
 	manager.Join(ut)
 	return ut
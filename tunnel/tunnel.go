@@ -11,6 +11,7 @@ import (
 	"github.com/Dreamacro/clash/adapter/inbound"
 	"github.com/Dreamacro/clash/component/nat"
 	"github.com/Dreamacro/clash/component/resolver"
+	"github.com/Dreamacro/clash/component/sniffer"
 	C "github.com/Dreamacro/clash/constant"
 	"github.com/Dreamacro/clash/constant/provider"
 	"github.com/Dreamacro/clash/context"
@@ -26,6 +27,7 @@ var (
 	proxies       = make(map[string]C.Proxy)
 	providers     map[string]provider.ProxyProvider
 	ruleProviders map[string]*ruleProvider.RuleProvider
+	sniffers      *sniffer.Dispatcher
 	configMux     sync.RWMutex
 
 	// Outbound Rule
@@ -85,6 +87,19 @@ func UpdateProxies(newProxies map[string]C.Proxy, newProviders map[string]provid
 	configMux.Unlock()
 }
 
+// UpdateSniffer handle update sniffer, nil disables sniffing
+func UpdateSniffer(dispatcher *sniffer.Dispatcher) {
+	configMux.Lock()
+	sniffers = dispatcher
+	configMux.Unlock()
+}
+
+func snifferDispatcher() *sniffer.Dispatcher {
+	configMux.RLock()
+	defer configMux.RUnlock()
+	return sniffers
+}
+
 // Mode return current mode
 func Mode() TunnelMode {
 	return mode
@@ -217,6 +232,10 @@ func handleUDPConn(packet *inbound.PacketAdapter) {
 			cond.Broadcast()
 		}()
 
+		if dispatcher := snifferDispatcher(); dispatcher != nil {
+			dispatcher.SniffPacket(packet.Data(), metadata)
+		}
+
 		ctx := context.NewPacketConnContext(metadata)
 		proxy, rule, err := resolveMetadata(ctx, metadata)
 		if err != nil {
@@ -231,6 +250,7 @@ func handleUDPConn(packet *inbound.PacketAdapter) {
 			} else {
 				log.Warnln("[UDP] dial %s (match %s/%s) to %s error: %s", proxy.Name(), rule.RuleType().String(), rule.Payload(), metadata.RemoteAddress(), err.Error())
 			}
+			statistic.DefaultManager.PushHandshakeFailure(proxy.Name(), rule)
 			return
 		}
 		ctx.InjectPacketConn(rawPc)
@@ -268,6 +288,11 @@ func handleTCPConn(ctx C.ConnContext) {
 		return
 	}
 
+	conn := ctx.Conn()
+	if dispatcher := snifferDispatcher(); dispatcher != nil {
+		conn = dispatcher.SniffConn(conn, metadata)
+	}
+
 	proxy, rule, err := resolveMetadata(ctx, metadata)
 	if err != nil {
 		log.Warnln("[Metadata] parse failed: %s", err.Error())
@@ -281,6 +306,7 @@ func handleTCPConn(ctx C.ConnContext) {
 		} else {
 			log.Warnln("[TCP] dial %s (match %s/%s) to %s error: %s", proxy.Name(), rule.RuleType().String(), rule.Payload(), metadata.RemoteAddress(), err.Error())
 		}
+		statistic.DefaultManager.PushHandshakeFailure(proxy.Name(), rule)
 		return
 	}
 	remoteConn = statistic.NewTCPTracker(remoteConn, statistic.DefaultManager, metadata, rule)
@@ -297,7 +323,7 @@ func handleTCPConn(ctx C.ConnContext) {
 		log.Infoln("[TCP] %s --> %s doesn't match any rule using DIRECT", metadata.SourceAddress(), metadata.RemoteAddress())
 	}
 
-	handleSocket(ctx, remoteConn)
+	handleSocket(conn, remoteConn)
 }
 
 func shouldResolveIP(rule C.Rule, metadata *C.Metadata) bool {